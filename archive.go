@@ -0,0 +1,179 @@
+package stuffbin
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveWalkFunc is called for every regular file in an archive with its
+// target path and a reader of its contents. zf is the file if the archive
+// is a ZIP, which allows it to be copied without recompression.
+type archiveWalkFunc func(r io.Reader, targetPath string, fInfo os.FileInfo, zf *zip.File) error
+
+// StuffArchive is Stuff with the files in an existing ZIP, tar, or gzip
+// or zstd compressed tar archive (eg: a frontend build artifact from CI)
+// instead of local files and directories.
+func StuffArchive(in, out, archivePath string) (int64, int64, error) {
+	return StuffArchiveWithOpt(in, out, StuffOpt{}, archivePath)
+}
+
+// StuffArchiveWithOpt is StuffArchive with StuffOpt options. The archive
+// path can have an alias (eg: dist.tar.gz:/static) that its files are
+// placed under. Tar archives are converted to the ZIP payload and files in
+// ZIP archives are copied without recompression where possible. Incremental
+// doesn't apply to archives.
+func StuffArchiveWithOpt(in, out string, o StuffOpt, archivePath string) (int64, int64, error) {
+	return stuffEntries(in, out, o, []stuffEntry{{path: archivePath, archive: true}}, false, nil)
+}
+
+// walkArchive calls the callback for every regular file in a ZIP, tar,
+// .tar.gz, or .tar.zst archive that isn't excluded by the walk options.
+// The format is detected from the contents of the archive.
+func walkArchive(cb archiveWalkFunc, o walkOpt, p string) error {
+	srcPath, alias, err := splitAlias(p)
+	if err != nil {
+		return err
+	}
+	if alias != "" {
+		alias = cleanPath("/", alias)
+	}
+
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	target := archiveTarget(o, alias, "")
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(262)
+
+	var r io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return walkZip(cb, f, stat.Size(), target)
+
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", srcPath, err)
+		}
+		defer gr.Close()
+		r = gr
+
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", srcPath, err)
+		}
+		defer zr.Close()
+		r = zr
+
+	case len(magic) == 262 && bytes.HasPrefix(magic[257:], []byte("ustar")):
+
+	default:
+		return fmt.Errorf("%s: unknown archive format. Should be zip, tar, tar.gz, or tar.zst", srcPath)
+	}
+
+	if err := walkTar(cb, r, target); err != nil {
+		return fmt.Errorf("error reading %s: %v", srcPath, err)
+	}
+	return nil
+}
+
+// archiveTarget returns a function that returns the target path of a file
+// in an archive, or an empty string if it's excluded. With an alias, the
+// optional base directory of the files in the archive is replaced with it.
+func archiveTarget(o walkOpt, alias, base string) func(name string) string {
+	return func(name string) string {
+		name = strings.TrimPrefix(path.Clean("/"+name), "/")
+		if isExcluded(o.exclude, name) {
+			return ""
+		}
+		if o.skipHidden {
+			for _, s := range strings.Split(name, "/") {
+				if isHidden(s) {
+					return ""
+				}
+			}
+		}
+
+		if alias != "" {
+			return cleanPath(o.rootPath, path.Join(alias, strings.TrimPrefix(name, base)))
+		}
+		return cleanPath(o.rootPath, rewritePath(o.rewrite, name))
+	}
+}
+
+// walkZip calls the callback for every regular file in a ZIP archive
+// that has a target path.
+func walkZip(cb archiveWalkFunc, r io.ReaderAt, size int64, target func(string) string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		tp := target(f.Name)
+		if tp == "" {
+			continue
+		}
+
+		rd, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = cb(rd, tp, f.FileInfo(), f)
+		rd.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkTar calls the callback for every regular file in a tar archive
+// that has a target path.
+func walkTar(cb archiveWalkFunc, r io.Reader, target func(string) string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		tp := target(hdr.Name)
+		if tp == "" {
+			continue
+		}
+
+		if err := cb(tr, tp, hdr.FileInfo(), nil); err != nil {
+			return err
+		}
+	}
+}
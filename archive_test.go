@@ -0,0 +1,74 @@
+package stuffbin
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+var archiveFiles = map[string]string{
+	"index.html":        "<html></html>",
+	"assets/app.js":     "console.log('app');",
+	"assets/app.js.map": "{}",
+}
+
+func TestStuffArchive(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		out = filepath.Join(dir, "stuffed")
+	)
+
+	// ZIP.
+	zb := &bytes.Buffer{}
+	zw := zip.NewWriter(zb)
+	for name, body := range archiveFiles {
+		w, err := zw.Create("dist/" + name)
+		assert(t, "error creating zip file", nil, err)
+		_, _ = w.Write([]byte(body))
+	}
+	assert(t, "error closing zip", nil, zw.Close())
+
+	// tar.gz.
+	tb := &bytes.Buffer{}
+	gw := gzip.NewWriter(tb)
+	tw := tar.NewWriter(gw)
+	assert(t, "error writing tar", nil, tw.WriteHeader(&tar.Header{Name: "./dist/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, body := range archiveFiles {
+		err := tw.WriteHeader(&tar.Header{Name: "./dist/" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(body))})
+		assert(t, "error writing tar", nil, err)
+		_, _ = tw.Write([]byte(body))
+	}
+	assert(t, "error closing tar", nil, tw.Close())
+	assert(t, "error closing gzip", nil, gw.Close())
+
+	for name, b := range map[string][]byte{"dist.zip": zb.Bytes(), "dist.tar.gz": tb.Bytes()} {
+		archive := filepath.Join(dir, name)
+		assert(t, "error writing archive", nil, os.WriteFile(archive, b, 0644))
+
+		_, _, err := StuffArchiveWithOpt(mockBin, out, StuffOpt{Exclude: []string{"*.map"}}, archive)
+		assert(t, "error stuffing", nil, err)
+		fs, err := UnStuff(out)
+		assert(t, "error unstuffing", nil, err)
+		files := fs.List()
+		sort.Strings(files)
+		assert(t, "mismatch in stuffed files", []string{"/dist/assets/app.js", "/dist/index.html"}, files)
+
+		// Alias.
+		_, _, err = StuffArchiveWithOpt(mockBin, out, StuffOpt{Brotli: []string{"*.html"}}, archive+":/static")
+		assert(t, "error stuffing", nil, err)
+		fs, err = UnStuff(out)
+		assert(t, "error unstuffing", nil, err)
+		b, err := fs.Read("/static/dist/index.html")
+		assert(t, "error reading file", nil, err)
+		assert(t, "mismatch in file", archiveFiles["index.html"], string(b))
+		assert(t, "file count", len(archiveFiles), fs.Len())
+	}
+
+	_, _, err := StuffArchive(mockBin, out, "mock/foo.txt")
+	assert(t, "expected error on unknown format", true, err != nil)
+}
@@ -0,0 +1,202 @@
+package stuffbin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// lenFingerprint is the number of hex characters of the file's
+// hash that are inserted into fingerprinted file names.
+const lenFingerprint = 8
+
+// AssetMap maps logical file paths in a FileSystem
+// (eg: /static/app.css) to their fingerprinted paths
+// (eg: /static/app.3f9ab2c1.css).
+type AssetMap map[string]string
+
+// Fingerprint adds a copy of every file in the FileSystem matching any of the
+// given glob patterns under a name that carries a hash of its contents,
+// for instance, /static/app.css becomes /static/app.3f9ab2c1.css.
+// As the names change whenever the contents change, fingerprinted assets
+// can be served with far-future cache headers. If no patterns are given,
+// all files are fingerprinted. The original files are retained and files
+// that are already fingerprinted copies are skipped.
+func Fingerprint(fs FileSystem, patterns ...string) (AssetMap, error) {
+	var paths []string
+	if len(patterns) == 0 {
+		paths = fs.List()
+	} else {
+		for _, p := range patterns {
+			m, err := fs.Glob(p)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, m...)
+		}
+	}
+
+	out := make(AssetMap, len(paths))
+	for _, p := range paths {
+		if _, ok := out[p]; ok {
+			continue
+		}
+
+		f, err := fs.Get(p)
+		if err != nil {
+			return nil, err
+		}
+
+		// Loading the file releases it (see File.Close).
+		if err := f.load(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		b := f.b
+		if isFingerprinted(p, b) {
+			continue
+		}
+		fp := fingerprintPath(p, b)
+
+		// Add the fingerprinted copy unless it's already there.
+		if c, err := fs.Get(fp); err != nil {
+			if err := fs.Add(newFile(fp, f.info, b)); err != nil {
+				return nil, err
+			}
+		} else {
+			c.Close()
+		}
+		out[p] = fp
+	}
+
+	return out, nil
+}
+
+// URL returns the fingerprinted path of the given logical path. If the
+// path has not been fingerprinted, it is returned as-is.
+func (a AssetMap) URL(p string) string {
+	if fp, ok := a[cleanPath("/", p)]; ok {
+		return fp
+	}
+	return p
+}
+
+// FuncMap returns a template.FuncMap with an `asset` function that
+// resolves logical paths to fingerprinted paths in templates,
+// eg: {{ asset "/static/app.css" }}.
+func (a AssetMap) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"asset": a.URL,
+	}
+}
+
+// AssetFuncs returns a template.FuncMap with functions for referencing
+// files in the FileSystem from templates. The optional AssetMap is used
+// to resolve fingerprinted URLs.
+//
+//	asset "/static/app.css"   fingerprinted URL of the file.
+//	inline "/static/app.css"  contents of the file as a string (escaped by html/template).
+//	svg "/static/logo.svg"    contents of the file as unescaped template.HTML.
+//	json "/data/config.json"  contents of the file decoded from JSON.
+func AssetFuncs(fs FileSystem, a AssetMap) template.FuncMap {
+	return template.FuncMap{
+		"asset": a.URL,
+		"inline": func(p string) (string, error) {
+			b, err := fs.Read(p)
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", p, err)
+			}
+			return string(b), nil
+		},
+		"svg": func(p string) (template.HTML, error) {
+			b, err := fs.Read(p)
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", p, err)
+			}
+			return template.HTML(b), nil
+		},
+		"json": func(p string) (interface{}, error) {
+			b, err := fs.Read(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+
+			var out interface{}
+			if err := json.Unmarshal(b, &out); err != nil {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+			return out, nil
+		},
+	}
+}
+
+// Rewrite replaces the logical paths in the given HTML (or any other
+// text) with their fingerprinted paths. Only whole URLs bounded by quotes,
+// parentheses, whitespace, ? or # are replaced, so /static/app.css doesn't
+// rewrite /lib/static/app.css or /static/app.css.map.
+func (a AssetMap) Rewrite(b []byte) []byte {
+	if len(a) == 0 {
+		return b
+	}
+
+	// Match longer paths first so that a path that is a prefix of
+	// another (eg: /app.js and /app.js.map) doesn't clobber it.
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		keys = append(keys, regexp.QuoteMeta(k))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+	re := regexp.MustCompile("(?:^|[\\s\"'`()])(" + strings.Join(keys, "|") + ")")
+
+	var (
+		out  []byte
+		last int
+	)
+	for _, m := range re.FindAllSubmatchIndex(b, -1) {
+		start, end := m[2], m[3]
+		if end < len(b) && !isURLEnd(b[end]) {
+			continue
+		}
+		out = append(out, b[last:start]...)
+		out = append(out, a[string(b[start:end])]...)
+		last = end
+	}
+	return append(out, b[last:]...)
+}
+
+// isURLEnd checks whether a character ends a URL in Rewrite.
+func isURLEnd(c byte) bool {
+	return strings.IndexByte(" \t\r\n\"'`()?#", c) >= 0
+}
+
+// isFingerprinted checks whether a path is the fingerprinted
+// copy of a file with the given bytes.
+func isFingerprinted(p string, b []byte) bool {
+	var (
+		ext  = path.Ext(p)
+		base = strings.TrimSuffix(p, ext)
+		hash = path.Ext(base)
+	)
+	if len(hash) != lenFingerprint+1 {
+		return false
+	}
+	return fingerprintPath(strings.TrimSuffix(base, hash)+ext, b) == p
+}
+
+// fingerprintPath inserts a short hash of the given bytes into
+// the file name before its extension.
+func fingerprintPath(p string, b []byte) string {
+	var (
+		h   = sha256.Sum256(b)
+		ext = path.Ext(p)
+	)
+	return strings.TrimSuffix(p, ext) + "." + hex.EncodeToString(h[:])[:lenFingerprint] + ext
+}
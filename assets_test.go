@@ -0,0 +1,92 @@
+package stuffbin
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	fs, err := NewLocalFS("/", "mock/bar.txt:/static/bar.txt", "mock/foo.txt:/static/foo.txt")
+	assert(t, "error creating local FS", nil, err)
+
+	a, err := Fingerprint(fs, "/static/bar.txt")
+	assert(t, "error fingerprinting", nil, err)
+	assert(t, "fingerprint count", 1, len(a))
+
+	fp := a.URL("/static/bar.txt")
+	assert(t, "fingerprinted path", fingerprintPath("/static/bar.txt", []byte("bar")), fp)
+	assert(t, "unfingerprinted path", "/static/foo.txt", a.URL("/static/foo.txt"))
+
+	b, err := fs.Read(fp)
+	assert(t, "error reading fingerprinted file", nil, err)
+	assert(t, "fingerprinted file contents", "bar", string(b))
+	assert(t, "file count", 3, fs.Len())
+
+	// Rewrite.
+	assert(t, "rewritten HTML", `<link href="`+fp+`" /><a href="/static/foo.txt">`,
+		string(a.Rewrite([]byte(`<link href="/static/bar.txt" /><a href="/static/foo.txt">`))))
+
+	// Only whole URLs are rewritten.
+	assert(t, "rewritten partial URLs", `<script src="/lib/static/bar.txt"></script> url(/static/bar.txt.map) `+fp+`?v=1`,
+		string(a.Rewrite([]byte(`<script src="/lib/static/bar.txt"></script> url(/static/bar.txt.map) /static/bar.txt?v=1`))))
+	assert(t, "rewritten adjacent URLs", `(`+fp+`) `+fp+`#top '`+fp+`'`,
+		string(a.Rewrite([]byte(`(/static/bar.txt) /static/bar.txt#top '/static/bar.txt'`))))
+
+	// Fingerprinted copies aren't fingerprinted again.
+	a, err = Fingerprint(fs)
+	assert(t, "error fingerprinting", nil, err)
+	assert(t, "fingerprint count after fingerprinting again", 2, len(a))
+	assert(t, "file count after fingerprinting again", 4, fs.Len())
+	a, err = Fingerprint(fs)
+	assert(t, "error fingerprinting", nil, err)
+	assert(t, "file count after fingerprinting thrice", 4, fs.Len())
+
+	// Template func.
+	tpl, err := template.New("").Funcs(a.FuncMap()).Parse(`{{ asset "/static/bar.txt" }}`)
+	assert(t, "error parsing template", nil, err)
+	buf := bytes.Buffer{}
+	err = tpl.Execute(&buf, nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template", fp, buf.String())
+}
+
+func TestAssetFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stuffbin")
+	assert(t, "error creating temp dir", nil, err)
+	defer os.RemoveAll(dir)
+
+	for name, b := range map[string]string{
+		"logo.svg":    `<svg></svg>`,
+		"config.json": `{"name": "stuffbin"}`,
+		"app.css":     `a{}`,
+	} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(b), 0644)
+		assert(t, "error writing file", nil, err)
+	}
+
+	fs, err := NewLocalFS("/", dir+":/static")
+	assert(t, "error creating local FS", nil, err)
+
+	a, err := Fingerprint(fs, "/static/*.css")
+	assert(t, "error fingerprinting", nil, err)
+
+	tpl, err := template.New("").Funcs(AssetFuncs(fs, a)).Parse(
+		`{{ asset "/static/app.css" }}|{{ svg "/static/logo.svg" }}|{{ inline "/static/logo.svg" }}|{{ (json "/static/config.json").name }}`)
+	assert(t, "error parsing template", nil, err)
+
+	b := bytes.Buffer{}
+	err = tpl.Execute(&b, nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template",
+		a.URL("/static/app.css")+`|<svg></svg>|&lt;svg&gt;&lt;/svg&gt;|stuffbin`, b.String())
+
+	// Missing files should fail execution.
+	tpl, err = template.New("").Funcs(AssetFuncs(fs, nil)).Parse(`{{ inline "/nope" }}`)
+	assert(t, "error parsing template", nil, err)
+	err = tpl.Execute(&b, nil)
+	assert(t, "expected error on missing file", true, err != nil)
+}
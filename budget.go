@@ -0,0 +1,200 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxOffenders is the number of largest files that are listed in a
+// SizeError when the payload exceeds StuffOpt.MaxSize.
+const maxOffenders = 10
+
+// SizeError is returned when the stuffed payload exceeds StuffOpt.MaxSize
+// or files exceed StuffOpt.MaxFileSize.
+type SizeError struct {
+	// Size is the size of the payload if it exceeds MaxSize, or else 0.
+	Size    int64
+	MaxSize int64
+
+	// Files are the files that exceed MaxFileSize or, if there are none,
+	// the largest files in the payload. They're sorted by their size.
+	Files       []FileSize
+	MaxFileSize int64
+}
+
+// FileSize is the path and the uncompressed size of a stuffed file.
+type FileSize struct {
+	Path string
+	Size int64
+}
+
+// Error returns the exceeded limits and the files that are responsible.
+func (e *SizeError) Error() string {
+	var s strings.Builder
+	if e.Size > 0 {
+		fmt.Fprintf(&s, "payload size %s exceeds the max size %s", formatSize(e.Size), formatSize(e.MaxSize))
+	} else {
+		fmt.Fprintf(&s, "%d file(s) exceed the max file size %s", len(e.Files), formatSize(e.MaxFileSize))
+	}
+
+	if len(e.Files) > 0 {
+		s.WriteString(". Largest files: ")
+		for n, f := range e.Files {
+			if n > 0 {
+				s.WriteString(", ")
+			}
+			fmt.Fprintf(&s, "%s (%s)", f.Path, formatSize(f.Size))
+		}
+	}
+	return s.String()
+}
+
+// budget records the sizes of the files that are stuffed to check them
+// against StuffOpt.MaxSize and StuffOpt.MaxFileSize.
+type budget struct {
+	o     StuffOpt
+	files []FileSize
+}
+
+// newBudget returns a budget for the options and the options with a
+// Progress function that records the files, or nil if there are no limits.
+func newBudget(o StuffOpt) (*budget, StuffOpt) {
+	if o.MaxSize <= 0 && o.MaxFileSize <= 0 {
+		return nil, o
+	}
+
+	b := &budget{o: o}
+	fn := o.Progress
+	o.Progress = func(e Event) {
+		if e.Type == EventFileDone {
+			b.files = append(b.files, FileSize{Path: e.Path, Size: e.Size})
+		}
+		if fn != nil {
+			fn(e)
+		}
+	}
+	return b, o
+}
+
+// check returns a SizeError if the payload of the given size
+// or any of the recorded files exceed the limits.
+func (b *budget) check(size int64) error {
+	if b == nil {
+		return nil
+	}
+
+	sort.SliceStable(b.files, func(i, j int) bool {
+		return b.files[i].Size > b.files[j].Size
+	})
+
+	var big []FileSize
+	if b.o.MaxFileSize > 0 {
+		for _, f := range b.files {
+			if f.Size <= b.o.MaxFileSize {
+				break
+			}
+			big = append(big, f)
+		}
+	}
+
+	if b.o.MaxSize > 0 && size > b.o.MaxSize {
+		if len(big) == 0 {
+			big = b.files
+			if len(big) > maxOffenders {
+				big = big[:maxOffenders]
+			}
+		}
+		return &SizeError{Size: size, MaxSize: b.o.MaxSize, Files: big, MaxFileSize: b.o.MaxFileSize}
+	}
+	if len(big) > 0 {
+		return &SizeError{Files: big, MaxFileSize: b.o.MaxFileSize}
+	}
+
+	return nil
+}
+
+// checkUnzipSize returns a SizeError if the uncompressed sizes of the
+// files in a ZIP exceed UnStuffOpt.MaxFileSize or UnStuffOpt.MaxSize. The
+// sizes in the ZIP's headers are enforced by archive/zip as files are read.
+func checkUnzipSize(files []*zip.File, o UnStuffOpt) error {
+	if o.MaxSize <= 0 && o.MaxFileSize <= 0 {
+		return nil
+	}
+
+	var (
+		sizes = make([]FileSize, 0, len(files))
+		total uint64
+	)
+	for _, f := range files {
+		size := f.UncompressedSize64
+		if _, _, s, ok := encExtra(f); ok {
+			size = s
+		}
+		total += size
+
+		// Sizes beyond int64 are capped to be reported.
+		if size > math.MaxInt64 {
+			size = math.MaxInt64
+		}
+		sizes = append(sizes, FileSize{Path: f.Name, Size: int64(size)})
+	}
+	if total > math.MaxInt64 {
+		total = math.MaxInt64
+	}
+
+	b := &budget{o: StuffOpt{MaxSize: o.MaxSize, MaxFileSize: o.MaxFileSize}, files: sizes}
+	return b.check(int64(total))
+}
+
+// zstdMaxSize returns the max size that a CodecZstd payload may decompress
+// to with UnStuffOpt.MaxSize, which leaves room for the ZIP's headers.
+func zstdMaxSize(o UnStuffOpt) uint64 {
+	if o.MaxSize <= 0 {
+		return 0
+	}
+	return uint64(o.MaxSize) * 2
+}
+
+// ParseSize parses a size in bytes with an optional K, M, or G suffix
+// (eg: 512K, 50MB, 1.5G) in powers of 1024.
+func ParseSize(s string) (int64, error) {
+	var (
+		str  = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+		mult = float64(1)
+	)
+	switch {
+	case strings.HasSuffix(str, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(str, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(str, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		str = str[:len(str)-1]
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(n * mult), nil
+}
+
+// formatSize formats a size in bytes in the largest
+// unit (B, KB, MB, GB) it's at least one of.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%0.2f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%0.2f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%0.2f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
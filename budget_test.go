@@ -0,0 +1,51 @@
+package stuffbin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStuffMaxSize(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "stuffed")
+
+	_, _, err := StuffWithOpt(mockBin, out, StuffOpt{MaxSize: 1 << 20, MaxFileSize: 1 << 20}, localFiles...)
+	assert(t, "error stuffing within limits", nil, err)
+
+	// The payload is over the limit and the largest files are listed.
+	_, _, err = StuffWithOpt(mockBin, out, StuffOpt{MaxSize: 10}, localFiles...)
+	e, ok := err.(*SizeError)
+	assert(t, "expected SizeError", true, ok)
+	assert(t, "expected payload size", true, e.Size > 10)
+	assert(t, "file count", len(localFiles), len(e.Files))
+	assert(t, "expected largest file first", "/mock/foo.txt", e.Files[0].Path)
+
+	// Only the files over the limit are listed.
+	stat, err := os.Stat("mock/foo.txt")
+	assert(t, "error reading file", nil, err)
+	_, _, err = StuffWithOpt(mockBin, out, StuffOpt{MaxFileSize: stat.Size() - 1}, localFiles...)
+	e, ok = err.(*SizeError)
+	assert(t, "expected SizeError", true, ok)
+	assert(t, "mismatch in files", []FileSize{{Path: "/mock/foo.txt", Size: stat.Size()}}, e.Files)
+
+	// The existing output is left intact.
+	_, err = UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+}
+
+func TestParseSize(t *testing.T) {
+	for s, n := range map[string]int64{
+		"100":   100,
+		"512K":  512 << 10,
+		"50MB":  50 << 20,
+		"1.5g":  3 << 29,
+		" 2 M ": 2 << 20,
+	} {
+		v, err := ParseSize(s)
+		assert(t, "error parsing size", nil, err)
+		assert(t, "mismatch in size "+s, n, v)
+	}
+
+	_, err := ParseSize("10X")
+	assert(t, "expected error on invalid size", true, err != nil)
+}
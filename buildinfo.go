@@ -0,0 +1,107 @@
+package stuffbin
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Keys of the build metadata in the Meta of a stuffed binary's ID.
+const (
+	MetaVersion   = "version"
+	MetaCommit    = "commit"
+	MetaBuildTime = "build_time"
+)
+
+// BuildInfo is the build metadata of the assets stuffed into a binary,
+// for instance, the version and the commit of a frontend build. It's
+// stored in the Meta of the binary's ID (see StuffOpt.Meta).
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildTime time.Time
+
+	// Extra is the rest of the metadata.
+	Extra map[string]string
+}
+
+// NewBuildInfo returns a BuildInfo with the given version and commit
+// built at the current time, or at the SOURCE_DATE_EPOCH (Unix
+// timestamp) environment variable if it's set for reproducible builds.
+func NewBuildInfo(version, commit string) BuildInfo {
+	t := time.Now()
+	if s := os.Getenv("SOURCE_DATE_EPOCH"); s != "" {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			t = time.Unix(n, 0)
+		}
+	}
+
+	return BuildInfo{Version: version, Commit: commit, BuildTime: t.UTC()}
+}
+
+// Meta returns the build info as metadata to be stuffed with StuffOpt.Meta.
+func (b BuildInfo) Meta() map[string]string {
+	out := make(map[string]string, len(b.Extra)+3)
+	for k, v := range b.Extra {
+		out[k] = v
+	}
+	if b.Version != "" {
+		out[MetaVersion] = b.Version
+	}
+	if b.Commit != "" {
+		out[MetaCommit] = b.Commit
+	}
+	if !b.BuildTime.IsZero() {
+		out[MetaBuildTime] = b.BuildTime.Format(time.RFC3339)
+	}
+	return out
+}
+
+// GetBuildInfo returns the build info in the ID of a stuffed binary.
+// A build time that can't be parsed as RFC3339 is left in Extra.
+func GetBuildInfo(path string) (BuildInfo, error) {
+	id, err := GetFileID(path)
+	if err != nil {
+		return BuildInfo{}, err
+	}
+
+	return parseBuildInfo(id.Extra().Meta), nil
+}
+
+// ReadBuildInfo returns the build info of the assets stuffed into the
+// running executable, for instance, to report them in a /version endpoint.
+func ReadBuildInfo() (BuildInfo, error) {
+	path, err := selfPath()
+	if err != nil {
+		return BuildInfo{}, err
+	}
+
+	return GetBuildInfo(path)
+}
+
+// parseBuildInfo returns the build info in the given metadata.
+func parseBuildInfo(meta map[string]string) BuildInfo {
+	var b BuildInfo
+	for k, v := range meta {
+		switch k {
+		case MetaVersion:
+			b.Version = v
+			continue
+		case MetaCommit:
+			b.Commit = v
+			continue
+		case MetaBuildTime:
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				b.BuildTime = t
+				continue
+			}
+		}
+
+		if b.Extra == nil {
+			b.Extra = make(map[string]string)
+		}
+		b.Extra[k] = v
+	}
+
+	return b
+}
@@ -0,0 +1,40 @@
+package stuffbin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildInfo(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "stuffed")
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	b := NewBuildInfo("1.2.0", "abc123")
+	assert(t, "mismatch in build time", time.Unix(1700000000, 0).UTC(), b.BuildTime)
+	b.Extra = map[string]string{"env": "prod"}
+
+	_, _, err := StuffWithOpt(mockBin, out, StuffOpt{Meta: b.Meta()}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	got, err := GetBuildInfo(out)
+	assert(t, "error getting build info", nil, err)
+	assert(t, "mismatch in build info", b, got)
+
+	// Binaries without build info have none.
+	_, _, err = Stuff(mockBin, out, "/", localFiles...)
+	assert(t, "error stuffing", nil, err)
+	got, err = GetBuildInfo(out)
+	assert(t, "error getting build info", nil, err)
+	assert(t, "mismatch in empty build info", BuildInfo{}, got)
+
+	_, err = GetBuildInfo(mockBin)
+	assert(t, "expected ErrNoID", ErrNoID, err)
+
+	// The test binary isn't stuffed.
+	if _, err := os.Executable(); err == nil {
+		_, err = ReadBuildInfo()
+		assert(t, "expected ErrNoID", ErrNoID, err)
+	}
+}
@@ -0,0 +1,79 @@
+package stuffbin
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MetaBundle is the prefix of the keys of named bundles in the Meta of a
+// stuffed binary's ID, eg: bundle.docs=/docs/**,/samples/**.
+const MetaBundle = "bundle."
+
+// GetStuffNames returns the sorted names of the bundles in a stuffed
+// binary (see StuffOpt.Bundles).
+func GetStuffNames(path string) ([]string, error) {
+	id, err := GetFileID(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for k := range id.Extra().Meta {
+		if name := strings.TrimPrefix(k, MetaBundle); name != k {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// UnStuffBundle is UnStuffPaths for the files in the named bundle of a
+// stuffed binary (see StuffOpt.Bundles), for instance, to load the "core"
+// assets of an app on startup and its "docs" only when they're requested.
+func UnStuffBundle(path, name string) (FileSystem, error) {
+	return UnStuffBundleWithOpt(path, name, UnStuffOpt{})
+}
+
+// UnStuffBundleWithOpt is UnStuffBundle with UnStuffOpt options.
+func UnStuffBundleWithOpt(path, name string, o UnStuffOpt) (FileSystem, error) {
+	id, err := GetFileID(path)
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := id.Extra().Meta[MetaBundle+name]
+	if !ok {
+		return nil, fmt.Errorf("unknown bundle '%s'", name)
+	}
+	return UnStuffPathsWithOpt(path, o, strings.Split(v, ",")...)
+}
+
+// bundleMeta returns the given metadata with the bundles added to it.
+func bundleMeta(meta map[string]string, bundles map[string][]string) (map[string]string, error) {
+	out := make(map[string]string, len(meta)+len(bundles))
+	for k, v := range meta {
+		out[k] = v
+	}
+
+	for name, patterns := range bundles {
+		if name == "" || strings.ContainsAny(name, ",=") {
+			return nil, fmt.Errorf("invalid bundle name '%s'", name)
+		}
+		if len(patterns) == 0 {
+			return nil, fmt.Errorf("bundle '%s' has no patterns", name)
+		}
+		for _, p := range patterns {
+			if strings.Contains(p, ",") {
+				return nil, errors.New("bundle patterns can't have commas")
+			}
+			if err := checkPattern(p); err != nil {
+				return nil, err
+			}
+		}
+		out[MetaBundle+name] = strings.Join(patterns, ",")
+	}
+
+	return out, nil
+}
@@ -0,0 +1,53 @@
+package stuffbin
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestUnStuffBundle(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "bundle.exe")
+	o := StuffOpt{
+		Meta: map[string]string{MetaVersion: "1.0.0"},
+		Bundles: map[string][]string{
+			"core": {"/mock/foo.txt"},
+			"docs": {"/mock/b*.txt", "/mock/subdir/**"},
+		},
+	}
+	_, _, err := StuffWithOpt(mockBin, out, o, "mock/foo.txt", "mock/bar.txt", "mock/subdir")
+	assert(t, "error stuffing", nil, err)
+
+	names, err := GetStuffNames(out)
+	assert(t, "error getting names", nil, err)
+	assert(t, "mismatch in names", []string{"core", "docs"}, names)
+
+	fs, err := UnStuffBundle(out, "docs")
+	assert(t, "error unstuffing bundle", nil, err)
+	files := fs.List()
+	sort.Strings(files)
+	assert(t, "mismatch in files", []string{"/mock/bar.txt", "/mock/subdir/baz.txt"}, files)
+
+	fs, err = UnStuffBundle(out, "core")
+	assert(t, "error unstuffing bundle", nil, err)
+	assert(t, "mismatch in files", []string{"/mock/foo.txt"}, fs.List())
+
+	_, err = UnStuffBundle(out, "samples")
+	assert(t, "unstuffed an unknown bundle", true, err != nil)
+
+	// The rest of the metadata is kept.
+	id, err := GetFileID(out)
+	assert(t, "error getting ID", nil, err)
+	assert(t, "mismatch in meta", "1.0.0", id.Extra().Meta[MetaVersion])
+
+	// Invalid bundles.
+	for _, b := range []map[string][]string{
+		{"": {"/a"}},
+		{"a": nil},
+		{"a": {"/a,b"}},
+		{"a": {"/[a"}},
+	} {
+		_, _, err := StuffWithOpt(mockBin, out, StuffOpt{Bundles: b}, "mock")
+		assert(t, "stuffed an invalid bundle", true, err != nil)
+	}
+}
@@ -0,0 +1,125 @@
+package stuffbin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec represents the compression format of a stuffed payload.
+type Codec uint8
+
+const (
+	// CodecZip is a ZIP archive of DEFLATE compressed files.
+	CodecZip Codec = iota
+
+	// CodecZstd is a ZIP archive of uncompressed files that is compressed
+	// as a whole with Zstandard. This compresses large bundles with many
+	// files better and decompresses several times faster than CodecZip.
+	CodecZstd
+)
+
+// String returns the name of the codec.
+func (c Codec) String() string {
+	switch c {
+	case CodecZip:
+		return "zip"
+	case CodecZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(c))
+}
+
+// ParseCodec returns the Codec for the given name (zip, zstd).
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "zip", "":
+		return CodecZip, nil
+	case "zstd":
+		return CodecZstd, nil
+	}
+	return 0, fmt.Errorf("unknown codec '%s'", name)
+}
+
+// newPayloadWriter returns a writer that compresses the ZIP bytes
+// written to it with the given codec into w. The writer should be closed
+// to flush the payload. level is the codec specific compression level.
+// 0 is the default.
+func newPayloadWriter(c Codec, w io.Writer, level int) (io.WriteCloser, error) {
+	switch c {
+	case CodecZip:
+		return nopWriteCloser{w}, nil
+	case CodecZstd:
+		opts := []zstd.EOption{}
+		if level > 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	}
+	return nil, fmt.Errorf("unknown codec %d", c)
+}
+
+// newPayloadReader returns a reader that decompresses a payload
+// of the given codec from r into ZIP bytes.
+func newPayloadReader(c Codec, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CodecZip:
+		return io.NopCloser(r), nil
+	case CodecZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown codec %d", c)
+}
+
+// decodePayload decompresses a payload of the given codec and returns
+// the ZIP bytes. Payloads that decompress to more than the optional max
+// size fail with zstd.ErrDecoderSizeExceeded.
+func decodePayload(c Codec, b []byte, max uint64) ([]byte, error) {
+	switch c {
+	case CodecZip:
+		return b, nil
+	case CodecZstd:
+		if max == 0 {
+			dec, err := zstd.NewReader(nil)
+			if err != nil {
+				return nil, err
+			}
+			defer dec.Close()
+			return dec.DecodeAll(b, nil)
+		}
+
+		// Payloads are streamed to stop at the max size as the
+		// size in the frame header may be missing or wrong.
+		dec, err := zstd.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+
+		out := &bytes.Buffer{}
+		if _, err := io.Copy(out, io.LimitReader(dec, int64(max)+1)); err != nil {
+			return nil, err
+		}
+		if uint64(out.Len()) > max {
+			return nil, zstd.ErrDecoderSizeExceeded
+		}
+		return out.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown codec %d", c)
+}
+
+// nopWriteCloser is an io.Writer with a no-op Close.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing.
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,138 @@
+package stuffbin
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"sort"
+)
+
+// Kinds of the changes to the files between two stuffed binaries.
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// Change is a file that differs between two stuffed binaries.
+type Change struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+
+	// OldSize and NewSize are the sizes of the file in the old and the
+	// new binaries, which are 0 for added and removed files.
+	OldSize int64 `json:"old_size"`
+	NewSize int64 `json:"new_size"`
+
+	// OldSum and NewSum are the SHA-256 hashes of the file's contents.
+	OldSum [32]byte `json:"-"`
+	NewSum [32]byte `json:"-"`
+}
+
+// Delta returns the change in the size of the file.
+func (c Change) Delta() int64 {
+	return c.NewSize - c.OldSize
+}
+
+// CompareStuff compares the files in two stuffed binaries by the SHA-256
+// hashes of their contents, which are streamed from the payloads (see
+// WalkStuff), and returns the files that were added, removed, or modified
+// in the new binary sorted by path. Encrypted payloads of both binaries are
+// decrypted with UnStuffOpt.Key.
+func CompareStuff(oldPath, newPath string, o UnStuffOpt) ([]Change, error) {
+	old, err := hashStuff(oldPath, o)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := hashStuff(newPath, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Change
+	for p, n := range cur {
+		c := Change{Path: p, NewSize: n.Size, NewSum: n.Sum}
+		if prev, ok := old[p]; !ok {
+			c.Kind = ChangeAdded
+		} else if prev.Sum != n.Sum {
+			c.Kind, c.OldSize, c.OldSum = ChangeModified, prev.Size, prev.Sum
+		} else {
+			continue
+		}
+		out = append(out, c)
+	}
+	for p, prev := range old {
+		if _, ok := cur[p]; !ok {
+			out = append(out, Change{Path: p, Kind: ChangeRemoved, OldSize: prev.Size, OldSum: prev.Sum})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Path < out[j].Path
+	})
+	return out, nil
+}
+
+// FileSum is the size and the SHA-256 hash of the contents
+// of a file in a stuffed binary.
+type FileSum struct {
+	Path string
+	Size int64
+	Sum  [32]byte
+}
+
+// Checksums are the SHA-256 hashes of the payload of a stuffed
+// binary and of its files.
+type Checksums struct {
+	// Payload is the hash of the payload as it's stored in the binary (or
+	// its sidecar), which is ID.Checksum for payloads stuffed with
+	// StuffOpt.Checksum.
+	Payload [32]byte
+
+	// Files are the hashes of the files sorted by path.
+	Files []FileSum
+}
+
+// ChecksumStuff returns the SHA-256 hashes of the payload of a stuffed
+// binary and of the contents of its files, which are streamed from the
+// payload (see WalkStuff), for instance, to record the provenance of the
+// assets of a release. Encrypted payloads are decrypted with
+// UnStuffOpt.Key to hash their files.
+func ChecksumStuff(path string, o UnStuffOpt) (Checksums, error) {
+	_, b, err := getStored(path)
+	if err != nil {
+		return Checksums{}, err
+	}
+
+	files, err := hashStuff(path, o)
+	if err != nil {
+		return Checksums{}, err
+	}
+
+	out := Checksums{Payload: sha256.Sum256(b), Files: make([]FileSum, 0, len(files))}
+	for _, f := range files {
+		out.Files = append(out.Files, f)
+	}
+	sort.Slice(out.Files, func(i, j int) bool {
+		return out.Files[i].Path < out.Files[j].Path
+	})
+	return out, nil
+}
+
+// hashStuff returns the sizes and the hashes of the files in a stuffed binary.
+func hashStuff(path string, o UnStuffOpt) (map[string]FileSum, error) {
+	out := make(map[string]FileSum)
+	err := WalkStuff(path, o, func(p string, _ os.FileInfo, r io.Reader) error {
+		h := sha256.New()
+		n, err := io.Copy(h, r)
+		if err != nil {
+			return err
+		}
+
+		s := FileSum{Path: p, Size: n}
+		copy(s.Sum[:], h.Sum(nil))
+		out[p] = s
+		return nil
+	})
+	return out, err
+}
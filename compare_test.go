@@ -0,0 +1,79 @@
+package stuffbin
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareStuff(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		oldBin = filepath.Join(dir, "old.exe")
+		newBin = filepath.Join(dir, "new.exe")
+	)
+	_, _, err := Stuff(mockBin, oldBin, "/", "mock/foo.txt", "mock/bar.txt", "mock/subdir/baz.txt")
+	assert(t, "error stuffing", nil, err)
+	_, _, err = StuffWithOpt(mockBin, newBin, StuffOpt{Codec: CodecZstd}, "mock/foo.txt",
+		"mock/foofunc.txt:/mock/bar.txt", "mock/foofunc.txt")
+	assert(t, "error stuffing", nil, err)
+
+	changes, err := CompareStuff(oldBin, newBin, UnStuffOpt{})
+	assert(t, "error comparing", nil, err)
+
+	size := func(p string) int64 {
+		st, err := os.Stat(p)
+		assert(t, "error reading file", nil, err)
+		return st.Size()
+	}
+	exp := []struct {
+		path, kind       string
+		oldSize, newSize int64
+	}{
+		{"/mock/bar.txt", ChangeModified, size("mock/bar.txt"), size("mock/foofunc.txt")},
+		{"/mock/foofunc.txt", ChangeAdded, 0, size("mock/foofunc.txt")},
+		{"/mock/subdir/baz.txt", ChangeRemoved, size("mock/subdir/baz.txt"), 0},
+	}
+	assert(t, "mismatch in change count", len(exp), len(changes))
+	for n, e := range exp {
+		c := changes[n]
+		assert(t, "mismatch in path", e.path, c.Path)
+		assert(t, "mismatch in kind", e.kind, c.Kind)
+		assert(t, "mismatch in old size", e.oldSize, c.OldSize)
+		assert(t, "mismatch in new size", e.newSize, c.NewSize)
+		assert(t, "mismatch in delta", e.newSize-e.oldSize, c.Delta())
+	}
+
+	changes, err = CompareStuff(oldBin, oldBin, UnStuffOpt{})
+	assert(t, "error comparing", nil, err)
+	assert(t, "mismatch in change count", 0, len(changes))
+
+	_, err = CompareStuff(mockBin, newBin, UnStuffOpt{})
+	assert(t, "compared an unstuffed binary", ErrNoID, err)
+}
+
+func TestChecksumStuff(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "app")
+	_, _, err := StuffWithOpt(mockBin, bin, StuffOpt{Checksum: true, Passphrase: "secret"}, "mock/foo.txt", "mock/bar.txt")
+	assert(t, "error stuffing", nil, err)
+
+	sums, err := ChecksumStuff(bin, UnStuffOpt{Key: Key([]byte("secret"))})
+	assert(t, "error getting checksums", nil, err)
+
+	id, err := GetFileID(bin)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "mismatch in payload checksum", id.Checksum, sums.Payload)
+
+	assert(t, "mismatch in file count", 2, len(sums.Files))
+	for n, p := range []string{"mock/bar.txt", "mock/foo.txt"} {
+		b, err := os.ReadFile(p)
+		assert(t, "error reading file", nil, err)
+		assert(t, "mismatch in path", "/"+p, sums.Files[n].Path)
+		assert(t, "mismatch in size", int64(len(b)), sums.Files[n].Size)
+		assert(t, "mismatch in checksum", sha256.Sum256(b), sums.Files[n].Sum)
+	}
+
+	_, err = ChecksumStuff(bin, UnStuffOpt{})
+	assert(t, "hashed encrypted files without a key", ErrNoKey, err)
+}
@@ -0,0 +1,255 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// dictName is the name of the entry in a payload ZIP that has the
+	// dictionary of the files compressed with it (see StuffOpt.Dictionary).
+	// Target paths are absolute, so it can't collide with files.
+	dictName = ".stuffbin.dict"
+
+	// dictMethod is the ZIP compression method of files that are
+	// compressed with Zstandard and the payload's dictionary.
+	dictMethod uint16 = 0x7364
+
+	// maxDictSize is the max size of trained dictionaries. They're
+	// also limited to a fraction (1/dictRatio) of the size of the samples
+	// as they're stored in the payload.
+	maxDictSize = 64 << 10
+	dictRatio   = 10
+
+	// maxDictSample is the max size of the beginning of a sample
+	// that's added to the content of a dictionary.
+	maxDictSample = 1 << 10
+)
+
+// zstdDictMagic is the beginning of dictionaries in the zstd format.
+var zstdDictMagic = []byte{0x37, 0xa4, 0x30, 0xec}
+
+// TrainDictionary trains a raw content compression dictionary on samples of
+// small files that have a lot in common, for instance, templates or locale
+// files. Lines that are shared by the samples and the beginnings of the
+// samples make up the dictionary. The dictionary can be shared across
+// builds with StuffOpt.DictionaryData.
+func TrainDictionary(samples [][]byte) ([]byte, error) {
+	// Count the samples that every line appears in.
+	var (
+		counts = map[string]int{}
+		lines  []string
+	)
+	for _, s := range samples {
+		seen := map[string]bool{}
+		for _, l := range bytes.SplitAfter(s, []byte("\n")) {
+			if len(l) < 8 || seen[string(l)] {
+				continue
+			}
+			seen[string(l)] = true
+			if counts[string(l)] == 0 {
+				lines = append(lines, string(l))
+			}
+			counts[string(l)]++
+		}
+	}
+
+	// Lines that are longer and shared by more samples save the most.
+	sort.SliceStable(lines, func(i, j int) bool {
+		return counts[lines[i]]*len(lines[i]) > counts[lines[j]]*len(lines[j])
+	})
+	max := 0
+	for _, s := range samples {
+		max += len(s)
+	}
+	if max /= dictRatio; max > maxDictSize {
+		max = maxDictSize
+	}
+
+	var (
+		parts [][]byte
+		size  int
+	)
+	for _, l := range lines {
+		if counts[l] > 1 && size+len(l) <= max {
+			parts = append(parts, []byte(l))
+			size += len(l)
+		}
+	}
+	for _, s := range samples {
+		if len(s) > maxDictSample {
+			s = s[:maxDictSample]
+		}
+		if size+len(s) <= max {
+			parts = append(parts, s)
+			size += len(s)
+		}
+	}
+
+	// The most valuable content goes last as it's
+	// the closest to the data that's compressed.
+	d := make([]byte, 0, size)
+	for i := len(parts) - 1; i >= 0; i-- {
+		d = append(d, parts[i]...)
+	}
+	if len(d) < 8 {
+		return nil, errors.New("not enough data in the samples to train a dictionary")
+	}
+
+	return d, nil
+}
+
+// dictOptions returns the zstd encoder and decoder options for a
+// dictionary in the zstd format (eg: zstd --train) or with raw content.
+func dictOptions(dict []byte) (zstd.EOption, zstd.DOption) {
+	if bytes.HasPrefix(dict, zstdDictMagic) {
+		return zstd.WithEncoderDict(dict), zstd.WithDecoderDicts(dict)
+	}
+
+	// Dictionary IDs below 32768 are reserved.
+	id := 32768 + crc32.ChecksumIEEE(dict)%(1<<31-32768)
+	return zstd.WithEncoderDictRaw(id, dict), zstd.WithDecoderDictRaw(id, dict)
+}
+
+// trainEntries trains a dictionary on the files in the given entries that
+// match o.Dictionary. It returns nil if there are no files or too little
+// data to train a dictionary on.
+func trainEntries(o StuffOpt, wo walkOpt, entries []stuffEntry) ([]byte, error) {
+	var samples [][]byte
+	for _, e := range entries {
+		if err := entryWalker(e)(func(r io.Reader, targetPath string, fInfo os.FileInfo, zf *zip.File) error {
+			if !matchAny(o.Dictionary, targetPath) {
+				return nil
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if len(b) > 0 {
+				samples = append(samples, b)
+			}
+			return nil
+		}, wo, e.path); err != nil {
+			return nil, err
+		}
+	}
+	if len(samples) == 0 {
+		return nil, nil
+	}
+
+	// Files are compressed without a dictionary if it can't be trained.
+	d, err := TrainDictionary(samples)
+	if err != nil {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// registerDictCompressor registers the compressor of dictMethod with
+// the given dictionary in a zip.Writer. The returned encoder should be
+// closed after the ZIP is written.
+func registerDictCompressor(zw *zip.Writer, dict []byte) (*zstd.Encoder, error) {
+	opt, _ := dictOptions(dict)
+	enc, err := zstd.NewWriter(nil, opt, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		return nil, fmt.Errorf("invalid dictionary: %v", err)
+	}
+
+	zw.RegisterCompressor(dictMethod, func(w io.Writer) (io.WriteCloser, error) {
+		return &dictWriter{w: w, enc: enc}, nil
+	})
+	return enc, nil
+}
+
+// readDict reads the dictionary of a payload ZIP, if it has one, and
+// registers the decompressor of dictMethod with it in the zip.Reader.
+// Files that decompress to more than the optional max size fail. The
+// returned decoder should be closed after the files are read.
+func readDict(r *zip.Reader, max uint64) ([]byte, *zstd.Decoder, error) {
+	var dict []byte
+	for _, f := range r.File {
+		if f.Name != dictName {
+			continue
+		}
+
+		rd, err := f.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		dict, err = io.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading dictionary: %v", err)
+		}
+		break
+	}
+	if dict == nil {
+		return nil, nil, nil
+	}
+
+	_, opt := dictOptions(dict)
+	opts := []zstd.DOption{opt}
+	if max > 0 {
+		opts = append(opts, zstd.WithDecoderMaxMemory(max))
+	}
+	dec, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid dictionary: %v", err)
+	}
+	r.RegisterDecompressor(dictMethod, func(r io.Reader) io.ReadCloser {
+		b, err := io.ReadAll(r)
+		if err == nil {
+			b, err = dec.DecodeAll(b, nil)
+		}
+		if err != nil {
+			return io.NopCloser(&errReader{err})
+		}
+		return io.NopCloser(bytes.NewReader(b))
+	})
+
+	return dict, dec, nil
+}
+
+// writeDict writes the dictionary entry to a payload ZIP.
+func writeDict(zw *zip.Writer, dict []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: dictName, Method: zip.Deflate})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(dict)
+	return err
+}
+
+// dictWriter buffers a small file and compresses it as a
+// whole with the dictionary's encoder when it's closed.
+type dictWriter struct {
+	w   io.Writer
+	enc *zstd.Encoder
+	buf bytes.Buffer
+}
+
+func (d *dictWriter) Write(b []byte) (int, error) {
+	return d.buf.Write(b)
+}
+
+func (d *dictWriter) Close() error {
+	_, err := d.w.Write(d.enc.EncodeAll(d.buf.Bytes(), nil))
+	return err
+}
+
+// errReader is an io.Reader that returns an error.
+type errReader struct {
+	err error
+}
+
+func (e *errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
@@ -0,0 +1,73 @@
+package stuffbin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStuffDictionary(t *testing.T) {
+	dir := t.TempDir()
+	tpl := filepath.Join(dir, "templates")
+	assert(t, "error creating dir", nil, os.Mkdir(tpl, 0755))
+
+	files := map[string]string{}
+	for i := 0; i < 200; i++ {
+		b := fmt.Sprintf("<div class=\"card\">\n  <h2 class=\"card-title\">{{ .Title }} %d</h2>\n  <p class=\"card-body\">{{ .Body }}</p>\n  <a href=\"/items/%d\" class=\"btn btn-primary\">View</a>\n</div>\n", i, i*7)
+		name := fmt.Sprintf("card%d.html", i)
+		assert(t, "error writing file", nil, os.WriteFile(filepath.Join(tpl, name), []byte(b), 0644))
+		files["/templates/"+name] = b
+	}
+
+	var (
+		out  = filepath.Join(dir, "dict.bin")
+		out2 = filepath.Join(dir, "nodict.bin")
+	)
+	_, zDict, err := StuffWithOpt(mockBin, out, StuffOpt{Dictionary: []string{"*.html"}}, tpl+":/templates", "mock/foo.txt")
+	assert(t, "error stuffing", nil, err)
+	_, zPlain, err := StuffWithOpt(mockBin, out2, StuffOpt{}, tpl+":/templates", "mock/foo.txt")
+	assert(t, "error stuffing", nil, err)
+	assert(t, "dictionary didn't shrink the payload", true, zDict < zPlain*3/4)
+
+	check := func(path string, n int) {
+		fs, err := UnStuff(path)
+		assert(t, "error unstuffing", nil, err)
+		assert(t, "file count", n, fs.Len())
+		for p, b := range files {
+			f, err := fs.Get(p)
+			assert(t, "error getting "+p, nil, err)
+			assert(t, "mismatch in "+p, b, string(f.ReadBytes()))
+		}
+		_, err = fs.Get("/" + dictName)
+		assert(t, "dictionary is listed as a file", true, err != nil)
+	}
+	check(out, len(files)+1)
+
+	// Unchanged files are copied with the same dictionary.
+	_, zIncr, err := StuffWithOpt(out, out, StuffOpt{Dictionary: []string{"*.html"}, Incremental: true}, tpl+":/templates", "mock/foo.txt")
+	assert(t, "error restuffing", nil, err)
+	assert(t, "mismatch in incremental size", zDict, zIncr)
+	check(out, len(files)+1)
+
+	// Added files keep the existing dictionary.
+	_, _, err = StuffAdd(out, out, "/", "mock/bar.txt")
+	assert(t, "error adding", nil, err)
+	check(out, len(files)+2)
+
+	// A shared dictionary is used as-is.
+	var samples [][]byte
+	for _, b := range files {
+		samples = append(samples, []byte(b))
+	}
+	d, err := TrainDictionary(samples)
+	assert(t, "error training dictionary", nil, err)
+	_, _, err = StuffWithOpt(mockBin, out, StuffOpt{Dictionary: []string{"*.html"}, DictionaryData: d}, tpl+":/templates")
+	assert(t, "error stuffing with a dictionary", nil, err)
+	check(out, len(files))
+
+	_, err = TrainDictionary(nil)
+	assert(t, "expected error without samples", true, err != nil)
+	_, _, err = StuffWithOpt(mockBin, out, StuffOpt{Dictionary: []string{"[*.html"}}, tpl)
+	assert(t, "expected error on invalid pattern", true, err != nil)
+}
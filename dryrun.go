@@ -0,0 +1,37 @@
+package stuffbin
+
+import "os"
+
+// dryRun compresses the payload of the given entries into a temporary file
+// instead of a binary and calls o.DryRun with the files in it (see
+// StuffOpt.DryRun). Payloads that are encrypted as a whole are compressed
+// without the encryption, so that they can be listed without the key. The
+// options should have been checked with checkStuffOpt.
+func dryRun(o StuffOpt, entries []stuffEntry, prev *prevPayload) (int64, int64, error) {
+	if !encryptFiles(o, entries) && (prev == nil || !prev.keep || !prev.encFiles) {
+		o.EncryptionKey, o.Passphrase, o.Recipients = nil, "", nil
+	}
+
+	f, err := os.CreateTemp("", "stuffbin-*")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	id, err := encodePayload(f, o, entries, prev)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := writeID(f, id, 0, nil); err != nil {
+		return 0, 0, err
+	}
+
+	files, err := ListStuff(f.Name(), UnStuffOpt{})
+	if err != nil {
+		return 0, 0, err
+	}
+	o.DryRun(files)
+
+	return 0, int64(id.ZipSize), nil
+}
@@ -0,0 +1,51 @@
+package stuffbin
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		bin   = filepath.Join(dir, "app")
+		files []Entry
+	)
+	o := StuffOpt{
+		Exclude:    []string{"baz.txt"},
+		Passphrase: "secret",
+		DryRun:     func(f []Entry) { files = f },
+	}
+	binLen, zipLen, err := StuffWithOpt(mockBin, bin, o, "mock/foo.txt:/static/foo.txt", "mock/subdir:/sub", "mock/bar.txt")
+	assert(t, "error in dry run", nil, err)
+	assert(t, "binary size", int64(0), binLen)
+	assert(t, "payload size", true, zipLen > 0)
+
+	_, err = os.Stat(bin)
+	assert(t, "wrote a binary", true, os.IsNotExist(err))
+
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.Path)
+		assert(t, "compressed size of "+f.Path, true, f.CompressedSize > 0)
+	}
+	sort.Strings(paths)
+	assert(t, "mismatch in dry run paths", []string{"/mock/bar.txt", "/static/foo.txt"}, paths)
+
+	// The limits are checked.
+	o.MaxSize = 1
+	_, _, err = StuffWithOpt(mockBin, bin, o, "mock/foo.txt")
+	_, ok := err.(*SizeError)
+	assert(t, "expected size error", true, ok)
+
+	// Targets are dry runs too.
+	o.MaxSize, files = 0, nil
+	zipLen, err = StuffTargets([]Target{{In: mockBin, Out: bin}}, o, "mock/foo.txt")
+	assert(t, "error in dry run", nil, err)
+	assert(t, "payload size", true, zipLen > 0)
+	assert(t, "mismatch in file count", 1, len(files))
+	_, err = os.Stat(bin)
+	assert(t, "wrote a binary", true, os.IsNotExist(err))
+}
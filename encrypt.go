@@ -0,0 +1,777 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// lenKey is the size of the AES-256 keys that payloads are encrypted with.
+	lenKey = 32
+
+	// encChunkSize is the size of the chunks that encrypted payloads are
+	// split into. Each chunk is sealed with AES-GCM separately so that
+	// payloads can be encrypted and decrypted as streams without holding
+	// them in memory. The nonce of a chunk is the nonce prefix in the ID (8)
+	// followed by the chunk's counter (3) and a byte that's 1 for the last
+	// chunk, which prevents chunks from being reordered or truncated.
+	encChunkSize = 64 * 1024
+
+	// maxEncChunks is the maximum number of chunks that fit the counter,
+	// which limits encrypted payloads to 1 TB.
+	maxEncChunks = 1 << 24
+
+	// scrypt parameters for deriving keys from passphrases.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrNoKey is returned when reading an encrypted payload without a key.
+var ErrNoKey = errors.New("payload is encrypted and no key was given")
+
+// ErrDecrypt is returned when an encrypted payload can't be decrypted.
+var ErrDecrypt = errors.New("error decrypting payload. The key may be wrong or the payload corrupt")
+
+// KeyFunc returns the secret that an encrypted payload with the given ID
+// is decrypted with. For payloads encrypted with a passphrase
+// (FlagPassphrase), it's the passphrase, for payloads encrypted to age
+// recipients (FlagAge), it's one or more age identities (AGE-SECRET-KEY-1...)
+// in the age identity file format, and for others, the 32 byte key.
+// As it's called at runtime, the secret can be read from the environment
+// (see KeyFromEnv), a KMS, or a prompt instead of being compiled into the
+// application.
+type KeyFunc func(id ID) ([]byte, error)
+
+// Key returns a KeyFunc that returns the given key or passphrase.
+func Key(secret []byte) KeyFunc {
+	return func(ID) ([]byte, error) {
+		return secret, nil
+	}
+}
+
+// KeyFromEnv returns a KeyFunc that reads the passphrase, the age
+// identity, or the hex encoded key, from the given environment variable.
+func KeyFromEnv(name string) KeyFunc {
+	return func(id ID) ([]byte, error) {
+		v := os.Getenv(name)
+		if v == "" {
+			return nil, ErrNoKey
+		}
+		if id.Flags&(FlagPassphrase|FlagAge) != 0 {
+			return []byte(v), nil
+		}
+
+		b, err := hex.DecodeString(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in %s: %v", name, err)
+		}
+		return b, nil
+	}
+}
+
+// IdentityFile returns a KeyFunc that reads age identities from
+// the given file (eg: one generated by age-keygen) for payloads
+// encrypted to age recipients.
+func IdentityFile(path string) KeyFunc {
+	return func(ID) ([]byte, error) {
+		return os.ReadFile(path)
+	}
+}
+
+// EncryptStuff writes a copy of a stuffed binary whose payload is encrypted
+// with the encryption key, the passphrase, or the recipients in the options
+// to out without re-compressing it, for instance, to protect the assets of a
+// binary that was stuffed without encryption. Other options are ignored.
+// The payload's checksum is updated and its signature, which is of the
+// plain payload, is dropped (see SignStuff). It returns the size of the
+// binary and the payload.
+func EncryptStuff(in, out string, o StuffOpt) (int64, int64, error) {
+	o, err := checkStuffOpt(o)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !o.hasKey() {
+		return 0, 0, errors.New("encrypting needs EncryptionKey, Passphrase, or Recipients")
+	}
+
+	id, b, err := getStored(in)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := verifyChecksum(id, b); err != nil {
+		return 0, 0, err
+	}
+	if id.Flags&(FlagEncrypted|FlagEncryptedFiles) != 0 {
+		return 0, 0, errors.New("payload is already encrypted")
+	}
+
+	var buf bytes.Buffer
+	ew, err := newPayloadEncrypter(&id, &buf, o)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := ew.Write(b); err != nil {
+		return 0, 0, err
+	}
+	if err := ew.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	id.Version = idVersion2
+	return stuffStored(in, out, resetSums(id, buf.Bytes()), buf.Bytes())
+}
+
+// DecryptStuff writes a copy of a stuffed binary whose payload is decrypted
+// with the key returned by the KeyFunc to out. Payloads that are encrypted as
+// a whole are decrypted without re-compressing them. Payloads with files
+// that are encrypted individually are stuffed again with the same codec,
+// metadata, and checksum, without the metadata of the files. The payload's
+// checksum is updated and its signature is dropped like with EncryptStuff.
+func DecryptStuff(in, out string, key KeyFunc) (int64, int64, error) {
+	if key == nil {
+		return 0, 0, ErrNoKey
+	}
+
+	id, b, err := getStored(in)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := verifyChecksum(id, b); err != nil {
+		return 0, 0, err
+	}
+
+	switch {
+	case id.Flags&FlagEncrypted != 0:
+		if b, err = decryptPayload(id, b, key); err != nil {
+			return 0, 0, err
+		}
+		id.Flags &^= FlagEncrypted | FlagPassphrase | FlagAge
+		id.Salt, id.Nonce, id.wrappedKey = [16]byte{}, [8]byte{}, ""
+		return stuffStored(in, out, resetSums(id, b), b)
+
+	case id.Flags&FlagEncryptedFiles != 0:
+		fs, err := UnStuffWithOpt(in, UnStuffOpt{Key: key})
+		if err != nil {
+			return 0, 0, err
+		}
+		o := StuffOpt{
+			Codec:    id.Codec,
+			Meta:     id.Extra().Meta,
+			Checksum: id.Flags&FlagChecksum != 0,
+			Section:  id.Flags&FlagSection != 0,
+			Sidecar:  id.Flags&FlagSidecar != 0,
+		}
+		return StuffFSWithOpt(in, out, o, fs)
+	}
+
+	return 0, 0, errors.New("payload isn't encrypted")
+}
+
+// resetSums returns the ID with the checksum of the given payload,
+// if it has one, and without the signature.
+func resetSums(id ID, b []byte) ID {
+	if id.Flags&FlagChecksum != 0 {
+		id.Checksum = sha256.Sum256(b)
+	}
+	id.Flags &^= FlagHMAC | FlagEd25519
+	id.signature = ""
+	return id
+}
+
+// payloadKey returns the key that the encrypted payload
+// with the given ID is decrypted with.
+func payloadKey(id ID, fn KeyFunc) ([]byte, error) {
+	if fn == nil {
+		return nil, ErrNoKey
+	}
+
+	secret, err := fn(id)
+	if err != nil {
+		return nil, err
+	}
+	return deriveKey(id, secret)
+}
+
+// deriveKey derives the key from the passphrase with the salt in the ID
+// for payloads encrypted with a passphrase, decrypts the wrapped key in the
+// ID with the age identities for payloads encrypted to age recipients, and
+// validates the key for others.
+func deriveKey(id ID, secret []byte) ([]byte, error) {
+	if id.Flags&FlagPassphrase != 0 {
+		return scrypt.Key(secret, id.Salt[:], scryptN, scryptR, scryptP, lenKey)
+	}
+	if id.Flags&FlagAge != 0 {
+		return unwrapKey(id.Extra().WrappedKey, secret)
+	}
+	if len(secret) != lenKey {
+		return nil, fmt.Errorf("invalid AES-256 key size %d", len(secret))
+	}
+	return secret, nil
+}
+
+// key returns the KeyFunc in the options, or one for the encryption key
+// or the passphrase, which decrypts existing payloads that are encrypted
+// with it.
+func (o StuffOpt) key() KeyFunc {
+	if o.Key != nil {
+		return o.Key
+	}
+	if o.Passphrase != "" {
+		return Key([]byte(o.Passphrase))
+	}
+	if o.EncryptionKey != nil {
+		return Key(o.EncryptionKey)
+	}
+	return nil
+}
+
+// hasKey checks whether the options have a key, a passphrase,
+// or recipients to encrypt with.
+func (o StuffOpt) hasKey() bool {
+	return o.EncryptionKey != nil || o.Passphrase != "" || len(o.Recipients) > 0
+}
+
+// newPayloadEncrypter returns an encryptWriter that encrypts the payload
+// into w with the key in the options (see newKey) and sets the encryption
+// fields of the ID.
+func newPayloadEncrypter(id *ID, w io.Writer, o StuffOpt) (*encryptWriter, error) {
+	id.Flags |= FlagEncrypted
+	if _, err := rand.Read(id.Nonce[:]); err != nil {
+		return nil, err
+	}
+
+	key, err := newKey(id, o)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, key, id.Nonce, nil)
+}
+
+// newKey returns the key to encrypt with from the encryption key, the
+// passphrase, or the recipients in the options and sets the fields of the
+// ID that are needed to get it back.
+func newKey(id *ID, o StuffOpt) ([]byte, error) {
+	key := o.EncryptionKey
+	if len(o.Recipients) > 0 {
+		id.Flags |= FlagAge
+		key = make([]byte, lenKey)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+
+		wk, err := wrapKey(key, o.Recipients)
+		if err != nil {
+			return nil, err
+		}
+		id.wrappedKey = string(wk)
+	}
+	if o.Passphrase != "" {
+		id.Flags |= FlagPassphrase
+		if _, err := rand.Read(id.Salt[:]); err != nil {
+			return nil, err
+		}
+
+		k, err := deriveKey(*id, []byte(o.Passphrase))
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	}
+
+	return key, nil
+}
+
+// wrapKey encrypts a key to the given age recipients.
+func wrapKey(key []byte, recipients []string) ([]byte, error) {
+	rs, err := parseRecipients(recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, rs...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(key); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unwrapKey decrypts a key encrypted with wrapKey with
+// the age identities in the given identity file.
+func unwrapKey(wrapped, identities []byte) ([]byte, error) {
+	ids, err := age.ParseIdentities(bytes.NewReader(identities))
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identities: %v", err)
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(wrapped), ids...)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	key, err := io.ReadAll(io.LimitReader(r, lenKey+1))
+	if err != nil || len(key) != lenKey {
+		return nil, ErrDecrypt
+	}
+	return key, nil
+}
+
+// parseRecipients parses age X25519 recipients (age1...).
+func parseRecipients(recipients []string) ([]age.Recipient, error) {
+	out := make([]age.Recipient, 0, len(recipients))
+	for _, r := range recipients {
+		rc, err := age.ParseX25519Recipient(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient '%s': %v", r, err)
+		}
+		out = append(out, rc)
+	}
+	return out, nil
+}
+
+// encryptWriter is a writer that encrypts the bytes written
+// to it in chunks of encChunkSize into w.
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce [12]byte
+	n     uint32
+
+	// ad is the additional data every chunk is authenticated with.
+	ad []byte
+
+	buf []byte
+	out []byte
+}
+
+// newEncryptWriter returns an encryptWriter that encrypts into w with the
+// given key and nonce prefix, and authenticates the chunks with the
+// additional data ad. The writer should be closed to seal the last chunk.
+func newEncryptWriter(w io.Writer, key []byte, prefix [8]byte, ad []byte) (*encryptWriter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &encryptWriter{
+		w:    w,
+		aead: aead,
+		ad:   ad,
+		buf:  make([]byte, 0, encChunkSize),
+		out:  make([]byte, 0, encChunkSize+aead.Overhead()),
+	}
+	copy(e.nonce[:], prefix[:])
+	return e, nil
+}
+
+// Write encrypts and writes every full chunk.
+func (e *encryptWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		// A full chunk is only sealed when more data follows
+		// as the last chunk is sealed differently.
+		if len(e.buf) == encChunkSize {
+			if err := e.seal(false); err != nil {
+				return n - len(b), err
+			}
+		}
+
+		c := copy(e.buf[len(e.buf):encChunkSize], b)
+		e.buf = e.buf[:len(e.buf)+c]
+		b = b[c:]
+	}
+	return n, nil
+}
+
+// Close seals and writes the last chunk.
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+// seal encrypts the buffered chunk and writes it.
+func (e *encryptWriter) seal(last bool) error {
+	if e.n >= maxEncChunks {
+		return errors.New("payload is too large to encrypt")
+	}
+
+	e.out = e.aead.Seal(e.out[:0], chunkNonce(e.nonce, e.n, last), e.buf, e.ad)
+	if _, err := e.w.Write(e.out); err != nil {
+		return err
+	}
+	e.buf = e.buf[:0]
+	e.n++
+	return nil
+}
+
+// decryptReader is a reader that decrypts a payload
+// encrypted by encryptWriter.
+type decryptReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	nonce [12]byte
+	n     uint32
+
+	// left is the number of encrypted bytes left to read.
+	left int64
+	done bool
+
+	// ad is the additional data every chunk is authenticated with.
+	ad []byte
+
+	buf []byte
+	out []byte
+}
+
+// newDecryptReader returns a decryptReader that decrypts size bytes from r
+// with the given key, nonce prefix, and additional data.
+func newDecryptReader(r io.Reader, size int64, key []byte, prefix [8]byte, ad []byte) (*decryptReader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decryptReader{
+		r:    r,
+		aead: aead,
+		left: size,
+		ad:   ad,
+		buf:  make([]byte, encChunkSize+aead.Overhead()),
+	}
+	copy(d.nonce[:], prefix[:])
+	return d, nil
+}
+
+// Read reads and decrypts the payload chunk by chunk.
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk.
+func (d *decryptReader) open() error {
+	size := int64(len(d.buf))
+	if d.left < size {
+		size = d.left
+	}
+	if size < int64(d.aead.Overhead()) || d.n >= maxEncChunks {
+		return ErrDecrypt
+	}
+
+	b := d.buf[:size]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	d.left -= size
+	d.done = d.left == 0
+
+	out, err := d.aead.Open(b[:0], chunkNonce(d.nonce, d.n, d.done), b, d.ad)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.out = out
+	d.n++
+	return nil
+}
+
+// decryptPayload decrypts an encrypted payload with the key
+// returned by the KeyFunc.
+func decryptPayload(id ID, b []byte, fn KeyFunc) ([]byte, error) {
+	key, err := payloadKey(id, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := newDecryptReader(bytes.NewReader(b), int64(len(b)), key, id.Nonce, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decrypt the chunks in-place. The plaintext is written behind the
+	// position the ciphertext is read from as it's shorter.
+	out := b[:0]
+	for !d.done {
+		if err := d.open(); err != nil {
+			return nil, err
+		}
+		out = append(out, d.out...)
+	}
+	return out, nil
+}
+
+// newAEAD returns an AES-GCM AEAD for the given key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != lenKey {
+		return nil, fmt.Errorf("invalid AES-256 key size %d", len(key))
+	}
+
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
+// chunkNonce sets the counter and the last chunk byte of a nonce
+// and returns it.
+func chunkNonce(nonce [12]byte, n uint32, last bool) []byte {
+	var c [4]byte
+	binary.BigEndian.PutUint32(c[:], n)
+	copy(nonce[8:11], c[1:])
+	nonce[11] = 0
+	if last {
+		nonce[11] = 1
+	}
+	return nonce[:]
+}
+
+// encExtraID is the ID of the ZIP extra field of files that are
+// encrypted individually (see StuffOpt.Encrypt). Its data is the nonce
+// prefix (8) of the file followed by the compression method (2) and the
+// size (8) of the file before it was compressed and encrypted. The file is
+// stored in the ZIP as is, and its chunks are authenticated with its name,
+// method, and size (see fileAD) so that it can't be swapped with another.
+const (
+	encExtraID  = 0x7362
+	lenEncExtra = 18
+)
+
+// matchEncrypt checks whether the target path of a file matches any of
+// the StuffOpt.Encrypt patterns, which are matched like the Exclude patterns.
+func matchEncrypt(patterns []string, p string) bool {
+	return isExcluded(patterns, p)
+}
+
+// encryptFiles checks whether files are to be encrypted individually.
+func encryptFiles(o StuffOpt, entries []stuffEntry) bool {
+	if len(o.Encrypt) > 0 {
+		return true
+	}
+	for _, e := range entries {
+		if e.encrypt {
+			return true
+		}
+	}
+	return false
+}
+
+// fileAD returns the additional data the chunks of a file that's encrypted
+// individually are authenticated with: its method (2) and size (8)
+// followed by its name.
+func fileAD(name string, method uint16, size uint64) []byte {
+	b := make([]byte, 10, 10+len(name))
+	binary.LittleEndian.PutUint16(b[0:2], method)
+	binary.LittleEndian.PutUint64(b[2:10], size)
+	return append(b, name...)
+}
+
+// isEncryptedFile checks whether a file in a ZIP is encrypted individually.
+func isEncryptedFile(f *zip.File) bool {
+	_, _, _, ok := encExtra(f)
+	return ok
+}
+
+// zipEncryptedFile compresses a file from the local file system with the
+// given method and level, encrypts it with the key, and adds it to the
+// given zip.Writer.
+func zipEncryptedFile(srcPath, targetPath string, method uint16, level int, comment string, key []byte, zw *zip.Writer) error {
+	z, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+
+	info, err := z.Stat()
+	if err != nil {
+		return err
+	}
+
+	return zipEncryptedReader(z, info, targetPath, method, level, comment, key, zw)
+}
+
+// zipEncryptedReader is zipEncryptedFile for a file with
+// the given info that's read from r.
+func zipEncryptedReader(r io.Reader, info os.FileInfo, targetPath string, method uint16, level int, comment string, key []byte, zw *zip.Writer) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = targetPath
+	hdr.Comment = comment
+
+	w, nonce, err := createEncrypted(hdr, method, uint64(info.Size()), zw)
+	if err != nil {
+		return err
+	}
+	ew, err := newEncryptWriter(w, key, nonce, fileAD(targetPath, method, uint64(info.Size())))
+	if err != nil {
+		return err
+	}
+
+	var cw io.WriteCloser = nopWriteCloser{ew}
+	if method == zip.Deflate {
+		if cw, err = flate.NewWriter(ew, level); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(cw, r); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+// reencryptZipFile decrypts a file that's encrypted individually with
+// oldKey and adds it to the given zip.Writer encrypted with newKey. The file
+// isn't recompressed.
+func reencryptZipFile(f *zip.File, oldKey, newKey []byte, zw *zip.Writer) error {
+	nonce, method, size, _ := encExtra(f)
+
+	rd, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	dr, err := newDecryptReader(rd, int64(f.UncompressedSize64), oldKey, nonce, fileAD(f.Name, method, size))
+	if err != nil {
+		return err
+	}
+
+	hdr := f.FileHeader
+	w, nonce, err := createEncrypted(&hdr, method, size, zw)
+	if err != nil {
+		return err
+	}
+	ew, err := newEncryptWriter(w, newKey, nonce, fileAD(f.Name, method, size))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ew, dr); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+// createEncrypted adds a stored file with the encryption extra field for
+// the given compression method and size to the zip.Writer and returns its
+// writer and random nonce prefix.
+func createEncrypted(hdr *zip.FileHeader, method uint16, size uint64, zw *zip.Writer) (io.Writer, [8]byte, error) {
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, nonce, err
+	}
+
+	extra := make([]byte, 4+lenEncExtra)
+	binary.LittleEndian.PutUint16(extra[0:2], encExtraID)
+	binary.LittleEndian.PutUint16(extra[2:4], lenEncExtra)
+	copy(extra[4:12], nonce[:])
+	binary.LittleEndian.PutUint16(extra[12:14], method)
+	binary.LittleEndian.PutUint64(extra[14:22], size)
+
+	hdr.Method = zip.Store
+	hdr.Extra = extra
+	hdr.CRC32 = 0
+	hdr.CompressedSize64 = 0
+	hdr.UncompressedSize64 = 0
+
+	w, err := zw.CreateHeader(hdr)
+	return w, nonce, err
+}
+
+// encExtra returns the nonce prefix, the compression method, and the
+// original size from the encryption extra field of a file, if it has one.
+func encExtra(f *zip.File) ([8]byte, uint16, uint64, bool) {
+	var nonce [8]byte
+
+	b := f.Extra
+	for len(b) >= 4 {
+		tag, size := binary.LittleEndian.Uint16(b[0:2]), int(binary.LittleEndian.Uint16(b[2:4]))
+		b = b[4:]
+		if size > len(b) {
+			break
+		}
+		if tag == encExtraID && size == lenEncExtra {
+			copy(nonce[:], b[0:8])
+			return nonce, binary.LittleEndian.Uint16(b[8:10]), binary.LittleEndian.Uint64(b[10:18]), true
+		}
+		b = b[size:]
+	}
+	return nonce, 0, 0, false
+}
+
+// readEncryptedFile decrypts and decompresses a file that's
+// encrypted individually.
+func readEncryptedFile(f *zip.File, key []byte) ([]byte, error) {
+	nonce, method, size, _ := encExtra(f)
+	if size > maxInt {
+		return nil, fmt.Errorf("%s: size %d is too large for this platform", f.Name, size)
+	}
+
+	rd, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	dr, err := newDecryptReader(rd, int64(f.UncompressedSize64), key, nonce, fileAD(f.Name, method, size))
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = dr
+	switch method {
+	case zip.Store:
+	case zip.Deflate:
+		fr := flate.NewReader(dr)
+		defer fr.Close()
+		r = fr
+	default:
+		return nil, fmt.Errorf("%s: unknown compression method %d", f.Name, method)
+	}
+
+	// Files are read up to a byte past their size to catch mismatches
+	// without decompressing them beyond it.
+	b, err := readSized(f.Name, r, size)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) != size {
+		return nil, fmt.Errorf("%s: size mismatch after decryption", f.Name)
+	}
+	return b, nil
+}
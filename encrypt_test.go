@@ -0,0 +1,181 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryptChunks(t *testing.T) {
+	var (
+		key   = make([]byte, lenKey)
+		nonce [8]byte
+	)
+
+	// Sizes around the chunk boundaries.
+	for _, n := range []int{0, 1, encChunkSize - 1, encChunkSize, encChunkSize + 1, 3 * encChunkSize} {
+		plain := make([]byte, n)
+		_, _ = rand.Read(plain)
+
+		var buf bytes.Buffer
+		ew, err := newEncryptWriter(&buf, key, nonce, nil)
+		assert(t, "error creating writer", nil, err)
+		_, err = ew.Write(plain)
+		assert(t, "error encrypting", nil, err)
+		assert(t, "error closing writer", nil, ew.Close())
+		enc := buf.Bytes()
+
+		dr, err := newDecryptReader(bytes.NewReader(enc), int64(len(enc)), key, nonce, nil)
+		assert(t, "error creating reader", nil, err)
+		b, err := io.ReadAll(dr)
+		assert(t, "error decrypting", nil, err)
+		assert(t, "mismatch in decrypted bytes", true, bytes.Equal(plain, b))
+
+		// Payloads truncated at a chunk boundary should be rejected.
+		if n > encChunkSize {
+			cut := enc[:encChunkSize+ew.aead.Overhead()]
+			dr, err := newDecryptReader(bytes.NewReader(cut), int64(len(cut)), key, nonce, nil)
+			assert(t, "error creating reader", nil, err)
+			_, err = io.ReadAll(dr)
+			assert(t, "expected error on truncated payload", ErrDecrypt, err)
+		}
+	}
+}
+
+func TestEncryptStuff(t *testing.T) {
+	ident, err := age.GenerateX25519Identity()
+	assert(t, "error generating identity", nil, err)
+	_, priv, err := ed25519.GenerateKey(nil)
+	assert(t, "error generating key", nil, err)
+
+	var (
+		dir = t.TempDir()
+		bin = filepath.Join(dir, "app")
+		enc = filepath.Join(dir, "app.enc")
+		dec = filepath.Join(dir, "app.dec")
+	)
+	_, _, err = StuffWithOpt(mockBin, bin, StuffOpt{Codec: CodecZstd, Checksum: true, SigningKey: priv}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+	orig, err := GetStuff(bin)
+	assert(t, "error getting stuff", nil, err)
+
+	for _, o := range []StuffOpt{{Passphrase: "secret"}, {Recipients: []string{ident.Recipient().String()}}} {
+		_, _, err = EncryptStuff(bin, enc, o)
+		assert(t, "error encrypting", nil, err)
+
+		id, err := GetFileID(enc)
+		assert(t, "error getting file ID", nil, err)
+		assert(t, "ID encrypted", FlagEncrypted, id.Flags&FlagEncrypted)
+		assert(t, "ID signature", uint16(0), id.Flags&FlagEd25519)
+		_, err = UnStuff(enc)
+		assert(t, "unstuffed without a key", ErrNoKey, err)
+
+		_, _, err = EncryptStuff(enc, enc, o)
+		assert(t, "encrypted twice", true, err != nil)
+
+		key := o.key()
+		if len(o.Recipients) > 0 {
+			key = Key([]byte(ident.String()))
+		}
+		b, err := GetStuffWithOpt(enc, UnStuffOpt{Key: key})
+		assert(t, "error getting encrypted stuff", nil, err)
+		assert(t, "mismatch in payload", orig, b)
+
+		_, _, err = DecryptStuff(enc, dec, key)
+		assert(t, "error decrypting", nil, err)
+		id, err = GetFileID(dec)
+		assert(t, "error getting file ID", nil, err)
+		assert(t, "ID flags", FlagChecksum, id.Flags)
+		b, err = GetStuff(dec)
+		assert(t, "error getting decrypted stuff", nil, err)
+		assert(t, "mismatch in payload", orig, b)
+	}
+
+	_, _, err = EncryptStuff(bin, enc, StuffOpt{})
+	assert(t, "encrypted without a key", true, err != nil)
+	_, _, err = DecryptStuff(bin, dec, Key([]byte("secret")))
+	assert(t, "decrypted a plain payload", true, err != nil)
+}
+
+func TestDecryptStuffFiles(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		bin = filepath.Join(dir, "app")
+		dec = filepath.Join(dir, "app.dec")
+	)
+	o := StuffOpt{Passphrase: "secret", Encrypt: []string{"/mock/foo.txt"}, Meta: map[string]string{MetaVersion: "1.0.0"}}
+	_, _, err := StuffWithOpt(mockBin, bin, o, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	_, _, err = DecryptStuff(bin, dec, nil)
+	assert(t, "decrypted without a key", ErrNoKey, err)
+	_, _, err = DecryptStuff(bin, dec, Key([]byte("secret")))
+	assert(t, "error decrypting", nil, err)
+
+	id, err := GetFileID(dec)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID flags", uint16(0), id.Flags&FlagEncryptedFiles)
+	assert(t, "ID meta", "1.0.0", id.Extra().Meta[MetaVersion])
+
+	fs, err := UnStuff(dec)
+	assert(t, "error unstuffing", nil, err)
+	b, err := fs.Read("/mock/foo.txt")
+	assert(t, "error reading file", nil, err)
+	local, err := ioutil.ReadFile("mock/foo.txt")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in file", local, b)
+}
+
+func TestEncryptedFileSwap(t *testing.T) {
+	var (
+		key   = make([]byte, lenKey)
+		buf   bytes.Buffer
+		names = []string{"/mock/foo.txt", "/mock/bar.txt"}
+	)
+	zw := zip.NewWriter(&buf)
+	for _, n := range names {
+		err := zipEncryptedFile("."+n, n, zip.Deflate, flate.DefaultCompression, "", key, zw)
+		assert(t, "error encrypting file", nil, err)
+	}
+	assert(t, "error closing zip", nil, zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert(t, "error reading zip", nil, err)
+	for _, f := range zr.File {
+		b, err := readEncryptedFile(f, key)
+		assert(t, "error decrypting file", nil, err)
+		local, err := ioutil.ReadFile("." + f.Name)
+		assert(t, "error reading file", nil, err)
+		assert(t, "mismatch in file", local, b)
+	}
+
+	// Files that are moved to another path shouldn't decrypt.
+	var swapped bytes.Buffer
+	zw = zip.NewWriter(&swapped)
+	for i, f := range zr.File {
+		hdr := f.FileHeader
+		hdr.Name = names[1-i]
+		w, err := zw.CreateRaw(&hdr)
+		assert(t, "error creating file", nil, err)
+		r, err := f.OpenRaw()
+		assert(t, "error opening file", nil, err)
+		_, err = io.Copy(w, r)
+		assert(t, "error copying file", nil, err)
+	}
+	assert(t, "error closing zip", nil, zw.Close())
+
+	zr, err = zip.NewReader(bytes.NewReader(swapped.Bytes()), int64(swapped.Len()))
+	assert(t, "error reading zip", nil, err)
+	for _, f := range zr.File {
+		_, err := readEncryptedFile(f, key)
+		assert(t, "expected error on swapped file", ErrDecrypt, err)
+	}
+}
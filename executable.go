@@ -0,0 +1,59 @@
+package stuffbin
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"os"
+)
+
+// ErrNotExecutable is returned by CheckExecutable for files
+// that aren't ELF, PE, or Mach-O executables.
+var ErrNotExecutable = errors.New("not an ELF, PE, or Mach-O executable")
+
+// CheckExecutable checks whether the file at the given path starts with
+// the magic bytes of an ELF, PE, or Mach-O (including universal)
+// executable. Stuffing works with any file, but an input that isn't an
+// executable is usually a mistake, for instance, swapped arguments, that
+// should be caught before the result ships.
+func CheckExecutable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b := make([]byte, 8)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		return ErrNotExecutable
+	}
+	if !isExecutable(b) {
+		return ErrNotExecutable
+	}
+
+	return nil
+}
+
+// isExecutable checks whether the given first 8 bytes of a
+// file are the magic bytes of an executable.
+func isExecutable(b []byte) bool {
+	var (
+		le = binary.LittleEndian.Uint32(b)
+		be = binary.BigEndian.Uint32(b)
+	)
+	switch {
+	case bytes.HasPrefix(b, []byte(elf.ELFMAG)):
+		return true
+	case bytes.HasPrefix(b, []byte("MZ")):
+		return true
+	case le == macho.Magic32 || le == macho.Magic64 || be == macho.Magic32 || be == macho.Magic64:
+		return true
+	case be == macho.MagicFat:
+		// Java class files have the same magic followed by their
+		// version (>= 45) instead of the number of architectures.
+		return binary.BigEndian.Uint32(b[4:]) < 45
+	}
+	return false
+}
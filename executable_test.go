@@ -0,0 +1,34 @@
+package stuffbin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckExecutable(t *testing.T) {
+	exe, err := os.Executable()
+	assert(t, "error getting executable", nil, err)
+	assert(t, "executable not recognized", nil, CheckExecutable(exe))
+
+	dir := t.TempDir()
+	for _, c := range []struct {
+		name string
+		b    []byte
+		err  error
+	}{
+		{"elf", []byte("\x7fELF\x02\x01\x01\x00"), nil},
+		{"pe", []byte("MZ\x90\x00\x03\x00\x00\x00"), nil},
+		{"macho", []byte("\xcf\xfa\xed\xfe\x0c\x00\x00\x01"), nil},
+		{"fat", []byte("\xca\xfe\xba\xbe\x00\x00\x00\x02"), nil},
+		{"class", []byte("\xca\xfe\xba\xbe\x00\x00\x00\x34"), ErrNotExecutable},
+		{"text", []byte("body { color: red; }"), ErrNotExecutable},
+		{"short", []byte("MZ"), ErrNotExecutable},
+	} {
+		p := filepath.Join(dir, c.name)
+		assert(t, "error writing file", nil, os.WriteFile(p, c.b, 0644))
+		assert(t, "mismatch in check of "+c.name, c.err, CheckExecutable(p))
+	}
+
+	assert(t, "expected error on missing file", true, CheckExecutable(filepath.Join(dir, "nonexistent")) != nil)
+}
@@ -0,0 +1,193 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ExtractToDir writes the files in a FileSystem to a directory, creating
+// it and the subdirectories as needed. Files get the permissions (eg: the
+// executable bit of scripts) and the modification times they were stuffed
+// with. Existing files are overwritten.
+func ExtractToDir(fs FileSystem, dir string) error {
+	for _, p := range fs.List() {
+		f, err := fs.Get(p)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		err = extractFile(dir, p, info, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExtractStuff is ExtractToDir for the files in a stuffed binary that are
+// streamed from its payload to the directory (see WalkStuff), so that the
+// memory used doesn't depend on the size of the payload. If glob patterns
+// are given, only the matching files are written (see UnStuffPaths). It
+// returns the number of files written.
+func ExtractStuff(in, dir string, o UnStuffOpt, patterns ...string) (int, error) {
+	for _, p := range patterns {
+		if err := checkPattern(p); err != nil {
+			return 0, err
+		}
+	}
+
+	n := 0
+	err := WalkStuff(in, o, func(p string, info os.FileInfo, r io.Reader) error {
+		if len(patterns) > 0 && !matchPath(patterns, p) {
+			return nil
+		}
+		if err := extractFile(dir, p, info, r); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// WalkStuff calls fn with every file in a stuffed binary and a reader of its
+// contents that's streamed from the payload instead of loading the payload
+// into memory. The reader is only valid during the call. Payloads that are
+// compressed with a codec other than CodecZip, encrypted as a whole, or
+// verified with a signature are read into memory first, and so are files
+// that are encrypted individually. The latter are skipped without the key.
+func WalkStuff(in string, o UnStuffOpt, fn func(path string, info os.FileInfo, r io.Reader) error) error {
+	p, err := openStuff(in, o)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	zr, err := zip.NewReader(p, p.size)
+	if err != nil {
+		return payloadError("", err)
+	}
+	if err := checkUnzipSize(zr.File, o); err != nil {
+		return err
+	}
+	_, dec, err := readDict(zr, uint64(o.MaxFileSize))
+	if err != nil {
+		return payloadError(dictName, err)
+	}
+	if dec != nil {
+		defer dec.Close()
+	}
+
+	var compressed int64
+	pr := newProgress(o.Progress, func() int64 { return compressed })
+
+	for _, f := range zr.File {
+		if f.Name == dictName || (isEncryptedFile(f) && p.key == nil) {
+			continue
+		}
+		pr.start(f.Name, int64(f.UncompressedSize64))
+		compressed += int64(f.CompressedSize64)
+
+		if isEncryptedFile(f) {
+			b, err := readEncryptedFile(f, p.key)
+			if err != nil {
+				return payloadError(f.Name, err)
+			}
+			if err := fn(f.Name, f.FileInfo(), bytes.NewReader(b)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rd, err := f.Open()
+		if err != nil {
+			return payloadError(f.Name, err)
+		}
+		err = fn(f.Name, f.FileInfo(), &payloadReader{r: rd, path: f.Name})
+		rd.Close()
+		if err != nil {
+			return err
+		}
+	}
+	pr.finish()
+
+	return nil
+}
+
+// payloadReader is a reader of a file in a payload
+// that returns read errors as PayloadErrors.
+type payloadReader struct {
+	r    io.Reader
+	path string
+}
+
+func (r *payloadReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = payloadError(r.path, err)
+	}
+	return n, err
+}
+
+// WriteStuff writes the ZIP payload of a stuffed binary to w, like
+// GetStuff, streaming it from the binary when it can be read in place
+// (see WalkStuff). It returns the number of bytes written.
+func WriteStuff(in string, w io.Writer, o UnStuffOpt) (int64, error) {
+	p, err := openStuff(in, o)
+	if err != nil {
+		return 0, err
+	}
+	defer p.Close()
+
+	return io.Copy(w, io.NewSectionReader(p, 0, p.size))
+}
+
+// extractFile writes a file with the given target path and info
+// read from r to the directory.
+func extractFile(dir, p string, info os.FileInfo, r io.Reader) error {
+	// Paths are cleaned as absolute paths so that
+	// they can't point outside the directory.
+	target := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+p)))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	mode := info.Mode().Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// Apply the mode to existing files and past the umask.
+	if err := os.Chmod(target, mode); err != nil {
+		return err
+	}
+	if t := info.ModTime(); !t.IsZero() {
+		if err := os.Chtimes(target, t, t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
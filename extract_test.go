@@ -0,0 +1,81 @@
+package stuffbin
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExtractToDir(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		script = filepath.Join(dir, "run.sh")
+		out    = filepath.Join(dir, "stuffed")
+		ext    = filepath.Join(dir, "extracted")
+		mtime  = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	)
+	assert(t, "error writing file", nil, os.WriteFile(script, []byte("#!/bin/sh\necho hi\n"), 0755))
+	assert(t, "error setting mtime", nil, os.Chtimes(script, mtime, mtime))
+
+	_, _, err := Stuff(mockBin, out, "/", script+":/bin/run.sh", "mock/foo.txt")
+	assert(t, "error stuffing", nil, err)
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "error extracting", nil, ExtractToDir(fs, ext))
+
+	stat, err := os.Stat(filepath.Join(ext, "bin", "run.sh"))
+	assert(t, "error reading extracted file", nil, err)
+	assert(t, "mismatch in mtime", true, mtime.Equal(stat.ModTime()))
+	if os.PathSeparator == '/' {
+		assert(t, "mismatch in mode", os.FileMode(0755), stat.Mode().Perm())
+	}
+
+	orig, err := os.ReadFile("mock/foo.txt")
+	assert(t, "error reading file", nil, err)
+	b, err := os.ReadFile(filepath.Join(ext, "mock", "foo.txt"))
+	assert(t, "error reading extracted file", nil, err)
+	assert(t, "mismatch in extracted file", string(orig), string(b))
+}
+
+func TestExtractStuff(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		out = filepath.Join(dir, "stuffed")
+	)
+	for _, o := range []StuffOpt{{Checksum: true}, {Codec: CodecZstd}, {Sidecar: true}} {
+		_, _, err := StuffWithOpt(mockBin, out, o, "mock/foo.txt", "mock/subdir")
+		assert(t, "error stuffing", nil, err)
+
+		ext := t.TempDir()
+		n, err := ExtractStuff(out, ext, UnStuffOpt{})
+		assert(t, "error extracting", nil, err)
+		assert(t, "mismatch in file count", 2, n)
+
+		orig, err := os.ReadFile("mock/subdir/baz.txt")
+		assert(t, "error reading file", nil, err)
+		b, err := os.ReadFile(filepath.Join(ext, "mock", "subdir", "baz.txt"))
+		assert(t, "error reading extracted file", nil, err)
+		assert(t, "mismatch in extracted file", string(orig), string(b))
+
+		// The streamed payload is the same as GetStuff's.
+		var buf bytes.Buffer
+		_, err = WriteStuff(out, &buf, UnStuffOpt{})
+		assert(t, "error writing stuff", nil, err)
+		zb, err := GetStuff(out)
+		assert(t, "error getting stuff", nil, err)
+		assert(t, "mismatch in payload", true, bytes.Equal(zb, buf.Bytes()))
+
+		// Only the matching files.
+		ext = t.TempDir()
+		n, err = ExtractStuff(out, ext, UnStuffOpt{}, "/mock/subdir/**")
+		assert(t, "error extracting", nil, err)
+		assert(t, "mismatch in file count", 1, n)
+		_, err = os.Stat(filepath.Join(ext, "mock", "foo.txt"))
+		assert(t, "extracted a file that doesn't match", true, os.IsNotExist(err))
+	}
+
+	_, err := ExtractStuff(out, t.TempDir(), UnStuffOpt{}, "[")
+	assert(t, "extracted with an invalid pattern", true, err != nil)
+}
@@ -6,10 +6,16 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	iofs "io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	ttemplate "text/template"
+	"time"
 )
 
 // FileSystem represents a simple filesystem abstraction
@@ -28,12 +34,32 @@ type FileSystem interface {
 	FileServer() http.Handler
 }
 
+// Reloader is implemented by FileSystems that can swap in the files
+// of a stuffed binary (see ReloadFS).
+type Reloader interface {
+	ReloadFrom(path string) error
+}
+
 // memFS implements an in-memory FileSystem.
 type memFS struct {
+	mu    sync.RWMutex
 	files map[string]*File
 
+	// pool has the Files that FileServer opens, which are
+	// returned to it when they're closed.
+	pool sync.Pool
+
+	// paths is the sorted index of the file paths that Glob and List
+	// use. It's reset when files are added or deleted, rebuilt on use,
+	// and never modified once built.
+	paths []string
+
 	// size is the total size of all files in the filesystem.
 	size int64
+
+	// payload is the reference to the open payload that the files of
+	// a lazy FileSystem are read from (see UnStuffOpt.Lazy), if any.
+	payload *payloadRef
 }
 
 // localFS implements a passthrough to the local filesystem.
@@ -44,12 +70,37 @@ type localFS struct {
 	size int64
 }
 
-// File represents an abstraction over http.File.
+// File represents an abstraction over http.File. Its content is never
+// modified and is shared by the Files that FileSystem.Get returns for a
+// path. Only the reader is per File.
 type File struct {
 	path string
 	info os.FileInfo
 	b    []byte
-	rd   *bytes.Reader
+	rd   bytes.Reader
+
+	// meta is the optional metadata of the file from a stuffing manifest.
+	meta map[string]string
+
+	// pool is the pool that the File is returned to when it's closed.
+	pool *sync.Pool
+
+	// lazy is the content of a file that hasn't been read yet
+	// (see UnStuffOpt.Lazy) or is streamed from the payload.
+	lazy   *lazyFile
+	stream fileStream
+
+	// ref is the reference to the payload that a lazy File returned by
+	// FileSystem.Get holds until it's loaded or closed.
+	ref *payloadRef
+}
+
+// fileInfo implements os.FileInfo for files created in memory.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
 }
 
 // ErrNotSupported indicates interface methods
@@ -63,15 +114,62 @@ func NewFS() (FileSystem, error) {
 	}, nil
 }
 
+// LocalFSOpt represents options for creating a FileSystem
+// from local files.
+type LocalFSOpt struct {
+	// RootPath is the root path to bind all files to. Defaults to /.
+	RootPath string
+
+	// Exclude is an optional list of glob patterns of local files and
+	// directories to skip (eg: **/*.map, **/.DS_Store, node_modules/**).
+	// A pattern matches a path or any of its trailing sub-paths, so
+	// node_modules/** excludes node_modules directories at any depth.
+	// In addition to the filepath.Match syntax, a ** segment matches zero
+	// or more directories.
+	Exclude []string
+
+	// SkipHidden skips dotfiles and dot-directories (eg: .git, .DS_Store)
+	// in directories that are walked.
+	SkipHidden bool
+
+	// NoRecursive skips the subdirectories of the directories that are given.
+	NoRecursive bool
+
+	// RespectGitignore skips the files that git ignores (see StuffOpt).
+	RespectGitignore bool
+
+	// Rewrite is an optional list of sed style rewrite rules
+	// (eg: s|^frontend/dist|/admin|) applied to the paths of files
+	// without an alias. See StuffOpt.Rewrite.
+	Rewrite []string
+}
+
 // NewLocalFS returns a new instance of FileSystem
 // with the given list of local files and directories mapped to it.
 func NewLocalFS(rootPath string, paths ...string) (FileSystem, error) {
+	return NewLocalFSWithOpt(LocalFSOpt{RootPath: rootPath}, paths...)
+}
+
+// NewLocalFSWithOpt is NewLocalFS with LocalFSOpt options.
+func NewLocalFSWithOpt(o LocalFSOpt, paths ...string) (FileSystem, error) {
+	if o.RootPath == "" {
+		o.RootPath = "/"
+	}
+	if err := checkExclude(o.Exclude); err != nil {
+		return nil, err
+	}
+	rw, err := parseRewrites(o.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
 	fs, _ := NewFS()
 	if err := walkPaths(func(srcPath, targetPath string, fInfo os.FileInfo) error {
 		f, err := os.Open(srcPath)
 		if err != nil {
 			return err
 		}
+		defer f.Close()
 
 		// Copy bytes.
 		buf := new(bytes.Buffer)
@@ -81,8 +179,8 @@ func NewLocalFS(rootPath string, paths ...string) (FileSystem, error) {
 		}
 
 		// Add the file to the filesystem.
-		return fs.Add(NewFile(targetPath, fInfo, buf.Bytes()))
-	}, rootPath, paths...); err != nil {
+		return fs.Add(newFile(targetPath, fInfo, buf.Bytes()))
+	}, walkOpt{rootPath: o.RootPath, exclude: o.Exclude, skipHidden: o.SkipHidden, noRecursive: o.NoRecursive, gitIgnore: o.RespectGitignore, rewrite: rw}, paths...); err != nil {
 		return nil, err
 	}
 
@@ -91,15 +189,18 @@ func NewLocalFS(rootPath string, paths ...string) (FileSystem, error) {
 
 // Add adds a file to the FileSystem.
 func (fs *memFS) Add(f *File) error {
-	p := f.Path()
-	if _, ok := fs.files[p]; ok {
-		return fmt.Errorf("file already exists: %v", p)
-	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 
 	// Clean the path. This also ensures that all files are
 	// always mounted to /. For instance, /mock/foo and mock/bar
 	// will be mounted as /mock/foo and /mock/bar respectively.
-	fs.files[cleanPath("", f.Path())] = f
+	p := cleanPath("", f.Path())
+	if _, ok := fs.files[p]; ok {
+		return fmt.Errorf("file already exists: %v", p)
+	}
+	fs.files[p] = f
+	fs.paths = nil
 
 	// Append the filesize to the FileSystem.
 	s, err := f.Stat()
@@ -111,61 +212,121 @@ func (fs *memFS) Add(f *File) error {
 	return nil
 }
 
-// List returns the list of the file paths in the FileSystem.
+// List returns the list of the file paths in the FileSystem
+// in lexical order.
 func (fs *memFS) List() []string {
-	var (
-		out = make([]string, len(fs.files))
-		i   = 0
-	)
-	for p := range fs.files {
-		out[i] = p
-		i++
+	return append([]string(nil), fs.index()...)
+}
+
+// index returns the sorted paths of the files, building the index if
+// files have been added or deleted since it was last built. The returned
+// slice must not be modified.
+func (fs *memFS) index() []string {
+	fs.mu.RLock()
+	paths := fs.paths
+	fs.mu.RUnlock()
+	if paths != nil {
+		return paths
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.paths == nil {
+		paths := make([]string, 0, len(fs.files))
+		for p := range fs.files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		fs.paths = paths
 	}
-	return out
+	return fs.paths
 }
 
 // Len returns the number of files in the FileSystem.
 func (fs *memFS) Len() int {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
 	return len(fs.files)
 }
 
 // Size returns the total size of all the files in the FileSystem.
 func (fs *memFS) Size() int64 {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
 	return fs.size
 }
 
-// Get returns a copy of a File from the FileSystem by its path.
+// Get returns a File from the FileSystem by its path with its own
+// reader. The content isn't copied.
 func (fs *memFS) Get(fPath string) (*File, error) {
-	f, ok := fs.files[cleanPath("/", fPath)]
-	if !ok {
+	out := &File{}
+	if !fs.open(fPath, out) {
 		return nil, os.ErrNotExist
 	}
-	return NewFile(f.path, f.info, f.b), nil
+	return out, nil
+}
+
+// open sets out to the File stored at a path with its own reader. Paths
+// that are already clean, such as the ones from http.FileServer, are
+// looked up as is. It's done under the lock so that lazy Files get their
+// reference to the payload before a reload can close it.
+func (fs *memFS) open(fPath string, out *File) bool {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	f, ok := fs.files[fPath]
+	if !ok {
+		if f, ok = fs.files[cleanPath("/", fPath)]; !ok {
+			return false
+		}
+	}
+	out.reset(f)
+	return true
 }
 
 // Glob returns the file paths in the filesystem matching
-// a pattern.
+// a pattern in lexical order. Only the paths in the sorted index that
+// start with the pattern's leading literal part (eg: /templates/ in
+// /templates/*.html) are matched against it.
 func (fs *memFS) Glob(pattern string) ([]string, error) {
-	var out []string
-	for _, f := range fs.List() {
-		ok, err := filepath.Match(pattern, f)
-		if err != nil {
-			return nil, err
-		}
-		if ok {
-			out = append(out, f)
+	// Bad patterns are errors even if there are no paths to match.
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var (
+		paths  = fs.index()
+		prefix = globPrefix(pattern)
+		out    []string
+	)
+	for i := sort.SearchStrings(paths, prefix); i < len(paths) && strings.HasPrefix(paths[i], prefix); i++ {
+		if ok, _ := filepath.Match(pattern, paths[i]); ok {
+			out = append(out, paths[i])
 		}
 	}
 
 	return out, nil
 }
 
+// globPrefix returns the leading part of a filepath.Match pattern
+// without pattern characters or escapes.
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
 // Read returns a copy of a File's bytes from the FileSystem by its path.
 func (fs *memFS) Read(fPath string) ([]byte, error) {
 	f, err := fs.Get(fPath)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
+	if err := f.load(); err != nil {
+		return nil, err
+	}
 	return f.ReadBytes(), nil
 }
 
@@ -176,12 +337,17 @@ func (fs *memFS) Open(path string) (http.File, error) {
 
 // Delete deletes the given path.
 func (fs *memFS) Delete(fPath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
 	fPath = cleanPath("/", fPath)
-	_, ok := fs.files[fPath]
+	f, ok := fs.files[fPath]
 	if !ok {
 		return os.ErrNotExist
 	}
 	delete(fs.files, fPath)
+	fs.paths = nil
+	fs.size -= f.info.Size()
 	return nil
 }
 
@@ -190,45 +356,170 @@ func (fs *memFS) Merge(src FileSystem) error {
 	return MergeFS(fs, src)
 }
 
+// ReloadFrom re-reads the payload of a stuffed binary, for instance, the
+// executable after it has been replaced by a self-update, and atomically
+// swaps the files in the FileSystem with its files. Files that have
+// already been read aren't affected. On errors, the files are left as is.
+// See ReloadFS.
+func (fs *memFS) ReloadFrom(path string) error {
+	return ReloadFS(fs, path, UnStuffOpt{})
+}
+
+// Close closes the payload that the files of a FileSystem unstuffed with
+// UnStuffOpt.Lazy are read from once the Files that were returned by Get
+// and haven't been read are closed. Files that haven't been read can't be
+// read after it. It does nothing for other FileSystems.
+func (fs *memFS) Close() error {
+	fs.mu.Lock()
+	p := fs.payload
+	fs.payload = nil
+	fs.mu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	return p.release()
+}
+
 // FileServer returns an http.Handler that serves the files from
-// the file system like http.FileServer.
+// the file system like http.FileServer. The Files that it opens are
+// pooled and reused once they're closed.
 func (fs *memFS) FileServer() http.Handler {
-	return http.FileServer(fs)
+	return http.FileServer(pooledFS{fs})
 }
 
-// NewFile creates and returns a new instance of File.
+// pooledFS is an http.FileSystem that opens pooled Files that are
+// returned to the pool when they're closed, for http.FileServer, which
+// doesn't use Files after closing them.
+type pooledFS struct {
+	fs *memFS
+}
+
+// Open returns a pooled File by its path.
+func (p pooledFS) Open(path string) (http.File, error) {
+	out, _ := p.fs.pool.Get().(*File)
+	if out == nil {
+		out = &File{}
+	}
+	if !p.fs.open(path, out) {
+		p.fs.pool.Put(out)
+		return nil, os.ErrNotExist
+	}
+	out.pool = &p.fs.pool
+	return out, nil
+}
+
+// NewFile creates and returns a new instance of File with
+// a copy of the given bytes.
 func NewFile(path string, info os.FileInfo, b []byte) *File {
+	return newFile(path, info, append(make([]byte, 0, len(b)), b...))
+}
+
+// newFile is NewFile without copying the bytes, which mustn't be
+// modified afterwards, for instance, bytes that have just been read.
+func newFile(path string, info os.FileInfo, b []byte) *File {
 	f := &File{
 		path: path,
 		info: info,
-		b:    make([]byte, len(b)),
+		b:    b,
 	}
-	copy(f.b, b)
-	f.rd = bytes.NewReader(f.b)
+	f.rd.Reset(b)
 	return f
 }
 
+// reset sets the File to the content of a File with a new reader.
+// Lazy Files take a reference to their payload.
+func (f *File) reset(src *File) {
+	f.path, f.info, f.b, f.meta, f.lazy = src.path, src.info, src.b, src.meta, src.lazy
+	f.rd.Reset(src.b)
+	f.stream = fileStream{}
+	f.ref = nil
+	if f.lazy != nil && f.lazy.ref.acquire() {
+		f.ref = f.lazy.ref
+	}
+}
+
+// release releases the File's reference to its payload, if any.
+func (f *File) release() {
+	if f.ref != nil {
+		f.ref.release()
+		f.ref = nil
+	}
+}
+
+// load decompresses the content of a File that's loaded lazily
+// when it's first read.
+func (f *File) load() error {
+	if f.lazy == nil {
+		return nil
+	}
+	load := f.lazy.load
+	if f.lazy.stream {
+		f.closeStream()
+		load = f.lazy.readAll
+	}
+	b, err := load()
+	if err != nil {
+		return err
+	}
+	f.b, f.lazy = b, nil
+	f.rd.Reset(b)
+	f.release()
+	return nil
+}
+
 // Path returns the path of the file.
 func (f *File) Path() string {
 	return f.path
 }
 
-// ReadBytes returns the bytes of the given file.
+// Meta returns the optional metadata of the file that was
+// set in a stuffing manifest. It's nil if there's none.
+func (f *File) Meta() map[string]string {
+	return f.meta
+}
+
+// ReadBytes returns a copy of the bytes of the given file. It's nil
+// if the file is loaded lazily and can't be read (see UnStuffOpt.Lazy).
 func (f *File) ReadBytes() []byte {
+	if f.load() != nil {
+		return nil
+	}
 	b := make([]byte, len(f.b))
 	copy(b, f.b)
 	return b
 }
 
 // Close emulates http.File's Close but internally,
-// it simply seeks the File's reader to 0.
+// it simply seeks the File's reader to 0. Files opened by
+// FileServer are returned to its pool instead. Lazy Files
+// release their payload (see UnStuffOpt.Lazy).
 func (f *File) Close() error {
+	if f.lazy != nil && f.lazy.stream {
+		f.closeStream()
+	}
+	f.release()
+	if p := f.pool; p != nil {
+		*f = File{}
+		p.Put(f)
+		return nil
+	}
+	if f.lazy != nil {
+		return nil
+	}
+
 	_, err := f.Seek(0, 0)
 	return err
 }
 
 // Read reads the file contents.
 func (f *File) Read(b []byte) (int, error) {
+	if f.lazy != nil && f.lazy.stream {
+		return f.readStream(b)
+	}
+	if err := f.load(); err != nil {
+		return 0, err
+	}
 	return f.rd.Read(b)
 }
 
@@ -239,6 +530,12 @@ func (f *File) Readdir(count int) ([]os.FileInfo, error) {
 
 // Seek seeks the given offset in the file.
 func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.lazy != nil && f.lazy.stream {
+		return f.seekStream(offset, whence)
+	}
+	if err := f.load(); err != nil {
+		return 0, err
+	}
 	return f.rd.Seek(offset, whence)
 }
 
@@ -247,6 +544,24 @@ func (f *File) Stat() (os.FileInfo, error) {
 	return f.info, nil
 }
 
+// Name returns the base name of the file.
+func (f *fileInfo) Name() string { return f.name }
+
+// Size returns the size of the file.
+func (f *fileInfo) Size() int64 { return f.size }
+
+// Mode returns the file mode.
+func (f *fileInfo) Mode() os.FileMode { return f.mode }
+
+// ModTime returns the modification time.
+func (f *fileInfo) ModTime() time.Time { return f.modTime }
+
+// IsDir is always false.
+func (f *fileInfo) IsDir() bool { return false }
+
+// Sys returns nil.
+func (f *fileInfo) Sys() interface{} { return nil }
+
 func cleanPath(rootPath, p string) string {
 	if rootPath == "" {
 		rootPath = "/"
@@ -264,48 +579,351 @@ func cleanPath(rootPath, p string) string {
 	return p
 }
 
+// TemplateError represents an error in reading or parsing a template file.
+type TemplateError struct {
+	// Path is the path of the file in the FileSystem.
+	Path string
+
+	// Line is the line in the file where the error occurred.
+	// It is 0 if the line is not known.
+	Line int
+
+	Err error
+}
+
+// TemplateErrors is a list of errors from parsing multiple template files.
+type TemplateErrors []*TemplateError
+
+// TemplateOpt represents options for parsing templates.
+type TemplateOpt struct {
+	// Funcs is an optional map of functions applied to the templates.
+	Funcs map[string]interface{}
+
+	// LeftDelim and RightDelim are optional action delimiters that
+	// replace the default {{ and }}, for instance, to avoid clashes with
+	// client side template syntax (Vue, Angular etc.) in the files.
+	LeftDelim  string
+	RightDelim string
+
+	// Options is an optional list of options passed to
+	// template.Option(), eg: "missingkey=error".
+	Options []string
+}
+
 // ParseTemplatesGlob takes a file system, a file path pattern,
 // and parses matching files into a template.Template with an
 // optional template.FuncMap that will be applied to the compiled
 // templates.
 func ParseTemplatesGlob(f template.FuncMap, fs FileSystem, pattern string) (*template.Template, error) {
-	paths, err := fs.Glob(pattern)
+	return ParseTemplatesGlobOpt(TemplateOpt{Funcs: f}, fs, pattern)
+}
+
+// ParseTemplatesGlobOpt is ParseTemplatesGlob with TemplateOpt options.
+func ParseTemplatesGlobOpt(o TemplateOpt, fs FileSystem, pattern string) (*template.Template, error) {
+	paths, err := globTemplates(fs, pattern)
 	if err != nil {
 		return nil, err
 	}
-	if len(paths) == 0 {
-		return nil, fmt.Errorf("pattern %s matches no files", pattern)
-	}
-	return ParseTemplates(f, fs, paths...)
+	return ParseTemplatesOpt(o, fs, paths...)
 }
 
 // ParseTemplates takes a file system, a list of file paths,
-// and parses them into a template.Template.
+// and parses them into a template.Template. Each file is parsed into
+// an associated template named after its cleaned path (eg: /templates/index.html)
+// which can be executed with ExecuteTemplate() or looked up with LookupTemplate().
+// The returned template is the one named after the first path, so Execute()
+// runs the first file and not the last one as in older versions.
 func ParseTemplates(f template.FuncMap, fs FileSystem, path ...string) (*template.Template, error) {
-	tpl := template.New(filepath.Base(path[0]))
-	if f != nil {
-		tpl = tpl.Funcs(f)
-	}
+	return ParseTemplatesOpt(TemplateOpt{Funcs: f}, fs, path...)
+}
 
+// ParseTemplatesOpt is ParseTemplates with TemplateOpt options.
+func ParseTemplatesOpt(o TemplateOpt, fs FileSystem, path ...string) (*template.Template, error) {
+	return parseTemplates(o, fs.Read, path...)
+}
+
+// parseTemplates parses the files at the given paths, read with
+// the read function, into a template.Template.
+func parseTemplates(o TemplateOpt, read func(string) ([]byte, error), path ...string) (*template.Template, error) {
 	if len(path) == 0 {
 		return nil, fmt.Errorf("no files named in call to ParseTemplates")
 	}
 
+	var (
+		tpl  *template.Template
+		errs TemplateErrors
+	)
 	for _, p := range path {
-		f, err := fs.Read(p)
+		b, err := read(p)
 		if err != nil {
-			return nil, fmt.Errorf("%s: %v", p, err)
+			errs = append(errs, newTemplateError(p, "", err))
+			continue
 		}
 
-		_, err = tpl.Parse(string(f))
+		var (
+			name = cleanPath("/", p)
+			t    *template.Template
+		)
+		if tpl == nil {
+			tpl = template.New(name).Delims(o.LeftDelim, o.RightDelim).Option(o.Options...)
+			if o.Funcs != nil {
+				tpl = tpl.Funcs(o.Funcs)
+			}
+		}
+		if name == tpl.Name() {
+			t = tpl
+		} else {
+			t = tpl.New(name)
+		}
+
+		if _, err := t.Parse(string(b)); err != nil {
+			errs = append(errs, newTemplateError(p, name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return tpl, nil
+}
+
+// LookupTemplate returns the template associated with tpl that was
+// parsed from the given file path, or nil if there is none.
+func LookupTemplate(tpl *template.Template, path string) *template.Template {
+	return tpl.Lookup(cleanPath("/", path))
+}
+
+// ParseTextTemplatesGlob is the text/template equivalent of ParseTemplatesGlob.
+// Unlike html/template, text/template does not escape content, which makes
+// it suitable for plaintext e-mails, SQL, config files etc.
+func ParseTextTemplatesGlob(f ttemplate.FuncMap, fs FileSystem, pattern string) (*ttemplate.Template, error) {
+	return ParseTextTemplatesGlobOpt(TemplateOpt{Funcs: f}, fs, pattern)
+}
+
+// ParseTextTemplatesGlobOpt is ParseTextTemplatesGlob with TemplateOpt options.
+func ParseTextTemplatesGlobOpt(o TemplateOpt, fs FileSystem, pattern string) (*ttemplate.Template, error) {
+	paths, err := globTemplates(fs, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTextTemplatesOpt(o, fs, paths...)
+}
+
+// ParseTextTemplates is the text/template equivalent of ParseTemplates.
+func ParseTextTemplates(f ttemplate.FuncMap, fs FileSystem, path ...string) (*ttemplate.Template, error) {
+	return ParseTextTemplatesOpt(TemplateOpt{Funcs: f}, fs, path...)
+}
+
+// ParseTextTemplatesOpt is ParseTextTemplates with TemplateOpt options.
+func ParseTextTemplatesOpt(o TemplateOpt, fs FileSystem, path ...string) (*ttemplate.Template, error) {
+	return parseTextTemplates(o, fs.Read, path...)
+}
+
+// parseTextTemplates is the text/template equivalent of parseTemplates.
+func parseTextTemplates(o TemplateOpt, read func(string) ([]byte, error), path ...string) (*ttemplate.Template, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("no files named in call to ParseTextTemplates")
+	}
+
+	var (
+		tpl  *ttemplate.Template
+		errs TemplateErrors
+	)
+	for _, p := range path {
+		b, err := read(p)
 		if err != nil {
-			return nil, err
+			errs = append(errs, newTemplateError(p, "", err))
+			continue
+		}
+
+		var (
+			name = cleanPath("/", p)
+			t    *ttemplate.Template
+		)
+		if tpl == nil {
+			tpl = ttemplate.New(name).Delims(o.LeftDelim, o.RightDelim).Option(o.Options...)
+			if o.Funcs != nil {
+				tpl = tpl.Funcs(o.Funcs)
+			}
+		}
+		if name == tpl.Name() {
+			t = tpl
+		} else {
+			t = tpl.New(name)
+		}
+
+		if _, err := t.Parse(string(b)); err != nil {
+			errs = append(errs, newTemplateError(p, name, err))
 		}
 	}
 
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return tpl, nil
 }
 
+// LookupTextTemplate is the text/template equivalent of LookupTemplate.
+func LookupTextTemplate(tpl *ttemplate.Template, path string) *ttemplate.Template {
+	return tpl.Lookup(cleanPath("/", path))
+}
+
+// Error returns the error message prefixed with the file path and line.
+func (e *TemplateError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// Error returns the messages of all the errors, one per line.
+func (e TemplateErrors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// newTemplateError returns a TemplateError for the given file path.
+// Parse errors from the template package are of the form
+// "template: name:line: msg" from which the line number is extracted.
+func newTemplateError(path, name string, err error) *TemplateError {
+	e := &TemplateError{Path: path, Err: err}
+	if name == "" {
+		return e
+	}
+
+	msg := strings.TrimPrefix(err.Error(), "template: "+name+":")
+	if msg == err.Error() {
+		return e
+	}
+
+	// Line number followed by a colon.
+	i := strings.Index(msg, ":")
+	if i < 0 {
+		return e
+	}
+	line, lErr := strconv.Atoi(msg[:i])
+	if lErr != nil {
+		return e
+	}
+
+	// Column or context, if any, followed by the actual message.
+	e.Line = line
+	e.Err = errors.New(strings.TrimSpace(msg[i+1:]))
+	return e
+}
+
+// ParseTemplatesFS is the equivalent of ParseTemplatesGlob that parses
+// files matching one or more patterns from any io/fs.FS, for instance,
+// an embed.FS. This allows the same template parsing code path to be
+// used with go:embed and stuffbin. As with ParseTemplates, templates are
+// named after their paths rooted at /, eg: /templates/index.html.
+func ParseTemplatesFS(f template.FuncMap, fsys iofs.FS, patterns ...string) (*template.Template, error) {
+	return ParseTemplatesFSOpt(TemplateOpt{Funcs: f}, fsys, patterns...)
+}
+
+// ParseTemplatesFSOpt is ParseTemplatesFS with TemplateOpt options.
+func ParseTemplatesFSOpt(o TemplateOpt, fsys iofs.FS, patterns ...string) (*template.Template, error) {
+	paths, err := globIOFS(fsys, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplates(o, readIOFS(fsys), paths...)
+}
+
+// ParseTextTemplatesFS is the text/template equivalent of ParseTemplatesFS.
+func ParseTextTemplatesFS(f ttemplate.FuncMap, fsys iofs.FS, patterns ...string) (*ttemplate.Template, error) {
+	return ParseTextTemplatesFSOpt(TemplateOpt{Funcs: f}, fsys, patterns...)
+}
+
+// ParseTextTemplatesFSOpt is ParseTextTemplatesFS with TemplateOpt options.
+func ParseTextTemplatesFSOpt(o TemplateOpt, fsys iofs.FS, patterns ...string) (*ttemplate.Template, error) {
+	paths, err := globIOFS(fsys, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	return parseTextTemplates(o, readIOFS(fsys), paths...)
+}
+
+// globIOFS returns the file paths in an io/fs.FS matching one or
+// more patterns and errors if a pattern matches nothing.
+func globIOFS(fsys iofs.FS, patterns ...string) ([]string, error) {
+	var out []string
+	for _, pattern := range patterns {
+		paths, err := iofs.Glob(fsys, strings.TrimPrefix(pattern, "/"))
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("pattern %s matches no files", pattern)
+		}
+		out = append(out, paths...)
+	}
+	return out, nil
+}
+
+// readIOFS returns a function that reads files from an io/fs.FS
+// given paths that may be rooted at /.
+func readIOFS(fsys iofs.FS) func(string) ([]byte, error) {
+	return func(p string) ([]byte, error) {
+		return iofs.ReadFile(fsys, strings.TrimPrefix(p, "/"))
+	}
+}
+
+// globTemplates returns the file paths in the FileSystem matching
+// a pattern and errors if there are none.
+func globTemplates(fs FileSystem, pattern string) ([]string, error) {
+	paths, err := fs.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("pattern %s matches no files", pattern)
+	}
+	return paths, nil
+}
+
+// ReloadFS is Reloader.ReloadFrom with UnStuffOpt options, for instance,
+// to reload encrypted payloads with a key. It's safe to read from the
+// FileSystem while it's being reloaded. The payload of a lazy FileSystem
+// (see UnStuffOpt.Lazy) is closed once the files are swapped and the Files
+// that were returned by Get before and haven't been read are closed.
+func ReloadFS(fs FileSystem, path string, o UnStuffOpt) error {
+	dest, ok := fs.(*memFS)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	src, err := UnStuffWithOpt(path, o)
+	if err != nil {
+		return err
+	}
+	s, ok := src.(*memFS)
+	if !ok {
+		s = &memFS{files: make(map[string]*File)}
+		if err := MergeFS(s, src); err != nil {
+			return err
+		}
+	}
+
+	dest.mu.Lock()
+	old := dest.payload
+	dest.files, dest.paths, dest.size, dest.payload = s.files, nil, s.size, s.payload
+	dest.mu.Unlock()
+
+	if old != nil {
+		old.release()
+	}
+	return nil
+}
+
 // MergeFS merges FileSystem b into a, overwriting conflicting paths.
 func MergeFS(dest FileSystem, src FileSystem) error {
 	for _, path := range src.List() {
@@ -316,7 +934,8 @@ func MergeFS(dest FileSystem, src FileSystem) error {
 		}
 
 		// Check if the path exists in the target. If yes, remove.
-		if err, _ := dest.Get(path); err != nil {
+		if d, _ := dest.Get(path); d != nil {
+			d.Close()
 			if err := dest.Delete(path); err != nil {
 				return err
 			}
@@ -2,9 +2,15 @@ package stuffbin
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -31,6 +37,67 @@ func TestFileServer(t *testing.T) {
 	assert(t, "status error in GET "+uri, 404, res.StatusCode)
 }
 
+func TestFileServerConcurrent(t *testing.T) {
+	fs, _ := NewFS()
+	for _, p := range []string{"/a.txt", "/b.txt"} {
+		b := bytes.Repeat([]byte(p), 1000)
+		assert(t, "error adding file", nil, fs.Add(NewFile(p, &fileInfo{name: p[1:], size: int64(len(b))}, b)))
+	}
+
+	// Pooled Files are reused across requests without mixing them up.
+	var (
+		h    = fs.FileServer()
+		wg   sync.WaitGroup
+		errs = make(chan string, 100)
+	)
+	for n := 0; n < 100; n++ {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, p, nil))
+			if want := strings.Repeat(p, 1000); rec.Code != 200 || rec.Body.String() != want {
+				errs <- p
+			}
+		}([]string{"/a.txt", "/b.txt"}[n%2])
+	}
+	wg.Wait()
+	close(errs)
+	for p := range errs {
+		t.Errorf("mismatch in served file %s", p)
+	}
+
+	// Files from Get aren't pooled and can be read after closing them.
+	f, err := fs.Get("/a.txt")
+	assert(t, "error getting file", nil, err)
+	assert(t, "error closing file", nil, f.Close())
+	b, err := ioutil.ReadAll(f)
+	assert(t, "error reading closed file", nil, err)
+	assert(t, "mismatch in closed file", 6000, len(b))
+}
+
+// BenchmarkFileServer serves a 1 MB file.
+func BenchmarkFileServer(b *testing.B) {
+	fs, _ := NewFS()
+	fs.Add(NewFile("/a.bin", &fileInfo{name: "a.bin", size: 1 << 20}, make([]byte, 1<<20)))
+
+	var (
+		h   = fs.FileServer()
+		req = httptest.NewRequest(http.MethodGet, "/a.bin", nil)
+	)
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		h.ServeHTTP(discardWriter{}, req)
+	}
+}
+
+// discardWriter is an http.ResponseWriter that discards the response.
+type discardWriter struct{}
+
+func (discardWriter) Header() http.Header         { return http.Header{} }
+func (discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardWriter) WriteHeader(int)             {}
+
 func TestNewLocalFSWithAlias(t *testing.T) {
 	fs, err := NewLocalFS("/", "mock/:test/", "mock/foo.txt")
 	assert(t, "error creating local FS", nil, err)
@@ -73,6 +140,71 @@ func TestNewLocalFS(t *testing.T) {
 	assert(t, "mismatch in local FS", f, f2)
 }
 
+func TestNewLocalFSExclude(t *testing.T) {
+	fs, err := NewLocalFSWithOpt(LocalFSOpt{
+		RootPath: "/",
+		Exclude:  []string{"**/*.exe*", "*.go", "subdir/**", "foo*"},
+	}, "mock/", "mock/foo.txt:/foo.txt")
+	assert(t, "error creating local FS", nil, err)
+
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in local FS", []string{"/mock/bar.txt"}, f)
+
+	_, err = NewLocalFSWithOpt(LocalFSOpt{Exclude: []string{"a/["}}, "mock/")
+	assert(t, "expected error on invalid pattern", true, err != nil)
+
+	for _, c := range []struct {
+		pattern, path string
+		ok            bool
+	}{
+		{"**/*.map", "static/js/app.js.map", true},
+		{"**/*.map", "app.js.map", true},
+		{"node_modules/**", "static/node_modules/x/y.js", true},
+		{"node_modules", "static/node_modules", true},
+		{"static/*.css", "web/static/app.css", true},
+		{"static/*.css", "static/css/app.css", false},
+		{"**/.DS_Store", "static/.DS_Store.txt", false},
+	} {
+		assert(t, c.pattern+" "+c.path, c.ok, isExcluded([]string{c.pattern}, c.path))
+	}
+}
+
+func TestNewLocalFSSkipHidden(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"a.txt", ".DS_Store", ".git/config", "sub/.a.txt.swp", "sub/b.txt"} {
+		p := filepath.Join(dir, f)
+		assert(t, "error creating dir", nil, os.MkdirAll(filepath.Dir(p), 0755))
+		assert(t, "error writing file", nil, os.WriteFile(p, []byte(f), 0644))
+	}
+
+	fs, err := NewLocalFSWithOpt(LocalFSOpt{SkipHidden: true}, dir+":/static", filepath.Join(dir, ".DS_Store")+":/.DS_Store")
+	assert(t, "error creating local FS", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in local FS", []string{"/.DS_Store", "/static/a.txt", "/static/sub/b.txt"}, f)
+
+	fs, err = NewLocalFSWithOpt(LocalFSOpt{}, dir+":/static")
+	assert(t, "error creating local FS", nil, err)
+	assert(t, "hidden files should be included by default", 5, fs.Len())
+}
+
+func TestNewLocalFSRewrite(t *testing.T) {
+	fs, err := NewLocalFSWithOpt(LocalFSOpt{
+		Rewrite: []string{`s|^mock/subdir|/sub|`, `s#\.txt$#.text#`},
+	}, "mock/subdir", "mock/bar.txt", "mock/foo.txt:/foo.txt")
+	assert(t, "error creating local FS", nil, err)
+
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in local FS", []string{"/foo.txt", "/mock/bar.text", "/sub/baz.text"}, f)
+
+	for _, r := range []string{"s|a|b", "x|a|b|", "s|(|b|", "s|a|b|c|"} {
+		_, err = NewLocalFSWithOpt(LocalFSOpt{Rewrite: []string{r}}, "mock/bar.txt")
+		assert(t, "expected error on invalid rule "+r, true, err != nil)
+	}
+}
+
 func TestGlob(t *testing.T) {
 	fs, err := NewLocalFS("/", "mock/", "mock/foo.txt:/foo.txt")
 	assert(t, "error creating local FS", nil, err)
@@ -87,6 +219,92 @@ func TestGlob(t *testing.T) {
 	g, err = fs.Glob("/mock/*.exe")
 	assert(t, "glob creation failed", nil, err)
 	assert(t, "glob match failed", []string{"/mock/mock.exe"}, g)
+
+	// The index follows added and deleted files.
+	assert(t, "error adding file", nil, fs.Add(NewFile("/mock/b.exe", &fileInfo{name: "b.exe", size: 1}, []byte("b"))))
+	g, err = fs.Glob("/mock/*.exe")
+	assert(t, "glob creation failed", nil, err)
+	assert(t, "glob match failed after add", []string{"/mock/b.exe", "/mock/mock.exe"}, g)
+
+	assert(t, "error deleting file", nil, fs.Delete("/mock/mock.exe"))
+	g, err = fs.Glob("/*/*.exe")
+	assert(t, "glob creation failed", nil, err)
+	assert(t, "glob match failed after delete", []string{"/mock/b.exe"}, g)
+
+	// Paths are checked for duplicates after they're cleaned.
+	assert(t, "expected error on duplicate path", true, fs.Add(NewFile("/mock//b.exe", &fileInfo{name: "b.exe", size: 1}, []byte("b"))) != nil)
+
+	_, err = fs.Glob("/mock/[")
+	assert(t, "expected error on bad pattern", true, err != nil)
+}
+
+func TestGetSharesContent(t *testing.T) {
+	b := []byte("hello")
+	fs, _ := NewFS()
+	assert(t, "error adding file", nil, fs.Add(NewFile("/a.txt", &fileInfo{name: "a.txt", size: 5}, b)))
+
+	// NewFile copies the bytes.
+	b[0] = 'j'
+	got, err := fs.Read("/a.txt")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in file", "hello", string(got))
+
+	// Read returns a copy.
+	got[0] = 'j'
+	got, _ = fs.Read("/a.txt")
+	assert(t, "mismatch in file after modifying a read", "hello", string(got))
+
+	// Files share the content but not the reader.
+	f1, _ := fs.Get("/a.txt")
+	f2, _ := fs.Get("/a.txt")
+	assert(t, "content not shared", &f1.b[0], &f2.b[0])
+
+	p := make([]byte, 2)
+	f1.Read(p)
+	n, _ := f2.Read(p)
+	assert(t, "mismatch in independent read", "he", string(p[:n]))
+}
+
+// BenchmarkGet gets a 1 MB file, which shouldn't copy its content.
+func BenchmarkGet(b *testing.B) {
+	fs, _ := NewFS()
+	fs.Add(NewFile("/a.bin", &fileInfo{name: "a.bin", size: 1 << 20}, make([]byte, 1<<20)))
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		fs.Get("/a.bin")
+	}
+}
+
+// BenchmarkGlob globs a filesystem of 10,000 files with the path index and
+// by matching every path as Glob used to.
+func BenchmarkGlob(b *testing.B) {
+	fs, _ := NewFS()
+	for n := 0; n < 10000; n++ {
+		fs.Add(NewFile(fmt.Sprintf("/static/%d/%d.js", n%100, n), &fileInfo{}, nil))
+	}
+	for n := 0; n < 50; n++ {
+		fs.Add(NewFile(fmt.Sprintf("/templates/%d.html", n), &fileInfo{}, nil))
+	}
+
+	for _, pattern := range []string{"/templates/*.html", "/static/4?/*.js", "/*/*.html"} {
+		b.Run("index "+pattern, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				fs.Glob(pattern)
+			}
+		})
+		b.Run("scan "+pattern, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				var out []string
+				for _, p := range fs.List() {
+					if ok, _ := filepath.Match(pattern, p); ok {
+						out = append(out, p)
+					}
+				}
+				sort.Strings(out)
+			}
+		})
+	}
 }
 
 func TestParseTemplates(t *testing.T) {
@@ -118,6 +336,164 @@ func TestParseTemplates(t *testing.T) {
 	assert(t, "mismatch in executed template", "foo\nfoo - func\n", b.String())
 }
 
+func TestParseTemplatesNamed(t *testing.T) {
+	fs, err := NewLocalFS("/", "mock/bar.txt:/bar.txt", "mock/foo.txt:/foo.txt", "mock/foofunc.txt:/foofunc.txt")
+	assert(t, "error creating local FS", nil, err)
+
+	mp := map[string]interface{}{
+		"Foo": func() string {
+			return "func"
+		},
+	}
+	tpl, err := ParseTemplates(mp, fs, "bar.txt", "/foo.txt", "/foofunc.txt")
+	assert(t, "error parsing template", nil, err)
+	assert(t, "root template name", "/bar.txt", tpl.Name())
+
+	// Each file should be executable by its path.
+	b := bytes.Buffer{}
+	err = tpl.Execute(&b, nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template", "bar", b.String())
+
+	b.Reset()
+	err = tpl.ExecuteTemplate(&b, "/foo.txt", nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template", "foo\nfoo - func\n", b.String())
+
+	assert(t, "lookup by path", true, LookupTemplate(tpl, "foo.txt") != nil)
+	assert(t, "lookup by path", true, LookupTemplate(tpl, "/nope.txt") == nil)
+
+	ttpl, err := ParseTextTemplates(mp, fs, "/bar.txt", "/foo.txt", "/foofunc.txt")
+	assert(t, "error parsing template", nil, err)
+	b.Reset()
+	err = LookupTextTemplate(ttpl, "foo.txt").Execute(&b, nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template", "foo\nfoo - func\n", b.String())
+}
+
+func TestParseTemplatesRoot(t *testing.T) {
+	fs, _ := NewFS()
+	for _, f := range []string{"/a.html", "/b.html"} {
+		fs.Add(NewFile(f, &fileInfo{name: f[1:]}, []byte(f[1:2])))
+	}
+
+	// The returned template runs the first file.
+	tpl, err := ParseTemplates(nil, fs, "/a.html", "/b.html")
+	assert(t, "error parsing template", nil, err)
+	b := bytes.Buffer{}
+	assert(t, "template execute failed", nil, tpl.Execute(&b, nil))
+	assert(t, "mismatch in root template", "a", b.String())
+
+	b.Reset()
+	assert(t, "template execute failed", nil, tpl.ExecuteTemplate(&b, "/b.html", nil))
+	assert(t, "mismatch in executed template", "b", b.String())
+}
+
+func TestParseTemplatesOpt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stuffbin")
+	assert(t, "error creating temp dir", nil, err)
+	defer os.RemoveAll(dir)
+
+	fPath := filepath.Join(dir, "vue.html")
+	err = ioutil.WriteFile(fPath, []byte(`<p>{{ msg }}</p>[[ Foo ]] [[ .Name ]]`), 0644)
+	assert(t, "error writing template", nil, err)
+
+	fs, err := NewLocalFS("/", fPath+":/vue.html")
+	assert(t, "error creating local FS", nil, err)
+
+	o := TemplateOpt{
+		Funcs: map[string]interface{}{
+			"Foo": func() string {
+				return "func"
+			},
+		},
+		LeftDelim:  "[[",
+		RightDelim: "]]",
+		Options:    []string{"missingkey=error"},
+	}
+	tpl, err := ParseTemplatesGlobOpt(o, fs, "/*.html")
+	assert(t, "error parsing template", nil, err)
+
+	b := bytes.Buffer{}
+	err = tpl.Execute(&b, map[string]string{"Name": "x"})
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template", "<p>{{ msg }}</p>func x", b.String())
+
+	// missingkey=error.
+	b.Reset()
+	err = tpl.Execute(&b, map[string]string{})
+	assert(t, "expected missingkey error", true, err != nil)
+
+	ttpl, err := ParseTextTemplatesGlobOpt(o, fs, "/*.html")
+	assert(t, "error parsing template", nil, err)
+	b.Reset()
+	err = ttpl.Execute(&b, map[string]string{"Name": "<x>"})
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template", "<p>{{ msg }}</p>func <x>", b.String())
+}
+
+func TestParseTemplatesErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stuffbin")
+	assert(t, "error creating temp dir", nil, err)
+	defer os.RemoveAll(dir)
+
+	for name, b := range map[string]string{
+		"a.html": "ok",
+		"b.html": "line1\nline2 {{ end }}",
+		"c.html": "\n\n{{ if }}",
+	} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(b), 0644)
+		assert(t, "error writing template", nil, err)
+	}
+
+	fs, err := NewLocalFS("/", dir+":/")
+	assert(t, "error creating local FS", nil, err)
+
+	_, err = ParseTemplates(nil, fs, "/a.html", "/b.html", "/c.html", "/nope.html")
+	errs, ok := err.(TemplateErrors)
+	assert(t, "expected TemplateErrors", true, ok)
+	assert(t, "error count", 3, len(errs))
+	assert(t, "error path", "/b.html", errs[0].Path)
+	assert(t, "error line", 2, errs[0].Line)
+	assert(t, "error path", "/c.html", errs[1].Path)
+	assert(t, "error line", 3, errs[1].Line)
+	assert(t, "error path", "/nope.html", errs[2].Path)
+	assert(t, "error line", 0, errs[2].Line)
+
+	_, err = ParseTextTemplatesGlob(nil, fs, "/*.html")
+	errs, ok = err.(TemplateErrors)
+	assert(t, "expected TemplateErrors", true, ok)
+	assert(t, "error count", 2, len(errs))
+	msgs := []string{errs[0].Error(), errs[1].Error()}
+	sort.Strings(msgs)
+	assert(t, "error message", `/b.html:2: unexpected {{end}}`, msgs[0])
+}
+
+func TestParseTemplatesFS(t *testing.T) {
+	mp := map[string]interface{}{
+		"Foo": func() string {
+			return "func"
+		},
+	}
+
+	fsys := os.DirFS("mock")
+	tpl, err := ParseTemplatesFS(mp, fsys, "foo.txt", "/foofunc.txt")
+	assert(t, "error parsing template", nil, err)
+	assert(t, "root template name", "/foo.txt", tpl.Name())
+
+	b := bytes.Buffer{}
+	err = tpl.Execute(&b, nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template", "foo\nfoo - func\n", b.String())
+
+	ttpl, err := ParseTextTemplatesFS(mp, fsys, "subdir/*.txt")
+	assert(t, "error parsing template", nil, err)
+	assert(t, "lookup by path", true, LookupTextTemplate(ttpl, "subdir/baz.txt") != nil)
+
+	_, err = ParseTemplatesFS(mp, fsys, "nope/*.txt")
+	assert(t, "expected error on no matches", true, err != nil)
+}
+
 func TestParseTemplatesGlob(t *testing.T) {
 	// Template func map.
 	mp := map[string]interface{}{
@@ -141,6 +517,36 @@ func TestParseTemplatesGlob(t *testing.T) {
 	assert(t, "mismatch in executed template", "foo\nfoo - func\n", b.String())
 }
 
+func TestParseTextTemplates(t *testing.T) {
+	fs, err := NewLocalFS("/", "mock/foo.txt:/foo.txt", "mock/foofunc.txt:/foofunc.txt")
+	assert(t, "error creating local FS", nil, err)
+
+	// Template func map.
+	mp := map[string]interface{}{
+		"Foo": func() string {
+			return "<func>"
+		},
+	}
+	tpl, err := ParseTextTemplates(mp, fs, "/foo.txt", "/foofunc.txt")
+	assert(t, "error parsing template", nil, err)
+
+	// text/template should not escape the output.
+	b := bytes.Buffer{}
+	err = tpl.Execute(&b, nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template", "foo\nfoo - <func>\n", b.String())
+
+	tpl, err = ParseTextTemplatesGlob(mp, fs, "/*.txt")
+	assert(t, "error parsing template", nil, err)
+	b.Reset()
+	err = tpl.Execute(&b, nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template", "foo\nfoo - <func>\n", b.String())
+
+	_, err = ParseTextTemplates(mp, fs)
+	assert(t, "expected error on no files", true, err != nil)
+}
+
 func TestMerge(t *testing.T) {
 	fs, err := NewLocalFS("/", "mock/", "mock/foo.txt:/foo.txt")
 	assert(t, "error creating local FS", nil, err)
@@ -175,3 +581,44 @@ func TestMergeOverwrite(t *testing.T) {
 	b, err := fs.Get("/foo.txt")
 	assert(t, "merged value doesn't match", "baz\n", string(b.ReadBytes()))
 }
+
+func TestReloadFrom(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "reload.exe")
+	_, _, err := Stuff(mockBin, out, "/", "mock/foo.txt")
+	assert(t, "error stuffing", nil, err)
+
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	old, err := fs.Get("/mock/foo.txt")
+	assert(t, "error getting file", nil, err)
+	oldB := old.ReadBytes()
+
+	// Replace the binary as a self-update would.
+	_, _, err = Stuff(mockBin, out, "/", "mock/subdir/baz.txt:/mock/foo.txt", "mock/bar.txt")
+	assert(t, "error stuffing", nil, err)
+
+	// Read while reloading.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			fs.Read("/mock/foo.txt")
+			fs.List()
+		}
+	}()
+	assert(t, "error reloading", nil, fs.(Reloader).ReloadFrom(out))
+	<-done
+
+	list := fs.List()
+	sort.Strings(list)
+	assert(t, "mismatch in files", []string{"/mock/bar.txt", "/mock/foo.txt"}, list)
+	b, err := fs.Read("/mock/foo.txt")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in reloaded file", "baz\n", string(b))
+	assert(t, "mismatch in old file", oldB, old.ReadBytes())
+
+	// Failed reloads leave the files as is.
+	assert(t, "reloaded a missing binary", true, fs.(Reloader).ReloadFrom(filepath.Join(dir, "nope")) != nil)
+	assert(t, "mismatch in file count", 2, fs.Len())
+}
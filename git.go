@@ -0,0 +1,77 @@
+package stuffbin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitPrefix is the prefix of paths that are stuffed as they exist at a git
+// revision instead of the working directory, in the format
+// git:ref:path[:alias] (eg: git:HEAD:frontend/dist, git:v1.2.0:static:/static).
+// The path is relative to the working directory, which should be in the
+// git repository. Colons in the path are escaped like in other paths (see
+// splitAlias).
+const gitPrefix = "git:"
+
+// GitPath returns the path to stuff the given file or directory as it
+// exists at the given git revision (eg: HEAD, v1.2.0, a commit hash).
+func GitPath(ref, p string) string {
+	return gitPrefix + ref + ":" + joinAlias(p, "")
+}
+
+// isGitPath checks whether a path to stuff is a git revision path.
+func isGitPath(p string) bool {
+	return strings.HasPrefix(p, gitPrefix)
+}
+
+// walkGit calls the callback for every file in a git revision path
+// (see gitPrefix) that isn't excluded by the walk options. The files are
+// read with git archive, which needs git to be installed.
+func walkGit(cb archiveWalkFunc, o walkOpt, p string) error {
+	ref, rest, _ := strings.Cut(strings.TrimPrefix(p, gitPrefix), ":")
+	src, alias, err := splitAlias(rest)
+	if err != nil {
+		return err
+	}
+	if ref == "" || src == "" {
+		return fmt.Errorf("invalid git path '%s'. Should be git:ref:path[:alias]", p)
+	}
+
+	var (
+		srcPath = path.Clean(filepath.ToSlash(src))
+		base    = srcPath
+	)
+	if alias != "" {
+		alias = cleanPath("/", alias)
+	}
+	if base == "." {
+		base = ""
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "archive", "--format=tar", ref, "--", srcPath)
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error running git: %v", err)
+	}
+
+	werr := walkTar(cb, out, archiveTarget(o, alias, base))
+	if werr != nil {
+		_ = cmd.Process.Kill()
+	}
+	_, _ = io.Copy(io.Discard, out)
+
+	if err := cmd.Wait(); err != nil && werr == nil {
+		return fmt.Errorf("error reading %s: %v: %s", p, err, strings.TrimSpace(stderr.String()))
+	}
+	return werr
+}
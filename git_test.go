@@ -0,0 +1,41 @@
+package stuffbin
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestStuffGit(t *testing.T) {
+	if err := exec.Command("git", "rev-parse", "HEAD").Run(); err != nil {
+		t.Skip("skipping test outside a git repository")
+	}
+
+	// Untracked files shouldn't be stuffed.
+	untracked := "mock/subdir/untracked.txt"
+	assert(t, "error writing file", nil, os.WriteFile(untracked, []byte("x"), 0644))
+	defer os.Remove(untracked)
+
+	out := filepath.Join(t.TempDir(), "stuffed")
+	_, _, err := Stuff(mockBin, out, "/", GitPath("HEAD", "mock/subdir")+":/sub", GitPath("HEAD", "mock/bar.txt"))
+	assert(t, "error stuffing", nil, err)
+
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	files := fs.List()
+	sort.Strings(files)
+	assert(t, "mismatch in stuffed files", []string{"/mock/bar.txt", "/sub/baz.txt"}, files)
+
+	want, err := exec.Command("git", "show", "HEAD:mock/bar.txt").Output()
+	assert(t, "error running git", nil, err)
+	b, err := fs.Read("/mock/bar.txt")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in file", string(want), string(b))
+
+	_, _, err = Stuff(mockBin, out, "/", "git:HEAD:mock/nonexistent")
+	assert(t, "expected error on unknown path", true, err != nil)
+	_, _, err = Stuff(mockBin, out, "/", "git:HEAD")
+	assert(t, "expected error on invalid git path", true, err != nil)
+}
@@ -0,0 +1,165 @@
+package stuffbin
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a parsed .gitignore pattern.
+type ignoreRule struct {
+	// segs are the pattern's segments relative to the directory
+	// of the .gitignore file (see matchSegments).
+	segs    []string
+	negate  bool
+	dirOnly bool
+}
+
+// gitIgnore matches the paths in a directory against the .gitignore files
+// in it and in its parent directories up to the root of its git working
+// tree, and the tree's .git/info/exclude, the same way git does. Global
+// excludes (core.excludesFile) aren't read so that stuffing doesn't depend
+// on the machine it runs on.
+type gitIgnore struct {
+	// top is the root of the working tree, or the directory
+	// if it's not in one.
+	top string
+
+	// rules are the rules of the directories that have been
+	// read, by path.
+	rules map[string][]ignoreRule
+}
+
+// newGitIgnore returns a gitIgnore for the paths in the given directory.
+func newGitIgnore(dir string) (*gitIgnore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &gitIgnore{top: abs, rules: make(map[string][]ignoreRule)}
+	for d := abs; ; {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			g.top = d
+			break
+		}
+		p := filepath.Dir(d)
+		if p == d {
+			break
+		}
+		d = p
+	}
+
+	// .git/info/exclude has a lower precedence than the .gitignore files,
+	// so its rules come first as the last matching rule wins.
+	b, _ := os.ReadFile(filepath.Join(g.top, ".git", "info", "exclude"))
+	ex := parseIgnore(b)
+	g.rules[g.top] = append(ex, g.load(g.top)...)
+	return g, nil
+}
+
+// ignored checks whether a local path is ignored. The parent directories
+// of the path aren't checked as walks skip ignored directories, and like
+// with git, files in them can't be re-included.
+func (g *gitIgnore) ignored(p string, isDir bool) bool {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(g.top, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if parts[len(parts)-1] == ".git" {
+		return true
+	}
+
+	// Match the rules of every directory from the top down to the path's
+	// with the path relative to the directory.
+	ignored := false
+	for i := range parts {
+		dir := filepath.Join(g.top, filepath.FromSlash(strings.Join(parts[:i], "/")))
+		for _, r := range g.load(dir) {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			if matchSegments(r.segs, parts[i:]) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// load returns the rules of the .gitignore file in a directory.
+func (g *gitIgnore) load(dir string) []ignoreRule {
+	if r, ok := g.rules[dir]; ok {
+		return r
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	r := parseIgnore(b)
+	g.rules[dir] = r
+	return r
+}
+
+// parseIgnore parses the patterns in a .gitignore file. Invalid
+// patterns are skipped as git does.
+func parseIgnore(b []byte) []ignoreRule {
+	var out []ignoreRule
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSuffix(l, "\r")
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		// Trailing spaces are ignored unless they're escaped.
+		for strings.HasSuffix(l, " ") && !strings.HasSuffix(l, `\ `) {
+			l = l[:len(l)-1]
+		}
+
+		var r ignoreRule
+		switch {
+		case strings.HasPrefix(l, "!"):
+			r.negate = true
+			l = l[1:]
+		case strings.HasPrefix(l, `\!`), strings.HasPrefix(l, `\#`):
+			l = l[1:]
+		}
+		if strings.HasSuffix(l, "/") {
+			r.dirOnly = true
+			l = strings.TrimSuffix(l, "/")
+		}
+		if l == "" {
+			continue
+		}
+
+		// Patterns without a slash match names at any depth. The rest are
+		// relative to the directory of the .gitignore file.
+		if !strings.Contains(l, "/") {
+			l = "**/" + l
+		}
+		l = strings.ReplaceAll(strings.TrimPrefix(l, "/"), "[!", "[^")
+
+		r.segs = strings.Split(l, "/")
+
+		// A trailing /** matches everything inside a directory,
+		// but not the directory itself.
+		if r.segs[len(r.segs)-1] == "**" {
+			r.segs = append(r.segs[:len(r.segs)-1], "*", "**")
+		}
+
+		valid := true
+		for _, s := range r.segs {
+			if _, err := path.Match(s, ""); err != nil {
+				valid = false
+			}
+		}
+		if valid {
+			out = append(out, r)
+		}
+	}
+	return out
+}
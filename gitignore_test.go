@@ -0,0 +1,65 @@
+package stuffbin
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestRespectGitignore(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".git/HEAD":                "ref: refs/heads/main",
+		".git/info/exclude":        "*.swp\n",
+		".gitignore":               "# deps\nnode_modules/\n/dist\n*.log\n!keep.log\nweb/build/**\n!web/build/app.js\n",
+		"web/index.html":           "x",
+		"web/app.log":              "x",
+		"web/keep.log":             "x",
+		"web/a.swp":                "x",
+		"web/node_modules/m/m.js":  "x",
+		"web/dist/d.js":            "x",
+		"web/build/app.js":         "x",
+		"web/build/app.map":        "x",
+		"web/sub/.gitignore":       "*.tmp\n",
+		"web/sub/s.tmp":            "x",
+		"web/sub/s.css":            "x",
+		"dist/top.js":              "x",
+		"web/sub/node_modules.txt": "x",
+	}
+	for p, b := range files {
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		assert(t, "error creating dir", nil, os.MkdirAll(filepath.Dir(p), 0755))
+		assert(t, "error writing file", nil, os.WriteFile(p, []byte(b), 0644))
+	}
+
+	list := func(o LocalFSOpt, paths ...string) []string {
+		t.Helper()
+		fs, err := NewLocalFSWithOpt(o, paths...)
+		assert(t, "error creating local FS", nil, err)
+		f := fs.List()
+		sort.Strings(f)
+		return f
+	}
+
+	// The rules of the .gitignore files up to the working tree's root
+	// and of nested ones apply to walked directories.
+	o := LocalFSOpt{RespectGitignore: true}
+	assert(t, "mismatch in files",
+		[]string{"/x/build/app.js", "/x/dist/d.js", "/x/index.html", "/x/keep.log", "/x/sub/.gitignore", "/x/sub/node_modules.txt", "/x/sub/s.css"},
+		list(o, filepath.Join(dir, "web")+":/x"))
+
+	// .git and anchored patterns are matched relative to the root.
+	assert(t, "mismatch in root files",
+		[]string{"/.gitignore", "/web/build/app.js", "/web/dist/d.js", "/web/index.html", "/web/keep.log",
+			"/web/sub/.gitignore", "/web/sub/node_modules.txt", "/web/sub/s.css"},
+		list(o, dir+":/"))
+
+	// Glob patterns skip ignored files.
+	assert(t, "mismatch in globbed files", []string{"/js/build/app.js", "/js/dist/d.js"},
+		list(o, dir+"/web/**/*.js:/js"))
+
+	// Explicit paths are always stuffed.
+	assert(t, "mismatch in explicit file", []string{"/app.log"},
+		list(o, filepath.Join(dir, "web", "app.log")+":/app.log"))
+}
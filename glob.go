@@ -0,0 +1,107 @@
+package stuffbin
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// hasMeta checks whether a path has glob pattern characters.
+func hasMeta(p string) bool {
+	return strings.ContainsAny(filepath.ToSlash(p), "*?[")
+}
+
+// expandGlob returns the paths to stuff (see splitAlias) of the local files
+// that match a glob pattern in lexical order, so that patterns behave the
+// same across shells and platforms. In addition to the filepath.Match
+// syntax, a ** segment matches zero or more directories. As with shells,
+// wildcards don't match dotfiles and dot-directories unless the pattern has
+// dot names. The paths of the files under the pattern's leading directory
+// are mapped to the optional alias like those of directories, for instance,
+// assets/**/*.css:/css maps assets/a/b.css to /css/a/b.css. Files that git
+// ignores are skipped if ignore is set (see StuffOpt.RespectGitignore).
+func expandGlob(pattern, alias string, ignore bool) ([]string, error) {
+	segs := strings.Split(filepath.ToSlash(pattern), "/")
+	if err := checkPattern(filepath.ToSlash(pattern)); err != nil {
+		return nil, err
+	}
+
+	// Walk the leading directory without pattern characters.
+	n := 0
+	for n < len(segs) && !hasMeta(segs[n]) {
+		n++
+	}
+	root := strings.Join(segs[:n], "/")
+	switch {
+	case root == "" && n > 0:
+		root = "/"
+	case root == "":
+		root = "."
+	}
+	root = filepath.FromSlash(root)
+
+	var (
+		match = segs[n:]
+		dots  = false
+		out   []string
+	)
+	for _, s := range match {
+		if strings.HasPrefix(s, ".") {
+			dots = true
+		}
+	}
+
+	var ig *gitIgnore
+	if ignore {
+		g, err := newGitIgnore(root)
+		if err != nil {
+			return nil, err
+		}
+		ig = g
+	}
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		if (!dots && isHidden(d.Name())) || (ig != nil && ig.ignored(p, d.IsDir())) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !matchSegments(match, strings.Split(rel, "/")) {
+			return nil
+		}
+
+		if alias != "" {
+			out = append(out, joinAlias(p, path.Join(alias, rel)))
+		} else {
+			out = append(out, joinAlias(p, ""))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no files match '%s'", pattern)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,49 @@
+package stuffbin
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestExpandGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"a.css", "b.js", "sub/c.css", "sub/deep/d.css", ".hidden/e.css", "sub/.f.css"} {
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		assert(t, "error creating dir", nil, os.MkdirAll(filepath.Dir(p), 0755))
+		assert(t, "error writing file", nil, os.WriteFile(p, []byte("x"), 0644))
+	}
+
+	list := func(o LocalFSOpt, paths ...string) []string {
+		t.Helper()
+		fs, err := NewLocalFSWithOpt(o, paths...)
+		assert(t, "error creating local FS", nil, err)
+		f := fs.List()
+		sort.Strings(f)
+		return f
+	}
+
+	// Files under the pattern's leading directory are mapped to the alias.
+	assert(t, "mismatch in globbed files", []string{"/css/a.css", "/css/sub/c.css", "/css/sub/deep/d.css"},
+		list(LocalFSOpt{}, dir+"/**/*.css:/css/"))
+	assert(t, "mismatch in globbed files", []string{"/css/sub/c.css"},
+		list(LocalFSOpt{}, dir+"/s*/*.css:/css"))
+
+	// Dotfiles only match dot names.
+	assert(t, "mismatch in globbed dotfiles", []string{"/css/.hidden/e.css", "/css/sub/.f.css"},
+		list(LocalFSOpt{}, dir+"/**/.*:/css", dir+"/.hidden/*.css:/css/.hidden"))
+
+	// Exclude applies to the matches.
+	assert(t, "mismatch in excluded glob", []string{"/x/a.css", "/x/b.js"},
+		list(LocalFSOpt{Exclude: []string{"sub/**"}}, dir+"/**:/x"))
+
+	// Directories are walked without their subdirectories with NoRecursive.
+	assert(t, "mismatch in non-recursive dir", []string{"/x/a.css", "/x/b.js"},
+		list(LocalFSOpt{NoRecursive: true}, dir+":/x"))
+
+	_, err := NewLocalFS("/", dir+"/**/*.png")
+	assert(t, "expected error on no matches", true, err != nil)
+	_, err = NewLocalFS("/", dir+"/[*.css")
+	assert(t, "expected error on invalid pattern", true, err != nil)
+}
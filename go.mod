@@ -1,3 +1,16 @@
 module github.com/knadh/stuffbin
 
-go 1.12
+go 1.21
+
+require github.com/klauspost/compress v1.17.11
+
+require github.com/andybalholm/brotli v1.1.1
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	filippo.io/age v1.2.1
+	golang.org/x/crypto v0.31.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
@@ -0,0 +1,206 @@
+package stuffbin
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessLog represents a single request served by a FileSystem handler.
+type AccessLog struct {
+	Method   string
+	Path     string
+	Status   int
+	Bytes    int64
+	Duration time.Duration
+}
+
+// AccessLogFunc is a callback that receives an AccessLog entry
+// after every request is served.
+type AccessLogFunc func(AccessLog)
+
+// Limits represents the limits applied by WithLimits.
+type Limits struct {
+	// MaxConcurrent is the maximum number of requests that are
+	// served concurrently. 0 means no limit.
+	MaxConcurrent int
+
+	// MaxWait is the duration a request waits for a free slot when
+	// MaxConcurrent requests are in flight before it is rejected with
+	// a 503. 0 means wait until the request is cancelled.
+	MaxWait time.Duration
+
+	// MaxBodySize is the maximum size of a request body in bytes.
+	// 0 means no limit.
+	MaxBodySize int64
+}
+
+// logWriter wraps an http.ResponseWriter and records the status code
+// and the number of bytes written.
+type logWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WithAccessLog wraps an http.Handler (for instance, FileSystem.FileServer())
+// and invokes the given callback with the details of every request served.
+func WithAccessLog(h http.Handler, cb AccessLogFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			start = time.Now()
+			lw    = &logWriter{ResponseWriter: w}
+		)
+		h.ServeHTTP(lw, r)
+
+		// Nothing was explicitly written. net/http sends a 200.
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+
+		cb(AccessLog{
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Status:   lw.status,
+			Bytes:    lw.bytes,
+			Duration: time.Since(start),
+		})
+	})
+}
+
+// WithLimits wraps an http.Handler (for instance, FileSystem.FileServer())
+// and limits the number of requests served concurrently and the size of
+// request bodies so that a burst of requests for large files doesn't
+// exhaust memory.
+func WithLimits(h http.Handler, l Limits) http.Handler {
+	var sem chan struct{}
+	if l.MaxConcurrent > 0 {
+		sem = make(chan struct{}, l.MaxConcurrent)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sem != nil {
+			var timeout <-chan time.Time
+			if l.MaxWait > 0 {
+				t := time.NewTimer(l.MaxWait)
+				defer t.Stop()
+				timeout = t.C
+			}
+
+			// Wait for a free slot.
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-timeout:
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if l.MaxBodySize > 0 {
+			if r.ContentLength > l.MaxBodySize {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, l.MaxBodySize)
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// WithPrecompressed wraps an http.Handler (for instance, FileSystem.FileServer())
+// and serves brotli compressed variants of files (path.br) from the FileSystem,
+// if they exist, to clients that accept brotli. The variants can be generated at
+// stuff time with StuffOpt.Brotli. Other requests are passed to the handler.
+func WithPrecompressed(h http.Handler, fs FileSystem) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		f, err := fs.Get(r.URL.Path + ".br")
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+
+		// The response varies by the encoding irrespective of whether
+		// this client accepts brotli or not.
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsEncoding(r, "br") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// Set the content type of the original file and not the .br.
+		name := path.Base(r.URL.Path)
+		if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.Header().Set("Content-Encoding", "br")
+
+		var modTime time.Time
+		if info, err := f.Stat(); err == nil && info != nil {
+			modTime = info.ModTime()
+		}
+		http.ServeContent(w, r, name, modTime, f)
+	})
+}
+
+// acceptsEncoding checks whether the request's Accept-Encoding header
+// has the given encoding with a non-zero quality.
+func acceptsEncoding(r *http.Request, enc string) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		chunks := strings.Split(v, ";")
+		if !strings.EqualFold(strings.TrimSpace(chunks[0]), enc) {
+			continue
+		}
+
+		// Quality, eg: br;q=0.
+		if len(chunks) > 1 {
+			q := strings.TrimSpace(chunks[1])
+			if strings.HasPrefix(q, "q=") {
+				if f, err := strconv.ParseFloat(q[2:], 64); err == nil && f == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// NewAccessLogger returns an AccessLogFunc that prints
+// requests to the given log.Logger.
+func NewAccessLogger(l *log.Logger) AccessLogFunc {
+	return func(a AccessLog) {
+		l.Printf("%s %s %d %d %s", a.Method, a.Path, a.Status, a.Bytes, a.Duration)
+	}
+}
+
+// WriteHeader records the status code and writes the header.
+func (w *logWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the number of bytes written.
+func (w *logWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
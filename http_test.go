@@ -0,0 +1,129 @@
+package stuffbin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestWithAccessLog(t *testing.T) {
+	fs, err := UnStuff(mockBinStuffed)
+	assert(t, "error unstuffing", nil, err)
+
+	logs := make(chan AccessLog, 2)
+	ts := httptest.NewServer(WithAccessLog(fs.FileServer(), func(a AccessLog) {
+		logs <- a
+	}))
+	defer ts.Close()
+
+	uri := "/" + localFiles[0]
+	res, err := http.Get(ts.URL + uri)
+	assert(t, "error in GET "+uri, nil, err)
+	res.Body.Close()
+
+	a := <-logs
+	assert(t, "log method", http.MethodGet, a.Method)
+	assert(t, "log path", uri, a.Path)
+	assert(t, "log status", 200, a.Status)
+	assert(t, "log bytes", 3, a.Bytes)
+
+	res, err = http.Get(ts.URL + "/nope")
+	assert(t, "error in GET /nope", nil, err)
+	res.Body.Close()
+
+	a = <-logs
+	assert(t, "log status", 404, a.Status)
+}
+
+func TestWithLimits(t *testing.T) {
+	var (
+		hold    = make(chan struct{})
+		started = make(chan struct{})
+	)
+	h := WithLimits(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-hold
+		}
+		w.WriteHeader(http.StatusOK)
+	}), Limits{MaxConcurrent: 1, MaxWait: time.Millisecond * 10, MaxBodySize: 2})
+
+	// Occupy the only slot.
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		close(done)
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert(t, "status with no free slots", http.StatusServiceUnavailable, rec.Code)
+
+	close(hold)
+	<-done
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert(t, "status with free slots", http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("large")))
+	assert(t, "status with large body", http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestWithPrecompressed(t *testing.T) {
+	_, _, err := StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{Brotli: []string{"*.go", "*.txt"}}, "mock/mock.go:/mock.go", "mock/bar.txt:/bar.txt")
+	assert(t, "error stuffing", nil, err)
+	defer os.Remove(mockBinStuffed2)
+
+	fs, err := UnStuff(mockBinStuffed2)
+	assert(t, "error unstuffing", nil, err)
+
+	// bar.txt is too small to benefit from compression.
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in stuffed files", []string{"/bar.txt", "/mock.go", "/mock.go.br"}, f)
+
+	orig, _ := fs.Read("/mock.go")
+	h := WithPrecompressed(fs.FileServer(), fs)
+
+	// Brotli.
+	req := httptest.NewRequest(http.MethodGet, "/mock.go", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert(t, "status", http.StatusOK, rec.Code)
+	assert(t, "content encoding", "br", rec.Header().Get("Content-Encoding"))
+	assert(t, "vary", "Accept-Encoding", rec.Header().Get("Vary"))
+
+	b, err := ioutil.ReadAll(brotli.NewReader(rec.Body))
+	assert(t, "error decompressing", nil, err)
+	assert(t, "mismatch in decompressed body", string(orig), string(b))
+
+	// No brotli.
+	for _, enc := range []string{"", "gzip", "br;q=0"} {
+		req = httptest.NewRequest(http.MethodGet, "/mock.go", nil)
+		req.Header.Set("Accept-Encoding", enc)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert(t, "status", http.StatusOK, rec.Code)
+		assert(t, "content encoding", "", rec.Header().Get("Content-Encoding"))
+		assert(t, "mismatch in body", string(orig), rec.Body.String())
+	}
+
+	// File without a .br variant.
+	req = httptest.NewRequest(http.MethodGet, "/bar.txt", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert(t, "content encoding", "", rec.Header().Get("Content-Encoding"))
+	assert(t, "mismatch in body", "bar", rec.Body.String())
+}
@@ -0,0 +1,246 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// prevPayload is the ZIP payload of an existing stuffed binary
+// that's used for incremental stuffing and adding files.
+type prevPayload struct {
+	list  []*zip.File
+	files map[string]*zip.File
+	tmp   *os.File
+
+	// encFiles is set if files in the payload are encrypted individually
+	// and key is their key, if it's known.
+	encFiles bool
+	key      []byte
+
+	// dict is the dictionary of the files compressed with it, if any,
+	// and dec is its decoder.
+	dict []byte
+	dec  *zstd.Decoder
+
+	// keep copies all files in the payload that are not overwritten
+	// by new files to the new payload, except for the ones that
+	// match the remove patterns.
+	keep   bool
+	remove []string
+}
+
+// loadPrevPayload decompresses the payload of the first of the given
+// stuffed binaries that exists into a temporary ZIP file, which allows
+// the binary to be overwritten while its files are being reused. It
+// returns nil if none of the files exist or have a payload. Encrypted
+// payloads are decrypted with the optional KeyFunc.
+func loadPrevPayload(key KeyFunc, paths ...string) (*prevPayload, error) {
+	for _, p := range paths {
+		id, err := GetFileID(p)
+		if err != nil {
+			if errors.Is(err, ErrNoID) || os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		pl, err := copyPayload(p, id, key)
+		if err != nil {
+			return nil, err
+		}
+		return pl, nil
+	}
+
+	return nil, nil
+}
+
+// copyPayload decompresses the payload of a stuffed binary
+// into a temporary ZIP file and reads its files.
+func copyPayload(path string, id ID, key KeyFunc) (*prevPayload, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Sidecars are read whole (see readStuff).
+	offset, zipLen := id.payloadOffset(), id.ZipSize
+	if id.Flags&FlagSidecar != 0 {
+		f.Close()
+		if f, err = openSidecar(id, filepath.Dir(path)); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		offset = 0
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if id.Flags&FlagSidecar != 0 {
+		zipLen = uint64(stat.Size())
+	}
+	if offset > uint64(stat.Size()) || zipLen > uint64(stat.Size())-offset {
+		return nil, fmt.Errorf("payload (%d bytes at %d) exceeds the file size %d", zipLen, offset, stat.Size())
+	}
+
+	// Compute the checksum of the payload as it's read.
+	var (
+		hash = sha256.New()
+		src  = io.TeeReader(io.NewSectionReader(f, int64(offset), int64(zipLen)), hash)
+	)
+
+	// Decrypt encrypted payloads before decompressing them.
+	var dec io.Reader = src
+	if id.Flags&FlagEncrypted != 0 {
+		k, err := payloadKey(id, key)
+		if err != nil {
+			return nil, err
+		}
+		if dec, err = newDecryptReader(src, int64(zipLen), k, id.Nonce, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "stuffbin-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	pl := &prevPayload{tmp: tmp}
+
+	rd, err := newPayloadReader(id.Codec, dec)
+	if err != nil {
+		pl.Close()
+		return nil, err
+	}
+	size, err := io.Copy(tmp, rd)
+	rd.Close()
+	if err != nil {
+		pl.Close()
+		return nil, err
+	}
+
+	// The decompressor may not read trailing bytes of the payload.
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		pl.Close()
+		return nil, err
+	}
+	if id.Flags&FlagChecksum != 0 && !bytes.Equal(hash.Sum(nil), id.Checksum[:]) {
+		pl.Close()
+		return nil, fmt.Errorf("error reading the payload of %s: %v", path, ErrChecksum)
+	}
+
+	r, err := zip.NewReader(tmp, size)
+	if err != nil {
+		pl.Close()
+		return nil, fmt.Errorf("error reading the payload of %s: %v", path, err)
+	}
+	if pl.dict, pl.dec, err = readDict(r, 0); err != nil {
+		pl.Close()
+		return nil, fmt.Errorf("error reading the payload of %s: %v", path, err)
+	}
+
+	// Files that are encrypted individually can only be kept with the key.
+	pl.encFiles = id.Flags&FlagEncryptedFiles != 0
+	if pl.encFiles && key != nil {
+		if pl.key, err = payloadKey(id, key); err != nil {
+			pl.Close()
+			return nil, err
+		}
+	}
+
+	pl.list = r.File
+	pl.files = make(map[string]*zip.File, len(r.File))
+	for _, zf := range r.File {
+		pl.files[zf.Name] = zf
+	}
+
+	return pl, nil
+}
+
+// Close closes and removes the temporary payload file.
+func (p *prevPayload) Close() error {
+	if p.dec != nil {
+		p.dec.Close()
+	}
+	p.tmp.Close()
+	return os.Remove(p.tmp.Name())
+}
+
+// isUnchanged checks whether a local file has the same size
+// and CRC-32 checksum as a file in a ZIP.
+func isUnchanged(srcPath string, fInfo os.FileInfo, f *zip.File) (bool, error) {
+	if f.UncompressedSize64 != uint64(fInfo.Size()) {
+		return false, nil
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, src); err != nil {
+		return false, err
+	}
+
+	return h.Sum32() == f.CRC32, nil
+}
+
+// copyZipFile copies a compressed file as-is from
+// a ZIP to a zip.Writer with the given comment.
+func copyZipFile(f *zip.File, comment string, zw *zip.Writer) error {
+	return copyZipFileAs(f, f.Name, comment, zw)
+}
+
+// copyZipFileAs is copyZipFile that renames the file.
+func copyZipFileAs(f *zip.File, name, comment string, zw *zip.Writer) error {
+	rd, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+
+	hdr := f.FileHeader
+	hdr.Name = name
+	hdr.Comment = comment
+
+	w, err := zw.CreateRaw(&hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, rd)
+	return err
+}
+
+// recompressZipFile decompresses a file from a ZIP and writes
+// it to a zip.Writer compressed with the given method.
+func recompressZipFile(f *zip.File, method uint16, zw *zip.Writer) error {
+	rd, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	// The extra fields are written again from the header.
+	hdr := f.FileHeader
+	hdr.Method = method
+	hdr.Extra = nil
+
+	w, err := zw.CreateHeader(&hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, rd)
+	return err
+}
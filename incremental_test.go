@@ -0,0 +1,59 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStuffIncremental(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		a   = filepath.Join(dir, "a.txt")
+		b   = filepath.Join(dir, "b.txt")
+		out = filepath.Join(dir, "stuffed")
+	)
+	body := bytes.Repeat([]byte("stuffbin incremental "), 200)
+	assert(t, "error writing file", nil, os.WriteFile(a, body, 0644))
+	assert(t, "error writing file", nil, os.WriteFile(b, body, 0644))
+
+	files := []string{a + ":/a.txt", b + ":/b.txt"}
+	_, _, err := StuffWithOpt(mockBin, out, StuffOpt{CompressionLevel: flate.BestCompression, Incremental: true}, files...)
+	assert(t, "error stuffing", nil, err)
+	best := zipSizes(t, out)
+
+	// Change b and restuff in-place with a weaker compression level. a should
+	// be copied over with its original compression and only b recompressed.
+	body = append(body, []byte("changed")...)
+	assert(t, "error writing file", nil, os.WriteFile(b, body, 0644))
+	_, _, err = StuffWithOpt(out, out, StuffOpt{CompressionLevel: flate.HuffmanOnly, Incremental: true}, files...)
+	assert(t, "error stuffing", nil, err)
+
+	sizes := zipSizes(t, out)
+	assert(t, "unchanged file should be reused", best["/a.txt"], sizes["/a.txt"])
+	assert(t, "changed file should be recompressed", true, sizes["/b.txt"] > best["/b.txt"])
+
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	got, err := fs.Read("/b.txt")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in changed file", true, bytes.Equal(body, got))
+}
+
+// zipSizes returns the compressed sizes of the files in a stuffed binary.
+func zipSizes(t *testing.T, path string) map[string]uint64 {
+	b, err := GetStuff(path)
+	assert(t, "error getting stuff", nil, err)
+
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	assert(t, "error reading zip", nil, err)
+
+	out := map[string]uint64{}
+	for _, f := range r.File {
+		out[f.Name] = f.CompressedSize64
+	}
+	return out
+}
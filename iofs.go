@@ -0,0 +1,242 @@
+package stuffbin
+
+import (
+	"io"
+	iofs "io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ioFS implements io/fs.FS over a FileSystem.
+type ioFS struct {
+	fs FileSystem
+}
+
+// NewIOFS returns an io/fs.FS over the files in a FileSystem, for instance,
+// to pass to http.FS, template.ParseFS, or fs.WalkDir. Paths are relative
+// to / (eg: static/app.js for /static/app.js) and directories are derived
+// from the paths of the files. It implements fs.ReadFileFS, fs.ReadDirFS,
+// and fs.StatFS.
+func NewIOFS(fs FileSystem) iofs.FS {
+	return &ioFS{fs: fs}
+}
+
+// UnStuffFS is UnStuff that returns an io/fs.FS (see NewIOFS).
+func UnStuffFS(path string) (iofs.FS, error) {
+	return UnStuffFSWithOpt(path, UnStuffOpt{})
+}
+
+// UnStuffFSWithOpt is UnStuffFS with UnStuffOpt options.
+func UnStuffFSWithOpt(path string, o UnStuffOpt) (iofs.FS, error) {
+	fs, err := UnStuffWithOpt(path, o)
+	if err != nil {
+		return nil, err
+	}
+	return NewIOFS(fs), nil
+}
+
+// Open opens the named file or directory.
+func (f *ioFS) Open(name string) (iofs.File, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	// FileSystem paths have no backslashes as they're cleaned
+	// into slashes, which would match other names.
+	if strings.Contains(name, `\`) {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrNotExist}
+	}
+
+	if file, err := f.fs.Get("/" + name); err == nil {
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &ioFile{File: file, info: namedInfo{info, path.Base(name)}}, nil
+	}
+
+	entries, ok := f.readDir(name)
+	if !ok {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrNotExist}
+	}
+	return &ioDir{info: dirInfo{name: path.Base(name)}, entries: entries}, nil
+}
+
+// ReadFile returns the contents of the named file.
+func (f *ioFS) ReadFile(name string) ([]byte, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "readfile", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	b, err := f.fs.Read("/" + name)
+	if err != nil || strings.Contains(name, `\`) {
+		return nil, &iofs.PathError{Op: "readfile", Path: name, Err: iofs.ErrNotExist}
+	}
+	return b, nil
+}
+
+// ReadDir returns the entries of the named directory sorted by name.
+func (f *ioFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	entries, ok := f.readDir(name)
+	if !ok {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: iofs.ErrNotExist}
+	}
+	return entries, nil
+}
+
+// Stat returns the info of the named file or directory.
+func (f *ioFS) Stat(name string) (iofs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, &iofs.PathError{Op: "stat", Path: name, Err: iofs.ErrNotExist}
+	}
+	defer file.Close()
+
+	return file.Stat()
+}
+
+// readDir returns the entries of the named directory sorted by name
+// and whether it exists. Directories exist if they have files.
+func (f *ioFS) readDir(name string) ([]iofs.DirEntry, bool) {
+	if strings.Contains(name, `\`) {
+		return nil, false
+	}
+
+	prefix := "/"
+	if name != "." {
+		prefix = "/" + name + "/"
+	}
+
+	var (
+		entries = map[string]iofs.DirEntry{}
+		found   = name == "."
+	)
+	for _, p := range f.fs.List() {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		found = true
+
+		// Files in subdirectories add the subdirectory.
+		rest := p[len(prefix):]
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			entries[rest[:i]] = iofs.FileInfoToDirEntry(dirInfo{name: rest[:i]})
+			continue
+		}
+
+		file, err := f.fs.Get(p)
+		if err != nil {
+			continue
+		}
+		info, err := file.Stat()
+		file.Close()
+		if err != nil {
+			continue
+		}
+		entries[rest] = iofs.FileInfoToDirEntry(namedInfo{info, rest})
+	}
+	if !found {
+		return nil, false
+	}
+
+	out := make([]iofs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name() < out[j].Name()
+	})
+	return out, true
+}
+
+// ioFile is a file in an ioFS whose info has the name that it was opened with.
+type ioFile struct {
+	*File
+	info iofs.FileInfo
+}
+
+// Stat returns the file's info.
+func (f *ioFile) Stat() (iofs.FileInfo, error) {
+	return f.info, nil
+}
+
+// ioDir is a directory in an ioFS.
+type ioDir struct {
+	info    dirInfo
+	entries []iofs.DirEntry
+	off     int
+}
+
+// Stat returns the directory's info.
+func (d *ioDir) Stat() (iofs.FileInfo, error) {
+	return d.info, nil
+}
+
+// Read always fails as directories can't be read.
+func (d *ioDir) Read([]byte) (int, error) {
+	return 0, &iofs.PathError{Op: "read", Path: d.info.name, Err: iofs.ErrInvalid}
+}
+
+// Close is a no-op.
+func (d *ioDir) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, or all
+// the remaining entries if n <= 0.
+func (d *ioDir) ReadDir(n int) ([]iofs.DirEntry, error) {
+	rest := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+		return rest, nil
+	}
+
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.off += n
+	return rest[:n], nil
+}
+
+// namedInfo is file info with the base name of the file's path,
+// which is the alias of files that were stuffed with one.
+type namedInfo struct {
+	iofs.FileInfo
+	name string
+}
+
+// Name returns the base name of the file.
+func (n namedInfo) Name() string { return n.name }
+
+// dirInfo is the info of a directory in an ioFS.
+type dirInfo struct {
+	name string
+}
+
+// Name returns the base name of the directory.
+func (d dirInfo) Name() string { return d.name }
+
+// Size is always 0.
+func (d dirInfo) Size() int64 { return 0 }
+
+// Mode returns the directory mode.
+func (d dirInfo) Mode() iofs.FileMode { return iofs.ModeDir | 0555 }
+
+// ModTime is always zero.
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+
+// IsDir is always true.
+func (d dirInfo) IsDir() bool { return true }
+
+// Sys returns nil.
+func (d dirInfo) Sys() interface{} { return nil }
@@ -0,0 +1,45 @@
+package stuffbin
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestUnStuffFS(t *testing.T) {
+	fsys, err := UnStuffFS(mockBinStuffed)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "error testing FS", nil, fstest.TestFS(fsys, "mock/bar.txt", "mock/foo.txt"))
+
+	b, err := fs.ReadFile(fsys, "mock/foo.txt")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in file", true, len(b) > 0)
+
+	_, err = fsys.Open("/mock/foo.txt")
+	assert(t, "opened an invalid path", true, err != nil)
+	_, err = fsys.Open("mock/none.txt")
+	assert(t, "opened a missing file", true, err != nil)
+}
+
+func TestNewIOFS(t *testing.T) {
+	mfs, _ := NewFS()
+	for _, p := range []string{"/index.html", "/static/app.js", "/static/css/app.css", "/a.txt"} {
+		assert(t, "error adding file", nil, mfs.Add(NewFile(p, &fileInfo{name: "src", size: 2, mode: 0644}, []byte("hi"))))
+	}
+
+	fsys := NewIOFS(mfs)
+	assert(t, "error testing FS", nil, fstest.TestFS(fsys, "index.html", "static/app.js", "static/css/app.css", "a.txt"))
+
+	entries, err := fs.ReadDir(fsys, "static")
+	assert(t, "error reading dir", nil, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert(t, "mismatch in entries", []string{"app.js", "css"}, names)
+
+	// Files are named after their paths.
+	info, err := fs.Stat(fsys, "static/app.js")
+	assert(t, "error getting info", nil, err)
+	assert(t, "mismatch in name", "app.js", info.Name())
+}
@@ -0,0 +1,245 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"sync"
+)
+
+// lazyFile is the content of a file in a payload that's decompressed
+// when the file is first read (see UnStuffOpt.Lazy).
+type lazyFile struct {
+	once sync.Once
+	zf   *zip.File
+	key  []byte
+
+	// ra is the payload that files stored without compression are read
+	// from in place if the file is streamed (see UnStuffOpt.StreamSize).
+	ra     io.ReaderAt
+	ref    *payloadRef
+	stream bool
+
+	b   []byte
+	err error
+}
+
+// fileStream is the position of a File that's streamed from the payload.
+type fileStream struct {
+	r io.ReadCloser
+
+	// pos is the offset of r in the file and off
+	// is the offset of the File.
+	pos int64
+	off int64
+}
+
+// storedReader reads a file stored without compression in place.
+type storedReader struct {
+	*io.SectionReader
+}
+
+func (storedReader) Close() error { return nil }
+
+// load returns the content of the file, decompressing it only once.
+func (l *lazyFile) load() ([]byte, error) {
+	l.once.Do(func() {
+		f, err := readZipFile(l.zf, l.key)
+		if err != nil {
+			l.err = payloadError(l.zf.Name, err)
+			return
+		}
+		l.b = f.b
+	})
+	return l.b, l.err
+}
+
+// open returns a reader that decompresses the file from the start.
+func (l *lazyFile) open() (io.ReadCloser, error) {
+	if l.zf.Method == zip.Store {
+		off, err := l.zf.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		return storedReader{io.NewSectionReader(l.ra, off, int64(l.zf.CompressedSize64))}, nil
+	}
+	return l.zf.Open()
+}
+
+// readAll decompresses the whole file for the reads of a
+// streamed file that need it in memory (eg: File.ReadBytes).
+func (l *lazyFile) readAll() ([]byte, error) {
+	r, err := l.open()
+	if err != nil {
+		return nil, payloadError(l.zf.Name, err)
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, payloadError(l.zf.Name, err)
+	}
+	return b, nil
+}
+
+// readStream reads a streamed File from its offset, reopening or
+// seeking the file's reader if the File has been seeked.
+func (f *File) readStream(b []byte) (int, error) {
+	s := &f.stream
+	if s.r != nil && s.off != s.pos {
+		if sk, ok := s.r.(io.Seeker); ok {
+			if _, err := sk.Seek(s.off, io.SeekStart); err != nil {
+				return 0, err
+			}
+			s.pos = s.off
+		} else if s.off < s.pos {
+			s.r.Close()
+			s.r = nil
+		}
+	}
+	if s.r == nil {
+		r, err := f.lazy.open()
+		if err != nil {
+			return 0, payloadError(f.path, err)
+		}
+		s.r, s.pos = r, 0
+	}
+
+	if s.off > s.pos {
+		n, err := io.CopyN(io.Discard, s.r, s.off-s.pos)
+		s.pos += n
+		if err != nil {
+			if err != io.EOF {
+				err = payloadError(f.path, err)
+			}
+			return 0, err
+		}
+	}
+
+	n, err := s.r.Read(b)
+	s.pos += int64(n)
+	s.off = s.pos
+	if err != nil && err != io.EOF {
+		err = payloadError(f.path, err)
+	}
+	return n, err
+}
+
+// seekStream sets the offset of a streamed File, which is read from
+// the offset on the next read.
+func (f *File) seekStream(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.stream.off
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	f.stream.off = offset
+	return offset, nil
+}
+
+// closeStream closes the reader of a streamed File and rewinds it.
+func (f *File) closeStream() {
+	if f.stream.r != nil {
+		f.stream.r.Close()
+	}
+	f.stream = fileStream{}
+}
+
+// payloadRef counts the references to the payload of a lazy FileSystem,
+// which are held by the FileSystem and by the Files that it returns until
+// they're loaded or closed, and closes the payload once there are none.
+type payloadRef struct {
+	mu sync.Mutex
+	n  int
+	p  io.Closer
+}
+
+// acquire adds a reference unless the payload has already been closed.
+func (r *payloadRef) acquire() bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.n == 0 {
+		return false
+	}
+	r.n++
+	return true
+}
+
+// release removes a reference and closes the payload if it was the last.
+func (r *payloadRef) release() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.n--; r.n > 0 {
+		return nil
+	}
+	return r.p.Close()
+}
+
+// lazyFS returns a FileSystem with the files in a payload whose
+// content is decompressed when they're first read. The payload
+// is kept open for the files to be read from until the FileSystem
+// is closed (see payloadRef).
+func lazyFS(p *payload, o UnStuffOpt) (FileSystem, error) {
+	r, err := zip.NewReader(p, p.size)
+	if err != nil {
+		p.Close()
+		return nil, payloadError("", err)
+	}
+
+	// The dictionary's decoder is used as long as the files are.
+	if _, _, err := readDict(r, uint64(o.MaxFileSize)); err != nil {
+		p.Close()
+		return nil, payloadError(dictName, err)
+	}
+
+	files := payloadFiles(r, p.key, nil)
+	if err := checkUnzipSize(files, o); err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	ref := &payloadRef{n: 1, p: p}
+	fs := &memFS{files: make(map[string]*File), payload: ref}
+	for _, zf := range files {
+		info := zf.FileInfo()
+		if _, _, size, ok := encExtra(zf); ok {
+			info = &fileInfo{
+				name:    info.Name(),
+				size:    int64(size),
+				mode:    info.Mode(),
+				modTime: info.ModTime(),
+			}
+		}
+
+		f := &File{
+			path: zf.Name,
+			info: info,
+			meta: parseMeta(zf.Comment),
+			lazy: &lazyFile{
+				zf:     zf,
+				key:    p.key,
+				ra:     p,
+				ref:    ref,
+				stream: o.StreamSize > 0 && info.Size() > o.StreamSize && !isEncryptedFile(zf),
+			},
+		}
+		if err := fs.Add(f); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
@@ -0,0 +1,199 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUnStuffLazy(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "lazy.exe")
+	o := StuffOpt{
+		Passphrase: "secret",
+		Encrypt:    []string{"/mock/bar.txt"},
+	}
+	_, _, err := StuffWithOpt(mockBin, out, o, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	fs, err := UnStuffWithOpt(out, UnStuffOpt{Key: Key([]byte("secret")), Lazy: true})
+	assert(t, "error unstuffing", nil, err)
+
+	// The files are listed from the ZIP directory without reading them.
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in unstuffed file paths", stuffedFiles, f)
+
+	var size int64
+	for n, p := range stuffedFiles {
+		b, err := os.ReadFile(localFiles[n])
+		assert(t, "error reading file", nil, err)
+		size += int64(len(b))
+
+		file, err := fs.Get(p)
+		assert(t, "error getting file", nil, err)
+		info, err := file.Stat()
+		assert(t, "error getting file info", nil, err)
+		assert(t, "mismatch in file size "+p, int64(len(b)), info.Size())
+		assert(t, "file read before use "+p, true, fs.(*memFS).files[p].lazy.b == nil)
+	}
+	assert(t, "mismatch in size", size, fs.Size())
+
+	// The files are decompressed once on their first reads.
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			b, err := fs.Read(stuffedFiles[n%2])
+			exp, _ := os.ReadFile(localFiles[n%2])
+			if err != nil || string(b) != string(exp) {
+				t.Errorf("mismatch in lazily read file %s: %v", stuffedFiles[n%2], err)
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	// Files that can't be read fail on reads.
+	b, err := os.ReadFile(out)
+	assert(t, "error reading file", nil, err)
+	fs, err = UnStuffBytesWithOpt(b, UnStuffOpt{Lazy: true})
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "mismatch in file count without key", 1, fs.Len())
+	id, err := GetFileID(out)
+	assert(t, "error getting file ID", nil, err)
+	zf := fs.(*memFS).files["/mock/foo.txt"].lazy.zf
+	off, err := zf.DataOffset()
+	assert(t, "error getting file offset", nil, err)
+	for n := uint64(0); n < zf.CompressedSize64; n++ {
+		b[id.payloadOffset()+uint64(off)+n] ^= 0xff
+	}
+	_, err = fs.Read(fs.List()[0])
+	assert(t, "expected error reading corrupt file", true, err != nil)
+
+	file, err := fs.Get(fs.List()[0])
+	assert(t, "error getting file", nil, err)
+	_, err = file.Read(make([]byte, 10))
+	assert(t, "expected error reading corrupt file", true, err != nil)
+}
+
+func TestUnStuffStream(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		out = filepath.Join(dir, "stream.exe")
+		b   = make([]byte, 1<<20)
+	)
+	rnd := rand.New(rand.NewSource(1))
+	for n := range b {
+		b[n] = "abcdefgh"[rnd.Intn(8)]
+	}
+	for _, name := range []string{"data.csv", "data.bin", "small.txt"} {
+		data := b
+		if name == "small.txt" {
+			data = b[:100]
+		}
+		assert(t, "error writing file", nil, os.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+	_, _, err := StuffWithOpt(mockBin, out, StuffOpt{Store: []string{"*.bin"}},
+		filepath.Join(dir, "data.csv")+":/data.csv", filepath.Join(dir, "data.bin")+":/data.bin", filepath.Join(dir, "small.txt")+":/small.txt")
+	assert(t, "error stuffing", nil, err)
+
+	fs, err := UnStuffWithOpt(out, UnStuffOpt{Lazy: true, StreamSize: 1024})
+	assert(t, "error unstuffing", nil, err)
+
+	for _, p := range []string{"/data.csv", "/data.bin"} {
+		f, err := fs.Get(p)
+		assert(t, "error getting file", nil, err)
+
+		// Reads after seeks forward and backward.
+		buf := make([]byte, 10)
+		for _, off := range []int64{1000, 500000, 20, 0, 1<<20 - 5} {
+			n, err := f.Seek(off, io.SeekStart)
+			assert(t, "error seeking "+p, nil, err)
+			assert(t, "mismatch in offset "+p, off, n)
+
+			l, err := io.ReadFull(f, buf)
+			if off+10 > 1<<20 {
+				assert(t, "expected EOF "+p, io.ErrUnexpectedEOF, err)
+			} else {
+				assert(t, "error reading "+p, nil, err)
+			}
+			assert(t, "mismatch in read "+p, string(b[off:off+int64(l)]), string(buf[:l]))
+		}
+		size, err := f.Seek(0, io.SeekEnd)
+		assert(t, "error seeking "+p, nil, err)
+		assert(t, "mismatch in size "+p, int64(len(b)), size)
+		assert(t, "error closing "+p, nil, f.Close())
+
+		// The content isn't kept in memory.
+		got, err := fs.Read(p)
+		assert(t, "error reading "+p, nil, err)
+		assert(t, "mismatch in content "+p, true, bytes.Equal(b, got))
+		assert(t, "streamed file loaded "+p, true, fs.(*memFS).files[p].lazy.b == nil)
+	}
+	assert(t, "mismatch in stored method", zip.Store, fs.(*memFS).files["/data.bin"].lazy.zf.Method)
+	assert(t, "small file streamed", false, fs.(*memFS).files["/small.txt"].lazy.stream)
+
+	// Ranges are served from streamed files.
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/data.csv", nil)
+	req.Header.Set("Range", "bytes=700000-700099")
+	fs.FileServer().ServeHTTP(rec, req)
+	assert(t, "mismatch in status", http.StatusPartialContent, rec.Code)
+	assert(t, "mismatch in range", string(b[700000:700100]), rec.Body.String())
+	assert(t, "mismatch in content type", true, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv"))
+}
+
+func TestCloseLazy(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "lazy.exe")
+	_, _, err := Stuff(mockBin, out, "/", localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	o := UnStuffOpt{Lazy: true}
+	fs, err := UnStuffWithOpt(out, o)
+	assert(t, "error unstuffing", nil, err)
+	old := fs.(*memFS).payload.p.(*payload)
+
+	// Files that were returned before a reload are read from
+	// the old payload, which is closed once they're done.
+	f, err := fs.Get(stuffedFiles[0])
+	assert(t, "error getting file", nil, err)
+	assert(t, "error reloading", nil, ReloadFS(fs, out, o))
+	_, err = old.bin.Stat()
+	assert(t, "old payload closed with open files", nil, err)
+
+	exp, err := os.ReadFile(localFiles[0])
+	assert(t, "error reading file", nil, err)
+	b, err := io.ReadAll(f)
+	assert(t, "error reading file across reload", nil, err)
+	assert(t, "mismatch in file read across reload", string(exp), string(b))
+	_, err = old.bin.Stat()
+	assert(t, "old payload not closed", true, err != nil)
+
+	b, err = fs.Read(stuffedFiles[0])
+	assert(t, "error reading reloaded file", nil, err)
+	assert(t, "mismatch in reloaded file", string(exp), string(b))
+
+	// Closing the FileSystem closes the payload once its Files are closed.
+	cur := fs.(*memFS).payload.p.(*payload)
+	f, err = fs.Get(stuffedFiles[1])
+	assert(t, "error getting file", nil, err)
+	assert(t, "error closing", nil, fs.(io.Closer).Close())
+	_, err = cur.bin.Stat()
+	assert(t, "payload closed with open files", nil, err)
+	assert(t, "error closing file", nil, f.Close())
+	_, err = cur.bin.Stat()
+	assert(t, "payload not closed", true, err != nil)
+
+	_, err = fs.Read(stuffedFiles[1])
+	assert(t, "expected error reading after close", true, err != nil)
+	assert(t, "error closing twice", nil, fs.(io.Closer).Close())
+}
@@ -0,0 +1,85 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"os"
+	"time"
+)
+
+// Entry is a file in the payload of a stuffed binary as it's listed
+// in the payload's ZIP directory.
+type Entry struct {
+	Path string `json:"path"`
+
+	// Size is the size of the file's contents and CompressedSize is the
+	// size of the file in the payload.
+	Size           uint64 `json:"size"`
+	CompressedSize uint64 `json:"compressed_size"`
+
+	// CRC32 is the CRC-32 of the file as it's stored in the payload,
+	// which is of the encrypted contents of encrypted files.
+	CRC32   uint32      `json:"crc32"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+
+	// Encrypted is true for files that are encrypted individually.
+	Encrypted bool `json:"encrypted,omitempty"`
+
+	// Meta is the optional metadata of the file (see File.Meta).
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// Ratio returns the ratio of the compressed size to the size of the file.
+func (e Entry) Ratio() float64 {
+	if e.Size == 0 {
+		return 1
+	}
+	return float64(e.CompressedSize) / float64(e.Size)
+}
+
+// ListStuff lists the files in a stuffed binary from its payload's ZIP
+// directory without reading them. If glob patterns are given, only the
+// matching files are listed (see UnStuffPaths). Files that are encrypted
+// individually are listed without the key. Payloads that are encrypted as
+// a whole need UnStuffOpt.Key.
+func ListStuff(path string, o UnStuffOpt, patterns ...string) ([]Entry, error) {
+	for _, p := range patterns {
+		if err := checkPattern(p); err != nil {
+			return nil, err
+		}
+	}
+	p, err := openStuff(path, o)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	zr, err := zip.NewReader(p, p.size)
+	if err != nil {
+		return nil, payloadError("", err)
+	}
+
+	out := make([]Entry, 0, len(zr.File))
+	for _, f := range zr.File {
+		if f.Name == dictName || (len(patterns) > 0 && !matchPath(patterns, f.Name)) {
+			continue
+		}
+
+		e := Entry{
+			Path:           f.Name,
+			Size:           f.UncompressedSize64,
+			CompressedSize: f.CompressedSize64,
+			CRC32:          f.CRC32,
+			Mode:           f.Mode(),
+			ModTime:        f.Modified,
+			Meta:           parseMeta(f.Comment),
+		}
+		if _, _, size, ok := encExtra(f); ok {
+			e.Size = size
+			e.Encrypted = true
+		}
+		out = append(out, e)
+	}
+
+	return out, nil
+}
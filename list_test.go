@@ -0,0 +1,45 @@
+package stuffbin
+
+import (
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListStuff(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "list.exe")
+	o := StuffOpt{
+		Passphrase: "secret",
+		Encrypt:    []string{"/mock/bar.txt"},
+	}
+	_, _, err := StuffWithOpt(mockBin, out, o, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	entries, err := ListStuff(out, UnStuffOpt{})
+	assert(t, "error listing", nil, err)
+	assert(t, "mismatch in entry count", len(stuffedFiles), len(entries))
+
+	for n, e := range entries {
+		assert(t, "mismatch in path", stuffedFiles[n], e.Path)
+
+		b, err := os.ReadFile(localFiles[n])
+		assert(t, "error reading file", nil, err)
+		assert(t, "mismatch in size", uint64(len(b)), e.Size)
+		assert(t, "mismatch in encrypted", e.Path == "/mock/bar.txt", e.Encrypted)
+		if !e.Encrypted {
+			assert(t, "mismatch in crc", crc32.ChecksumIEEE(b), e.CRC32)
+		}
+	}
+
+	entries, err = ListStuff(out, UnStuffOpt{}, "/mock/f*")
+	assert(t, "error listing", nil, err)
+	assert(t, "mismatch in entry count", 1, len(entries))
+	assert(t, "mismatch in path", "/mock/foo.txt", entries[0].Path)
+
+	_, err = ListStuff(out, UnStuffOpt{}, "[")
+	assert(t, "listed with an invalid pattern", true, err != nil)
+
+	_, err = ListStuff(mockBin, UnStuffOpt{})
+	assert(t, "listed an unstuffed binary", ErrNoID, err)
+}
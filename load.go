@@ -0,0 +1,108 @@
+package stuffbin
+
+import (
+	"errors"
+	"fmt"
+	iofs "io/fs"
+)
+
+// LoadOpt represents options for LoadAssets.
+type LoadOpt struct {
+	// UnStuffOpt are the options that the running executable
+	// is unstuffed with.
+	UnStuffOpt UnStuffOpt
+
+	// LocalPaths is an optional list of local files and directories
+	// (with optional aliases, see NewLocalFS) that are loaded if the
+	// executable isn't stuffed, for instance, in development.
+	LocalPaths []string
+
+	// LocalRoot is the root path to bind the local files to. Defaults to /.
+	LocalRoot string
+
+	// FS is an optional io/fs.FS, for instance, an embed.FS, that files are
+	// loaded from if the executable isn't stuffed and the local files
+	// can't be loaded. Its files are rooted at /.
+	FS iofs.FS
+
+	// Logf is an optional function that's called with the source
+	// that the assets are loaded from and the fallbacks, for instance,
+	// log.Printf.
+	Logf func(format string, v ...interface{})
+}
+
+// LoadAssets loads the assets of an application from the first source
+// that's available: the payload stuffed into the running executable
+// (see UnStuffSelf), the local files in LocalPaths, and the io/fs.FS in FS.
+// Errors other than ErrNoID from a stuffed executable, such as a failed
+// signature verification, are returned without falling back. If no source
+// is available, the error of the last one is returned.
+func LoadAssets(o LoadOpt) (FileSystem, error) {
+	logf := o.Logf
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+
+	fs, err := UnStuffSelfWithOpt(o.UnStuffOpt)
+	if err == nil {
+		logf("loaded %d stuffed files", fs.Len())
+		return fs, nil
+	}
+	if err != ErrNoID {
+		return nil, fmt.Errorf("error reading stuffed binary: %v", err)
+	}
+	logf("executable isn't stuffed")
+
+	if len(o.LocalPaths) > 0 {
+		fs, err = NewLocalFS(o.LocalRoot, o.LocalPaths...)
+		if err == nil {
+			logf("loaded %d local files", fs.Len())
+			return fs, nil
+		}
+		if o.FS == nil {
+			return nil, fmt.Errorf("error loading local files: %v", err)
+		}
+		logf("error loading local files: %v", err)
+	}
+
+	if o.FS != nil {
+		fs, err = loadIOFS(o.FS)
+		if err != nil {
+			return nil, fmt.Errorf("error loading files from FS: %v", err)
+		}
+		logf("loaded %d files from FS", fs.Len())
+		return fs, nil
+	}
+
+	return nil, err
+}
+
+// loadIOFS returns a FileSystem with the files in an io/fs.FS rooted at /.
+func loadIOFS(fsys iofs.FS) (FileSystem, error) {
+	fs, _ := NewFS()
+	err := iofs.WalkDir(fsys, ".", func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return errors.New(p + ": not a regular file")
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		b, err := iofs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		return fs.Add(newFile("/"+p, info, b))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
@@ -0,0 +1,47 @@
+package stuffbin
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadAssets(t *testing.T) {
+	var logs []string
+	logf := func(format string, v ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, v...))
+	}
+
+	// The test binary isn't stuffed and falls back to the local files.
+	fs, err := LoadAssets(LoadOpt{LocalPaths: localFiles, Logf: logf})
+	assert(t, "error loading assets", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in loaded file paths", stuffedFiles, f)
+	assert(t, "mismatch in logs", []string{"executable isn't stuffed", fmt.Sprintf("loaded %d local files", len(stuffedFiles))}, logs)
+
+	// Missing local files fall back to the FS.
+	mfs := fstest.MapFS{
+		"index.html":     {Data: []byte("index")},
+		"static/app.css": {Data: []byte("body {}")},
+	}
+	logs = nil
+	fs, err = LoadAssets(LoadOpt{LocalPaths: []string{"missing"}, FS: mfs, Logf: logf})
+	assert(t, "error loading assets", nil, err)
+	f = fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in loaded file paths", []string{"/index.html", "/static/app.css"}, f)
+	b, err := fs.Read("/static/app.css")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in file", "body {}", string(b))
+	assert(t, "mismatch in log count", 3, len(logs))
+
+	// Without the FS, the local error is returned.
+	_, err = LoadAssets(LoadOpt{LocalPaths: []string{"missing"}})
+	assert(t, "loaded missing local files", true, err != nil)
+
+	// No sources.
+	_, err = LoadAssets(LoadOpt{})
+	assert(t, "mismatch in error", ErrNoID, err)
+}
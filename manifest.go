@@ -0,0 +1,350 @@
+package stuffbin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest describes a list of files to stuff along with the stuffing
+// options. It can be loaded from a YAML or JSON file with LoadManifest.
+//
+//	in: dist/app
+//	out: dist/app.stuffed
+//	root: /
+//	codec: zip
+//	level: 9
+//	brotli: ["*.css", "*.js"]
+//	exclude: ["**/*.map"]
+//	files:
+//	  - src: frontend/dist
+//	    alias: /static
+//	  - src: assets/fonts
+//	    store: true
+//	  - src: config.sample.toml
+//	    meta:
+//	      version: "2"
+//	  - src: bin/helper.exe
+//	    platforms: [windows]
+type Manifest struct {
+	// In and Out are the optional paths of the input and the output
+	// binaries, which the CLI stuffs when they aren't given as flags.
+	// StuffManifest ignores them.
+	In  string `json:"in" yaml:"in"`
+	Out string `json:"out" yaml:"out"`
+
+	// RootPath is the root path to bind all files to. Defaults to /.
+	RootPath string `json:"root" yaml:"root"`
+
+	// Codec is the name of the payload compression format (zip, zstd).
+	Codec string `json:"codec" yaml:"codec"`
+
+	// CompressionLevel, Store, Brotli, Dictionary, Exclude, SkipHidden,
+	// NoRecursive, RespectGitignore, Rewrite, Incremental, Section, Sidecar,
+	// and Checksum are the corresponding StuffOpt options.
+	CompressionLevel int      `json:"level" yaml:"level"`
+	Store            []string `json:"store" yaml:"store"`
+	Brotli           []string `json:"brotli" yaml:"brotli"`
+	Dictionary       []string `json:"dictionary" yaml:"dictionary"`
+	Exclude          []string `json:"exclude" yaml:"exclude"`
+	SkipHidden       bool     `json:"skip_hidden" yaml:"skip_hidden"`
+	NoRecursive      bool     `json:"no_recursive" yaml:"no_recursive"`
+	RespectGitignore bool     `json:"respect_gitignore" yaml:"respect_gitignore"`
+	Rewrite          []string `json:"rewrite" yaml:"rewrite"`
+	Incremental      bool     `json:"incremental" yaml:"incremental"`
+	Section          bool     `json:"section" yaml:"section"`
+	Sidecar          bool     `json:"sidecar" yaml:"sidecar"`
+	Checksum         bool     `json:"checksum" yaml:"checksum"`
+
+	// Meta is optional metadata that's stored in the stuffed binary's ID.
+	// See StuffOpt.Meta.
+	Meta map[string]string `json:"meta" yaml:"meta"`
+
+	// Bundles are optional named sets of path glob patterns that can be
+	// unstuffed on their own. See StuffOpt.Bundles.
+	Bundles map[string][]string `json:"bundles" yaml:"bundles"`
+
+	// Recipients is an optional list of age X25519 public keys to
+	// encrypt the payload to. See StuffOpt.Recipients.
+	Recipients []string `json:"recipients" yaml:"recipients"`
+
+	// Encrypt is an optional list of glob patterns of files to encrypt
+	// individually to the Recipients. See StuffOpt.Encrypt.
+	Encrypt []string `json:"encrypt" yaml:"encrypt"`
+
+	// SigningKey is the optional path of an Ed25519 private key file to
+	// sign the payload with. See LoadSigningKey and StuffOpt.SigningKey.
+	SigningKey string `json:"signing_key" yaml:"signing_key"`
+
+	// Minify is an optional list of glob patterns of CSS, JS, HTML, and
+	// SVG files to minify with the built-in minifiers (eg: "*"). See Minify.
+	Minify []string `json:"minify" yaml:"minify"`
+
+	// MaxSize and MaxFileSize are the optional max sizes of the payload
+	// and of a file with an optional K, M, or G suffix (eg: 50MB). See
+	// StuffOpt.MaxSize and ParseSize.
+	MaxSize     string `json:"max_size" yaml:"max_size"`
+	MaxFileSize string `json:"max_file_size" yaml:"max_file_size"`
+
+	// Platform is the GOOS/GOARCH (eg: linux/amd64) that files with
+	// Platforms are matched against. Defaults to the platform of the
+	// input binary (see BinaryPlatform).
+	Platform string `json:"platform" yaml:"platform"`
+
+	Files []ManifestFile `json:"files" yaml:"files"`
+}
+
+// ManifestFile is a local file or directory in a Manifest.
+type ManifestFile struct {
+	// Src is the local file or directory path relative to
+	// the working directory.
+	Src string `json:"src" yaml:"src"`
+
+	// Alias is the optional target path of Src.
+	Alias string `json:"alias" yaml:"alias"`
+
+	// Store stores the files without compression.
+	Store bool `json:"store" yaml:"store"`
+
+	// Brotli adds brotli compressed .br copies of the files.
+	Brotli bool `json:"brotli" yaml:"brotli"`
+
+	// Encrypt encrypts the files individually to the Recipients.
+	Encrypt bool `json:"encrypt" yaml:"encrypt"`
+
+	// Archive indicates that Src is a ZIP, tar, .tar.gz, or .tar.zst
+	// archive whose files are stuffed under the Alias. See StuffArchive.
+	Archive bool `json:"archive" yaml:"archive"`
+
+	// Meta is optional metadata that's stuffed along with every
+	// file. It's available on unstuffed files via File.Meta().
+	Meta map[string]string `json:"meta" yaml:"meta"`
+
+	// Platforms is an optional list of GOOS or GOOS/GOARCH platforms
+	// (eg: darwin, linux/arm64, */amd64) that the files are only stuffed
+	// into binaries for. See Manifest.Platform.
+	Platforms []string `json:"platforms" yaml:"platforms"`
+}
+
+// LoadManifest reads a YAML or JSON (.json) manifest file.
+func LoadManifest(path string) (Manifest, error) {
+	var m Manifest
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.Unmarshal(b, &m)
+	} else {
+		err = yaml.Unmarshal(b, &m)
+	}
+	if err != nil {
+		return m, fmt.Errorf("error parsing manifest %s: %v", path, err)
+	}
+
+	return m, nil
+}
+
+// StuffFromManifest is Stuff with the files and options
+// described in the given YAML or JSON manifest file.
+func StuffFromManifest(in, out, manifestPath string) (int64, int64, error) {
+	m, err := LoadManifest(manifestPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return StuffManifest(in, out, m)
+}
+
+// StuffManifest is Stuff with the files and options described in a Manifest.
+// $VARS and ~ in the source paths and aliases of files are expanded
+// (see ExpandPath).
+func StuffManifest(in, out string, m Manifest) (int64, int64, error) {
+	o, err := m.StuffOpt()
+	if err != nil {
+		return 0, 0, err
+	}
+	return StuffManifestWithOpt(in, out, m, o)
+}
+
+// StuffOpt returns the stuffing options in the Manifest. The signing key,
+// if any, is read from its file.
+func (m Manifest) StuffOpt() (StuffOpt, error) {
+	codec, err := ParseCodec(m.Codec)
+	if err != nil {
+		return StuffOpt{}, err
+	}
+
+	o := StuffOpt{
+		RootPath:         m.RootPath,
+		CompressionLevel: m.CompressionLevel,
+		Store:            m.Store,
+		Brotli:           m.Brotli,
+		Dictionary:       m.Dictionary,
+		Exclude:          m.Exclude,
+		SkipHidden:       m.SkipHidden,
+		NoRecursive:      m.NoRecursive,
+		RespectGitignore: m.RespectGitignore,
+		Rewrite:          m.Rewrite,
+		Incremental:      m.Incremental,
+		Section:          m.Section,
+		Sidecar:          m.Sidecar,
+		Checksum:         m.Checksum,
+		Meta:             m.Meta,
+		Bundles:          m.Bundles,
+		Recipients:       m.Recipients,
+		Encrypt:          m.Encrypt,
+		Codec:            codec,
+	}
+	if len(m.Minify) > 0 {
+		o.Transform = []TransformFunc{Minify(m.Minify...)}
+	}
+	if m.MaxSize != "" {
+		if o.MaxSize, err = ParseSize(m.MaxSize); err != nil {
+			return o, err
+		}
+	}
+	if m.MaxFileSize != "" {
+		if o.MaxFileSize, err = ParseSize(m.MaxFileSize); err != nil {
+			return o, err
+		}
+	}
+	if m.SigningKey != "" {
+		if o.SigningKey, err = LoadSigningKey(m.SigningKey); err != nil {
+			return o, err
+		}
+	}
+
+	return o, nil
+}
+
+// StuffManifestWithOpt is StuffManifest with StuffOpt options instead of the
+// options in the Manifest, for instance, the ones from Manifest.StuffOpt
+// with some of them overridden. Only the files and the platform of the
+// Manifest are used.
+func StuffManifestWithOpt(in, out string, m Manifest, o StuffOpt) (int64, int64, error) {
+	entries, err := manifestEntries(in, m)
+	if err != nil {
+		return 0, 0, err
+	}
+	return stuffEntries(in, out, o, entries, false, nil)
+}
+
+// manifestEntries returns the entries of the files in a Manifest
+// for the platform of the manifest or of the binary in.
+func manifestEntries(in string, m Manifest) ([]stuffEntry, error) {
+	var err error
+	if len(m.Files) == 0 {
+		return nil, fmt.Errorf("no files in the manifest")
+	}
+	if m.Platform != "" {
+		if err := checkPlatform(m.Platform); err != nil {
+			return nil, err
+		}
+	}
+	entries := make([]stuffEntry, 0, len(m.Files))
+	for n, f := range m.Files {
+		if f.Src == "" {
+			return nil, fmt.Errorf("no src for file %d in the manifest", n+1)
+		}
+
+		// Skip files that aren't for the binary's platform.
+		if len(f.Platforms) > 0 {
+			for _, p := range f.Platforms {
+				if err := checkPlatform(p); err != nil {
+					return nil, err
+				}
+			}
+			if m.Platform == "" {
+				if m.Platform, err = BinaryPlatform(in); err != nil {
+					return nil, fmt.Errorf("%v. Set the platform in the manifest", err)
+				}
+			}
+			if !matchPlatform(f.Platforms, m.Platform) {
+				continue
+			}
+		}
+		if f.Src, err = ExpandPath(f.Src); err != nil {
+			return nil, err
+		}
+		if f.Alias, err = ExpandPath(f.Alias); err != nil {
+			return nil, err
+		}
+
+		e := stuffEntry{
+			path:    joinAlias(f.Src, f.Alias),
+			store:   f.Store,
+			brotli:  f.Brotli,
+			encrypt: f.Encrypt,
+			archive: f.Archive,
+		}
+
+		// Git paths (git:ref:path) have their own colons.
+		if isGitPath(f.Src) {
+			e.path = f.Src
+			if f.Alias != "" {
+				e.path += ":" + f.Alias
+			}
+		}
+
+		if len(f.Meta) > 0 {
+			b, err := json.Marshal(f.Meta)
+			if err != nil {
+				return nil, err
+			}
+			e.comment = string(b)
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// ExpandPath expands $VAR and ${VAR} environment variables and a leading ~
+// (the user's home directory) in a path (eg: $DIST_DIR:/static,
+// ~/assets). Variables that are not set are an error instead of silently
+// expanding to empty strings, which could change the paths that are stuffed.
+func ExpandPath(p string) (string, error) {
+	var missing []string
+	p = os.Expand(p, func(v string) string {
+		val, ok := os.LookupEnv(v)
+		if !ok {
+			missing = append(missing, v)
+		}
+		return val
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s in path is not set", strings.Join(missing, ", "))
+	}
+
+	if p == "~" || strings.HasPrefix(p, "~/") || strings.HasPrefix(p, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = home + p[1:]
+	}
+
+	return p, nil
+}
+
+// parseMeta parses file metadata stuffed as a JSON object in
+// a ZIP file comment. Comments that are not JSON objects are ignored.
+func parseMeta(comment string) map[string]string {
+	if !strings.HasPrefix(comment, "{") {
+		return nil
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal([]byte(comment), &m); err != nil {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,147 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestStuffFromManifest(t *testing.T) {
+	dir := t.TempDir()
+	man := filepath.Join(dir, "stuffbin.yml")
+	err := os.WriteFile(man, []byte(`
+root: /app
+exclude: ["*.exe*"]
+files:
+  - src: mock/subdir
+    alias: /sub
+  - src: mock/mock.go
+    store: true
+    meta:
+      version: "2"
+`), 0644)
+	assert(t, "error writing manifest", nil, err)
+
+	out := filepath.Join(dir, "stuffed")
+	_, _, err = StuffFromManifest(mockBin, out, man)
+	assert(t, "error stuffing", nil, err)
+
+	b, err := GetStuff(out)
+	assert(t, "error getting stuff", nil, err)
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	assert(t, "error reading zip", nil, err)
+	methods := map[string]uint16{}
+	for _, f := range r.File {
+		methods[f.Name] = f.Method
+	}
+	assert(t, "stored file method", zip.Store, methods["/app/mock/mock.go"])
+
+	fs, err := UnZip(b)
+	assert(t, "error unzipping", nil, err)
+	files := fs.List()
+	sort.Strings(files)
+	assert(t, "mismatch in stuffed file paths", []string{"/app/mock/mock.go", "/app/sub/baz.txt"}, files)
+
+	f, err := fs.Get("/app/mock/mock.go")
+	assert(t, "error getting file", nil, err)
+	assert(t, "file meta", map[string]string{"version": "2"}, f.Meta())
+	f, err = fs.Get("/app/sub/baz.txt")
+	assert(t, "error getting file", nil, err)
+	assert(t, "file meta", true, f.Meta() == nil)
+
+	// JSON manifest.
+	man = filepath.Join(dir, "stuffbin.json")
+	err = os.WriteFile(man, []byte(`{"codec": "zstd", "files": [{"src": "mock/bar.txt"}]}`), 0644)
+	assert(t, "error writing manifest", nil, err)
+	_, _, err = StuffFromManifest(mockBin, out, man)
+	assert(t, "error stuffing", nil, err)
+
+	fs, err = UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "mismatch in stuffed file paths", []string{"/mock/bar.txt"}, fs.List())
+
+	_, _, err = StuffManifest(mockBin, out, Manifest{})
+	assert(t, "expected error on empty manifest", true, err != nil)
+}
+
+func TestManifestStuffOpt(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		key = filepath.Join(dir, "key.hex")
+	)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert(t, "error generating key", nil, err)
+	assert(t, "error writing key", nil, os.WriteFile(key, []byte(hex.EncodeToString(priv.Seed())), 0600))
+
+	man := filepath.Join(dir, "stuffbin.yml")
+	err = os.WriteFile(man, []byte(`
+in: dist/app
+out: dist/app.stuffed
+codec: zstd
+checksum: true
+signing_key: `+key+`
+files:
+  - src: mock/bar.txt
+`), 0644)
+	assert(t, "error writing manifest", nil, err)
+
+	m, err := LoadManifest(man)
+	assert(t, "error loading manifest", nil, err)
+	assert(t, "manifest in", "dist/app", m.In)
+	assert(t, "manifest out", "dist/app.stuffed", m.Out)
+
+	o, err := m.StuffOpt()
+	assert(t, "error getting options", nil, err)
+	assert(t, "option codec", CodecZstd, o.Codec)
+	assert(t, "option checksum", true, o.Checksum)
+	assert(t, "option signing key", priv, o.SigningKey)
+
+	// Options given separately override the manifest's.
+	out := filepath.Join(dir, "stuffed")
+	o.Codec = CodecZip
+	_, _, err = StuffManifestWithOpt(mockBin, out, m, o)
+	assert(t, "error stuffing", nil, err)
+
+	id, err := GetFileID(out)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID codec", CodecZip, id.Codec)
+	assert(t, "error verifying signature", nil, VerifySignature(out, pub))
+
+	m.SigningKey = filepath.Join(dir, "missing")
+	_, err = m.StuffOpt()
+	assert(t, "expected error on missing signing key", true, err != nil)
+}
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("STUFFBIN_DIST", "mock/subdir")
+	home, err := os.UserHomeDir()
+	assert(t, "error getting home dir", nil, err)
+
+	p, err := ExpandPath("$STUFFBIN_DIST:/static")
+	assert(t, "error expanding path", nil, err)
+	assert(t, "mismatch in expanded path", "mock/subdir:/static", p)
+
+	p, err = ExpandPath("~/assets/${STUFFBIN_DIST}")
+	assert(t, "error expanding path", nil, err)
+	assert(t, "mismatch in expanded path", home+"/assets/mock/subdir", p)
+
+	p, err = ExpandPath("mock/~foo")
+	assert(t, "error expanding path", nil, err)
+	assert(t, "mismatch in unexpanded path", "mock/~foo", p)
+
+	_, err = ExpandPath("$STUFFBIN_UNSET_VAR/static")
+	assert(t, "expected error on unset variable", true, err != nil)
+
+	// Manifest entries are expanded.
+	out := filepath.Join(t.TempDir(), "stuffed")
+	_, _, err = StuffManifest(mockBin, out, Manifest{Files: []ManifestFile{{Src: "$STUFFBIN_DIST", Alias: "/static"}}})
+	assert(t, "error stuffing", nil, err)
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "mismatch in stuffed files", []string{"/static/baz.txt"}, fs.List())
+}
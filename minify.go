@@ -0,0 +1,406 @@
+package stuffbin
+
+import (
+	"bytes"
+	"path"
+	"strings"
+)
+
+// minifiers are the built-in minifiers by file extension.
+var minifiers = map[string]func([]byte) []byte{
+	".css":  minifyCSS,
+	".js":   minifyJS,
+	".mjs":  minifyJS,
+	".html": minifyHTML,
+	".htm":  minifyHTML,
+	".svg":  minifyHTML,
+}
+
+// Minify returns a TransformFunc (see StuffOpt.Transform) that minifies
+// the CSS, JS, HTML, and SVG files that match the given glob patterns (eg:
+// *.css, static/**) with the built-in minifier for their extension. All
+// supported files are minified if there are no patterns.
+//
+// The minifiers are conservative. They remove comments and collapse
+// whitespace but leave strings, regular expressions, the contents of
+// <pre>, <textarea>, <script>, and <style> tags, and Go template
+// {{ actions }} as they are.
+func Minify(patterns ...string) TransformFunc {
+	return func(p string, b []byte) (string, []byte, error) {
+		fn, ok := minifiers[strings.ToLower(path.Ext(p))]
+		if !ok {
+			return p, b, nil
+		}
+		// Patterns are matched like StuffOpt.Exclude patterns.
+		if len(patterns) > 0 && !isExcluded(patterns, p) {
+			return p, b, nil
+		}
+
+		return p, fn(b), nil
+	}
+}
+
+// minifyCSS removes comments (except /*! license comments) and
+// whitespace that's not significant from CSS.
+func minifyCSS(b []byte) []byte {
+	var (
+		out   = make([]byte, 0, len(b))
+		space = false
+	)
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '"' || c == '\'':
+			out, space = appendSpace(out, space, c), false
+			n := skipString(b, i)
+			out = append(out, b[i+1:n]...)
+			i = n - 1
+
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			n := bytes.Index(b[i+2:], []byte("*/"))
+			end := len(b)
+			if n >= 0 {
+				end = i + 2 + n + 2
+			}
+			if i+2 < len(b) && b[i+2] == '!' {
+				out, space = appendSpace(out, space, c), false
+				out = append(out, b[i+1:end]...)
+			}
+			i = end - 1
+
+		case isSpace(c):
+			space = true
+
+		default:
+			// Drop the last semicolon in a block.
+			if c == '}' && len(out) > 0 && out[len(out)-1] == ';' {
+				out = out[:len(out)-1]
+			}
+			out, space = appendSpace(out, space, c), false
+		}
+	}
+
+	return bytes.TrimSpace(out)
+}
+
+// appendSpace appends a pending CSS space unless it's next to
+// punctuation where it's not significant, and then the next character.
+// Spaces before colons are kept as they're descendant combinators in
+// selectors (eg: a :hover).
+func appendSpace(out []byte, space bool, next byte) []byte {
+	if space && len(out) > 0 && !strings.ContainsRune("{};,:>", rune(out[len(out)-1])) && !strings.ContainsRune("{};,>", rune(next)) {
+		out = append(out, ' ')
+	}
+	return append(out, next)
+}
+
+// jsPunct is the JS punctuation that whitespace can be removed around
+// without joining tokens. Newlines are only removed after jsOpen and
+// before jsClose as automatic semicolon insertion doesn't apply there.
+const (
+	jsPunct = "{}()[];,=:?!&|*%^~"
+	jsOpen  = "{([;,=:?&|*%^~!"
+	jsClose = ")]};,:?="
+)
+
+// jsRegexpKeywords are the keywords that a / after starts a regular
+// expression instead of being a division.
+var jsRegexpKeywords = []string{"return", "typeof", "instanceof", "case", "do", "else", "in", "of", "new", "delete", "void", "throw", "yield", "await"}
+
+// minifyJS removes comments (except /*! license comments) and collapses
+// whitespace in JS. Newlines that automatic semicolon insertion may depend
+// on are kept.
+func minifyJS(b []byte) []byte {
+	var (
+		out     = make([]byte, 0, len(b))
+		space   = false
+		newline = false
+	)
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '/' && i+1 < len(b) && b[i+1] == '/':
+			n := bytes.IndexByte(b[i:], '\n')
+			if n < 0 {
+				i = len(b)
+				continue
+			}
+			i += n - 1
+
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			n := bytes.Index(b[i+2:], []byte("*/"))
+			end := len(b)
+			if n >= 0 {
+				end = i + 2 + n + 2
+			}
+			if i+2 < len(b) && b[i+2] == '!' {
+				out = appendJSSpace(out, space, newline, c)
+				space, newline = false, false
+				out = append(out[:len(out)-1], b[i:end]...)
+			} else if bytes.IndexByte(b[i:end], '\n') >= 0 {
+				newline = true
+			} else {
+				space = true
+			}
+			i = end - 1
+
+		case isSpace(c):
+			space = true
+			newline = newline || c == '\n' || c == '\r'
+
+		default:
+			var n int
+			switch {
+			case c == '"' || c == '\'':
+				n = skipString(b, i)
+			case c == '`':
+				n = skipTemplate(b, i)
+			case c == '/' && isJSRegexp(out):
+				n = skipRegexp(b, i)
+			default:
+				n = i + 1
+			}
+
+			out = appendJSSpace(out, space, newline, c)
+			space, newline = false, false
+			out = append(out, b[i+1:n]...)
+			i = n - 1
+		}
+	}
+
+	return bytes.TrimSpace(out)
+}
+
+// appendJSSpace appends pending JS whitespace where it's
+// significant, and then the next character.
+func appendJSSpace(out []byte, space, newline bool, next byte) []byte {
+	if len(out) == 0 || !space {
+		return append(out, next)
+	}
+
+	prev := out[len(out)-1]
+	switch {
+	case newline && !strings.ContainsRune(jsOpen, rune(prev)) && !strings.ContainsRune(jsClose, rune(next)):
+		out = append(out, '\n')
+	case !strings.ContainsRune(jsPunct, rune(prev)) && !strings.ContainsRune(jsPunct, rune(next)):
+		out = append(out, ' ')
+	}
+	return append(out, next)
+}
+
+// isJSRegexp checks whether a / after the given minified JS starts
+// a regular expression instead of being a division.
+func isJSRegexp(out []byte) bool {
+	s := bytes.TrimRight(out, " \n")
+	if len(s) == 0 {
+		return true
+	}
+
+	c := s[len(s)-1]
+	if strings.ContainsRune("(,=:[!&|?{};+-*%<>~^", rune(c)) {
+		return true
+	}
+	for _, k := range jsRegexpKeywords {
+		if bytes.HasSuffix(s, []byte(k)) && (len(s) == len(k) || !isWordByte(s[len(s)-len(k)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipString returns the index after the quoted string at i.
+func skipString(b []byte, i int) int {
+	q := b[i]
+	for i++; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case q, '\n':
+			return i + 1
+		}
+	}
+	return len(b)
+}
+
+// skipTemplate returns the index after the JS template literal at i,
+// skipping the strings and templates in its ${} expressions.
+func skipTemplate(b []byte, i int) int {
+	depth := 0
+	for i++; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '\\':
+			i++
+		case depth == 0 && c == '`':
+			return i + 1
+		case depth == 0 && c == '$' && i+1 < len(b) && b[i+1] == '{':
+			depth++
+			i++
+		case depth > 0 && c == '{':
+			depth++
+		case depth > 0 && c == '}':
+			depth--
+		case depth > 0 && (c == '"' || c == '\''):
+			i = skipString(b, i) - 1
+		case depth > 0 && c == '`':
+			i = skipTemplate(b, i) - 1
+		}
+	}
+	return len(b)
+}
+
+// skipRegexp returns the index after the JS regular expression at i.
+func skipRegexp(b []byte, i int) int {
+	class := false
+	for i++; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == '\\':
+			i++
+		case c == '[':
+			class = true
+		case c == ']':
+			class = false
+		case c == '/' && !class:
+			// Flags.
+			for i++; i < len(b) && isWordByte(b[i]); i++ {
+			}
+			return i
+		case c == '\n':
+			return i
+		}
+	}
+	return len(b)
+}
+
+// htmlRawTags are the HTML tags whose contents are left as they are.
+var htmlRawTags = []string{"pre", "textarea", "script", "style"}
+
+// minifyHTML removes comments (except conditional comments) and
+// collapses whitespace in HTML and SVG.
+func minifyHTML(b []byte) []byte {
+	var (
+		out   = make([]byte, 0, len(b))
+		space = false
+	)
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case bytes.HasPrefix(b[i:], []byte("{{")):
+			end := indexEnd(b, i, "}}")
+			out, space = appendHTMLSpace(out, space), false
+			out = append(out, b[i:end]...)
+			i = end - 1
+
+		case bytes.HasPrefix(b[i:], []byte("<!--")) && !bytes.HasPrefix(b[i:], []byte("<!--[if")):
+			i = indexEnd(b, i, "-->") - 1
+
+		case bytes.HasPrefix(b[i:], []byte("<![CDATA[")):
+			end := indexEnd(b, i, "]]>")
+			out, space = appendHTMLSpace(out, space), false
+			out = append(out, b[i:end]...)
+			i = end - 1
+
+		case c == '<':
+			// Copy the tag, collapsing the whitespace between attributes,
+			// and the contents of raw tags.
+			out, space = appendHTMLSpace(out, space), false
+			end := copyTag(&out, b, i)
+			if tag := rawTag(b[i:end]); tag != "" {
+				n := bytes.Index(bytes.ToLower(b[end:]), []byte("</"+tag))
+				if n < 0 {
+					n = len(b) - end
+				}
+				out = append(out, b[end:end+n]...)
+				end += n
+			}
+			i = end - 1
+
+		case isSpace(c):
+			space = true
+
+		default:
+			out, space = appendHTMLSpace(out, space), false
+			out = append(out, c)
+		}
+	}
+
+	return bytes.TrimSpace(out)
+}
+
+// appendHTMLSpace appends a pending HTML space.
+func appendHTMLSpace(out []byte, space bool) []byte {
+	if space && len(out) > 0 {
+		return append(out, ' ')
+	}
+	return out
+}
+
+// copyTag appends the tag at i to out with the whitespace between its
+// attributes collapsed and returns the index after it.
+func copyTag(out *[]byte, b []byte, i int) int {
+	space := false
+	for ; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '"' || c == '\'':
+			n := bytes.IndexByte(b[i+1:], c)
+			end := len(b)
+			if n >= 0 {
+				end = i + 1 + n + 1
+			}
+			*out = appendHTMLSpace(*out, space)
+			*out = append(*out, b[i:end]...)
+			space = false
+			i = end - 1
+		case bytes.HasPrefix(b[i:], []byte("{{")):
+			end := indexEnd(b, i, "}}")
+			*out = appendHTMLSpace(*out, space)
+			*out = append(*out, b[i:end]...)
+			space = false
+			i = end - 1
+		case isSpace(c):
+			space = true
+		case c == '>':
+			*out = append(*out, c)
+			return i + 1
+		default:
+			if c == '/' && space && i+1 < len(b) && b[i+1] == '>' {
+				space = false
+			}
+			*out = appendHTMLSpace(*out, space)
+			*out = append(*out, c)
+			space = false
+		}
+	}
+	return len(b)
+}
+
+// rawTag returns the name of the given opening tag if it's one
+// of htmlRawTags.
+func rawTag(tag []byte) string {
+	name := bytes.ToLower(bytes.TrimPrefix(tag, []byte("<")))
+	for _, t := range htmlRawTags {
+		if bytes.HasPrefix(name, []byte(t)) && len(name) > len(t) && !isWordByte(name[len(t)]) {
+			return t
+		}
+	}
+	return ""
+}
+
+// indexEnd returns the index after the first end marker after i, or the
+// length of b if there's none.
+func indexEnd(b []byte, i int, end string) int {
+	n := bytes.Index(b[i:], []byte(end))
+	if n < 0 {
+		return len(b)
+	}
+	return i + n + len(end)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
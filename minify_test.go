@@ -0,0 +1,38 @@
+package stuffbin
+
+import (
+	"testing"
+)
+
+func TestMinify(t *testing.T) {
+	for _, c := range []struct {
+		path, in, out string
+	}{
+		{
+			"/a.css",
+			"/* c */\nbody  {\n  color: red;\n  font: 12px \"a  b\";\n}\n\na :hover > b { margin: 0 }\n/*! license */",
+			"body{color:red;font:12px \"a  b\"}a :hover>b{margin:0}/*! license */",
+		},
+		{
+			"/a.js",
+			"// c\nvar a = 1 + +b; /* c */\nvar s = \"a  // b\", r = /a\\/ b[/]/g;\nreturn\nx\nfoo(a,\n  b)\nvar t = `a  ${ \"}\" }  b`;\ni++\n/*! license */",
+			"var a=1 + +b;var s=\"a  // b\",r=/a\\/ b[/]/g;return\nx\nfoo(a,b)\nvar t=`a  ${ \"}\" }  b`;i++\n/*! license */",
+		},
+		{
+			"/a.html",
+			"<!-- c -->\n<div  class=\"a  b\"   id=x>\n  Hello   {{ .Name  }}\n  <br />\n</div>\n<pre>  a\n  b</pre>\n<script>\n// keep\n</script>",
+			"<div class=\"a  b\" id=x> Hello {{ .Name  }} <br/> </div> <pre>  a\n  b</pre> <script>\n// keep\n</script>",
+		},
+		{"/a.txt", "a  b", "a  b"},
+	} {
+		p, b, err := Minify()(c.path, []byte(c.in))
+		assert(t, "error minifying", nil, err)
+		assert(t, "mismatch in path", c.path, p)
+		assert(t, "mismatch in minified "+c.path, c.out, string(b))
+	}
+
+	// Only files that match the patterns are minified.
+	_, b, err := Minify("static/**")("/templates/a.css", []byte("a { }"))
+	assert(t, "error minifying", nil, err)
+	assert(t, "unexpected minification", "a { }", string(b))
+}
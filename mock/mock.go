@@ -4,14 +4,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 
 	"github.com/knadh/stuffbin"
 )
 
 func main() {
 	// Read stuffed data from self.
-	fs, err := stuffbin.UnStuff(os.Args[0])
+	fs, err := stuffbin.UnStuffSelf()
 	if err != nil {
 		// Binary is unstuffed or is running in dev mode.
 		// Can halt here or fall back to the local filesystem.
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package stuffbin
+
+import "os"
+
+// copyOwner is a no-op on platforms without Unix file ownership.
+func copyOwner(f *os.File, info os.FileInfo) {}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package stuffbin
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner sets the owner and group of a file to those of the given file
+// info. It's best effort as only privileged users can change owners.
+func copyOwner(f *os.File, info os.FileInfo) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		_ = f.Chown(int(st.Uid), int(st.Gid))
+	}
+}
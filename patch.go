@@ -0,0 +1,311 @@
+package stuffbin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// patchBlock is the size of the blocks of the old binary that
+	// are matched in the new binary.
+	patchBlock = 64
+
+	// patchPrime is the multiplier of the rolling hash of blocks.
+	patchPrime = 16777619
+
+	opCopy   = 'c'
+	opInsert = 'i'
+)
+
+// patchMagic is the beginning of a patch.
+var patchMagic = []byte("stuffbinpatch1")
+
+// ErrPatch is returned when a patch doesn't apply
+// to a binary or produces a corrupt binary.
+var ErrPatch = errors.New("patch doesn't match the binary")
+
+// patchHeader is the header of a patch with the sizes and
+// the SHA-256 hashes of the old and the new binaries.
+type patchHeader struct {
+	OldSize int64
+	OldSum  [32]byte
+	NewSize int64
+	NewSum  [32]byte
+}
+
+// MakePatch returns a patch that turns the stuffed binary at oldPath
+// into the one at newPath, for instance, to ship small self-updates of
+// applications whose stuffed assets change between releases but whose
+// code barely does. Parts of the new binary that are in the old one, such
+// as the binary itself and unchanged files in ZIP payloads, are copied
+// from it. Payloads compressed with CodecZstd or encrypted as a whole
+// change completely and result in large patches.
+func MakePatch(oldPath, newPath string) ([]byte, error) {
+	old, err := os.ReadFile(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := patchHeader{
+		OldSize: int64(len(old)),
+		OldSum:  sha256.Sum256(old),
+		NewSize: int64(len(b)),
+		NewSum:  sha256.Sum256(b),
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write(patchMagic)
+	if err := binary.Write(buf, binary.LittleEndian, hdr); err != nil {
+		return nil, err
+	}
+
+	zw, err := zstd.NewWriter(buf, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		return nil, err
+	}
+	if err := writePatchOps(zw, old, b); err != nil {
+		zw.Close()
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ApplyPatch applies a patch made with MakePatch to the binary at oldPath
+// and writes the new binary to out. The old and the new binaries are
+// verified with their SHA-256 hashes in the patch and ErrPatch is returned
+// if they don't match.
+func ApplyPatch(oldPath, out string, patch []byte) error {
+	if !bytes.HasPrefix(patch, patchMagic) {
+		return fmt.Errorf("invalid patch: %v", ErrPatch)
+	}
+
+	var hdr patchHeader
+	r := bytes.NewReader(patch[len(patchMagic):])
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return fmt.Errorf("invalid patch: %v", err)
+	}
+	if hdr.NewSize < 0 || uint64(hdr.NewSize) > maxInt {
+		return fmt.Errorf("invalid patch: size %d", hdr.NewSize)
+	}
+
+	old, err := os.ReadFile(oldPath)
+	if err != nil {
+		return err
+	}
+	if int64(len(old)) != hdr.OldSize || sha256.Sum256(old) != hdr.OldSum {
+		return ErrPatch
+	}
+
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	b, err := readPatchOps(zr, old, hdr.NewSize)
+	if err != nil {
+		return fmt.Errorf("invalid patch: %v", err)
+	}
+	if sha256.Sum256(b) != hdr.NewSum {
+		return ErrPatch
+	}
+
+	// Write via a temporary file (see stuffEntries).
+	tmp, err := createTemp(out, oldPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return replaceFile(f, out)
+}
+
+// writePatchOps writes the copy and insert operations that turn old into b.
+// Blocks of old are indexed by their rolling hashes, which are looked up at
+// every offset of b. Matches are extended in both directions and copied,
+// and the rest of b is inserted.
+func writePatchOps(w io.Writer, old, b []byte) error {
+	index := make(map[uint32]int, len(old)/patchBlock)
+	for o := 0; o+patchBlock <= len(old); o += patchBlock {
+		h := blockHash(old[o : o+patchBlock])
+		if _, ok := index[h]; !ok {
+			index[h] = o
+		}
+	}
+
+	// pow is patchPrime^(patchBlock-1) to roll bytes out of the hash.
+	pow := uint32(1)
+	for i := 0; i < patchBlock-1; i++ {
+		pow *= patchPrime
+	}
+
+	var (
+		lit = 0
+		i   = 0
+		h   uint32
+	)
+	if len(b) >= patchBlock {
+		h = blockHash(b[:patchBlock])
+	}
+	for i+patchBlock <= len(b) {
+		if o, ok := index[h]; ok && bytes.Equal(old[o:o+patchBlock], b[i:i+patchBlock]) {
+			start, ostart := i, o
+			for start > lit && ostart > 0 && b[start-1] == old[ostart-1] {
+				start--
+				ostart--
+			}
+			end, oend := i+patchBlock, o+patchBlock
+			for end < len(b) && oend < len(old) && b[end] == old[oend] {
+				end++
+				oend++
+			}
+
+			if err := writeInsert(w, b[lit:start]); err != nil {
+				return err
+			}
+			if err := writeOp(w, opCopy, uint64(ostart), uint64(end-start)); err != nil {
+				return err
+			}
+
+			i, lit = end, end
+			if i+patchBlock <= len(b) {
+				h = blockHash(b[i : i+patchBlock])
+			}
+			continue
+		}
+
+		if i+patchBlock < len(b) {
+			h = (h-uint32(b[i])*pow)*patchPrime + uint32(b[i+patchBlock])
+		}
+		i++
+	}
+
+	return writeInsert(w, b[lit:])
+}
+
+// readPatchOps returns the new binary of the given size made by
+// applying the copy and insert operations read from r to old. The size,
+// which is read from the patch, isn't trusted and only a part of it
+// is allocated up front (see maxPrealloc).
+func readPatchOps(r io.Reader, old []byte, size int64) ([]byte, error) {
+	var (
+		br  = &byteReader{r: r}
+		out = bytes.NewBuffer(make([]byte, 0, min(size, maxPrealloc)))
+	)
+	for {
+		op, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opCopy:
+			off, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, err
+			}
+			n, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, err
+			}
+			if off > uint64(len(old)) || n > uint64(len(old))-off {
+				return nil, fmt.Errorf("copy of %d bytes at %d is out of bounds", n, off)
+			}
+			out.Write(old[off : off+n])
+
+		case opInsert:
+			n, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, err
+			}
+			if n > uint64(size)-uint64(out.Len()) {
+				return nil, fmt.Errorf("insert of %d bytes exceeds the size", n)
+			}
+			if _, err := io.CopyN(out, br.r, int64(n)); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unknown operation %d", op)
+		}
+
+		if int64(out.Len()) > size {
+			return nil, errors.New("patch exceeds the size of the binary")
+		}
+	}
+	if int64(out.Len()) != size {
+		return nil, errors.New("patch doesn't add up to the size of the binary")
+	}
+
+	return out.Bytes(), nil
+}
+
+// writeInsert writes an insert operation with the given bytes, if any.
+func writeInsert(w io.Writer, b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if err := writeOp(w, opInsert, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// writeOp writes an operation with its uvarint arguments.
+func writeOp(w io.Writer, op byte, args ...uint64) error {
+	b := []byte{op}
+	for _, a := range args {
+		b = binary.AppendUvarint(b, a)
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// blockHash returns the polynomial rolling hash of a block.
+func blockHash(b []byte) uint32 {
+	var h uint32
+	for _, c := range b {
+		h = h*patchPrime + uint32(c)
+	}
+	return h
+}
+
+// byteReader is an io.ByteReader over an io.Reader for reading uvarints.
+type byteReader struct {
+	r io.Reader
+	b [1]byte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(r.r, r.b[:]); err != nil {
+		return 0, err
+	}
+	return r.b[0], nil
+}
@@ -0,0 +1,81 @@
+package stuffbin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+	dir := t.TempDir()
+
+	// Assets that don't compress much so that the patch size
+	// shows that unchanged files are copied.
+	var (
+		rnd    = rand.New(rand.NewSource(1))
+		assets = filepath.Join(dir, "assets")
+		big    = make([]byte, 256*1024)
+	)
+	rnd.Read(big)
+	assert(t, "error creating dir", nil, os.Mkdir(assets, 0755))
+	assert(t, "error writing file", nil, os.WriteFile(filepath.Join(assets, "big.bin"), big, 0644))
+	assert(t, "error writing file", nil, os.WriteFile(filepath.Join(assets, "app.js"), []byte("console.log('v1');"), 0644))
+
+	oldBin := filepath.Join(dir, "old.bin")
+	_, _, err := Stuff(mockBin, oldBin, "/", assets+":/assets")
+	assert(t, "error stuffing", nil, err)
+
+	assert(t, "error writing file", nil, os.WriteFile(filepath.Join(assets, "app.js"), []byte("console.log('v2');"), 0644))
+	newBin := filepath.Join(dir, "new.bin")
+	_, _, err = Stuff(mockBin, newBin, "/", assets+":/assets")
+	assert(t, "error stuffing", nil, err)
+
+	p, err := MakePatch(oldBin, newBin)
+	assert(t, "error making patch", nil, err)
+	assert(t, "patch isn't small", true, len(p) < 4096)
+
+	out := filepath.Join(dir, "patched.bin")
+	assert(t, "error applying patch", nil, ApplyPatch(oldBin, out, p))
+
+	a, err := os.ReadFile(newBin)
+	assert(t, "error reading file", nil, err)
+	b, err := os.ReadFile(out)
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in patched binary", true, bytes.Equal(a, b))
+
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	f, err := fs.Get("/assets/app.js")
+	assert(t, "error getting file", nil, err)
+	assert(t, "mismatch in patched file", "console.log('v2');", string(f.ReadBytes()))
+
+	// Patches only apply to the binary they were made from.
+	assert(t, "expected patch mismatch", ErrPatch, ApplyPatch(newBin, out, p))
+
+	c := append([]byte{}, p...)
+	c[len(c)-1] ^= 0xff
+	assert(t, "expected error on corrupt patch", true, ApplyPatch(oldBin, out, c) != nil)
+	assert(t, "expected error on invalid patch", true, ApplyPatch(oldBin, out, []byte("invalid")) != nil)
+}
+
+func TestPatchBadHeader(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "patched.bin")
+	p, err := MakePatch(mockBin, mockBin)
+	assert(t, "error making patch", nil, err)
+
+	// NewSize follows the magic, OldSize (8), and OldSum (32).
+	off := len(patchMagic) + 40
+	for _, size := range []int64{-1, math.MaxInt64, 1 << 40, 0} {
+		c := append([]byte{}, p...)
+		binary.LittleEndian.PutUint64(c[off:off+8], uint64(size))
+		assert(t, "expected error on patch size", true, ApplyPatch(mockBin, out, c) != nil)
+	}
+
+	assert(t, "expected error on truncated header", true, ApplyPatch(mockBin, out, p[:off]) != nil)
+	assert(t, "error applying patch", nil, ApplyPatch(mockBin, out, p))
+}
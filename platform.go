@@ -0,0 +1,57 @@
+package stuffbin
+
+import (
+	"debug/buildinfo"
+	"fmt"
+	"strings"
+)
+
+// BinaryPlatform returns the GOOS/GOARCH platform (eg: linux/amd64) that
+// a Go binary was built for from the build info that's embedded in it.
+func BinaryPlatform(path string) (string, error) {
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading the build info of %s: %v", path, err)
+	}
+
+	var goos, goarch string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "GOOS":
+			goos = s.Value
+		case "GOARCH":
+			goarch = s.Value
+		}
+	}
+	if goos == "" || goarch == "" {
+		return "", fmt.Errorf("%s has no GOOS/GOARCH in its build info", path)
+	}
+
+	return goos + "/" + goarch, nil
+}
+
+// matchPlatform checks whether a GOOS/GOARCH platform matches any of the
+// given patterns. A pattern is a GOOS (eg: darwin), GOOS/GOARCH (eg:
+// linux/arm64), or either of them can be * (eg: */amd64).
+func matchPlatform(patterns []string, platform string) bool {
+	goos, goarch, _ := strings.Cut(platform, "/")
+	for _, p := range patterns {
+		os, arch, ok := strings.Cut(p, "/")
+		if !ok {
+			arch = "*"
+		}
+		if (os == "*" || os == goos) && (arch == "*" || arch == goarch) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPlatform validates a GOOS/GOARCH platform pattern.
+func checkPlatform(p string) error {
+	os, arch, ok := strings.Cut(p, "/")
+	if os == "" || (ok && (arch == "" || strings.Contains(arch, "/"))) {
+		return fmt.Errorf("invalid platform '%s'. Should be GOOS or GOOS/GOARCH, eg: linux/amd64", p)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package stuffbin
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+func TestBinaryPlatform(t *testing.T) {
+	exe, err := os.Executable()
+	assert(t, "error getting executable", nil, err)
+
+	p, err := BinaryPlatform(exe)
+	assert(t, "error getting platform", nil, err)
+	assert(t, "mismatch in platform", runtime.GOOS+"/"+runtime.GOARCH, p)
+
+	_, err = BinaryPlatform(mockBin)
+	assert(t, "expected error on non-Go binary", true, err != nil)
+}
+
+func TestMatchPlatform(t *testing.T) {
+	for _, c := range []struct {
+		patterns []string
+		ok       bool
+	}{
+		{[]string{"linux"}, true},
+		{[]string{"linux/amd64"}, true},
+		{[]string{"*/amd64"}, true},
+		{[]string{"linux/*"}, true},
+		{[]string{"darwin", "windows/amd64"}, false},
+		{[]string{"linux/arm64"}, false},
+	} {
+		assert(t, "mismatch in platform match", c.ok, matchPlatform(c.patterns, "linux/amd64"))
+	}
+}
+
+func TestStuffManifestPlatforms(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		out = filepath.Join(dir, "stuffed")
+	)
+	m := Manifest{
+		Files: []ManifestFile{
+			{Src: "mock/foo.txt"},
+			{Src: "mock/bar.txt", Platforms: []string{"windows"}},
+			{Src: "mock/foofunc.txt", Platforms: []string{"linux/arm64", "darwin"}},
+		},
+	}
+
+	// Non-Go binaries need an explicit platform.
+	_, _, err := StuffManifest(mockBin, out, m)
+	assert(t, "expected error without a platform", true, err != nil)
+
+	for platform, files := range map[string][]string{
+		"windows/amd64": {"/mock/bar.txt", "/mock/foo.txt"},
+		"darwin/arm64":  {"/mock/foo.txt", "/mock/foofunc.txt"},
+		"linux/amd64":   {"/mock/foo.txt"},
+	} {
+		m.Platform = platform
+		_, _, err := StuffManifest(mockBin, out, m)
+		assert(t, "error stuffing", nil, err)
+
+		fs, err := UnStuff(out)
+		assert(t, "error unstuffing", nil, err)
+		f := fs.List()
+		sort.Strings(f)
+		assert(t, "mismatch in files for "+platform, files, f)
+	}
+
+	m.Platform = "linux/"
+	_, _, err = StuffManifest(mockBin, out, m)
+	assert(t, "expected error on invalid platform", true, err != nil)
+}
@@ -0,0 +1,104 @@
+package stuffbin
+
+// EventType is the type of a progress Event.
+type EventType int
+
+const (
+	// EventFileStart is sent before a file is stuffed or unstuffed.
+	EventFileStart EventType = iota
+
+	// EventFileDone is sent after a file is stuffed or unstuffed.
+	EventFileDone
+
+	// EventDone is sent after all files are stuffed or unstuffed.
+	EventDone
+)
+
+// Event is a progress event that's sent to a ProgressFunc while files are
+// stuffed (see StuffOpt.Progress) or unstuffed (see UnStuffOpt.Progress).
+type Event struct {
+	Type EventType
+
+	// Path and Size are the target path and the uncompressed size of the
+	// file for file events.
+	Path string
+	Size int64
+
+	// Bytes is the uncompressed size of the files processed so far and
+	// Compressed is the size of the ZIP written (or read) so far. While
+	// stuffing, Compressed trails Bytes as compressors buffer data, and
+	// it's the size before the payload is compressed with CodecZstd
+	// or encrypted.
+	Bytes      int64
+	Compressed int64
+}
+
+// ProgressFunc is called synchronously with progress events. It
+// should return quickly as it blocks stuffing and unstuffing.
+type ProgressFunc func(e Event)
+
+// Ratio returns the compression ratio (compressed / uncompressed) of the
+// files processed so far, or 0 if no files have been processed.
+func (e Event) Ratio() float64 {
+	if e.Bytes == 0 {
+		return 0
+	}
+	return float64(e.Compressed) / float64(e.Bytes)
+}
+
+// progress sends the progress events of files that are processed one after
+// the other to a ProgressFunc. A file is done when the next one starts or
+// when all files are finished, which lets writers flush its data first.
+type progress struct {
+	fn         ProgressFunc
+	compressed func() int64
+
+	bytes int64
+	cur   *Event
+}
+
+// newProgress returns a progress that sends events to the optional fn
+// with the compressed size returned by the given function.
+func newProgress(fn ProgressFunc, compressed func() int64) *progress {
+	return &progress{fn: fn, compressed: compressed}
+}
+
+// start sends the start event of a file after the done
+// event of the previous file.
+func (p *progress) start(path string, size int64) {
+	if p.fn == nil {
+		return
+	}
+	p.done()
+
+	p.cur = &Event{Type: EventFileStart, Path: path, Size: size}
+	p.send(*p.cur)
+}
+
+// done sends the done event of the current file, if there's one.
+func (p *progress) done() {
+	if p.cur == nil {
+		return
+	}
+
+	e := *p.cur
+	e.Type = EventFileDone
+	p.bytes += e.Size
+	p.cur = nil
+	p.send(e)
+}
+
+// finish sends the done event of the last file and EventDone.
+func (p *progress) finish() {
+	if p.fn == nil {
+		return
+	}
+	p.done()
+	p.send(Event{Type: EventDone})
+}
+
+func (p *progress) send(e Event) {
+	e.Bytes = p.bytes
+	e.Compressed = p.compressed()
+	p.fn(e)
+}
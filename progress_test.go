@@ -0,0 +1,48 @@
+package stuffbin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "stuffed")
+
+	var size int64
+	for _, p := range localFiles {
+		stat, err := os.Stat(p)
+		assert(t, "error reading file", nil, err)
+		size += stat.Size()
+	}
+
+	check := func(events []Event) {
+		assert(t, "event count", 2*len(stuffedFiles)+1, len(events))
+		for n, p := range stuffedFiles {
+			assert(t, "mismatch in start event", EventFileStart, events[2*n].Type)
+			assert(t, "mismatch in start path", p, events[2*n].Path)
+			assert(t, "mismatch in done event", EventFileDone, events[2*n+1].Type)
+			assert(t, "mismatch in done path", p, events[2*n+1].Path)
+		}
+
+		last := events[len(events)-1]
+		assert(t, "mismatch in last event", EventDone, last.Type)
+		assert(t, "mismatch in bytes", size, last.Bytes)
+		assert(t, "expected compressed bytes", true, last.Compressed > 0)
+		assert(t, "expected ratio", true, last.Ratio() > 0)
+	}
+
+	var events []Event
+	_, _, err := StuffWithOpt(mockBin, out, StuffOpt{Progress: func(e Event) {
+		events = append(events, e)
+	}}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+	check(events)
+
+	events = nil
+	_, err = UnStuffWithOpt(out, UnStuffOpt{Progress: func(e Event) {
+		events = append(events, e)
+	}})
+	assert(t, "error unstuffing", nil, err)
+	check(events)
+}
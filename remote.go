@@ -0,0 +1,85 @@
+package stuffbin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RemoteOpt represents options for loading payloads over HTTP.
+type RemoteOpt struct {
+	// Client is the HTTP client that the payload is fetched with.
+	// Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// MaxSize is the optional max size of the payload in bytes.
+	// Larger payloads are rejected before they're fully downloaded.
+	MaxSize int64
+
+	// Progress is an optional function that's called as files are unzipped.
+	Progress ProgressFunc
+}
+
+// LoadRemoteStuff fetches a ZIP payload, for instance, one that's written
+// by `stuffbin unstuff`, over HTTP and returns a FileSystem with its
+// files. This is useful for optional asset packs that shouldn't inflate
+// the binary. The payload is verified with the given hex encoded SHA-256
+// checksum (eg: the output of sha256sum) and rejected with ErrChecksum if
+// it doesn't match. As with UnZip, files that are encrypted individually
+// are skipped.
+func LoadRemoteStuff(url, checksum string) (FileSystem, error) {
+	return LoadRemoteStuffWithOpt(url, checksum, RemoteOpt{})
+}
+
+// LoadRemoteStuffWithOpt is LoadRemoteStuff with RemoteOpt options.
+func LoadRemoteStuffWithOpt(url, checksum string, o RemoteOpt) (FileSystem, error) {
+	// The checksum may be a line of sha256sum's output with the file name.
+	if f := strings.Fields(checksum); len(f) > 0 {
+		checksum = f[0]
+	}
+	sum, err := hex.DecodeString(checksum)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, errors.New("invalid checksum. Should be a hex encoded SHA-256 hash")
+	}
+
+	c := o.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	resp, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching payload: %s", resp.Status)
+	}
+
+	var r io.Reader = resp.Body
+	if o.MaxSize > 0 {
+		if resp.ContentLength > o.MaxSize {
+			return nil, fmt.Errorf("payload size %d exceeds the max size %d", resp.ContentLength, o.MaxSize)
+		}
+		r = io.LimitReader(r, o.MaxSize+1)
+	}
+
+	h := sha256.New()
+	b, err := io.ReadAll(io.TeeReader(r, h))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching payload: %v", err)
+	}
+	if o.MaxSize > 0 && int64(len(b)) > o.MaxSize {
+		return nil, fmt.Errorf("payload exceeds the max size %d", o.MaxSize)
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return nil, ErrChecksum
+	}
+
+	return unZip(b, nil, UnStuffOpt{Progress: o.Progress})
+}
@@ -0,0 +1,46 @@
+package stuffbin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func TestLoadRemoteStuff(t *testing.T) {
+	zb, err := zipFiles(StuffOpt{}, localFiles...)
+	assert(t, "error zipping files", nil, err)
+	b := zb.Bytes()
+	h := sha256.Sum256(b)
+	sum := hex.EncodeToString(h[:])
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/assets.zip" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	fs, err := LoadRemoteStuff(srv.URL+"/assets.zip", sum+"  assets.zip\n")
+	assert(t, "error loading remote stuff", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in file paths", stuffedFiles, f)
+
+	h[0] ^= 0xff
+	_, err = LoadRemoteStuff(srv.URL+"/assets.zip", hex.EncodeToString(h[:]))
+	assert(t, "mismatch in error", ErrChecksum, err)
+
+	_, err = LoadRemoteStuff(srv.URL+"/assets.zip", "abc")
+	assert(t, "accepted an invalid checksum", true, err != nil)
+
+	_, err = LoadRemoteStuff(srv.URL+"/missing.zip", sum)
+	assert(t, "loaded a missing payload", true, err != nil)
+
+	_, err = LoadRemoteStuffWithOpt(srv.URL+"/assets.zip", sum, RemoteOpt{MaxSize: int64(len(b) - 1)})
+	assert(t, "loaded a payload above the max size", true, err != nil)
+}
@@ -0,0 +1,63 @@
+package stuffbin
+
+import (
+	"strings"
+)
+
+// Repack re-stuffs the files in the payload of a stuffed binary with the
+// given codec into the output binary without the original files, for
+// instance, to shrink older releases or to migrate them to a new payload
+// format. The payload keeps its metadata and checksum.
+func Repack(in, out string, codec Codec) (int64, int64, error) {
+	id, err := GetFileID(in)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	o := StuffOpt{Codec: codec, Meta: id.Extra().Meta, Checksum: id.Flags&FlagChecksum != 0}
+	return RepackWithOpt(in, out, o)
+}
+
+// RepackWithOpt is Repack with StuffOpt options, which are applied to the
+// files in the payload as they are to the files in a FileSystem (see
+// StuffFSWithOpt). Encrypted payloads are decrypted with the key in the
+// options. Files that are encrypted individually stay encrypted and need
+// the key. Brotli copies are kept as they are unless their files match
+// StuffOpt.Brotli, in which case they're compressed again. Per-file
+// metadata isn't kept.
+func RepackWithOpt(in, out string, o StuffOpt) (int64, int64, error) {
+	files, err := ListStuff(in, UnStuffOpt{Key: o.key()})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Files in the payload are stuffed again from memory.
+	fs, err := UnStuffWithOpt(in, UnStuffOpt{Key: o.key()})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var enc []string
+	for _, f := range files {
+		if f.Encrypted {
+			if o.key() == nil {
+				return 0, 0, ErrNoKey
+			}
+			enc = append(enc, escapePattern(f.Path))
+		}
+		if matchAny(o.Brotli, f.Path) {
+			fs.Delete(f.Path + ".br")
+		}
+	}
+	if len(enc) > 0 {
+		o.Encrypt = append(enc, o.Encrypt...)
+	}
+
+	return StuffFSWithOpt(in, out, o, fs)
+}
+
+// escapePattern escapes the glob metacharacters in a path
+// so that it's matched literally as a pattern.
+func escapePattern(p string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`).Replace(p)
+}
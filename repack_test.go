@@ -0,0 +1,77 @@
+package stuffbin
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestRepack(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		bin = filepath.Join(dir, "app")
+		out = filepath.Join(dir, "app.repacked")
+	)
+	o := StuffOpt{
+		Brotli:   []string{"*.go"},
+		Checksum: true,
+		Meta:     map[string]string{MetaVersion: "1.0.0"},
+	}
+	_, _, err := StuffWithOpt(mockBin, bin, o, "mock/mock.go", "mock/foo.txt", "mock/bar.txt")
+	assert(t, "error stuffing", nil, err)
+	orig, err := UnStuff(bin)
+	assert(t, "error unstuffing", nil, err)
+
+	_, _, err = Repack(bin, out, CodecZstd)
+	assert(t, "error repacking", nil, err)
+
+	id, err := GetFileID(out)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID codec", CodecZstd, id.Codec)
+	assert(t, "ID meta", "1.0.0", id.Extra().Meta[MetaVersion])
+	assert(t, "ID checksum", FlagChecksum, id.Flags&FlagChecksum)
+
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in stuffed file paths", []string{"/mock/bar.txt", "/mock/foo.txt", "/mock/mock.go", "/mock/mock.go.br"}, f)
+	for _, p := range f {
+		b, err := fs.Read(p)
+		assert(t, "error reading file", nil, err)
+		a, _ := orig.Read(p)
+		assert(t, "mismatch in file "+p, a, b)
+	}
+
+	// Brotli copies are compressed again without duplicates.
+	_, _, err = RepackWithOpt(bin, out, StuffOpt{Brotli: []string{"*.go", "*.txt"}})
+	assert(t, "error repacking with brotli", nil, err)
+	fs, err = UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	f = fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in stuffed file paths", []string{"/mock/bar.txt", "/mock/foo.txt", "/mock/mock.go", "/mock/mock.go.br"}, f)
+}
+
+func TestRepackEncryptedFiles(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		bin = filepath.Join(dir, "app")
+		out = filepath.Join(dir, "app.repacked")
+	)
+	o := StuffOpt{Passphrase: "secret", Encrypt: []string{"/mock/foo.txt"}}
+	_, _, err := StuffWithOpt(mockBin, bin, o, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	_, _, err = Repack(bin, out, CodecZstd)
+	assert(t, "repacked encrypted files without a key", ErrNoKey, err)
+
+	_, _, err = RepackWithOpt(bin, out, StuffOpt{Codec: CodecZstd, Passphrase: "secret"})
+	assert(t, "error repacking", nil, err)
+
+	files, err := ListStuff(out, UnStuffOpt{})
+	assert(t, "error listing", nil, err)
+	for _, f := range files {
+		assert(t, "encrypted "+f.Path, f.Path == "/mock/foo.txt", f.Encrypted)
+	}
+}
@@ -0,0 +1,54 @@
+package stuffbin
+
+import (
+	"sort"
+)
+
+// minRatioSize is the size under which files are left out of
+// WorstCompressed as their ratios say little about the payload's size.
+const minRatioSize = 1024
+
+// LargestFiles returns up to n of the given files (eg: from ListStuff) with
+// the largest compressed sizes, which take up the most space in the
+// binary, sorted by their compressed sizes.
+func LargestFiles(files []Entry, n int) []Entry {
+	out := append([]Entry(nil), files...)
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].CompressedSize != out[j].CompressedSize {
+			return out[i].CompressedSize > out[j].CompressedSize
+		}
+		return out[i].Path < out[j].Path
+	})
+	return top(out, n)
+}
+
+// WorstCompressed returns up to n of the given files (eg: from ListStuff)
+// with the worst compression ratios sorted by their ratios, for instance,
+// to find files that are already compressed and should be stored with
+// StuffOpt.Store or left out. Files under 1 KB are left out.
+func WorstCompressed(files []Entry, n int) []Entry {
+	out := make([]Entry, 0, len(files))
+	for _, f := range files {
+		if f.Size >= minRatioSize {
+			out = append(out, f)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if ri, rj := out[i].Ratio(), out[j].Ratio(); ri != rj {
+			return ri > rj
+		}
+		if out[i].CompressedSize != out[j].CompressedSize {
+			return out[i].CompressedSize > out[j].CompressedSize
+		}
+		return out[i].Path < out[j].Path
+	})
+	return top(out, n)
+}
+
+// top returns up to the first n files.
+func top(files []Entry, n int) []Entry {
+	if n >= 0 && len(files) > n {
+		return files[:n]
+	}
+	return files
+}
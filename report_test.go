@@ -0,0 +1,33 @@
+package stuffbin
+
+import (
+	"testing"
+)
+
+func TestLargestFiles(t *testing.T) {
+	files := []Entry{
+		{Path: "/a.txt", Size: 10000, CompressedSize: 1000},
+		{Path: "/b.png", Size: 4000, CompressedSize: 3990},
+		{Path: "/c.js", Size: 8000, CompressedSize: 2000},
+		{Path: "/d.txt", Size: 10, CompressedSize: 12},
+		{Path: "/e.css", Size: 2000, CompressedSize: 1000},
+	}
+	paths := func(files []Entry) []string {
+		out := []string{}
+		for _, f := range files {
+			out = append(out, f.Path)
+		}
+		return out
+	}
+
+	assert(t, "mismatch in largest files", []string{"/b.png", "/c.js", "/a.txt"}, paths(LargestFiles(files, 3)))
+	assert(t, "mismatch in all largest files", 5, len(LargestFiles(files, 10)))
+
+	// Small files are left out of the worst ratios and ties are
+	// broken by the compressed size.
+	assert(t, "mismatch in worst compressed files", []string{"/b.png", "/e.css", "/c.js", "/a.txt"}, paths(WorstCompressed(files, 5)))
+	assert(t, "mismatch in worst compressed file", []string{"/b.png"}, paths(WorstCompressed(files, 1)))
+
+	// The given files aren't reordered.
+	assert(t, "files reordered", "/a.txt", files[0].Path)
+}
@@ -0,0 +1,625 @@
+package stuffbin
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+const (
+	// elfSection is the name of the ELF section that has the payload.
+	elfSection = ".stuffbin"
+
+	// peSection is the name of the PE section that has the payload.
+	// PE section names are limited to 8 bytes.
+	peSection = "stuffbin"
+
+	// machoSegment and machoSection are the names of the Mach-O
+	// segment and its section that have the payload.
+	machoSegment = "__STUFFBIN"
+	machoSection = "__stuffbin"
+
+	// lenPESectionHdr is the size of a PE section header.
+	lenPESectionHdr = 40
+
+	// lenMachoSegmentCmd is the size of a Mach-O LC_SEGMENT_64 load
+	// command with a single section_64.
+	lenMachoSegmentCmd = 72 + 80
+
+	// Mach-O load commands that are not in the debug/macho package.
+	lcCodeSignature          = 0x1d
+	lcSegmentSplitInfo       = 0x1e
+	lcDyldInfo               = 0x22
+	lcDyldInfoOnly           = 0x80000022
+	lcFunctionStarts         = 0x26
+	lcDataInCode             = 0x29
+	lcDylibCodeSignDrs       = 0x2b
+	lcLinkerOptimizationHint = 0x2e
+	lcDyldExportsTrie        = 0x80000033
+	lcDyldChainedFixups      = 0x80000034
+
+	// machoPageSize is the page size that Mach-O segments are aligned to,
+	// which is 16K on arm64.
+	machoPageSize = 0x4000
+)
+
+// section is the layout of a section that's added to a binary for its
+// payload. The section's data is the copy of the binary's original headers
+// followed by the payload and the ID.
+type section struct {
+	// offset is the offset of the payload in the file.
+	offset int64
+
+	// header is the copy of the binary's original headers that precedes
+	// the payload in the section.
+	header []byte
+
+	// patch updates the binary's headers to add the section of the given
+	// size and writes any data that follows it.
+	patch func(f *os.File, size int64) error
+}
+
+// newSection lays out a new section at the end of a binary of the given
+// size, writes the structures that precede the payload, and seeks the file
+// to the payload's offset.
+func newSection(f *os.File, binSize int64) (*section, error) {
+	var (
+		r     = io.NewSectionReader(f, 0, binSize)
+		magic = make([]byte, 4)
+	)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, errors.New("section stuffing needs an ELF, PE, or Mach-O binary")
+	}
+
+	var (
+		s   *section
+		err error
+	)
+	switch {
+	case bytes.Equal(magic, []byte(elf.ELFMAG)):
+		s, err = newELFSection(f, r, binSize)
+	case bytes.Equal(magic[:2], []byte("MZ")):
+		s, err = newPESection(f, r, binSize)
+	case binary.LittleEndian.Uint32(magic) == macho.Magic64 || binary.BigEndian.Uint32(magic) == macho.Magic64:
+		s, err = newMachoSection(f, r, binSize)
+	default:
+		return nil, errors.New("section stuffing needs an ELF, PE, or Mach-O binary")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the copy of the headers before the payload.
+	start := s.offset - int64(len(s.header))
+	if _, err := f.WriteAt(s.header, start); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// finish patches the binary's headers for the section now that
+// the size of the payload and the ID that follows it is known.
+func (s *section) finish(f *os.File, size int64) error {
+	return s.patch(f, int64(len(s.header))+size)
+}
+
+// newELFSection adds a section to an ELF binary. The section names and
+// section header table are copied with the new section added to them
+// and written after the binary followed by the section's data.
+func newELFSection(f *os.File, r *io.SectionReader, binSize int64) (*section, error) {
+	ef, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	if ef.Section(elfSection) != nil {
+		return nil, fmt.Errorf("binary already has a %s section", elfSection)
+	}
+
+	var (
+		bo   = ef.ByteOrder
+		is64 = ef.Class == elf.ELFCLASS64
+
+		// Sizes of the ELF header and a section header.
+		ehSize, shEntSize = 52, 40
+	)
+	if is64 {
+		ehSize, shEntSize = 64, 64
+	}
+
+	hdr := make([]byte, ehSize)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+
+	// getAddr and putAddr read and write address and size fields
+	// that are 8 bytes in ELF64 and 4 bytes in ELF32.
+	getAddr := func(b []byte, off64, off32 int) uint64 {
+		if is64 {
+			return bo.Uint64(b[off64:])
+		}
+		return uint64(bo.Uint32(b[off32:]))
+	}
+	putAddr := func(b []byte, off64, off32 int, v uint64) {
+		if is64 {
+			bo.PutUint64(b[off64:], v)
+		} else {
+			bo.PutUint32(b[off32:], uint32(v))
+		}
+	}
+
+	var (
+		shOff           = getAddr(hdr, 0x28, 0x20)
+		shNum, shStrNdx int
+	)
+	if is64 {
+		shNum, shStrNdx = int(bo.Uint16(hdr[0x3c:])), int(bo.Uint16(hdr[0x3e:]))
+	} else {
+		shNum, shStrNdx = int(bo.Uint16(hdr[0x30:])), int(bo.Uint16(hdr[0x32:]))
+	}
+	if shNum == 0 || shStrNdx == 0 || shStrNdx >= shNum {
+		return nil, errors.New("unsupported ELF section header table")
+	}
+
+	// Read the section headers and the section names.
+	shdrs := make([]byte, shNum*shEntSize, (shNum+1)*shEntSize)
+	if _, err := r.ReadAt(shdrs, int64(shOff)); err != nil {
+		return nil, err
+	}
+	names, err := ef.Sections[shStrNdx].Data()
+	if err != nil {
+		return nil, err
+	}
+	nameOff := len(names)
+	names = append(names, elfSection+"\x00"...)
+
+	// Names, section headers, and the section.
+	var (
+		strOff = alignOffset(binSize, 8)
+		tblOff = alignOffset(strOff+int64(len(names)), 8)
+		secOff = tblOff + int64((shNum+1)*shEntSize)
+	)
+	if !is64 && secOff > int64(^uint32(0)) {
+		return nil, errors.New("binary is too large for a 32 bit ELF section")
+	}
+	if _, err := f.WriteAt(names, strOff); err != nil {
+		return nil, err
+	}
+
+	return &section{
+		offset: secOff + int64(ehSize),
+		header: hdr,
+		patch: func(f *os.File, size int64) error {
+			// Point the names section to the new names.
+			sh := shdrs[shStrNdx*shEntSize:]
+			putAddr(sh, 24, 16, uint64(strOff))
+			putAddr(sh, 32, 20, uint64(len(names)))
+
+			// Add the new section.
+			sh = make([]byte, shEntSize)
+			bo.PutUint32(sh[0:], uint32(nameOff))
+			bo.PutUint32(sh[4:], uint32(elf.SHT_PROGBITS))
+			putAddr(sh, 24, 16, uint64(secOff))
+			putAddr(sh, 32, 20, uint64(size))
+			putAddr(sh, 48, 32, 1)
+			if _, err := f.WriteAt(append(shdrs, sh...), tblOff); err != nil {
+				return err
+			}
+
+			// Point the ELF header to the new section header table.
+			h := append([]byte{}, hdr...)
+			putAddr(h, 0x28, 0x20, uint64(tblOff))
+			if is64 {
+				bo.PutUint16(h[0x3c:], uint16(shNum+1))
+			} else {
+				bo.PutUint16(h[0x30:], uint16(shNum+1))
+			}
+			_, err := f.WriteAt(h, 0)
+			return err
+		},
+	}, nil
+}
+
+// newPESection adds a section to a PE binary. The new section's header
+// is written to the free space after the existing section headers and
+// its data is written after the binary aligned to the file alignment.
+func newPESection(f *os.File, r *io.SectionReader, binSize int64) (*section, error) {
+	pf, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	if pf.Section(peSection) != nil {
+		return nil, fmt.Errorf("binary already has a %s section", peSection)
+	}
+
+	var secAlign, fileAlign, hdrSize uint32
+	switch oh := pf.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		secAlign, fileAlign, hdrSize = oh.SectionAlignment, oh.FileAlignment, oh.SizeOfHeaders
+	case *pe.OptionalHeader64:
+		secAlign, fileAlign, hdrSize = oh.SectionAlignment, oh.FileAlignment, oh.SizeOfHeaders
+	default:
+		return nil, errors.New("PE binary has no optional header")
+	}
+	if secAlign == 0 || fileAlign == 0 {
+		return nil, errors.New("invalid PE section alignment")
+	}
+
+	hdr := make([]byte, hdrSize)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+
+	// Offsets of the COFF header, the optional header, and the new section header.
+	var (
+		coffOff = int64(binary.LittleEndian.Uint32(hdr[0x3c:])) + 4
+		optOff  = coffOff + 20
+		shOff   = optOff + int64(pf.SizeOfOptionalHeader) + int64(len(pf.Sections))*lenPESectionHdr
+		shEnd   = shOff + lenPESectionHdr
+		vEnd    uint32
+	)
+
+	// There should be free space for the new section header
+	// before the first section's data.
+	if shEnd > int64(hdrSize) {
+		return nil, errors.New("no room for a new section header in the PE binary")
+	}
+	for _, s := range pf.Sections {
+		if s.Size > 0 && shEnd > int64(s.Offset) {
+			return nil, errors.New("no room for a new section header in the PE binary")
+		}
+		if e := alignUint32(s.VirtualAddress+s.VirtualSize, secAlign); e > vEnd {
+			vEnd = e
+		}
+	}
+	if !bytes.Equal(hdr[shOff:shEnd], make([]byte, lenPESectionHdr)) {
+		return nil, errors.New("no room for a new section header in the PE binary")
+	}
+
+	secOff := alignOffset(binSize, int64(fileAlign))
+	if secOff > int64(^uint32(0)) {
+		return nil, errors.New("binary is too large for a PE section")
+	}
+
+	return &section{
+		offset: secOff + int64(len(hdr)),
+		header: hdr,
+		patch: func(f *os.File, size int64) error {
+			rawSize := alignOffset(size, int64(fileAlign))
+			if secOff+rawSize > int64(^uint32(0)) {
+				return errors.New("payload is too large for a PE section")
+			}
+
+			h := append([]byte{}, hdr...)
+			sh := h[shOff:shEnd]
+			copy(sh[0:8], peSection)
+			binary.LittleEndian.PutUint32(sh[8:], uint32(size))
+			binary.LittleEndian.PutUint32(sh[12:], vEnd)
+			binary.LittleEndian.PutUint32(sh[16:], uint32(rawSize))
+			binary.LittleEndian.PutUint32(sh[20:], uint32(secOff))
+
+			// IMAGE_SCN_CNT_INITIALIZED_DATA | IMAGE_SCN_MEM_READ.
+			binary.LittleEndian.PutUint32(sh[36:], 0x40000040)
+
+			// NumberOfSections and SizeOfImage.
+			binary.LittleEndian.PutUint16(h[coffOff+2:], uint16(len(pf.Sections)+1))
+			binary.LittleEndian.PutUint32(h[optOff+56:], alignUint32(vEnd+uint32(size), secAlign))
+			if _, err := f.WriteAt(h, 0); err != nil {
+				return err
+			}
+
+			// Pad the section's data to the file alignment.
+			_, err := f.Write(make([]byte, rawSize-size))
+			return err
+		},
+	}, nil
+}
+
+// newMachoSection adds a segment with a single section to a 64 bit Mach-O
+// binary. codesign requires __LINKEDIT to be the last segment and to cover
+// the end of the file. So, the new segment's load command is inserted before
+// __LINKEDIT's in the free space after the load commands, a copy of
+// __LINKEDIT is written after the section's data, and the file offsets that
+// point into it are moved. An existing code signature is dropped as
+// stuffing invalidates it. The binary should be re-signed after stuffing
+// (see Codesign).
+func newMachoSection(f *os.File, r *io.SectionReader, binSize int64) (*section, error) {
+	mf, err := macho.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	if mf.Segment(machoSegment) != nil {
+		return nil, fmt.Errorf("binary already has a %s segment", machoSegment)
+	}
+
+	le := mf.Segment("__LINKEDIT")
+	if le == nil || le.Offset+le.Filesz != uint64(binSize) {
+		return nil, errors.New("Mach-O binary doesn't end with a __LINKEDIT segment")
+	}
+
+	var (
+		bo     = mf.ByteOrder
+		cmdOff = int64(32 + mf.Cmdsz)
+		cmdEnd = cmdOff + lenMachoSegmentCmd
+	)
+
+	// There should be free space for the new load command
+	// before the first section's data.
+	for _, s := range mf.Sections {
+		if s.Offset > 0 && s.Size > 0 && cmdEnd > int64(s.Offset) {
+			return nil, errors.New("no room for a new load command in the Mach-O binary. Link it with a bigger -headerpad")
+		}
+	}
+
+	hdr := make([]byte, cmdEnd)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[cmdOff:cmdEnd], make([]byte, lenMachoSegmentCmd)) {
+		return nil, errors.New("no room for a new load command in the Mach-O binary. Link it with a bigger -headerpad")
+	}
+
+	// The signature is at the end of __LINKEDIT and is dropped.
+	leSize := int64(le.Filesz)
+	for _, l := range mf.Loads {
+		raw := l.Raw()
+		if bo.Uint32(raw) == lcCodeSignature && int64(bo.Uint32(raw[8:])+bo.Uint32(raw[12:])) == binSize {
+			leSize -= int64(bo.Uint32(raw[12:]))
+		}
+	}
+
+	secOff := alignOffset(binSize, machoPageSize)
+	return &section{
+		offset: secOff + int64(len(hdr)),
+		header: hdr,
+		patch: func(f *os.File, size int64) error {
+			var (
+				leOff = alignOffset(secOff+size, machoPageSize)
+				delta = leOff - int64(le.Offset)
+				vmEnd = le.Addr + uint64(alignOffset(size, machoPageSize))
+			)
+			if leOff+leSize > int64(^uint32(0)) {
+				return errors.New("payload is too large for a Mach-O section")
+			}
+
+			// shift moves a 32 bit file offset into __LINKEDIT.
+			shift := func(b []byte, offsets ...int) {
+				for _, o := range offsets {
+					if v := bo.Uint32(b[o:]); v != 0 {
+						bo.PutUint32(b[o:], uint32(int64(v)+delta))
+					}
+				}
+			}
+
+			var (
+				cmds []byte
+				n    uint32
+			)
+			for _, l := range mf.Loads {
+				raw := append([]byte{}, l.Raw()...)
+
+				switch bo.Uint32(raw) {
+				case lcCodeSignature:
+					continue
+
+				case uint32(macho.LoadCmdSegment64):
+					if s, ok := l.(*macho.Segment); !ok || s.Name != "__LINKEDIT" {
+						break
+					}
+
+					// LC_SEGMENT_64 with read-only protection and a section_64.
+					seg := make([]byte, lenMachoSegmentCmd)
+					bo.PutUint32(seg[0:], uint32(macho.LoadCmdSegment64))
+					bo.PutUint32(seg[4:], lenMachoSegmentCmd)
+					copy(seg[8:24], machoSegment)
+					bo.PutUint64(seg[24:], le.Addr)
+					bo.PutUint64(seg[32:], vmEnd-le.Addr)
+					bo.PutUint64(seg[40:], uint64(secOff))
+					bo.PutUint64(seg[48:], uint64(size))
+					bo.PutUint32(seg[56:], 1)
+					bo.PutUint32(seg[60:], 1)
+					bo.PutUint32(seg[64:], 1)
+
+					sec := seg[72:]
+					copy(sec[0:16], machoSection)
+					copy(sec[16:32], machoSegment)
+					bo.PutUint64(sec[32:], le.Addr)
+					bo.PutUint64(sec[40:], uint64(size))
+					bo.PutUint32(sec[48:], uint32(secOff))
+
+					cmds = append(cmds, seg...)
+					n++
+
+					// Move __LINKEDIT after the new segment.
+					bo.PutUint64(raw[24:], vmEnd)
+					bo.PutUint64(raw[40:], uint64(leOff))
+					bo.PutUint64(raw[48:], uint64(leSize))
+
+				case uint32(macho.LoadCmdSymtab):
+					shift(raw, 8, 16)
+
+				case uint32(macho.LoadCmdDysymtab):
+					shift(raw, 32, 40, 48, 56, 64, 72)
+
+				case lcDyldInfo, lcDyldInfoOnly:
+					shift(raw, 8, 16, 24, 32, 40)
+
+				case lcSegmentSplitInfo, lcFunctionStarts, lcDataInCode, lcDylibCodeSignDrs,
+					lcLinkerOptimizationHint, lcDyldExportsTrie, lcDyldChainedFixups:
+					shift(raw, 8)
+				}
+
+				cmds = append(cmds, raw...)
+				n++
+			}
+
+			h := make([]byte, len(hdr))
+			copy(h, hdr[:32])
+			copy(h[32:], cmds)
+			bo.PutUint32(h[16:], n)
+			bo.PutUint32(h[20:], uint32(len(cmds)))
+			if _, err := f.WriteAt(h, 0); err != nil {
+				return err
+			}
+
+			// Pad the section's data to the page size and copy __LINKEDIT after it.
+			if _, err := f.Write(make([]byte, leOff-secOff-size)); err != nil {
+				return err
+			}
+			_, err := io.Copy(f, io.NewSectionReader(f, int64(le.Offset), leSize))
+			return err
+		},
+	}, nil
+}
+
+// Codesign returns a StuffOpt.PostStuff function that re-signs a stuffed
+// macOS binary using the codesign tool with the given identity and optional
+// arguments (eg: --options runtime --timestamp for notarization). The
+// identity - signs the binary ad-hoc, which is the least that binaries on
+// Apple silicon need to run. The binary should be stuffed with
+// StuffOpt.Section as codesign doesn't accept appended data.
+func Codesign(identity string, args ...string) func(path string) error {
+	return func(path string) error {
+		a := append([]string{"--force", "--sign", identity}, args...)
+		out, err := exec.Command("codesign", append(a, path)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("codesign failed: %v: %s", err, bytes.TrimSpace(out))
+		}
+		return nil
+	}
+}
+
+// readEmbeddedID reads the ID at the end of the payload section of an ELF,
+// PE, or Mach-O binary, or the ID that precedes the Authenticode signature
+// of a PE binary that was signed after it was stuffed.
+func readEmbeddedID(r io.ReaderAt) (ID, error) {
+	if off, size, ok := findSection(r); ok {
+		return readID(io.NewSectionReader(r, off, size), size)
+	}
+
+	// The signature is aligned to 8 bytes with zeroes after the ID.
+	if off, _, ok := peSignature(r); ok {
+		for pad := int64(0); pad < 8 && off-pad > 0; pad++ {
+			if id, err := readID(r, off-pad); err != ErrNoID {
+				return id, err
+			}
+		}
+	}
+
+	return ID{}, ErrNoID
+}
+
+// peSignature returns the offset and the size of the Authenticode
+// signature (certificate table) of a PE binary, if it has one.
+func peSignature(r io.ReaderAt) (int64, int64, bool) {
+	pf, err := pe.NewFile(r)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	var d pe.DataDirectory
+	switch oh := pf.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if oh.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+			d = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+		}
+	case *pe.OptionalHeader64:
+		if oh.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+			d = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+		}
+	}
+	if d.VirtualAddress == 0 || d.Size == 0 {
+		return 0, 0, false
+	}
+
+	// The directory's address is a file offset and not an RVA.
+	return int64(d.VirtualAddress), int64(d.Size), true
+}
+
+// findSection returns the offset and the size of the payload section
+// in an ELF, PE, or Mach-O binary, if there's one.
+func findSection(r io.ReaderAt) (int64, int64, bool) {
+	if f, err := elf.NewFile(r); err == nil {
+		if s := f.Section(elfSection); s != nil {
+			return int64(s.Offset), int64(s.Size), true
+		}
+		return 0, 0, false
+	}
+
+	// The data in the section is padded to the file alignment.
+	// VirtualSize is the actual size.
+	if f, err := pe.NewFile(r); err == nil {
+		if s := f.Section(peSection); s != nil {
+			return int64(s.Offset), int64(s.VirtualSize), true
+		}
+		return 0, 0, false
+	}
+
+	if f, err := macho.NewFile(r); err == nil {
+		if s := f.Section(machoSection); s != nil && s.Seg == machoSegment {
+			return int64(s.Offset), int64(s.Size), true
+		}
+	}
+
+	return 0, 0, false
+}
+
+// readHeaders reads the copy of the original headers of a binary
+// stuffed into a section.
+func readHeaders(r io.ReaderAt, id ID) ([]byte, error) {
+	if uint64(id.HeaderSize) > id.Offset {
+		return nil, fmt.Errorf("invalid header size %d at %d", id.HeaderSize, id.Offset)
+	}
+
+	b := make([]byte, id.HeaderSize)
+	if _, err := r.ReadAt(b, int64(id.Offset)-int64(id.HeaderSize)); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// clearPESignature clears the Authenticode signature's
+// data directory entry in a PE binary.
+func clearPESignature(f interface {
+	io.ReaderAt
+	io.WriterAt
+}) error {
+	var b [4]byte
+	if _, err := f.ReadAt(b[:], 0x3c); err != nil {
+		return err
+	}
+
+	// The data directories are after the PE signature (4), the COFF header (20),
+	// and the fields of the optional header (96 in PE32 and 112 in PE32+).
+	optOff := int64(binary.LittleEndian.Uint32(b[:])) + 24
+	if _, err := f.ReadAt(b[:2], optOff); err != nil {
+		return err
+	}
+	dirOff := optOff + 96
+	if binary.LittleEndian.Uint16(b[:2]) == 0x20b {
+		dirOff = optOff + 112
+	}
+
+	_, err := f.WriteAt(make([]byte, 8), dirOff+pe.IMAGE_DIRECTORY_ENTRY_SECURITY*8)
+	return err
+}
+
+// alignOffset rounds n up to a multiple of a.
+func alignOffset(n, a int64) int64 {
+	return (n + a - 1) / a * a
+}
+
+// alignUint32 rounds n up to a multiple of a.
+func alignUint32(n, a uint32) uint32 {
+	return (n + a - 1) / a * a
+}
@@ -0,0 +1,186 @@
+package stuffbin
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/pe"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestStuffSection(t *testing.T) {
+	// The test binary is a real ELF, PE, or Mach-O executable.
+	exe, err := os.Executable()
+	assert(t, "error getting executable", nil, err)
+
+	var (
+		dir = t.TempDir()
+		out = filepath.Join(dir, "stuffed")
+		raw = filepath.Join(dir, "stripped")
+	)
+	binSize, _, err := StuffWithOpt(exe, out, StuffOpt{Section: true}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	id, err := GetFileID(out)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID flags", FlagSection, id.Flags)
+	assert(t, "ID bin size", binSize, id.BinSize)
+
+	_, _, ok := findSection(mustOpen(t, out))
+	assert(t, "section not found", true, ok)
+
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in unstuffed file paths", stuffedFiles, f)
+
+	// The stuffed binary should remain a valid binary with its sections intact.
+	if orig, err := elf.Open(exe); err == nil {
+		defer orig.Close()
+		ef, err := elf.Open(out)
+		assert(t, "error reading stuffed ELF", nil, err)
+		defer ef.Close()
+		assert(t, "section count", len(orig.Sections)+1, len(ef.Sections))
+		for n, s := range orig.Sections {
+			// The section names are moved to add the new name.
+			if s.Name == ".shstrtab" {
+				continue
+			}
+			assert(t, "mismatch in section", s.SectionHeader, ef.Sections[n].SectionHeader)
+		}
+		assert(t, "mismatch in program headers", len(orig.Progs), len(ef.Progs))
+	}
+
+	// The ID should be found in the section even if data follows it.
+	b, err := os.ReadFile(out)
+	assert(t, "error reading file", nil, err)
+	assert(t, "error writing file", nil, os.WriteFile(raw, append(b, []byte("signature")...), 0755))
+	fs, err = UnStuff(raw)
+	assert(t, "error unstuffing with trailing data", nil, err)
+	assert(t, "file count", len(stuffedFiles), fs.Len())
+
+	// Restuffing in-place should replace the section and stripping
+	// should restore the original binary.
+	_, _, err = StuffWithOpt(out, out, StuffOpt{Section: true}, "mock/bar.txt")
+	assert(t, "error restuffing", nil, err)
+	fs, err = UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "mismatch in restuffed file paths", []string{"/mock/bar.txt"}, fs.List())
+
+	_, err = Strip(out, raw)
+	assert(t, "error stripping", nil, err)
+	orig, err := os.ReadFile(exe)
+	assert(t, "error reading file", nil, err)
+	stripped, err := os.ReadFile(raw)
+	assert(t, "error reading file", nil, err)
+	assert(t, "stripped binary doesn't match the original", true, bytes.Equal(orig, stripped))
+
+	// Arbitrary files can't have sections.
+	_, _, err = StuffWithOpt(mockBin, out, StuffOpt{Section: true}, localFiles...)
+	assert(t, "expected error on unknown format", true, err != nil)
+}
+
+func mustOpen(t *testing.T, path string) *os.File {
+	f, err := os.Open(path)
+	assert(t, "error opening file", nil, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestStuffPostStuff(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "stuffed")
+
+	// The hook should get the complete stuffed binary.
+	var called string
+	_, _, err := StuffWithOpt(mockBin, out, StuffOpt{PostStuff: func(path string) error {
+		called = path
+		_, err := UnStuff(path)
+		return err
+	}}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+	assert(t, "hook not called", out, called)
+
+	_, _, err = StuffWithOpt(mockBin, out, StuffOpt{PostStuff: func(string) error {
+		return os.ErrPermission
+	}}, localFiles...)
+	assert(t, "expected hook error", os.ErrPermission, err)
+}
+
+func TestStuffSignedPE(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		bin    = filepath.Join(dir, "app.exe")
+		out    = filepath.Join(dir, "stuffed.exe")
+		signed = filepath.Join(dir, "signed.exe")
+	)
+	assert(t, "error writing file", nil, os.WriteFile(bin, makePE(t), 0755))
+
+	for _, o := range []StuffOpt{{}, {Section: true}} {
+		_, _, err := StuffWithOpt(bin, out, o, localFiles...)
+		assert(t, "error stuffing", nil, err)
+
+		// Sign the binary after stuffing. The ID should be found before the signature.
+		b, err := os.ReadFile(out)
+		assert(t, "error reading file", nil, err)
+		b = signPE(b)
+		assert(t, "error writing file", nil, os.WriteFile(signed, b, 0755))
+
+		fs, err := UnStuff(signed)
+		assert(t, "error unstuffing signed binary", nil, err)
+		assert(t, "file count", len(stuffedFiles), fs.Len())
+
+		// Restuffing should replace the signature.
+		_, _, err = StuffWithOpt(signed, out, o, "mock/bar.txt")
+		assert(t, "error restuffing signed binary", nil, err)
+		_, _, ok := peSignature(mustOpen(t, out))
+		assert(t, "restuffed binary should not be signed", false, ok)
+	}
+
+	// Signed binaries that aren't stuffed can't be stuffed.
+	b, err := os.ReadFile(bin)
+	assert(t, "error reading file", nil, err)
+	assert(t, "error writing file", nil, os.WriteFile(signed, signPE(b), 0755))
+	_, _, err = Stuff(signed, out, "/", localFiles...)
+	assert(t, "expected ErrSigned", ErrSigned, err)
+}
+
+// makePE returns a minimal PE32+ binary without sections.
+func makePE(t *testing.T) []byte {
+	b := &bytes.Buffer{}
+	b.Write([]byte("MZ"))
+	b.Write(make([]byte, 0x3a))
+	_ = binary.Write(b, binary.LittleEndian, uint32(0x40))
+	b.Write([]byte("PE\x00\x00"))
+
+	oh := pe.OptionalHeader64{
+		Magic:               0x20b,
+		SectionAlignment:    0x1000,
+		FileAlignment:       0x200,
+		SizeOfHeaders:       0x200,
+		SizeOfImage:         0x1000,
+		NumberOfRvaAndSizes: 16,
+	}
+	fh := pe.FileHeader{Machine: pe.IMAGE_FILE_MACHINE_AMD64, SizeOfOptionalHeader: uint16(binary.Size(oh))}
+	assert(t, "error writing PE", nil, binary.Write(b, binary.LittleEndian, fh))
+	assert(t, "error writing PE", nil, binary.Write(b, binary.LittleEndian, oh))
+	b.Write(make([]byte, 0x200-b.Len()))
+	return b.Bytes()
+}
+
+// signPE appends a fake Authenticode certificate table
+// aligned to 8 bytes to a PE binary made by makePE.
+func signPE(b []byte) []byte {
+	b = append(b, make([]byte, (8-len(b)%8)%8)...)
+	cert := append([]byte{16, 0, 0, 0, 0, 2, 2, 0}, "signatur"...)
+
+	// The security directory is the 5th data directory after
+	// the PE signature, COFF header, and 112 bytes of the optional header.
+	d := 0x40 + 4 + 20 + 112 + 4*8
+	binary.LittleEndian.PutUint32(b[d:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[d+4:], uint32(len(cert)))
+	return append(b, cert...)
+}
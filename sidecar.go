@@ -0,0 +1,71 @@
+package stuffbin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SidecarExt is the extension that's appended to the name of a binary
+// to get the name of its sidecar payload file (eg: app.stuff).
+const SidecarExt = ".stuff"
+
+// sidecarPath returns the path of the sidecar of the binary at the given path.
+func sidecarPath(bin string) string {
+	return bin + SidecarExt
+}
+
+// writeSidecar writes the payload with the given function (see stuffBinary)
+// to a temporary file next to the binary at out, and the ID of the payload
+// with a reference to the sidecar to w. The payload's ID is moved from the
+// end of the temporary file to w. It returns the temporary file, which
+// should replace the sidecar after the binary is written, the size of the
+// payload, and the number of bytes written to w.
+func writeSidecar(w io.Writer, out string, binSize int64, sec *section, write func(w io.Writer, binSize int64, sec *section) (int64, int64, error)) (*os.File, int64, int64, error) {
+	sc := sidecarPath(out)
+	f, err := os.CreateTemp(filepath.Dir(sc), "."+filepath.Base(sc)+".*")
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		return f, 0, 0, err
+	}
+
+	zLen, n, err := write(f, binSize, nil)
+	if err != nil {
+		return f, 0, 0, err
+	}
+
+	id, err := readID(f, n)
+	if err != nil {
+		return f, 0, 0, err
+	}
+	if err := f.Truncate(zLen); err != nil {
+		return f, 0, 0, err
+	}
+
+	id.Version = idVersion2
+	id.Flags |= FlagSidecar
+	id.Sidecar = filepath.Base(sc)
+	m, err := writeID(w, id, binSize, sec)
+	if err != nil {
+		return f, 0, 0, err
+	}
+
+	return f, zLen, m, nil
+}
+
+// openSidecar opens the sidecar of the binary with the given ID in dir.
+func openSidecar(id ID, dir string) (*os.File, error) {
+	// The name should be a file in the directory.
+	if id.Sidecar == "" || id.Sidecar != filepath.Base(id.Sidecar) || id.Sidecar == ".." {
+		return nil, fmt.Errorf("invalid sidecar '%s' in ID", id.Sidecar)
+	}
+
+	f, err := os.Open(filepath.Join(dir, id.Sidecar))
+	if err != nil {
+		return nil, fmt.Errorf("error opening the sidecar payload: %v", err)
+	}
+	return f, nil
+}
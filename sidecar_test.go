@@ -0,0 +1,99 @@
+package stuffbin
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestStuffSidecar(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		out = filepath.Join(dir, "app.exe")
+		sc  = out + SidecarExt
+	)
+	binSize, zLen, err := StuffWithOpt(mockBin, out, StuffOpt{Sidecar: true, Checksum: true}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	// The binary only has the ID and the sidecar has the payload.
+	id, err := GetFileID(out)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID flags", FlagSidecar|FlagChecksum, id.Flags)
+	assert(t, "ID sidecar", "app.exe.stuff", id.Sidecar)
+	assert(t, "ID bin size", uint64(binSize), id.BinSize)
+	assert(t, "ID zip size", uint64(zLen), id.ZipSize)
+
+	st, err := os.Stat(sc)
+	assert(t, "error reading sidecar", nil, err)
+	assert(t, "sidecar size", zLen, st.Size())
+	st, err = os.Stat(out)
+	assert(t, "error reading binary", nil, err)
+	assert(t, "binary size", binSize+int64(len(makeIDBytes(id))), st.Size())
+
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in unstuffed file paths", stuffedFiles, f)
+
+	// The sidecar is found next to the binary or in SidecarDir.
+	b, err := os.ReadFile(out)
+	assert(t, "error reading file", nil, err)
+	_, err = UnStuffFrom(bytes.NewReader(b), int64(len(b)))
+	assert(t, "expected error without the sidecar", true, err != nil)
+	fs, err = UnStuffFromWithOpt(bytes.NewReader(b), int64(len(b)), UnStuffOpt{SidecarDir: dir})
+	assert(t, "error unstuffing with SidecarDir", nil, err)
+	assert(t, "file count", len(stuffedFiles), fs.Len())
+
+	// Incremental stuffing reuses the sidecar.
+	_, _, err = StuffWithOpt(out, out, StuffOpt{Sidecar: true, Incremental: true}, localFiles...)
+	assert(t, "error restuffing", nil, err)
+	st, err = os.Stat(out)
+	assert(t, "error reading binary", nil, err)
+	assert(t, "restuffed binary size", binSize+int64(lenIDBody+lenIDFooter+len("app.exe.stuff")), st.Size())
+
+	// Sidecars without a checksum can be replaced.
+	p, err := zipFiles(StuffOpt{}, "mock/bar.txt")
+	assert(t, "error zipping", nil, err)
+	assert(t, "error writing sidecar", nil, os.WriteFile(sc, p.Bytes(), 0644))
+	fs, err = UnStuff(out)
+	assert(t, "error unstuffing replaced sidecar", nil, err)
+	assert(t, "mismatch in replaced file paths", []string{"/mock/bar.txt"}, fs.List())
+
+	assert(t, "error removing sidecar", nil, os.Remove(sc))
+	_, err = UnStuff(out)
+	assert(t, "expected error on missing sidecar", true, err != nil)
+
+	_, _, err = StuffToWithOpt(&bytes.Buffer{}, bytes.NewReader(b), StuffOpt{Sidecar: true}, nil)
+	assert(t, "expected error with StuffTo", true, err != nil)
+}
+
+func TestStuffSidecarSection(t *testing.T) {
+	exe, err := os.Executable()
+	assert(t, "error getting executable", nil, err)
+
+	out := filepath.Join(t.TempDir(), "stuffed")
+	_, _, err = StuffWithOpt(exe, out, StuffOpt{Section: true, Sidecar: true}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	// The ID is in the section and the payload is in the sidecar.
+	id, err := GetFileID(out)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID flags", FlagSection|FlagSidecar, id.Flags)
+
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "file count", len(stuffedFiles), fs.Len())
+
+	// Stripping restores the original binary.
+	raw := out + ".stripped"
+	_, err = Strip(out, raw)
+	assert(t, "error stripping", nil, err)
+	orig, err := os.ReadFile(exe)
+	assert(t, "error reading file", nil, err)
+	stripped, err := os.ReadFile(raw)
+	assert(t, "error reading file", nil, err)
+	assert(t, "stripped binary doesn't match the original", true, bytes.Equal(orig, stripped))
+}
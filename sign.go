@@ -0,0 +1,126 @@
+package stuffbin
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SignStuff writes a copy of a stuffed binary whose payload is signed with
+// the given Ed25519 private key to out (see StuffOpt.SigningKey) without
+// re-compressing it, for instance, to sign release binaries in a separate
+// step after they're built. Existing signatures are replaced. The payload's
+// checksum, if any, is verified first. It returns the size of the binary
+// and the payload.
+func SignStuff(in, out string, key ed25519.PrivateKey) (int64, int64, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return 0, 0, fmt.Errorf("invalid Ed25519 private key size %d", len(key))
+	}
+
+	id, b, err := getStored(in)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := verifyChecksum(id, b); err != nil {
+		return 0, 0, err
+	}
+
+	digest := sha512.Sum512(b)
+	sig, err := key.Sign(nil, digest[:], &ed25519.Options{Hash: crypto.SHA512})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	id.Version = idVersion2
+	id.Flags = id.Flags&^FlagHMAC | FlagEd25519
+	id.signature = string(sig)
+
+	return stuffStored(in, out, id, b)
+}
+
+// VerifySignature verifies the Ed25519 signature of the payload of a
+// stuffed binary with the given public key without reading the files in
+// it, so encrypted payloads don't need the key. It returns ErrSignature if
+// the payload isn't signed or the signature is invalid.
+func VerifySignature(in string, pub ed25519.PublicKey) error {
+	id, b, err := getStored(in)
+	if err != nil {
+		return err
+	}
+	return verifyEd25519(id, b, pub)
+}
+
+// LoadSigningKey reads an Ed25519 private key from a PEM (PKCS #8, eg: from
+// openssl genpkey -algorithm ed25519), hex, or raw file with the key or its
+// seed.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	b, err := readKeyFile(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	if k, err := x509.ParsePKCS8PrivateKey(b); err == nil {
+		if k, ok := k.(ed25519.PrivateKey); ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("%s: not an Ed25519 private key", path)
+	}
+
+	switch len(b) {
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	}
+	return nil, fmt.Errorf("%s: invalid Ed25519 private key", path)
+}
+
+// LoadPublicKey reads an Ed25519 public key from a PEM (PKIX),
+// hex, or raw file.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	b, err := readKeyFile(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	if k, err := x509.ParsePKIXPublicKey(b); err == nil {
+		if k, ok := k.(ed25519.PublicKey); ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("%s: not an Ed25519 public key", path)
+	}
+
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s: invalid Ed25519 public key", path)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// readKeyFile reads a key file and returns the DER bytes of the PEM block
+// of the given type, or the hex decoded or raw contents of the file. $VARS
+// and ~ in the path are expanded (see ExpandPath).
+func readKeyFile(path, typ string) ([]byte, error) {
+	p, err := ExpandPath(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if blk, _ := pem.Decode(b); blk != nil {
+		if blk.Type != typ {
+			return nil, fmt.Errorf("%s: unexpected PEM block '%s'. Should be '%s'", path, blk.Type, typ)
+		}
+		return blk.Bytes, nil
+	}
+	if h, err := hex.DecodeString(strings.TrimSpace(string(b))); err == nil {
+		return h, nil
+	}
+	return b, nil
+}
@@ -0,0 +1,42 @@
+package stuffbin
+
+import (
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignStuff(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert(t, "error generating key", nil, err)
+
+	for _, o := range []StuffOpt{{Checksum: true}, {Codec: CodecZstd, HMACKey: []byte("x")}, {Sidecar: true}, {Passphrase: "secret"}} {
+		var (
+			dir = t.TempDir()
+			bin = filepath.Join(dir, "app")
+		)
+		_, _, err := StuffWithOpt(mockBin, bin, o, localFiles...)
+		assert(t, "error stuffing", nil, err)
+		assert(t, "unsigned binary verified", ErrSignature, VerifySignature(bin, pub))
+
+		_, _, err = SignStuff(bin, bin, priv)
+		assert(t, "error signing", nil, err)
+		assert(t, "error verifying signature", nil, VerifySignature(bin, pub))
+
+		id, err := GetFileID(bin)
+		assert(t, "error getting file ID", nil, err)
+		assert(t, "ID flags", FlagEd25519, id.Flags&(FlagEd25519|FlagHMAC))
+		assert(t, "ID codec", o.Codec, id.Codec)
+
+		fs, err := UnStuffWithOpt(bin, UnStuffOpt{PublicKey: pub, Key: o.key()})
+		assert(t, "error unstuffing signed binary", nil, err)
+		assert(t, "mismatch in file count", 2, fs.Len())
+
+		other, _, err := ed25519.GenerateKey(nil)
+		assert(t, "error generating key", nil, err)
+		assert(t, "verified with another key", ErrSignature, VerifySignature(bin, other))
+	}
+
+	_, _, err = SignStuff(mockBin, filepath.Join(t.TempDir(), "app"), priv)
+	assert(t, "signed an unstuffed binary", ErrNoID, err)
+}
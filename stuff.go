@@ -3,17 +3,61 @@ package stuffbin
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
 )
 
-// lenID is the length of the byte ID that's appended to binaries.
-const lenID = 24
+const (
+	// lenID is the length of the v1 byte ID that's appended to binaries.
+	lenID = 24
+
+	// lenIDFooter is the length of the v2 ID's footer that follows
+	// its variable length body: body length (4) + name (8).
+	lenIDFooter = 12
+
+	// lenIDBody is the length of the known fields in the v2 ID's body:
+	// Version (1) + Codec (1) + Flags (2) + BinSize (8) + ZipSize (8) +
+	// Offset (8) + HeaderSize (4) + Checksum (32) + MetaSize (4) +
+	// SignatureSize (4) + Salt (16) + Nonce (8) + WrappedKeySize (4) +
+	// SidecarSize (4).
+	lenIDBody = 104
+
+	// lenIDBodyMin is the length of the fields that every v2 ID's body has.
+	// Bodies written by older versions end after ZipSize.
+	lenIDBodyMin = 20
+
+	idVersion1 = 1
+	idVersion2 = 2
+
+	// IDVersion is the latest version of the ID format (see ID.Version)
+	// that this version of the package writes and reads. Binaries with
+	// newer IDs were stuffed by a newer version of stuffbin.
+	IDVersion = idVersion2
+
+	// maxTrailer is the max size of the data following an ID
+	// that's appended after stuffing (see scanID).
+	maxTrailer = 1 << 20
+)
 
 // WalkFunc is an abstraction over filepath.WalkFunc that's used as
 // a callback to receive the real file path and their corresponding
@@ -21,18 +65,359 @@ const lenID = 24
 // file and directory paths.
 type WalkFunc func(srcPath, targetPath string, fInfo os.FileInfo) error
 
+// TransformFunc transforms a file before it's stuffed, for instance, to
+// minify it, strip comments, or inject a license header. It gets the
+// target path and the contents of the file and returns its new path and
+// contents. Files for which an empty path is returned are not stuffed.
+type TransformFunc func(path string, b []byte) (string, []byte, error)
+
 // ID represents an identifier that is appended to binaries for identifying
-// stuffbin binaries. The fields are appended as bytes totalling
-// 8 + 12 + 8 + 8 = 36 bytes in the order Name BinSize ZipSize.
+// stuffbin binaries.
+//
+// v1 IDs are 8 + 8 + 8 = 24 bytes in the order Name BinSize ZipSize.
+//
+// v2 IDs have optional variable length sidecar, wrapped key, signature, and
+// metadata blocks followed by a variable length body and a footer, in the
+// order Sidecar (SidecarSize bytes) WrappedKey (WrappedKeySize bytes)
+// Signature (SignatureSize bytes) Meta (MetaSize bytes) Version (1) Codec (1)
+// Flags (2) BinSize (8) ZipSize (8) Offset (8) HeaderSize (4) Checksum (32)
+// MetaSize (4) SignatureSize (4) Salt (16) Nonce (8) WrappedKeySize (4)
+// SidecarSize (4) followed by the body length (4) and Name (8). As the Name
+// is always at the end, new fields can be appended to the body without
+// breaking older readers. v2 IDs are written for payloads
+// that are not plain ZIP archives (eg: zstd), that are stuffed into a section,
+// that are encrypted, that are in a sidecar, or that have a checksum,
+// metadata, or a signature.
 type ID struct {
 	Name    [8]byte
 	BinSize uint64
 	ZipSize uint64
+
+	Version uint8
+	Codec   Codec
+	Flags   uint16
+
+	// Offset is the offset of the payload in the file if it's not
+	// appended right after the original binary (BinSize).
+	Offset uint64
+
+	// HeaderSize is the size of the copy of the original binary's headers
+	// that precedes the payload when it's stuffed into a section
+	// (FlagSection). The headers are restored when the binary is restuffed
+	// or stripped.
+	HeaderSize uint32
+
+	// Checksum is the SHA-256 checksum of the payload as it's stored in
+	// the file if the ID has FlagChecksum.
+	Checksum [32]byte
+
+	// Salt is the salt with which the key of a payload that's encrypted
+	// with a passphrase (FlagPassphrase) is derived.
+	Salt [16]byte
+
+	// Nonce is the random prefix of the nonces of the chunks of an
+	// encrypted payload (FlagEncrypted).
+	Nonce [8]byte
+
+	// Sidecar is the file name of the sidecar file next to the binary
+	// that has the payload if the ID has FlagSidecar.
+	Sidecar string
+
+	// meta (JSON), signature, and wrappedKey are the variable length data
+	// of the ID (see Extra). They're kept encoded as strings so that IDs
+	// remain comparable.
+	meta       string
+	signature  string
+	wrappedKey string
+}
+
+// IDExtra is the variable length data of a v2 ID (see ID.Extra).
+type IDExtra struct {
+	// Meta is optional metadata about the payload (eg: version)
+	// that's stored as a JSON object in the v2 ID.
+	Meta map[string]string
+
+	// Signature is the signature of the payload as it's stored in the
+	// file, if the ID has FlagHMAC or FlagEd25519.
+	Signature []byte
+
+	// WrappedKey is the key of a payload that's encrypted to age
+	// recipients (FlagAge), encrypted with age.
+	WrappedKey []byte
+}
+
+// Extra returns a copy of the metadata, the signature, and the wrapped
+// key of the ID.
+func (id ID) Extra() IDExtra {
+	var e IDExtra
+	if id.meta != "" {
+		// The meta is validated when it's set.
+		_ = json.Unmarshal([]byte(id.meta), &e.Meta)
+	}
+	if id.signature != "" {
+		e.Signature = []byte(id.signature)
+	}
+	if id.wrappedKey != "" {
+		e.WrappedKey = []byte(id.wrappedKey)
+	}
+	return e
+}
+
+// setMeta sets the metadata of the ID.
+func (id *ID) setMeta(m map[string]string) {
+	id.meta = ""
+	if len(m) > 0 {
+		b, _ := json.Marshal(m)
+		id.meta = string(b)
+	}
+}
+
+// FlagSection indicates that the payload is stuffed into a named section
+// (ELF, PE) or segment (Mach-O) of the binary. See StuffOpt.Section.
+const FlagSection uint16 = 1 << 0
+
+// FlagChecksum indicates that the ID has the checksum of the payload,
+// which is verified when it's read. See StuffOpt.Checksum.
+const FlagChecksum uint16 = 1 << 1
+
+// FlagHMAC indicates that the ID has an HMAC-SHA256 signature of the
+// payload. See StuffOpt.HMACKey.
+const FlagHMAC uint16 = 1 << 2
+
+// FlagEd25519 indicates that the ID has an Ed25519ph signature of the
+// payload's SHA-512 digest. See StuffOpt.SigningKey.
+const FlagEd25519 uint16 = 1 << 3
+
+// FlagEncrypted indicates that the payload is encrypted with AES-256-GCM.
+// See StuffOpt.EncryptionKey and StuffOpt.Passphrase.
+const FlagEncrypted uint16 = 1 << 4
+
+// FlagPassphrase indicates that the key of an encrypted payload is derived
+// from a passphrase with scrypt and the salt in the ID.
+const FlagPassphrase uint16 = 1 << 5
+
+// FlagAge indicates that the key of an encrypted payload is encrypted to
+// age recipients and stored in the ID. See StuffOpt.Recipients.
+const FlagAge uint16 = 1 << 6
+
+// FlagEncryptedFiles indicates that some of the files in the payload are
+// encrypted individually with AES-256-GCM. See StuffOpt.Encrypt.
+const FlagEncryptedFiles uint16 = 1 << 7
+
+// FlagSidecar indicates that the payload is in a sidecar file next to the
+// binary and the binary only has its ID. See StuffOpt.Sidecar.
+const FlagSidecar uint16 = 1 << 8
+
+// StuffOpt represents options for stuffing files.
+type StuffOpt struct {
+	// RootPath is the root path to bind all files to. Defaults to /.
+	RootPath string
+
+	// CompressionLevel is the DEFLATE compression level from
+	// flate.HuffmanOnly (-2) to flate.BestCompression (9). 0 uses
+	// flate.DefaultCompression. To disable compression, use Store.
+	CompressionLevel int
+
+	// Store is an optional list of glob patterns (eg: *.png) matched
+	// against file names and target paths. Matching files are stored
+	// without compression, which is useful for already compressed files.
+	Store []string
+
+	// Brotli is an optional list of glob patterns (eg: *.css, *.js) matched
+	// against file names and target paths. For every matching file, a
+	// brotli compressed copy is added as path.br (eg: /static/app.css.br)
+	// if it is smaller than the original. WithPrecompressed() serves these
+	// to HTTP clients that accept brotli.
+	Brotli []string
+
+	// Dictionary is an optional list of glob patterns (eg: *.html,
+	// *.json) matched against file names and target paths like Brotli.
+	// Matching files are compressed individually with Zstandard and a
+	// dictionary that's trained on them (see TrainDictionary) and stored in
+	// the payload, so that many small files that have a lot in common, for
+	// instance, templates or locale strings, compress much better than with
+	// DEFLATE. Other ZIP tools can't extract these files. It only applies to
+	// CodecZip.
+	Dictionary []string
+
+	// DictionaryData is an optional dictionary from TrainDictionary that
+	// files matching Dictionary are compressed with instead of training one,
+	// for instance, to share a dictionary across releases. Dictionaries in
+	// the zstd format (eg: zstd --train) are supported too.
+	DictionaryData []byte
+
+	// Exclude is an optional list of glob patterns (eg: **/*.map,
+	// **/.DS_Store, node_modules/**) of local files and directories to
+	// skip. See NewLocalFSWithOpt.
+	Exclude []string
+
+	// SkipHidden skips dotfiles and dot-directories (eg: .git, .DS_Store)
+	// in directories that are walked. Paths that are listed explicitly
+	// are always stuffed.
+	SkipHidden bool
+
+	// NoRecursive only stuffs the files directly in the directories that
+	// are given, skipping their subdirectories.
+	NoRecursive bool
+
+	// RespectGitignore skips the files in directories that are walked (and
+	// that match glob patterns) that git ignores as per the .gitignore files
+	// of their working tree and its .git/info/exclude, and .git directories.
+	// Paths that are listed explicitly are always stuffed.
+	RespectGitignore bool
+
+	// Rewrite is an optional list of sed style rewrite rules
+	// (eg: s|^frontend/dist|/admin|) that are applied in order to the
+	// local paths of files without an alias to get their target paths.
+	// The replacement can refer to regexp groups as $1, $2 ...
+	Rewrite []string
+
+	// Incremental reuses the compressed files from the existing payload of
+	// the output binary (or the input binary if the output doesn't exist)
+	// for files whose size and CRC-32 checksum haven't changed, and only
+	// compresses the changed files. It only applies to CodecZip.
+	Incremental bool
+
+	// Section stuffs the payload into a named section of ELF (.stuffbin)
+	// and PE (stuffbin) binaries and a segment of Mach-O (__STUFFBIN)
+	// binaries instead of blindly appending it, so that the binaries
+	// remain structurally valid for tools that inspect them. The binary
+	// should not be stripped or signed before it's stuffed.
+	Section bool
+
+	// Sidecar writes the payload to a sidecar file next to the output
+	// binary (binary.stuff, see SidecarExt) and only appends its ID, which
+	// references the sidecar, to the binary. UnStuff reads the sidecar
+	// transparently. Code signatures of the binary aren't affected by the
+	// payload, which can be replaced without touching the binary as long as
+	// the ID has no checksum or signature. With Section, the ID is stuffed
+	// into the section. This writes a v2 ID.
+	Sidecar bool
+
+	// Meta is optional metadata about the payload (eg: version) that's
+	// stored in the ID and is available via GetFileID. This writes a v2 ID.
+	Meta map[string]string
+
+	// Bundles are optional named sets of path glob patterns (see
+	// UnStuffPaths) that are stored in the ID's Meta so that the files in
+	// a bundle can be loaded on their own with UnStuffBundle, eg:
+	// {"core": {"/static/**"}, "docs": {"/docs/**", "/samples/**"}}.
+	Bundles map[string][]string
+
+	// HMACKey is an optional secret key with which an HMAC-SHA256 signature
+	// of the payload is added to the ID. UnStuffVerified refuses to load
+	// payloads that don't have a valid signature for the key. This writes
+	// a v2 ID.
+	HMACKey []byte
+
+	// SigningKey is an optional Ed25519 private key with which an
+	// Ed25519ph signature of the payload is added to the ID. Applications
+	// that have the public key compiled in can refuse to load payloads
+	// that aren't signed by the key with UnStuffEd25519. Unlike HMACKey,
+	// the key needed to verify payloads can't be used to sign them. It
+	// can't be used along with HMACKey. This writes a v2 ID.
+	SigningKey ed25519.PrivateKey
+
+	// Checksum adds a SHA-256 checksum of the payload to the ID, which is
+	// verified by GetStuff and UnStuff so that corrupted or tampered payloads
+	// are rejected with ErrChecksum instead of failing to unzip in confusing
+	// ways. This writes a v2 ID.
+	Checksum bool
+
+	// EncryptionKey is an optional 32 byte key with which the payload is
+	// encrypted with AES-256-GCM so that it can't be read without the key
+	// (see UnStuffOpt.Key). The key isn't stored in the binary and should be
+	// supplied to the application at runtime. This writes a v2 ID.
+	EncryptionKey []byte
+
+	// Passphrase is an optional passphrase from which the key to encrypt
+	// the payload with is derived with scrypt. It can't be used along with
+	// EncryptionKey. This writes a v2 ID.
+	Passphrase string
+
+	// Recipients is an optional list of age X25519 public keys
+	// (age1...). The payload is encrypted with a random key that's
+	// encrypted to every recipient with age and stored in the ID, so that
+	// it can be decrypted with the identity (private key) of any of them,
+	// for instance, per-host keys (see IdentityFile). It can't be used along
+	// with EncryptionKey or Passphrase. This writes a v2 ID.
+	Recipients []string
+
+	// Encrypt is an optional list of glob patterns (eg: /licenses/**,
+	// /keys/**, *.pem) matched against target paths like Exclude. Only the
+	// matching files are encrypted individually with EncryptionKey,
+	// Passphrase, or Recipients, and the rest of the payload is left
+	// unencrypted, so that public files can be read without the key.
+	// Without the key, UnStuffWithOpt skips the encrypted files.
+	Encrypt []string
+
+	// Key is an optional KeyFunc that decrypts the existing encrypted payload
+	// with StuffAdd and Incremental, for instance, with an age identity
+	// for payloads encrypted to Recipients. Defaults to EncryptionKey or
+	// Passphrase.
+	Key KeyFunc
+
+	// PostStuff is an optional function that's called with the path of
+	// the output binary after it's stuffed, for instance, to re-sign it as
+	// stuffing invalidates existing signatures. See Codesign.
+	PostStuff func(path string) error
+
+	// DryRun is an optional function that makes stuffing a dry run, for
+	// instance, to check aliases, rewrite rules, and excludes. The files
+	// are compressed without writing the output binary and DryRun is called
+	// with the files that would be stuffed, with their target paths and
+	// their sizes in the payload. The stuffing functions then return 0 for
+	// the size of the binary and the projected size of the payload, which
+	// leaves out the few bytes that encrypting the payload as a whole adds.
+	DryRun func(files []Entry)
+
+	// Progress is an optional function that's called as files are
+	// stuffed, for instance, to show the progress of long runs.
+	Progress ProgressFunc
+
+	// Transform is an optional list of functions that every file is run
+	// through in order before it's stuffed. Incremental doesn't apply to
+	// transformed files.
+	Transform []TransformFunc
+
+	// MaxSize is the optional max size of the stuffed payload in bytes and
+	// MaxFileSize is the optional max uncompressed size of a file. Stuffing
+	// fails with a SizeError that lists the largest files if they're
+	// exceeded, for instance, to keep a release from growing unnoticed.
+	MaxSize     int64
+	MaxFileSize int64
+
+	// Codec is the compression format of the payload. With CodecZstd,
+	// files are stored in the ZIP uncompressed, the whole ZIP is compressed
+	// with Zstandard, CompressionLevel is the zstd level (1-22), and Store
+	// is ignored.
+	Codec Codec
 }
 
 // ErrNoID is used to indicate if an ID was found in a file or not.
 var ErrNoID = errors.New("no ID found in the file")
 
+// ErrChecksum is returned when the checksum of a payload doesn't match
+// the checksum in its ID.
+var ErrChecksum = errors.New("payload checksum mismatch. The file may be corrupt")
+
+// ErrCorruptPayload is returned in a PayloadError when a payload
+// or a file in it is damaged and can't be read.
+var ErrCorruptPayload = errors.New("payload is corrupt")
+
+// ErrTruncated is returned in a PayloadError when a payload or a file
+// in it is cut short, for instance, by an interrupted download.
+var ErrTruncated = errors.New("payload is truncated")
+
+// ErrSignature is returned when the signature of a payload is
+// missing or is invalid.
+var ErrSignature = errors.New("payload signature is missing or invalid")
+
+// ErrSigned is returned when stuffing a binary that has an Authenticode
+// signature, which stuffing would invalidate. Binaries should be signed
+// after they're stuffed.
+var ErrSigned = errors.New("binary has an Authenticode signature. Sign it after stuffing")
+
 // buildName is the name of the app that's injected
 var buildName = [8]byte{'s', 't', 'u', 'f', 'f', 'b', 'i', 'n'}
 
@@ -40,102 +425,1233 @@ var buildName = [8]byte{'s', 't', 'u', 'f', 'f', 'b', 'i', 'n'}
 // the files and appends them to the end of the binary's body and writes everything
 // to a new binary.
 func Stuff(in, out, rootPath string, files ...string) (int64, int64, error) {
-	z, err := zipFiles(rootPath, files...)
+	return StuffWithOpt(in, out, StuffOpt{RootPath: rootPath}, files...)
+}
+
+// StuffWithOpt is Stuff with StuffOpt options. The payload is streamed
+// to the output file as it's compressed and is never fully held in memory.
+func StuffWithOpt(in, out string, o StuffOpt, files ...string) (int64, int64, error) {
+	return stuffEntries(in, out, o, makeEntries(files), false, nil)
+}
+
+// StuffSigned is Stuff that adds an HMAC-SHA256 signature of the
+// payload with the given key to the ID. See UnStuffVerified.
+func StuffSigned(in, out, rootPath string, key []byte, files ...string) (int64, int64, error) {
+	return StuffWithOpt(in, out, StuffOpt{RootPath: rootPath, HMACKey: key}, files...)
+}
+
+// StuffAdd is Stuff that adds the files to the existing payload of
+// a stuffed input binary instead of replacing it. Existing files with
+// the same paths are overwritten. The payload keeps its codec. If the
+// input binary isn't stuffed, it's the same as Stuff.
+func StuffAdd(in, out, rootPath string, files ...string) (int64, int64, error) {
+	o := StuffOpt{RootPath: rootPath}
+
+	id, err := GetFileID(in)
+	if err != nil && err != ErrNoID {
+		return 0, 0, err
+	}
+	if err == nil {
+		o.Codec = id.Codec
+	}
+
+	return StuffAddWithOpt(in, out, o, files...)
+}
+
+// StuffAddWithOpt is StuffAdd with StuffOpt options. The payload is
+// written with the codec in the options.
+func StuffAddWithOpt(in, out string, o StuffOpt, files ...string) (int64, int64, error) {
+	return stuffEntries(in, out, o, makeEntries(files), true, nil)
+}
+
+// StuffRemove is StuffAdd that removes the files that match the given glob
+// patterns (see UnStuffPaths), along with their brotli copies, from the
+// existing payload of a stuffed input binary instead of adding files, for
+// instance, to hotfix a release. The payload keeps its codec, metadata,
+// and checksum. It returns ErrNoID if the input binary isn't stuffed.
+func StuffRemove(in, out string, patterns ...string) (int64, int64, error) {
+	id, err := GetFileID(in)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	o := StuffOpt{Codec: id.Codec, Meta: id.Extra().Meta, Checksum: id.Flags&FlagChecksum != 0}
+	return StuffRemoveWithOpt(in, out, o, patterns...)
+}
+
+// StuffRemoveWithOpt is StuffRemove with StuffOpt options. The payload is
+// written with the codec in the options. Patterns that don't match any
+// file are rejected with an error.
+func StuffRemoveWithOpt(in, out string, o StuffOpt, patterns ...string) (int64, int64, error) {
+	if len(patterns) == 0 {
+		return 0, 0, errors.New("no files to remove")
+	}
+	for _, p := range patterns {
+		if err := checkPattern(p); err != nil {
+			return 0, 0, err
+		}
+	}
+	files, err := ListStuff(in, UnStuffOpt{Key: o.key()})
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, p := range patterns {
+		found := false
+		for _, f := range files {
+			if found = matchPath([]string{p}, f.Path); found {
+				break
+			}
+		}
+		if !found {
+			return 0, 0, fmt.Errorf("%s: no files match", p)
+		}
+	}
+
+	return stuffEntries(in, out, o, nil, true, patterns)
+}
+
+// StuffFS is Stuff with the files in a FileSystem instead of local files
+// and directories, for instance, assets that were assembled or transformed
+// (minified, fingerprinted, filtered) in memory with NewFS.
+func StuffFS(in, out string, fs FileSystem) (int64, int64, error) {
+	return StuffFSWithOpt(in, out, StuffOpt{}, fs)
+}
+
+// StuffFSWithOpt is StuffFS with StuffOpt options. The paths of the files
+// in the FileSystem are used as-is under the RootPath. Incremental doesn't
+// apply to FileSystems.
+func StuffFSWithOpt(in, out string, o StuffOpt, fs FileSystem) (int64, int64, error) {
+	return stuffEntries(in, out, o, []stuffEntry{{fs: fs}}, false, nil)
+}
+
+// StuffTo writes a copy of the binary read from bin with the files in
+// the given FileSystem stuffed into it to dst, and returns the size of
+// the original binary and the stuffed ZIP. It's Stuff for binaries and
+// files held in memory or read from pipes that don't touch the disk.
+func StuffTo(dst io.Writer, bin io.Reader, fs FileSystem) (int64, int64, error) {
+	return StuffToWithOpt(dst, bin, StuffOpt{}, fs)
+}
+
+// StuffToWithOpt is StuffTo with StuffOpt options. The binary is read
+// into memory. Section needs random access to the output and Sidecar needs
+// its path, so they aren't supported, and Incremental and PostStuff don't
+// apply.
+func StuffToWithOpt(dst io.Writer, bin io.Reader, o StuffOpt, fs FileSystem) (int64, int64, error) {
+	o, err := checkStuffOpt(o)
+	if err != nil {
+		return 0, 0, err
+	}
+	if o.Section || o.Sidecar {
+		return 0, 0, errors.New("Section and Sidecar aren't supported with StuffTo. Use StuffWithOpt")
+	}
+
+	b, err := io.ReadAll(bin)
+	if err != nil {
+		return 0, 0, err
+	}
+	if b, err = stripBytes(b); err != nil {
+		return 0, 0, err
+	}
+	if _, err := dst.Write(b); err != nil {
+		return 0, 0, err
+	}
+
+	zLen, _, err := writePayload(dst, int64(len(b)), o, []stuffEntry{{fs: fs}}, nil, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int64(len(b)), zLen, nil
+}
+
+// StripTo is Strip for binaries that are read from bin and written to dst.
+func StripTo(dst io.Writer, bin io.Reader) (int64, error) {
+	b, err := io.ReadAll(bin)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := getID(bytes.NewReader(b), int64(len(b))); err != nil {
+		return 0, err
+	}
+	if b, err = stripBytes(b); err != nil {
+		return 0, err
+	}
+	if _, err := dst.Write(b); err != nil {
+		return 0, err
+	}
+	return int64(len(b)), nil
+}
+
+// stripBytes returns the original binary of a binary in memory that may
+// already be stuffed (see copyFile). The given slice is modified.
+func stripBytes(b []byte) ([]byte, error) {
+	r := bytes.NewReader(b)
+	old, err := getID(r, r.Size())
+	if err == nil && old.BinSize > 0 && old.BinSize <= uint64(len(b)) {
+		// Read the original headers of a binary stuffed into a section
+		// before they're overwritten.
+		var hdr []byte
+		if old.Flags&FlagSection != 0 {
+			if hdr, err = readHeaders(r, old); err != nil {
+				return nil, err
+			}
+		}
+		b = b[:old.BinSize]
+		copy(b, hdr)
+	}
+
+	if off, _, ok := peSignature(bytes.NewReader(b)); ok {
+		if off < int64(len(b)) {
+			return nil, ErrSigned
+		}
+		if err := clearPESignature(byteFile(b)); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// byteFile is a fixed size byte slice that can be read
+// and written at offsets.
+type byteFile []byte
+
+func (b byteFile) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(b).ReadAt(p, off)
+}
+
+func (b byteFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(b)) {
+		return 0, io.ErrShortWrite
+	}
+	return copy(b[off:], p), nil
+}
+
+// stuffEntry is a local file or directory path with an optional
+// alias (eg: /real/path:/alias/path) and its own stuffing options.
+type stuffEntry struct {
+	path string
+
+	// store and brotli apply StuffOpt.Store and StuffOpt.Brotli
+	// to all files in the entry.
+	store  bool
+	brotli bool
+
+	// comment is set as the ZIP comment of all files in the entry.
+	comment string
+
+	// encrypt encrypts all files in the entry individually.
+	// See StuffOpt.Encrypt.
+	encrypt bool
+
+	// archive indicates that the path is a ZIP or tar archive
+	// whose files are stuffed. See StuffArchive.
+	archive bool
+
+	// fs is a FileSystem whose files are stuffed instead of the path.
+	fs FileSystem
+}
+
+// makeEntries returns stuffEntries with no options for the given paths.
+func makeEntries(paths []string) []stuffEntry {
+	out := make([]stuffEntry, len(paths))
+	for n, p := range paths {
+		out[n] = stuffEntry{path: p}
+	}
+	return out
+}
+
+// stuffEntries stuffs the given entries into the binary. If merge is
+// set, the files in the existing payload of the input binary are kept.
+func stuffEntries(in, out string, o StuffOpt, entries []stuffEntry, merge bool, remove []string) (int64, int64, error) {
+	o, err := checkStuffOpt(o)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Load the existing payload before the output file is overwritten.
+	var prev *prevPayload
+	if merge {
+		prev, err = loadPrevPayload(o.key(), in)
+	} else if o.Incremental && o.Codec == CodecZip {
+		// Payloads that can't be decrypted with the key are rebuilt.
+		prev, err = loadPrevPayload(o.key(), out, in)
+		if err == ErrNoKey || err == ErrDecrypt {
+			err = nil
+		}
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if prev != nil {
+		defer prev.Close()
+		prev.keep = merge
+		prev.remove = remove
+	}
+
+	if o.DryRun != nil {
+		return dryRun(o, entries, prev)
+	}
+	return stuffBinary(in, out, o, func(w io.Writer, binSize int64, sec *section) (int64, int64, error) {
+		return writePayload(w, binSize, o, entries, prev, sec)
+	})
+}
+
+// stuffBinary copies the binary to out without its existing payload and
+// writes a payload and its ID after it with the given function, which
+// returns the size of the payload and the total number of bytes written.
+// The options should have been checked with checkStuffOpt.
+func stuffBinary(in, out string, o StuffOpt, write func(w io.Writer, binSize int64, sec *section) (int64, int64, error)) (int64, int64, error) {
+	var err error
+
+	// Write to a temporary file that replaces the output when done, so
+	// that an interrupted or failed run doesn't leave a truncated binary
+	// behind, and binaries can be stuffed in-place.
+	dst := out
+	if out, err = createTemp(dst, in); err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(out)
+
+	// Copy the binary and get the handle to append remaining data.
+	outFile, origSize, err := copyFile(in, out)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer outFile.Close()
+
+	// Stuffing invalidates Authenticode signatures. Binaries
+	// should be signed after they're stuffed.
+	if off, _, ok := peSignature(io.NewSectionReader(outFile, 0, origSize)); ok {
+		if off < origSize {
+			return 0, 0, ErrSigned
+		}
+
+		// The binary was signed after it was stuffed and the signature
+		// was dropped along with the old payload.
+		if err := clearPESignature(outFile); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Lay out the section in the binary and write the structures that
+	// precede the payload.
+	var sec *section
+	if o.Section {
+		if sec, err = newSection(outFile, origSize); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Write the payload and the ID after the binary, or the payload to
+	// a sidecar and only the ID after the binary.
+	var (
+		zLen, n int64
+		scFile  *os.File
+	)
+	if o.Sidecar {
+		scFile, zLen, n, err = writeSidecar(outFile, dst, origSize, sec, write)
+		if scFile != nil {
+			defer os.Remove(scFile.Name())
+			defer scFile.Close()
+		}
+	} else {
+		zLen, n, err = write(outFile, origSize, sec)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Point the section at the payload and the ID.
+	if sec != nil {
+		if err := sec.finish(outFile, n); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// If the output file already existed and was bigger, remove the
+	// remnants of its old data after the ID.
+	end, err := outFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := outFile.Truncate(end); err != nil {
+		return 0, 0, err
+	}
+
+	if scFile != nil {
+		if err := replaceFile(scFile, sidecarPath(dst)); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := replaceFile(outFile, dst); err != nil {
+		return 0, 0, err
+	}
+
+	if o.PostStuff != nil {
+		if err := o.PostStuff(dst); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return origSize, zLen, nil
+}
+
+// writePayload writes the compressed ZIP payload of the given entries
+// followed by its ID to out after a binary of the given size, and returns
+// the size of the payload and the total number of bytes written. sec is
+// the optional section of the binary that the payload is written into.
+// The options should have been checked with checkStuffOpt.
+func writePayload(out io.Writer, binSize int64, o StuffOpt, entries []stuffEntry, prev *prevPayload, sec *section) (int64, int64, error) {
+	id, err := encodePayload(out, o, entries, prev)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	n, err := writeID(out, id, binSize, sec)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	// Copy the binary and get the handle to append remaining data.
-	outFile, origSize, err := copyFile(in, out)
-	if err != nil {
-		return 0, 0, err
-	}
-	defer outFile.Close()
+	zLen := int64(id.ZipSize)
+	return zLen, zLen + n, nil
+}
+
+// stuffStored writes a copy of the stuffed binary at in with the given
+// payload as it's stored (see getStored) and its ID to out. The payload is
+// stuffed into a section or a sidecar again if the ID says so.
+func stuffStored(in, out string, id ID, b []byte) (int64, int64, error) {
+	o := StuffOpt{
+		Section: id.Flags&FlagSection != 0,
+		Sidecar: id.Flags&FlagSidecar != 0,
+	}
+	id.Flags &^= FlagSection | FlagSidecar
+	id.Offset, id.HeaderSize, id.Sidecar = 0, 0, ""
+	id.ZipSize = uint64(len(b))
+
+	return stuffBinary(in, out, o, func(w io.Writer, binSize int64, sec *section) (int64, int64, error) {
+		if _, err := w.Write(b); err != nil {
+			return 0, 0, err
+		}
+		n, err := writeID(w, id, binSize, sec)
+		if err != nil {
+			return 0, 0, err
+		}
+		return int64(len(b)), int64(len(b)) + n, nil
+	})
+}
+
+// encodePayload writes the compressed ZIP payload of the given entries
+// to out and returns its ID without the binary's size and section.
+func encodePayload(out io.Writer, o StuffOpt, entries []stuffEntry, prev *prevPayload) (ID, error) {
+	// Write the compressed ZIP directly to the output while counting its
+	// length and computing its checksum and signature.
+	var (
+		sum = sha256.New()
+		ws  = []io.Writer{out, sum}
+		sig hash.Hash
+	)
+	if o.HMACKey != nil {
+		sig = hmac.New(sha256.New, o.HMACKey)
+		ws = append(ws, sig)
+	}
+	if o.SigningKey != nil {
+		sig = sha512.New()
+		ws = append(ws, sig)
+	}
+	cw := &countWriter{w: io.MultiWriter(ws...)}
+
+	// Record the sizes of the files to check them against the limits.
+	bud, o := newBudget(o)
+
+	// Encrypt the compressed payload or only the selected files in it.
+	id := makeID(buildName, 0, 0)
+	var (
+		pw      io.Writer = cw
+		ew      *encryptWriter
+		fileKey []byte
+		err     error
+	)
+	// Payloads with encrypted files that are kept stay that way.
+	if encryptFiles(o, entries) || (prev != nil && prev.keep && prev.encFiles) {
+		if !o.hasKey() {
+			return ID{}, errors.New("encrypting files needs EncryptionKey, Passphrase, or Recipients")
+		}
+		if fileKey, err = newKey(&id, o); err != nil {
+			return ID{}, err
+		}
+		id.Flags |= FlagEncryptedFiles
+	} else if o.hasKey() {
+		if ew, err = newPayloadEncrypter(&id, cw, o); err != nil {
+			return ID{}, err
+		}
+		pw = ew
+	}
+
+	zw, err := newPayloadWriter(o.Codec, pw, o.CompressionLevel)
+	if err != nil {
+		return ID{}, err
+	}
+	if err := writeZip(zw, o, entries, prev, fileKey); err != nil {
+		zw.Close()
+		return ID{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return ID{}, err
+	}
+	if ew != nil {
+		if err := ew.Close(); err != nil {
+			return ID{}, err
+		}
+	}
+	zLen := cw.n
+	id.ZipSize = uint64(zLen)
+
+	if err := bud.check(zLen); err != nil {
+		return ID{}, err
+	}
+
+	// Plain ZIP payloads get a v1 ID for compatibility
+	// with older versions.
+	if o.Codec != CodecZip || o.Section || o.Sidecar || o.Checksum || len(o.Meta) > 0 || sig != nil || ew != nil || fileKey != nil {
+		id.Version = idVersion2
+		id.Codec = o.Codec
+		id.setMeta(o.Meta)
+	}
+	if o.Checksum {
+		id.Flags |= FlagChecksum
+		copy(id.Checksum[:], sum.Sum(nil))
+	}
+	if o.HMACKey != nil {
+		id.Flags |= FlagHMAC
+		id.signature = string(sig.Sum(nil))
+	}
+	if o.SigningKey != nil {
+		b, err := o.SigningKey.Sign(nil, sig.Sum(nil), &ed25519.Options{Hash: crypto.SHA512})
+		if err != nil {
+			return ID{}, err
+		}
+		id.Flags |= FlagEd25519
+		id.signature = string(b)
+	}
+
+	return id, nil
+}
+
+// writeID writes the ID of a payload in a binary of the given size and
+// optional section to out and returns the number of bytes written.
+func writeID(out io.Writer, id ID, binSize int64, sec *section) (int64, error) {
+	id.BinSize = uint64(binSize)
+	if sec != nil {
+		id.Flags |= FlagSection
+		id.Offset = uint64(sec.offset)
+		id.HeaderSize = uint32(len(sec.header))
+	}
+
+	n, err := out.Write(makeIDBytes(id))
+	return int64(n), err
+}
+
+// StripOpt represents options for stripping a stuffed binary.
+type StripOpt struct {
+	// KeepPayload writes the payload that's removed and its ID to a file
+	// next to the output binary (binary.stuff, see SidecarExt) from which
+	// it can be attached to the binary again as it was with Attach, for
+	// instance, to sign or patch the original binary in between.
+	KeepPayload bool
+}
+
+// Strip writes a copy of a stuffed binary without its payload to out and
+// returns the size of the original binary. The original headers of binaries
+// that were stuffed into a section are restored. in and out can be the same.
+func Strip(in, out string) (int64, error) {
+	return StripWithOpt(in, out, StripOpt{})
+}
+
+// StripWithOpt is Strip with options.
+func StripWithOpt(in, out string, o StripOpt) (int64, error) {
+	id, err := GetFileID(in)
+	if err != nil {
+		return 0, err
+	}
+
+	// Write via temporary files (see stuffEntries). The payload is
+	// written first as in can be out.
+	var kept *os.File
+	if o.KeepPayload {
+		_, b, err := getStored(in)
+		if err != nil {
+			return 0, err
+		}
+
+		sc := sidecarPath(out)
+		kept, err = os.CreateTemp(filepath.Dir(sc), "."+filepath.Base(sc)+".*")
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(kept.Name())
+		defer kept.Close()
+
+		if err := kept.Chmod(0644); err != nil {
+			return 0, err
+		}
+		if _, err := kept.Write(append(b, makeIDBytes(id)...)); err != nil {
+			return 0, err
+		}
+	}
+
+	tmp, err := createTemp(out, in)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	f, size, err := copyFile(in, tmp)
+	if err != nil {
+		return 0, err
+	}
+	if err := replaceFile(f, out); err != nil {
+		return 0, err
+	}
+
+	if kept != nil {
+		if err := replaceFile(kept, sidecarPath(out)); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// Attach stuffs a payload that was kept by StripWithOpt into a copy of the
+// binary at in and writes it to out. The payload is attached as it was with
+// its ID, checksum, and signature (into a section or a sidecar if it was),
+// so the binary doesn't need to be the one it was stripped from. It returns
+// the size of the binary and the payload.
+func Attach(in, out, payload string) (int64, int64, error) {
+	b, err := os.ReadFile(payload)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	id, err := readID(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return 0, 0, err
+	}
+	n := len(b) - len(makeIDBytes(id))
+	if id.ZipSize != uint64(n) {
+		return 0, 0, &PayloadError{Err: ErrCorruptPayload, Cause: fmt.Errorf("payload size %d doesn't match the ID's %d", n, id.ZipSize)}
+	}
+	b = b[:n]
+	if err := verifyChecksum(id, b); err != nil {
+		return 0, 0, err
+	}
+
+	return stuffStored(in, out, id, b)
+}
+
+// GetFileID attempts to get the stuffbin identifier from
+// the end of the file and returns the identifier name
+// and file sizes.
+func GetFileID(fName string) (ID, error) {
+	var id ID
+	f, err := os.Open(fName)
+	if err != nil {
+		return id, err
+	}
+	defer f.Close()
+
+	stat, err := os.Stat(fName)
+	if err != nil {
+		return id, err
+	}
+
+	return getID(f, stat.Size())
+}
+
+// getID reads the ID of a stuffed binary of the given size.
+func getID(r io.ReaderAt, size int64) (ID, error) {
+	id, err := readID(r, size)
+	if err != ErrNoID {
+		return id, err
+	}
+
+	// The payload may be in a section or followed by a signature.
+	if id, err = readEmbeddedID(r); err != ErrNoID {
+		return id, err
+	}
+
+	// Or followed by data that was appended after stuffing.
+	return scanID(r, size)
+}
+
+// scanID scans the last maxTrailer bytes of a reader of the given size
+// backwards for the ID of a payload that's followed by data that was
+// appended after stuffing, for instance, by installers or signing tools.
+// Matches of the name that aren't the end of a valid ID whose payload
+// precedes it are skipped.
+func scanID(r io.ReaderAt, size int64) (ID, error) {
+	start := size - maxTrailer - lenID
+	if start < 0 {
+		start = 0
+	}
+	b := make([]byte, size-start)
+	if _, err := r.ReadAt(b, start); err != nil && err != io.EOF {
+		return ID{}, err
+	}
+
+	for i := bytes.LastIndex(b, buildName[:]); i >= 0; i = bytes.LastIndex(b[:i], buildName[:]) {
+		// The name ends v2 IDs and begins v1 IDs.
+		for _, end := range []int64{start + int64(i) + 8, start + int64(i) + lenID} {
+			if end >= size {
+				continue
+			}
+			id, err := readID(r, end)
+			if err != nil {
+				continue
+			}
+
+			// The payload should precede the ID.
+			if id.Version == idVersion1 && id.BinSize+id.ZipSize+lenID != uint64(end) {
+				continue
+			}
+			if id.Flags&FlagSidecar == 0 && id.payloadOffset()+id.ZipSize > uint64(end) {
+				continue
+			}
+			return id, nil
+		}
+	}
+
+	return ID{}, ErrNoID
+}
+
+// readID reads a v2 or v1 ID from the end of a reader of the given size.
+func readID(r io.ReaderAt, size int64) (ID, error) {
+	var id ID
+
+	// v2 IDs end with the body length followed by the name.
+	if size >= lenIDFooter+lenIDBodyMin {
+		foot := make([]byte, lenIDFooter)
+		if _, err := r.ReadAt(foot, size-lenIDFooter); err != nil {
+			return id, err
+		}
+
+		if bytes.Equal(foot[4:12], buildName[:]) {
+			bodyLen := int64(binary.BigEndian.Uint32(foot[0:4]))
+			if bodyLen < lenIDBodyMin || bodyLen > size-lenIDFooter {
+				return id, fmt.Errorf("invalid ID body length %d", bodyLen)
+			}
+
+			body := make([]byte, bodyLen)
+			if _, err := r.ReadAt(body, size-lenIDFooter-bodyLen); err != nil {
+				return id, err
+			}
+
+			id = ID{
+				Name:    buildName,
+				Version: body[0],
+				Codec:   Codec(body[1]),
+				Flags:   binary.BigEndian.Uint16(body[2:4]),
+				BinSize: binary.BigEndian.Uint64(body[4:12]),
+				ZipSize: binary.BigEndian.Uint64(body[12:20]),
+			}
+			if id.Version < idVersion2 {
+				return id, fmt.Errorf("invalid ID version %d", id.Version)
+			}
+
+			// Fields that were added to the body later.
+			if bodyLen >= 32 {
+				id.Offset = binary.BigEndian.Uint64(body[20:28])
+				id.HeaderSize = binary.BigEndian.Uint32(body[28:32])
+			}
+			if bodyLen >= 64 {
+				copy(id.Checksum[:], body[32:64])
+			}
+			start := size - lenIDFooter - bodyLen
+			if bodyLen >= 68 {
+				metaLen := int64(binary.BigEndian.Uint32(body[64:68]))
+				if metaLen > start {
+					return id, fmt.Errorf("invalid ID meta length %d", metaLen)
+				}
+				if metaLen > 0 {
+					meta := make([]byte, metaLen)
+					if _, err := r.ReadAt(meta, start-metaLen); err != nil {
+						return id, err
+					}
+					var m map[string]string
+					if err := json.Unmarshal(meta, &m); err != nil {
+						return id, fmt.Errorf("invalid ID meta: %v", err)
+					}
+					id.meta = string(meta)
+				}
+				start -= metaLen
+			}
+			if bodyLen >= 72 {
+				sigLen := int64(binary.BigEndian.Uint32(body[68:72]))
+				if sigLen > start {
+					return id, fmt.Errorf("invalid ID signature length %d", sigLen)
+				}
+				if sigLen > 0 {
+					sig := make([]byte, sigLen)
+					if _, err := r.ReadAt(sig, start-sigLen); err != nil {
+						return id, err
+					}
+					id.signature = string(sig)
+				}
+				start -= sigLen
+			}
+			if bodyLen >= 96 {
+				copy(id.Salt[:], body[72:88])
+				copy(id.Nonce[:], body[88:96])
+			}
+			if bodyLen >= 100 {
+				keyLen := int64(binary.BigEndian.Uint32(body[96:100]))
+				if keyLen > start {
+					return id, fmt.Errorf("invalid ID wrapped key length %d", keyLen)
+				}
+				if keyLen > 0 {
+					wk := make([]byte, keyLen)
+					if _, err := r.ReadAt(wk, start-keyLen); err != nil {
+						return id, err
+					}
+					id.wrappedKey = string(wk)
+				}
+				start -= keyLen
+			}
+			if bodyLen >= 104 {
+				scLen := int64(binary.BigEndian.Uint32(body[100:104]))
+				if scLen > start {
+					return id, fmt.Errorf("invalid ID sidecar length %d", scLen)
+				}
+				if scLen > 0 {
+					b := make([]byte, scLen)
+					if _, err := r.ReadAt(b, start-scLen); err != nil {
+						return id, err
+					}
+					id.Sidecar = string(b)
+				}
+			}
+			return id, nil
+		}
+	}
+
+	var (
+		buf   = make([]byte, lenID)
+		start = size - lenID
+	)
+	if start < 0 {
+		return id, ErrNoID
+	}
+
+	if _, err := r.ReadAt(buf, start); err != nil {
+		return id, err
+	}
+
+	if !bytes.Equal(buf[0:8], buildName[:]) {
+		return id, ErrNoID
+	}
+
+	var name [8]byte
+	copy(name[:], buf[0:8])
+	return ID{
+		Name:    name,
+		BinSize: binary.BigEndian.Uint64(buf[8:16]),
+		ZipSize: binary.BigEndian.Uint64(buf[16:24]),
+		Version: idVersion1,
+		Codec:   CodecZip,
+	}, nil
+}
+
+// zipFiles takes a list of files and ZIPs them and returns the zipped bytes. It optionally
+// flattens the paths (eg: /some/path/file.txt becomes /file.txt) and adds
+// a base path (eg: /some/path/file.txt becomes /custombase/some/path/file.txt).
+// The files list can have targetsaliases separated by a :, for instance
+// /tmp/something/x:/assets/x, where the target followed by the colon is used as
+// the file path when stuffing. This is useful to unify assets into a common path where  during
+// the build process, the original assets can be scattered across different paths.
+func zipFiles(o StuffOpt, paths ...string) (*bytes.Buffer, error) {
+	o, err := checkStuffOpt(o)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeZip(buf, o, makeEntries(paths), nil, nil); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// checkStuffOpt validates the given options and returns
+// them with the defaults filled in.
+func checkStuffOpt(o StuffOpt) (StuffOpt, error) {
+	if o.RootPath == "" {
+		o.RootPath = "/"
+	}
+
+	switch o.Codec {
+	case CodecZip:
+		if o.CompressionLevel == 0 {
+			o.CompressionLevel = flate.DefaultCompression
+		}
+		if o.CompressionLevel < flate.HuffmanOnly || o.CompressionLevel > flate.BestCompression {
+			return o, fmt.Errorf("invalid compression level %d", o.CompressionLevel)
+		}
+	case CodecZstd:
+	default:
+		return o, fmt.Errorf("unknown codec %d", o.Codec)
+	}
+
+	if o.HMACKey != nil && o.SigningKey != nil {
+		return o, errors.New("HMACKey and SigningKey can't be used together")
+	}
+	if o.SigningKey != nil && len(o.SigningKey) != ed25519.PrivateKeySize {
+		return o, fmt.Errorf("invalid Ed25519 private key size %d", len(o.SigningKey))
+	}
+	if o.EncryptionKey != nil && o.Passphrase != "" {
+		return o, errors.New("EncryptionKey and Passphrase can't be used together")
+	}
+	if o.EncryptionKey != nil && len(o.EncryptionKey) != lenKey {
+		return o, fmt.Errorf("invalid AES-256 key size %d", len(o.EncryptionKey))
+	}
+	if len(o.Encrypt) > 0 && !o.hasKey() {
+		return o, errors.New("Encrypt needs EncryptionKey, Passphrase, or Recipients")
+	}
+	for _, p := range o.Encrypt {
+		for _, seg := range strings.Split(p, "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return o, fmt.Errorf("invalid encrypt pattern '%s': %v", p, err)
+			}
+		}
+	}
+	if len(o.Recipients) > 0 {
+		if o.EncryptionKey != nil || o.Passphrase != "" {
+			return o, errors.New("Recipients can't be used along with EncryptionKey or Passphrase")
+		}
+		if _, err := parseRecipients(o.Recipients); err != nil {
+			return o, err
+		}
+	}
+
+	for _, p := range o.Store {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return o, fmt.Errorf("invalid store pattern '%s': %v", p, err)
+		}
+	}
+	for _, p := range o.Brotli {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return o, fmt.Errorf("invalid brotli pattern '%s': %v", p, err)
+		}
+	}
+	for _, p := range o.Dictionary {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return o, fmt.Errorf("invalid dictionary pattern '%s': %v", p, err)
+		}
+	}
+	if err := checkExclude(o.Exclude); err != nil {
+		return o, err
+	}
+	if _, err := parseRewrites(o.Rewrite); err != nil {
+		return o, err
+	}
+	if len(o.Bundles) > 0 {
+		meta, err := bundleMeta(o.Meta, o.Bundles)
+		if err != nil {
+			return o, err
+		}
+		o.Meta = meta
+	}
+
+	return o, nil
+}
+
+// writeZip ZIPs the given list of file entries (see zipFiles) and writes
+// the archive to w as it goes. With o.Incremental, unchanged files in the
+// optional existing payload are copied over without recompression. Files
+// that match o.Encrypt are encrypted with the optional fileKey and files
+// that match o.Dictionary are compressed with a dictionary. The options
+// should have been checked with checkStuffOpt.
+func writeZip(w io.Writer, o StuffOpt, entries []stuffEntry, prev *prevPayload, fileKey []byte) error {
+	level, store := o.CompressionLevel, o.Store
+	if o.Codec != CodecZip {
+		// The payload is compressed as a whole. Store files as-is.
+		level, store = flate.NoCompression, []string{"*"}
+	}
+
+	wo := walkOpt{rootPath: o.RootPath, exclude: o.Exclude, skipHidden: o.SkipHidden, noRecursive: o.NoRecursive, gitIgnore: o.RespectGitignore}
+	rw, err := parseRewrites(o.Rewrite)
+	if err != nil {
+		return err
+	}
+	wo.rewrite = rw
+
+	// archive/zip automatically writes ZIP64 records for files and
+	// archives over 4GB or with more than 65535 entries.
+	cw := &countWriter{w: w}
+	zw := zip.NewWriter(cw)
+	pr := newProgress(o.Progress, func() int64 { return cw.n })
+
+	// flate writers are expensive to create. Reuse them across files.
+	var pool sync.Pool
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		if fw, ok := pool.Get().(*flate.Writer); ok {
+			fw.Reset(w)
+			return &pooledFlateWriter{Writer: fw, pool: &pool}, nil
+		}
+
+		fw, err := flate.NewWriter(w, level)
+		if err != nil {
+			return nil, err
+		}
+		return &pooledFlateWriter{Writer: fw, pool: &pool}, nil
+	})
+
+	var (
+		prevFiles map[string]*zip.File
+		written   = map[string]bool{}
+	)
+	if prev != nil {
+		prevFiles = prev.files
+	}
+
+	// Compress the files that match o.Dictionary with the given or a
+	// trained dictionary. Kept files keep the existing dictionary.
+	var dict []byte
+	if o.Codec == CodecZip {
+		switch {
+		case len(o.Dictionary) > 0 && o.DictionaryData != nil:
+			dict = o.DictionaryData
+		case len(o.Dictionary) > 0:
+			if dict, err = trainEntries(o, wo, entries); err != nil {
+				return err
+			}
+		case prev != nil && prev.keep:
+			dict = prev.dict
+		}
+	}
+	if dict != nil {
+		enc, err := registerDictCompressor(zw, dict)
+		if err != nil {
+			return err
+		}
+		defer enc.Close()
+
+		if err := writeDict(zw, dict); err != nil {
+			return err
+		}
+	}
+	sameDict := dict != nil && prev != nil && bytes.Equal(dict, prev.dict)
+
+	// fileMethod returns the compression method of a file.
+	// Encrypted files aren't compressed with the dictionary.
+	fileMethod := func(e stuffEntry, targetPath string) uint16 {
+		encrypt := fileKey != nil && (e.encrypt || matchEncrypt(o.Encrypt, targetPath))
+		switch {
+		case dict != nil && !encrypt && matchAny(o.Dictionary, targetPath):
+			return dictMethod
+		case e.store || matchAny(store, targetPath):
+			return zip.Store
+		}
+		return zip.Deflate
+	}
+
+	for _, e := range entries {
+		// zipEntry zips a file that's read from a reader.
+		zipEntry := func(r io.Reader, targetPath string, fInfo os.FileInfo, zf *zip.File) error {
+			// Transform the file in memory. Files without a path are dropped.
+			if len(o.Transform) > 0 {
+				b, err := io.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				if targetPath, b, err = transformFile(o.Transform, targetPath, b); err != nil {
+					return err
+				}
+				if targetPath == "" {
+					return nil
+				}
+
+				r, zf = bytes.NewReader(b), nil
+				fInfo = &fileInfo{name: path.Base(targetPath), size: int64(len(b)), mode: fInfo.Mode(), modTime: fInfo.ModTime()}
+			}
+
+			method := fileMethod(e, targetPath)
+			brotli := e.brotli || matchAny(o.Brotli, targetPath)
+
+			written[targetPath] = true
+			pr.start(targetPath, fInfo.Size())
+
+			if fileKey != nil && (e.encrypt || matchEncrypt(o.Encrypt, targetPath)) {
+				return zipEncryptedReader(r, fInfo, targetPath, method, level, e.comment, fileKey, zw)
+			}
+
+			// Files in ZIP archives that are compressed the same way are copied as-is.
+			if zf != nil && zf.Method == method && method != dictMethod && !brotli {
+				return copyZipFileAs(zf, targetPath, e.comment, zw)
+			}
+
+			if !brotli {
+				return zipReader(r, fInfo, targetPath, method, e.comment, zw)
+			}
+
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if err := zipReader(bytes.NewReader(b), fInfo, targetPath, method, e.comment, zw); err != nil {
+				return err
+			}
+			return zipBrotliBytes(b, fInfo, targetPath, zw)
+		}
+
+		// Files in file systems, archives, and git, and transformed
+		// local files are zipped from readers.
+		if e.fs != nil || e.archive || isGitPath(e.path) || len(o.Transform) > 0 {
+			if err := entryWalker(e)(zipEntry, wo, e.path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := walkPaths(func(srcPath, targetPath string, fInfo os.FileInfo) error {
+			method := fileMethod(e, targetPath)
+			brotli := e.brotli || matchAny(o.Brotli, targetPath)
+
+			written[targetPath] = true
+			pr.start(targetPath, fInfo.Size())
+
+			// Encrypt the file. Encrypted files don't get plain brotli copies.
+			if fileKey != nil && (e.encrypt || matchEncrypt(o.Encrypt, targetPath)) {
+				return zipEncryptedFile(srcPath, targetPath, method, level, e.comment, fileKey, zw)
+			}
+
+			// Copy the file and its brotli copy from the existing ZIP if it's unchanged.
+			if f, ok := prevFiles[targetPath]; ok && o.Incremental && f.Method == method && !isEncryptedFile(f) && (method != dictMethod || sameDict) {
+				ok, err := isUnchanged(srcPath, fInfo, f)
+				if err != nil {
+					return err
+				}
+				if ok {
+					br, hasBr := prevFiles[targetPath+".br"]
+					if !brotli || hasBr {
+						if err := copyZipFile(f, e.comment, zw); err != nil {
+							return err
+						}
+						if brotli {
+							return copyZipFile(br, br.Comment, zw)
+						}
+						return nil
+					}
+				}
+			}
+
+			if err := zipFile(srcPath, targetPath, method, e.comment, zw); err != nil {
+				return err
+			}
 
-	// Write compressed data and get the length.
-	zLen, err := io.Copy(outFile, z)
-	if err != nil {
-		return 0, 0, err
+			if brotli {
+				return zipBrotliFile(srcPath, targetPath, zw)
+			}
+			return nil
+		}, wo, e.path); err != nil {
+			return err
+		}
 	}
 
-	// Write the ID at end.
-	id := makeID(buildName, uint64(origSize), uint64(zLen))
-	if _, err := outFile.Write(makeIDBytes(id)); err != nil {
-		return 0, 0, err
-	}
+	// Keep the files in the existing payload that weren't overwritten
+	// along with their brotli copies.
+	if prev != nil && prev.keep {
+		for _, f := range prev.list {
+			if f.Name == dictName || written[f.Name] || (strings.HasSuffix(f.Name, ".br") && written[strings.TrimSuffix(f.Name, ".br")]) ||
+				matchPath(prev.remove, f.Name) || (strings.HasSuffix(f.Name, ".br") && matchPath(prev.remove, strings.TrimSuffix(f.Name, ".br"))) {
+				continue
+			}
+			pr.start(f.Name, int64(f.UncompressedSize64))
 
-	return origSize, zLen, nil
-}
+			// Encrypted files are re-encrypted with the new key.
+			if isEncryptedFile(f) {
+				if fileKey == nil || prev.key == nil {
+					return fmt.Errorf("%s: %v", f.Name, ErrNoKey)
+				}
+				if err := reencryptZipFile(f, prev.key, fileKey, zw); err != nil {
+					return err
+				}
+				continue
+			}
 
-// GetFileID attempts to get the stuffbin identifier from
-// the end of the file and returns the identifier name
-// and file sizes.
-func GetFileID(fName string) (ID, error) {
-	var id ID
-	f, err := os.Open(fName)
-	if err != nil {
-		return id, err
+			// Files compressed with another dictionary are recompressed.
+			if f.Method == dictMethod && !sameDict {
+				if err := recompressZipFile(f, fileMethod(stuffEntry{}, f.Name), zw); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := copyZipFile(f, f.Comment, zw); err != nil {
+				return err
+			}
+		}
 	}
-	defer f.Close()
 
-	stat, err := os.Stat(fName)
-	if err != nil {
-		return id, err
+	// Write the central directory.
+	if err := zw.Close(); err != nil {
+		return err
 	}
+	pr.finish()
 
-	var (
-		buf   = make([]byte, lenID)
-		start = stat.Size() - lenID
-	)
-	if start < 0 {
-		return id, ErrNoID
-	}
+	return nil
+}
 
-	_, err = f.ReadAt(buf, start)
-	if err != nil {
-		return id, err
+// transformFile runs a file through the given transform functions and
+// returns its cleaned new path and contents, or an empty path if it's dropped.
+func transformFile(fns []TransformFunc, p string, b []byte) (string, []byte, error) {
+	for _, fn := range fns {
+		var (
+			np  string
+			err error
+		)
+		if np, b, err = fn(p, b); err != nil {
+			return "", nil, fmt.Errorf("error transforming %s: %v", p, err)
+		}
+		if np == "" {
+			return "", nil, nil
+		}
+		p = path.Clean("/" + np)
 	}
 
-	if !bytes.Equal(buf[0:8], buildName[:]) {
-		return id, ErrNoID
-	}
+	return p, b, nil
+}
 
-	var name [8]byte
-	copy(name[:], buf[0:8])
-	return ID{
-		Name:    name,
-		BinSize: binary.BigEndian.Uint64(buf[8:16]),
-		ZipSize: binary.BigEndian.Uint64(buf[16:24]),
-	}, nil
+// countWriter is an io.Writer that counts the bytes
+// written to the underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int64
 }
 
-// zipFiles takes a list of files and ZIPs them and returns the zipped bytes. It optionally
-// flattens the paths (eg: /some/path/file.txt becomes /file.txt) and adds
-// a base path (eg: /some/path/file.txt becomes /custombase/some/path/file.txt).
-// The files list can have targetsaliases separated by a :, for instance
-// /tmp/something/x:/assets/x, where the target followed by the colon is used as
-// the file path when stuffing. This is useful to unify assets into a common path where  during
-// the build process, the original assets can be scattered across different paths.
-func zipFiles(rootPath string, paths ...string) (*bytes.Buffer, error) {
-	var (
-		buf = &bytes.Buffer{}
-		zw  = zip.NewWriter(buf)
-	)
-	defer zw.Close()
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
 
-	if err := walkPaths(func(srcPath, targetPath string, fInfo os.FileInfo) error {
-		return zipFile(srcPath, targetPath, zw)
-	}, rootPath, paths...); err != nil {
-		return nil, err
-	}
+// pooledFlateWriter is a flate.Writer that's returned to
+// its pool when closed.
+type pooledFlateWriter struct {
+	*flate.Writer
+	pool *sync.Pool
+}
 
-	return buf, nil
+// Close flushes the writer and returns it to the pool.
+func (w *pooledFlateWriter) Close() error {
+	err := w.Writer.Close()
+	w.pool.Put(w.Writer)
+	return err
 }
 
 // zipFile reads and adds a single file from the local file system to a given zip.Writer
-// while optionally losing the real path information (flattening)
-// or subsituting it with an alias.
-func zipFile(srcPath, targetPath string, zw *zip.Writer) error {
+// with the given compression method and comment while optionally losing the real path
+// information (flattening) or subsituting it with an alias.
+func zipFile(srcPath, targetPath string, method uint16, comment string, zw *zip.Writer) error {
 	z, err := os.Open(srcPath)
 	if err != nil {
 		return err
@@ -147,6 +1663,12 @@ func zipFile(srcPath, targetPath string, zw *zip.Writer) error {
 		return err
 	}
 
+	return zipReader(z, info, targetPath, method, comment, zw)
+}
+
+// zipReader adds a file with the given info that's read from r
+// to a given zip.Writer as targetPath.
+func zipReader(r io.Reader, info os.FileInfo, targetPath string, method uint16, comment string, zw *zip.Writer) error {
 	hdr, err := zip.FileInfoHeader(info)
 	if err != nil {
 		return err
@@ -154,21 +1676,108 @@ func zipFile(srcPath, targetPath string, zw *zip.Writer) error {
 
 	// Append the optional alias.
 	hdr.Name = targetPath
-	hdr.Method = zip.Deflate
+	hdr.Method = method
+	hdr.Comment = comment
 
 	w, err := zw.CreateHeader(hdr)
 	if err != nil {
 		return err
 	}
-	if _, err = io.Copy(w, z); err != nil {
+	if _, err = io.Copy(w, r); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// copyFile takes an input file path, copies it to an output path
-// and returns the size of the original file and the file handler
+// zipBrotliFile compresses a file from the local file system with brotli
+// and adds it to the given zip.Writer as targetPath.br without further
+// compression. The file is skipped if compression doesn't make it smaller.
+func zipBrotliFile(srcPath, targetPath string, zw *zip.Writer) error {
+	b, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+
+	return zipBrotliBytes(b, info, targetPath, zw)
+}
+
+// zipBrotliBytes is zipBrotliFile for the bytes of a file with the given info.
+func zipBrotliBytes(b []byte, info os.FileInfo, targetPath string, zw *zip.Writer) error {
+	buf := &bytes.Buffer{}
+	bw := brotli.NewWriterLevel(buf, brotli.BestCompression)
+	if _, err := bw.Write(b); err != nil {
+		return err
+	}
+	if err := bw.Close(); err != nil {
+		return err
+	}
+	if buf.Len() >= len(b) {
+		return nil
+	}
+
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = targetPath + ".br"
+	hdr.Method = zip.Store
+
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// createTemp creates an empty temporary file in the directory of the given
+// output path, so that it can replace the output with an atomic rename, and
+// returns its path. The file gets the permissions and, where possible, the
+// ownership of the given source file.
+func createTemp(out, src string) (string, error) {
+	stat, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(out), "."+filepath.Base(out)+".*")
+	if err != nil {
+		return "", err
+	}
+	if err := f.Chmod(stat.Mode().Perm()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	copyOwner(f, stat)
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// replaceFile syncs and closes a temporary file created with
+// createTemp and atomically renames it to the given path.
+func replaceFile(f *os.File, path string) error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// copyFile takes an input file path, copies it to an existing output
+// path (see createTemp) and returns the size of the original file and the file handler
 // of the new copy for further writing.
 func copyFile(in string, out string) (*os.File, int64, error) {
 	from, err := os.Open(in)
@@ -184,7 +1793,7 @@ func copyFile(in string, out string) (*os.File, int64, error) {
 	}
 	curSize := s.Size()
 
-	to, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE, 0755)
+	to, err := os.OpenFile(out, os.O_RDWR|os.O_TRUNC, 0)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -201,31 +1810,153 @@ func copyFile(in string, out string) (*os.File, int64, error) {
 	if old.BinSize > 0 {
 		curSize = int64(old.BinSize)
 
+		// Read the original headers of a binary stuffed into a section
+		// before they're truncated away.
+		var hdr []byte
+		if old.Flags&FlagSection != 0 {
+			if hdr, err = readHeaders(from, old); err != nil {
+				to.Close()
+				return nil, 0, err
+			}
+		}
+
 		// Truncate the file to its original binary size.
 		if err := to.Truncate(curSize); err != nil {
+			to.Close()
 			return nil, 0, err
 		}
 		if _, err := to.Seek(curSize, 0); err != nil {
+			to.Close()
 			return nil, 0, err
 		}
+
+		if hdr != nil {
+			if _, err := to.WriteAt(hdr, 0); err != nil {
+				to.Close()
+				return nil, 0, err
+			}
+		}
 	}
 
 	return to, curSize, nil
 }
 
-func walkPaths(cb WalkFunc, rootPath string, paths ...string) error {
+// entryWalker returns the function that walks the files of an entry.
+func entryWalker(e stuffEntry) func(cb archiveWalkFunc, o walkOpt, p string) error {
+	switch {
+	case e.fs != nil:
+		return func(cb archiveWalkFunc, o walkOpt, _ string) error {
+			return walkFS(cb, o, e.fs)
+		}
+	case e.archive:
+		return walkArchive
+	case isGitPath(e.path):
+		return walkGit
+	}
+	return walkLocal
+}
+
+// walkLocal is walkPaths that calls the callback with the opened files.
+func walkLocal(cb archiveWalkFunc, o walkOpt, p string) error {
+	return walkPaths(func(srcPath, targetPath string, fInfo os.FileInfo) error {
+		f, err := os.Open(srcPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return cb(f, targetPath, fInfo, nil)
+	}, o, p)
+}
+
+// walkFS calls the callback for every file in a FileSystem that isn't
+// excluded by the walk options in the order of their paths.
+func walkFS(cb archiveWalkFunc, o walkOpt, fs FileSystem) error {
+	paths := fs.List()
+	sort.Strings(paths)
+
+	target := archiveTarget(o, "", "")
+	for _, p := range paths {
+		tp := target(p)
+		if tp == "" {
+			continue
+		}
+
+		f, err := fs.Get(p)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		err = cb(f, tp, info, nil)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkOpt represents options for walkPaths.
+type walkOpt struct {
+	// rootPath is the root path to bind all target paths to.
+	rootPath string
+
+	// exclude is a list of glob patterns of paths to skip.
+	exclude []string
+
+	// skipHidden skips dotfiles and dot-directories inside walked directories.
+	skipHidden bool
+
+	// noRecursive skips the subdirectories of walked directories.
+	noRecursive bool
+
+	// gitIgnore skips the files that git ignores inside walked directories.
+	gitIgnore bool
+
+	// rewrite is a list of rules to rewrite the paths of files without aliases.
+	rewrite []rewriteRule
+}
+
+// rewriteRule is a parsed sed style path rewrite rule (s|pattern|replacement|).
+type rewriteRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// walkPaths walks the given list of local file and directory paths with
+// optional aliases and calls cb for every file that's not excluded. Paths
+// that don't exist are expanded as glob patterns (see expandGlob).
+func walkPaths(cb WalkFunc, o walkOpt, paths ...string) error {
 	for _, fp := range paths {
+		// Is there an alias (eg: /real/path:/alias/path)
+		src, alias, err := splitAlias(fp)
+		if err != nil {
+			return err
+		}
+
+		// Is it a glob pattern (eg: assets/**/*.css:/css)?
+		if hasMeta(src) {
+			if _, err := os.Stat(src); os.IsNotExist(err) {
+				matches, err := expandGlob(src, alias, o.gitIgnore)
+				if err != nil {
+					return err
+				}
+				if err := walkPaths(cb, o, matches...); err != nil {
+					return err
+				}
+				continue
+			}
+		}
 		var (
-			chunks     = strings.Split(fp, ":")
-			srcPath    = filepath.Clean(chunks[0])
+			srcPath    = filepath.Clean(src)
 			targetPath = ""
 		)
-
-		// Is there an alias (eg: /real/path:/alias/path)
-		if len(chunks) > 2 {
-			return fmt.Errorf("invalid alias format '%s'", fp)
-		} else if len(chunks) == 2 {
-			targetPath = cleanPath("/", chunks[1])
+		if alias != "" {
+			targetPath = cleanPath("/", alias)
 		}
 
 		// If it's a directory, find its children.
@@ -235,21 +1966,38 @@ func walkPaths(cb WalkFunc, rootPath string, paths ...string) error {
 		}
 
 		if stat.IsDir() {
+			var ig *gitIgnore
+			if o.gitIgnore {
+				if ig, err = newGitIgnore(srcPath); err != nil {
+					return err
+				}
+			}
+
 			if err := filepath.Walk(srcPath, func(p string, fInfo os.FileInfo, err error) error {
 				if err != nil {
 					return err
 				}
+				if isExcluded(o.exclude, p) || (o.skipHidden && p != srcPath && isHidden(fInfo.Name())) ||
+					(ig != nil && p != srcPath && ig.ignored(p, fInfo.IsDir())) {
+					if fInfo.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
 				if fInfo.IsDir() {
+					if o.noRecursive && p != srcPath {
+						return filepath.SkipDir
+					}
 					return nil
 				}
 
 				// If there's an alias, replace the whole dirpath with it.
-				tp := p
+				tp := rewritePath(o.rewrite, p)
 				if targetPath != "" {
 					tp = filepath.Join(targetPath, strings.TrimPrefix(p, srcPath))
 				}
 
-				return cb(p, filepath.Join(rootPath, tp), fInfo)
+				return cb(p, filepath.Join(o.rootPath, tp), fInfo)
 			}); err != nil {
 				return err
 			}
@@ -258,8 +2006,11 @@ func walkPaths(cb WalkFunc, rootPath string, paths ...string) error {
 		}
 
 		// Single file.
+		if isExcluded(o.exclude, srcPath) {
+			continue
+		}
 		if targetPath == "" {
-			targetPath = cleanPath(rootPath, srcPath)
+			targetPath = cleanPath(o.rootPath, rewritePath(o.rewrite, srcPath))
 		}
 		if err := cb(srcPath, targetPath, stat); err != nil {
 			return err
@@ -269,17 +2020,230 @@ func walkPaths(cb WalkFunc, rootPath string, paths ...string) error {
 	return nil
 }
 
+// aliasSep is the separator of a path to stuff and its alias that can
+// be used instead of a colon for paths with colons (eg: C:\assets=>/static).
+const aliasSep = "=>"
+
+// splitAlias splits a path to stuff into its source path and its optional
+// alias, which are separated by a colon (eg: /real/path:/alias/path).
+// Colons in the path can be escaped with a backslash (eg: a\:b:/alias) or
+// the alias can be separated with aliasSep instead. Windows volume names
+// (eg: C:) aren't separators.
+func splitAlias(p string) (string, string, error) {
+	if src, alias, ok := strings.Cut(p, aliasSep); ok {
+		if src == "" || alias == "" {
+			return "", "", fmt.Errorf("invalid alias format '%s'", p)
+		}
+		return src, alias, nil
+	}
+
+	var (
+		vol   = filepath.VolumeName(p)
+		parts = []string{vol}
+	)
+	for i := len(vol); i < len(p); i++ {
+		switch {
+		case p[i] == '\\' && i+1 < len(p) && p[i+1] == ':':
+			parts[len(parts)-1] += ":"
+			i++
+		case p[i] == ':':
+			parts = append(parts, "")
+		default:
+			parts[len(parts)-1] += p[i : i+1]
+		}
+	}
+
+	switch len(parts) {
+	case 1:
+		return parts[0], "", nil
+	case 2:
+		return parts[0], parts[1], nil
+	}
+	return "", "", fmt.Errorf("invalid alias format '%s'. Escape colons in paths with \\: or separate the alias with %s", p, aliasSep)
+}
+
+// joinAlias returns the path to stuff for a source path and an optional
+// alias (see splitAlias). Colons in the source path are escaped, or
+// aliasSep separates the alias if it has colons too.
+func joinAlias(src, alias string) string {
+	if strings.Contains(alias, ":") {
+		return src + aliasSep + alias
+	}
+
+	vol := filepath.VolumeName(src)
+	p := vol + strings.ReplaceAll(src[len(vol):], ":", `\:`)
+	if alias != "" {
+		p += ":" + alias
+	}
+	return p
+}
+
+// matchAny checks whether the base name or the whole of the given
+// path matches any of the given glob patterns.
+func matchAny(patterns []string, p string) bool {
+	base := filepath.Base(p)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRewrites parses a list of sed style rewrite rules in the form
+// s<d>pattern<d>replacement<d> where <d> is any delimiter character
+// (eg: s|^frontend/dist|/admin|).
+func parseRewrites(rules []string) ([]rewriteRule, error) {
+	out := make([]rewriteRule, 0, len(rules))
+	for _, r := range rules {
+		if len(r) < 4 || r[0] != 's' {
+			return nil, fmt.Errorf("invalid rewrite rule '%s'", r)
+		}
+
+		d := r[1:2]
+		parts := strings.Split(r[2:], d)
+		if len(parts) != 3 || parts[2] != "" {
+			return nil, fmt.Errorf("invalid rewrite rule '%s'", r)
+		}
+
+		re, err := regexp.Compile(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite rule '%s': %v", r, err)
+		}
+		out = append(out, rewriteRule{re: re, repl: parts[1]})
+	}
+
+	return out, nil
+}
+
+// rewritePath applies the rewrite rules in order to the slash separated
+// form of the given local path.
+func rewritePath(rules []rewriteRule, p string) string {
+	if len(rules) == 0 {
+		return p
+	}
+
+	p = filepath.ToSlash(p)
+	for _, r := range rules {
+		p = r.re.ReplaceAllString(p, r.repl)
+	}
+	return filepath.FromSlash(p)
+}
+
+// isHidden checks whether a file or directory name is a dotfile.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// checkExclude validates a list of exclude patterns.
+func checkExclude(patterns []string) error {
+	for _, p := range patterns {
+		for _, seg := range strings.Split(p, "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("invalid exclude pattern '%s': %v", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isExcluded checks whether the given local path or any of its trailing
+// sub-paths (eg: b/c and c for a/b/c) match any of the exclude patterns.
+// In addition to the filepath.Match syntax, a ** segment in a pattern
+// matches zero or more directories.
+func isExcluded(patterns []string, p string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+
+	parts := strings.Split(filepath.ToSlash(p), "/")
+	for _, pattern := range patterns {
+		segs := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+		for i := range parts {
+			if matchSegments(segs, parts[i:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against glob pattern segments
+// where a ** segment matches zero or more path segments.
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+
+	return len(parts) == 0
+}
+
 // makeID takes the individual ID fields and returns an ID.
 func makeID(name [8]byte, binLen, zipLen uint64) ID {
 	return ID{
 		Name:    name,
 		BinSize: binLen,
 		ZipSize: zipLen,
+		Version: idVersion1,
+	}
+}
+
+// payloadOffset returns the offset of the payload in the file.
+func (id ID) payloadOffset() uint64 {
+	if id.Offset > 0 {
+		return id.Offset
 	}
+	return id.BinSize
 }
 
-// makeIDBytes takes the values of an ID and returns them as a byte slice.
+// makeIDBytes takes the values of an ID and returns them as a byte slice
+// in the v1 or v2 format depending on the ID's version.
 func makeIDBytes(id ID) []byte {
+	if id.Version >= idVersion2 {
+		b := make([]byte, lenIDBody+lenIDFooter)
+		b[0] = id.Version
+		b[1] = byte(id.Codec)
+		binary.BigEndian.PutUint16(b[2:4], id.Flags)
+		binary.BigEndian.PutUint64(b[4:12], id.BinSize)
+		binary.BigEndian.PutUint64(b[12:20], id.ZipSize)
+		binary.BigEndian.PutUint64(b[20:28], id.Offset)
+		binary.BigEndian.PutUint32(b[28:32], id.HeaderSize)
+		copy(b[32:64], id.Checksum[:])
+		binary.BigEndian.PutUint32(b[64:68], uint32(len(id.meta)))
+		binary.BigEndian.PutUint32(b[68:72], uint32(len(id.signature)))
+		copy(b[72:88], id.Salt[:])
+		copy(b[88:96], id.Nonce[:])
+		binary.BigEndian.PutUint32(b[96:100], uint32(len(id.wrappedKey)))
+		binary.BigEndian.PutUint32(b[100:104], uint32(len(id.Sidecar)))
+		binary.BigEndian.PutUint32(b[lenIDBody:lenIDBody+4], lenIDBody)
+		copy(b[lenIDBody+4:], id.Name[:])
+
+		out := make([]byte, 0, len(id.Sidecar)+len(id.wrappedKey)+len(id.signature)+len(id.meta)+len(b))
+		out = append(out, id.Sidecar...)
+		out = append(out, id.wrappedKey...)
+		out = append(out, id.signature...)
+		out = append(out, id.meta...)
+		return append(out, b...)
+	}
+
 	b := make([]byte, lenID)
 	copy(b[0:8], id.Name[:])
 	binary.BigEndian.PutUint64(b[8:16], id.BinSize)
@@ -1,10 +1,15 @@
 package stuffbin
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"testing"
@@ -21,6 +26,7 @@ var mockID = ID{
 	Name:    [8]byte{'s', 't', 'u', 'f', 'f', 'b', 'i', 'n'},
 	BinSize: mockExeSize,
 	ZipSize: mockZipSize,
+	Version: 1,
 }
 
 var (
@@ -43,6 +49,53 @@ func TestMakeIDBytes(t *testing.T) {
 		b)
 }
 
+func TestMakeIDBytesV2(t *testing.T) {
+	id := ID{Name: buildName, BinSize: 512, ZipSize: 338, Version: 2, Codec: CodecZstd}
+	b := makeIDBytes(id)
+	assert(t, "ID length", lenIDBody+lenIDFooter, len(b))
+
+	id2, err := readID(bytes.NewReader(append([]byte("binary"), b...)), int64(len(b)+6))
+	assert(t, "error reading ID", nil, err)
+	assert(t, "mismatch in ID", id, id2)
+
+	_, err = readID(bytes.NewReader([]byte("not stuffed")), 11)
+	assert(t, "expected ErrNoID", ErrNoID, err)
+}
+
+func TestIDMeta(t *testing.T) {
+	id := ID{Name: buildName, BinSize: 512, ZipSize: 338, Version: 2}
+	id.setMeta(map[string]string{"version": "1.2.0"})
+	b := append([]byte("binary"), makeIDBytes(id)...)
+
+	id2, err := readID(bytes.NewReader(b), int64(len(b)))
+	assert(t, "error reading ID", nil, err)
+	assert(t, "mismatch in ID", true, id == id2)
+	assert(t, "mismatch in ID meta", "1.2.0", id2.Extra().Meta["version"])
+
+	// The meta returned by Extra is a copy.
+	id2.Extra().Meta["version"] = "2.0.0"
+	assert(t, "modified ID meta", "1.2.0", id2.Extra().Meta["version"])
+
+	// A meta length that's beyond the start of the file is invalid.
+	_, err = readID(bytes.NewReader(b[6:]), int64(len(b)-6))
+	assert(t, "error reading ID without preceding data", nil, err)
+	_, err = readID(bytes.NewReader(b[7:]), int64(len(b)-7))
+	assert(t, "expected error on truncated meta", true, err != nil)
+
+	_, _, err = StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{Meta: id.Extra().Meta}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+	defer os.Remove(mockBinStuffed2)
+
+	id2, err = GetFileID(mockBinStuffed2)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID version", 2, id2.Version)
+	assert(t, "mismatch in ID meta", id.Extra().Meta, id2.Extra().Meta)
+
+	fs, err := UnStuff(mockBinStuffed2)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "file count", len(localFiles), fs.Len())
+}
+
 func TestStuff(t *testing.T) {
 	exeSize, zipSize, err := Stuff(mockBin, mockBinReStuffed, "/", localFiles...)
 	assert(t, "error stuffing", nil, err)
@@ -53,6 +106,13 @@ func TestStuff(t *testing.T) {
 	assert(t, "error stuffing", nil, err)
 	assert(t, fmt.Sprintf("stuffed bin size doesn't match: exe %d + %d zip + %d id = %d", exeSize, zipSize, lenID, s.Size()), s.Size(), exeSize+zipSize+lenID)
 
+	// The streamed payload should be identical to an in-memory ZIP.
+	b, err := GetStuff(mockBinReStuffed)
+	assert(t, "error getting stuff", nil, err)
+	z, err := zipFiles(StuffOpt{}, localFiles...)
+	assert(t, "error zipping files", nil, err)
+	assert(t, "streamed payload mismatch", true, bytes.Equal(z.Bytes(), b))
+
 	// Stuff it again. It should have the same size.
 	exeSize2, zipSize2, err2 := Stuff(mockBinReStuffed, mockBinReStuffed, "/", "mock/bar.txt")
 	assert(t, "error stuffing", nil, err2)
@@ -65,6 +125,319 @@ func TestStuff(t *testing.T) {
 	_ = os.Remove(mockBinReStuffed)
 }
 
+func TestStuffZstd(t *testing.T) {
+	binSize, zSize, err := StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{Codec: CodecZstd}, "mock/")
+	assert(t, "error stuffing", nil, err)
+	defer os.Remove(mockBinStuffed2)
+
+	s, err := os.Stat(mockBinStuffed2)
+	assert(t, "error stuffing", nil, err)
+	assert(t, "stuffed bin size", binSize+zSize+lenIDBody+lenIDFooter, s.Size())
+
+	id, err := GetFileID(mockBinStuffed2)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID version", 2, id.Version)
+	assert(t, "ID codec", CodecZstd, id.Codec)
+	assert(t, "ID bin size", mockExeSize, id.BinSize)
+	assert(t, "ID zip size", zSize, id.ZipSize)
+
+	fs, err := UnStuff(mockBinStuffed2)
+	assert(t, "error unstuffing", nil, err)
+	b, err := fs.Read("/mock/subdir/baz.txt")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in unstuffed file", "baz\n", string(b))
+
+	// Stuffing over an existing bigger file should not leave its remnants.
+	_, zSize, err = StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{Codec: CodecZstd}, "mock/bar.txt")
+	assert(t, "error stuffing", nil, err)
+	id, err = GetFileID(mockBinStuffed2)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID zip size", zSize, id.ZipSize)
+
+	// Restuffing with a different codec should replace the payload.
+	_, zSize, err = Stuff(mockBinStuffed2, mockBinStuffed2, "/", localFiles...)
+	assert(t, "error restuffing", nil, err)
+	id, err = GetFileID(mockBinStuffed2)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID version", 1, id.Version)
+	assert(t, "ID codec", CodecZip, id.Codec)
+	assert(t, "ID bin size", mockExeSize, id.BinSize)
+	assert(t, "ID zip size", zSize, id.ZipSize)
+}
+
+func TestStuffAdd(t *testing.T) {
+	_, _, err := StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{Codec: CodecZstd, Brotli: []string{"*.go"}}, "mock/mock.go", "mock/foo.txt")
+	assert(t, "error stuffing", nil, err)
+	defer os.Remove(mockBinStuffed2)
+
+	// Overwrite foo.txt, drop the stale mock.go.br, and add bar.txt.
+	_, _, err = StuffAdd(mockBinStuffed2, mockBinStuffed2, "/", "mock/bar.txt", "mock/bar.txt:/mock/foo.txt", "mock/foo.txt:/mock/mock.go")
+	assert(t, "error adding", nil, err)
+
+	id, err := GetFileID(mockBinStuffed2)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID codec", CodecZstd, id.Codec)
+	assert(t, "ID bin size", mockExeSize, id.BinSize)
+
+	fs, err := UnStuff(mockBinStuffed2)
+	assert(t, "error unstuffing", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in stuffed file paths", []string{"/mock/bar.txt", "/mock/foo.txt", "/mock/mock.go"}, f)
+
+	b, err := fs.Read("/mock/foo.txt")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in overwritten file", "bar", string(b))
+
+	// Adding to an unstuffed binary is the same as stuffing.
+	_, _, err = StuffAdd(mockBin, mockBinStuffed2, "/", "mock/bar.txt")
+	assert(t, "error adding", nil, err)
+	fs, err = UnStuff(mockBinStuffed2)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "mismatch in stuffed file paths", []string{"/mock/bar.txt"}, fs.List())
+}
+
+func TestStuffRemove(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "app")
+	o := StuffOpt{
+		Codec:    CodecZstd,
+		Brotli:   []string{"*.go"},
+		Checksum: true,
+		Meta:     map[string]string{MetaVersion: "1.0.0"},
+	}
+	_, _, err := StuffWithOpt(mockBin, bin, o, "mock/mock.go", "mock/foo.txt", "mock/bar.txt", "mock/subdir")
+	assert(t, "error stuffing", nil, err)
+
+	// Remove mock.go along with its brotli copy, and subdir.
+	_, _, err = StuffRemove(bin, bin, "/mock/mock.go", "/mock/subdir/**")
+	assert(t, "error removing", nil, err)
+
+	id, err := GetFileID(bin)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID codec", CodecZstd, id.Codec)
+	assert(t, "ID meta", "1.0.0", id.Extra().Meta[MetaVersion])
+	assert(t, "ID checksum", FlagChecksum, id.Flags&FlagChecksum)
+
+	fs, err := UnStuff(bin)
+	assert(t, "error unstuffing", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in stuffed file paths", []string{"/mock/bar.txt", "/mock/foo.txt"}, f)
+
+	// Patterns that don't match are rejected.
+	_, _, err = StuffRemove(bin, bin, "/mock/nope.txt")
+	assert(t, "removed a missing file", true, err != nil)
+	_, _, err = StuffRemove(mockBin, bin, "/mock/foo.txt")
+	assert(t, "removed from an unstuffed binary", ErrNoID, err)
+}
+
+func TestStuffInPlace(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		bin = filepath.Join(dir, "app")
+	)
+	b, err := ioutil.ReadFile(mockBin)
+	assert(t, "error reading file", nil, err)
+	assert(t, "error writing file", nil, ioutil.WriteFile(bin, b, 0700))
+
+	_, _, err = Stuff(bin, bin, "/", localFiles...)
+	assert(t, "error stuffing in-place", nil, err)
+	stuffed, err := ioutil.ReadFile(bin)
+	assert(t, "error reading file", nil, err)
+
+	// A failed in-place stuff should leave the binary intact.
+	_, _, err = Stuff(bin, bin, "/", "mock/nonexistent")
+	assert(t, "expected error on missing file", true, err != nil)
+	b, err = ioutil.ReadFile(bin)
+	assert(t, "error reading file", nil, err)
+	assert(t, "binary changed after failed stuffing", true, bytes.Equal(stuffed, b))
+
+	_, err = Strip(bin, bin)
+	assert(t, "error stripping in-place", nil, err)
+	b, err = ioutil.ReadFile(bin)
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in stripped binary", mockExeSize, len(b))
+
+	stat, err := os.Stat(bin)
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in file mode", os.FileMode(0700), stat.Mode().Perm())
+
+	// No temporary files should be left behind.
+	files, err := os.ReadDir(dir)
+	assert(t, "error reading dir", nil, err)
+	assert(t, "file count", 1, len(files))
+}
+
+func TestStripKeepPayload(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		bin = filepath.Join(dir, "app")
+	)
+	_, _, err := StuffWithOpt(mockBin, bin, StuffOpt{Checksum: true, Meta: map[string]string{"version": "1.0"}}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+	stuffed, err := os.ReadFile(bin)
+	assert(t, "error reading file", nil, err)
+
+	// The payload and its ID are kept next to the binary stripped in-place.
+	_, err = StripWithOpt(bin, bin, StripOpt{KeepPayload: true})
+	assert(t, "error stripping", nil, err)
+	_, err = GetFileID(bin)
+	assert(t, "expected no ID in stripped binary", ErrNoID, err)
+	files, err := os.ReadDir(dir)
+	assert(t, "error reading dir", nil, err)
+	assert(t, "file count", 2, len(files))
+
+	// Attaching it restores the stuffed binary.
+	_, _, err = Attach(bin, bin, bin+SidecarExt)
+	assert(t, "error attaching", nil, err)
+	b, err := os.ReadFile(bin)
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in attached binary", true, bytes.Equal(stuffed, b))
+
+	// Corrupt payloads aren't attached.
+	p, err := os.ReadFile(bin + SidecarExt)
+	assert(t, "error reading payload", nil, err)
+	p[0] ^= 0xff
+	assert(t, "error writing payload", nil, os.WriteFile(bin+SidecarExt, p, 0644))
+	_, _, err = Attach(bin, filepath.Join(dir, "bad"), bin+SidecarExt)
+	assert(t, "expected checksum error", true, errors.Is(err, ErrChecksum))
+	_, _, err = Attach(bin, filepath.Join(dir, "bad"), bin+SidecarExt[:len(SidecarExt)-1])
+	assert(t, "expected error on missing payload", true, err != nil)
+}
+
+func TestStuffAtomic(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		bin = filepath.Join(dir, "app")
+		out = filepath.Join(dir, "stuffed")
+	)
+	b, err := ioutil.ReadFile(mockBin)
+	assert(t, "error reading file", nil, err)
+	assert(t, "error writing file", nil, ioutil.WriteFile(bin, b, 0750))
+
+	// The output should get the input's mode.
+	_, _, err = Stuff(bin, out, "/", localFiles...)
+	assert(t, "error stuffing", nil, err)
+	stat, err := os.Stat(out)
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in file mode", os.FileMode(0750), stat.Mode().Perm())
+	stuffed, err := ioutil.ReadFile(out)
+	assert(t, "error reading file", nil, err)
+
+	// A failed stuff should leave an existing output intact.
+	_, _, err = Stuff(bin, out, "/", "mock/nonexistent")
+	assert(t, "expected error on missing file", true, err != nil)
+	b, err = ioutil.ReadFile(out)
+	assert(t, "error reading file", nil, err)
+	assert(t, "output changed after failed stuffing", true, bytes.Equal(stuffed, b))
+
+	files, err := os.ReadDir(dir)
+	assert(t, "error reading dir", nil, err)
+	assert(t, "file count", 2, len(files))
+}
+
+func TestStuffFS(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "stuffed")
+
+	// Transform the files in memory before stuffing them.
+	fs, err := NewFS()
+	assert(t, "error creating FS", nil, err)
+	for _, p := range localFiles {
+		b, err := ioutil.ReadFile(p)
+		assert(t, "error reading file", nil, err)
+		stat, err := os.Stat(p)
+		assert(t, "error reading file", nil, err)
+		assert(t, "error adding file", nil, fs.Add(NewFile("/"+p, stat, bytes.ToUpper(b))))
+	}
+
+	_, _, err = StuffFSWithOpt(mockBin, out, StuffOpt{Exclude: []string{"mock/foo.txt"}}, fs)
+	assert(t, "error stuffing", nil, err)
+
+	ufs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "mismatch in unstuffed file paths", []string{"/mock/bar.txt"}, ufs.List())
+	b, err := ufs.Read("/mock/bar.txt")
+	assert(t, "error reading file", nil, err)
+	orig, err := fs.Read("/mock/bar.txt")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in file contents", string(orig), string(b))
+}
+
+func TestStuffTransform(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "stuffed")
+
+	o := StuffOpt{Transform: []TransformFunc{
+		// Drop bar.txt.
+		func(p string, b []byte) (string, []byte, error) {
+			if p == "/mock/bar.txt" {
+				return "", nil, nil
+			}
+			return p, b, nil
+		},
+		// Rename and change the rest.
+		func(p string, b []byte) (string, []byte, error) {
+			return "/static/" + filepath.Base(p), append([]byte("// license\n"), b...), nil
+		},
+	}}
+	_, _, err := StuffWithOpt(mockBin, out, o, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	fs, err := UnStuff(out)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "mismatch in file paths", []string{"/static/foo.txt"}, fs.List())
+
+	orig, err := ioutil.ReadFile("mock/foo.txt")
+	assert(t, "error reading file", nil, err)
+	b, err := fs.Read("/static/foo.txt")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in transformed file", "// license\n"+string(orig), string(b))
+	f, err := fs.Get("/static/foo.txt")
+	assert(t, "error getting file", nil, err)
+	stat, err := f.Stat()
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in file size", int64(len(b)), stat.Size())
+
+	// Errors abort stuffing.
+	o.Transform = []TransformFunc{func(p string, b []byte) (string, []byte, error) {
+		return "", nil, os.ErrInvalid
+	}}
+	_, _, err = StuffWithOpt(mockBin, out, o, localFiles...)
+	assert(t, "expected transform error", true, err != nil)
+}
+
+func TestStuffTo(t *testing.T) {
+	bin, err := ioutil.ReadFile(mockBin)
+	assert(t, "error reading file", nil, err)
+	fs, err := NewLocalFS("/", localFiles...)
+	assert(t, "error creating FS", nil, err)
+
+	var out bytes.Buffer
+	binSize, zLen, err := StuffTo(&out, bytes.NewReader(bin), fs)
+	assert(t, "error stuffing", nil, err)
+	assert(t, "mismatch in bin size", int64(mockExeSize), binSize)
+	assert(t, "mismatch in output size", binSize+zLen+lenID, int64(out.Len()))
+
+	fs, err = UnStuffFrom(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	assert(t, "error unstuffing", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in unstuffed file paths", stuffedFiles, f)
+
+	// Restuffing should replace the old payload.
+	var restuffed bytes.Buffer
+	binSize, _, err = StuffTo(&restuffed, bytes.NewReader(out.Bytes()), fs)
+	assert(t, "error restuffing", nil, err)
+	assert(t, "mismatch in restuffed bin size", int64(mockExeSize), binSize)
+
+	var stripped bytes.Buffer
+	_, err = StripTo(&stripped, bytes.NewReader(restuffed.Bytes()))
+	assert(t, "error stripping", nil, err)
+	assert(t, "stripped binary doesn't match the original", true, bytes.Equal(bin, stripped.Bytes()))
+
+	_, _, err = StuffToWithOpt(&out, bytes.NewReader(bin), StuffOpt{Section: true}, fs)
+	assert(t, "expected error with Section", true, err != nil)
+}
+
 func TestStuffCustomRoot(t *testing.T) {
 	_, _, err := Stuff(mockBin, mockBinStuffed2, "/root/", localFiles...)
 	assert(t, "error stuffing", nil, err)
@@ -85,11 +458,57 @@ func TestGetFileID(t *testing.T) {
 	assert(t, "error matching file ID", mockID, id)
 }
 
+func TestGetFileIDTrailer(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "trailer.exe")
+	for _, o := range []StuffOpt{{}, {Checksum: true, Meta: map[string]string{"version": "1.0"}}, {Sidecar: true}} {
+		_, zLen, err := StuffWithOpt(mockBin, out, o, localFiles...)
+		assert(t, "error stuffing", nil, err)
+		want, err := GetFileID(out)
+		assert(t, "error getting file ID", nil, err)
+
+		// Data appended after stuffing, including the name of the ID.
+		b, err := os.ReadFile(out)
+		assert(t, "error reading file", nil, err)
+		b = append(b, []byte("installer stuffbin trailer")...)
+		b = append(b, make([]byte, 4096)...)
+		assert(t, "error writing file", nil, os.WriteFile(out, b, 0755))
+
+		id, err := GetFileID(out)
+		assert(t, "error getting file ID", nil, err)
+		assert(t, "mismatch in file ID", want, id)
+		assert(t, "mismatch in zip size", uint64(zLen), id.ZipSize)
+
+		fs, err := UnStuff(out)
+		assert(t, "error unstuffing", nil, err)
+		f := fs.List()
+		sort.Strings(f)
+		assert(t, "mismatch in unstuffed file paths", stuffedFiles, f)
+	}
+
+	// v1 IDs.
+	want, err := GetFileID(mockBinStuffed)
+	assert(t, "error getting file ID", nil, err)
+	b, err := os.ReadFile(mockBinStuffed)
+	assert(t, "error reading file", nil, err)
+	b = append(b, []byte("trailer")...)
+	assert(t, "error writing file", nil, os.WriteFile(out, b, 0755))
+	id, err := GetFileID(out)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "mismatch in file ID", want, id)
+
+	// Trailers beyond the scanned size aren't scanned.
+	b = append(b, make([]byte, maxTrailer)...)
+	assert(t, "error writing file", nil, os.WriteFile(out, b, 0755))
+	_, err = GetFileID(out)
+	assert(t, "mismatch in error", ErrNoID, err)
+}
+
 func TestZipFiles(t *testing.T) {
 	// Zip some files including a file with an alias.
 	f := []string{"mock/foo.txt:/test/foo.txt"}
 	f = append(f, localFiles...)
-	b, err := zipFiles("/", f...)
+	b, err := zipFiles(StuffOpt{RootPath: "/"}, f...)
 	assert(t, "error zipping files", nil, err)
 
 	// Unzip the files and check if they're all there including
@@ -104,6 +523,79 @@ func TestZipFiles(t *testing.T) {
 	assert(t, "mismatch in zipped file paths", f, f2)
 }
 
+func TestSplitAlias(t *testing.T) {
+	for _, c := range []struct {
+		p, src, alias string
+	}{
+		{"mock/foo.txt", "mock/foo.txt", ""},
+		{"mock/foo.txt:/foo.txt", "mock/foo.txt", "/foo.txt"},
+		{`dist/a\:b.txt:/b.txt`, "dist/a:b.txt", "/b.txt"},
+		{`dist/a\:b.txt`, "dist/a:b.txt", ""},
+		{"dist/a:b.txt=>/b.txt", "dist/a:b.txt", "/b.txt"},
+		{"dist=>/c:d", "dist", "/c:d"},
+	} {
+		src, alias, err := splitAlias(c.p)
+		assert(t, "error splitting "+c.p, nil, err)
+		assert(t, "mismatch in path of "+c.p, c.src, src)
+		assert(t, "mismatch in alias of "+c.p, c.alias, alias)
+
+		src, alias, err = splitAlias(joinAlias(c.src, c.alias))
+		assert(t, "error splitting joined "+c.p, nil, err)
+		assert(t, "mismatch in joined path of "+c.p, c.src, src)
+		assert(t, "mismatch in joined alias of "+c.p, c.alias, alias)
+	}
+
+	for _, p := range []string{"a:b:c", "=>/a", "a=>"} {
+		_, _, err := splitAlias(p)
+		assert(t, "expected error on "+p, true, err != nil)
+	}
+
+	// Paths with colons can be stuffed.
+	dir := filepath.Join(t.TempDir(), "a:b")
+	assert(t, "error creating dir", nil, os.Mkdir(dir, 0755))
+	assert(t, "error writing file", nil, os.WriteFile(filepath.Join(dir, "c.txt"), []byte("c"), 0644))
+	for _, p := range []string{joinAlias(dir, "/static"), dir + aliasSep + "/static"} {
+		b, err := zipFiles(StuffOpt{}, p)
+		assert(t, "error zipping "+p, nil, err)
+		fs, err := UnZip(b.Bytes())
+		assert(t, "error unzipping", nil, err)
+		assert(t, "mismatch in zipped file paths", []string{"/static/c.txt"}, fs.List())
+	}
+}
+
+func TestZipFilesOpt(t *testing.T) {
+	// Stored files should be uncompressed while others are compressed.
+	b, err := zipFiles(StuffOpt{CompressionLevel: flate.BestCompression, Store: []string{"*.go"}}, "mock/mock.go", "mock/foo.txt")
+	assert(t, "error zipping files", nil, err)
+
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert(t, "error reading zip", nil, err)
+	methods := map[string]uint16{}
+	for _, f := range r.File {
+		methods[f.Name] = f.Method
+	}
+	assert(t, "stored file method", zip.Store, methods["/mock/mock.go"])
+	assert(t, "compressed file method", zip.Deflate, methods["/mock/foo.txt"])
+
+	// No compression should be bigger than best compression.
+	best, err := zipFiles(StuffOpt{CompressionLevel: flate.BestCompression}, "mock/mock.go")
+	assert(t, "error zipping files", nil, err)
+	fast, err := zipFiles(StuffOpt{CompressionLevel: flate.HuffmanOnly}, "mock/mock.go")
+	assert(t, "error zipping files", nil, err)
+	assert(t, "compression level", true, best.Len() < fast.Len())
+
+	fs, err := UnZip(b.Bytes())
+	assert(t, "error unzipping", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in zipped file paths", []string{"/mock/foo.txt", "/mock/mock.go"}, f)
+
+	_, err = zipFiles(StuffOpt{CompressionLevel: 10}, "mock/mock.go")
+	assert(t, "expected error on invalid level", true, err != nil)
+	_, err = zipFiles(StuffOpt{Store: []string{"["}}, "mock/mock.go")
+	assert(t, "expected error on invalid pattern", true, err != nil)
+}
+
 func setup() {
 	// Generate a fake EXE file with random bytes.
 	b := make([]byte, mockExeSize)
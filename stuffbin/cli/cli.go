@@ -0,0 +1,64 @@
+// Package cli implements the commands of the stuffbin CLI so that build
+// tools (eg: mage targets, custom release CLIs) can run them without
+// exec'ing the stuffbin binary. The Run functions take the options of a
+// command and a logger to which its messages are logged. The output of
+// commands (eg: listings) is written to the options' Output, which
+// defaults to os.Stdout. Errors have the exit codes of the CLI (see
+// ExitCode).
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/knadh/stuffbin"
+)
+
+// output returns a logger that writes the output of a command to w,
+// or to os.Stdout if w is nil.
+func output(w io.Writer) *log.Logger {
+	if w == nil {
+		w = os.Stdout
+	}
+	return log.New(w, "", 0)
+}
+
+// logTo returns l, or the default logger if l is nil.
+func logTo(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
+
+// LogStuffed logs the sizes of a stuffed binary.
+func LogStuffed(l *slog.Logger, binLen, zipLen int64) {
+	logTo(l).Info(fmt.Sprintf("stuffing complete. binary size is %0.2f KB and stuffed zip size is %0.2f KB.",
+		float64(binLen)/1024, float64(zipLen)/1024), "bin_size", binLen, "zip_size", zipLen)
+}
+
+// WarnVersion warns if the ID of a stuffed binary has a newer
+// version than the stuffbin package supports.
+func WarnVersion(l *slog.Logger, in string, id stuffbin.ID) {
+	if id.Version > stuffbin.IDVersion {
+		logTo(l).Warn(fmt.Sprintf("%s was stuffed with payload format v%d, which is newer than this stuffbin supports (v%d). Upgrade stuffbin if the payload can't be read",
+			in, id.Version, stuffbin.IDVersion), "path", in, "version", id.Version, "supported", stuffbin.IDVersion)
+	}
+}
+
+// CheckExecutables catches inputs that aren't executables, which
+// are usually swapped arguments, before they ship.
+func CheckExecutables(ins ...string) error {
+	for _, in := range ins {
+		if err := stuffbin.CheckExecutable(in); err != nil {
+			if err == stuffbin.ErrNotExecutable {
+				return WrapError(err, "%s: %v. Check the order of the arguments or use -force to stuff it anyway", in, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/knadh/stuffbin"
+)
+
+func TestRunStuffID(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		dir  = t.TempDir()
+		file = filepath.Join(dir, "a.txt")
+		out  = filepath.Join(dir, "app")
+		logs bytes.Buffer
+		l    = slog.New(slog.NewTextHandler(&logs, nil))
+	)
+	if err := os.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files are stuffed and the result is logged.
+	o := StuffOptions{In: exe, Out: out, Files: []string{file + ":/a.txt"}}
+	if err := RunStuff(o, l); err != nil {
+		t.Fatalf("error stuffing: %v", err)
+	}
+	if !strings.Contains(logs.String(), "stuffing complete") {
+		t.Fatalf("stuffing not logged: %s", logs.String())
+	}
+
+	// The ID and the files are written to Output.
+	var b bytes.Buffer
+	if err := RunID(IDOptions{Path: out, Output: &b}, l); err != nil {
+		t.Fatalf("error getting ID: %v", err)
+	}
+	if !strings.Contains(b.String(), "1 files totalling") || !strings.Contains(b.String(), "/a.txt") {
+		t.Fatalf("unexpected ID output: %s", b.String())
+	}
+
+	b.Reset()
+	if err := RunID(IDOptions{Path: out, JSON: true, Output: &b}, l); err != nil {
+		t.Fatalf("error getting ID: %v", err)
+	}
+	var id struct {
+		Files []stuffbin.Entry `json:"files"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &id); err != nil || len(id.Files) != 1 || id.Files[0].Path != "/a.txt" {
+		t.Fatalf("unexpected JSON ID: %v: %s", err, b.String())
+	}
+
+	// A dry run writes the files to Output and nothing else.
+	b.Reset()
+	if err := RunStuff(StuffOptions{Files: []string{file + ":/b.txt"}, DryRun: true, Output: &b}, l); err != nil {
+		t.Fatalf("error in dry run: %v", err)
+	}
+	if !strings.Contains(b.String(), "/b.txt") {
+		t.Fatalf("unexpected dry run output: %s", b.String())
+	}
+
+	// The largest files are reported after stuffing.
+	b.Reset()
+	o.Files, o.Top, o.Output = append(o.Files, file+":/c.txt"), 1, &b
+	if err := RunStuff(o, l); err != nil {
+		t.Fatalf("error stuffing: %v", err)
+	}
+	if !strings.HasPrefix(b.String(), "largest files:\n") || strings.Count(b.String(), ".txt") != 1 {
+		t.Fatalf("unexpected largest files: %s", b.String())
+	}
+
+	// Manifests are stuffed into multiple binaries.
+	m := stuffbin.Manifest{Files: []stuffbin.ManifestFile{{Src: file, Alias: "/m.txt"}}}
+	targets := []stuffbin.Target{{In: exe, Out: out + "-1"}, {In: exe, Out: out + "-2"}}
+	if err := RunStuff(StuffOptions{Manifest: &m, Targets: targets}, l); err != nil {
+		t.Fatalf("error stuffing targets: %v", err)
+	}
+	for _, tg := range targets {
+		fs, err := stuffbin.UnStuff(tg.Out)
+		if err != nil || fs.List()[0] != "/m.txt" {
+			t.Fatalf("unexpected files in %s: %v", tg.Out, err)
+		}
+	}
+
+	// Errors have the exit codes of the CLI.
+	for _, c := range []struct {
+		err  error
+		code int
+	}{
+		{RunID(IDOptions{Path: exe}, l), ExitNoID},
+		{RunID(IDOptions{Path: filepath.Join(dir, "none")}, l), ExitIO},
+		{RunStuff(StuffOptions{In: exe, Files: []string{file}}, l), ExitUsage},
+		{RunStuff(StuffOptions{In: file, Out: out, Files: []string{file}}, l), ExitUsage},
+	} {
+		if got := ExitCode(c.err); got != c.code {
+			t.Errorf("exit code of '%v': %d != %d", c.err, got, c.code)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/knadh/stuffbin"
+)
+
+// Exit codes of the CLI for the classes of errors, so that
+// scripts can branch on them instead of on error messages.
+const (
+	ExitOK = 0
+
+	// ExitFailed is the exit code of errors that aren't classified.
+	ExitFailed = 1
+
+	// ExitUsage is the exit code of invalid commands, flags, and
+	// arguments. The flag package exits with it too.
+	ExitUsage = 2
+
+	// ExitNoID is the exit code for binaries that aren't stuffed.
+	ExitNoID = 3
+
+	// ExitCorrupt is the exit code for payloads that are corrupt,
+	// truncated, or can't be decrypted.
+	ExitCorrupt = 4
+
+	// ExitIO is the exit code of errors reading or writing files.
+	ExitIO = 5
+
+	// ExitVerify is the exit code of failed verifications and
+	// missing or invalid signatures.
+	ExitVerify = 6
+)
+
+// ExitError is an error with the exit code of its class.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// UsageError returns an error about invalid flags or arguments.
+func UsageError(format string, a ...interface{}) error {
+	return &ExitError{Code: ExitUsage, Err: fmt.Errorf(format, a...)}
+}
+
+// VerifyError returns an error about a failed verification.
+func VerifyError(format string, a ...interface{}) error {
+	return &ExitError{Code: ExitVerify, Err: fmt.Errorf(format, a...)}
+}
+
+// WrapError is fmt.Errorf for a message about the error err
+// that keeps its exit code (see ExitCode).
+func WrapError(err error, format string, a ...interface{}) error {
+	return &ExitError{Code: ExitCode(err), Err: fmt.Errorf(format, a...)}
+}
+
+// ExitCode returns the exit code of the class of an error. Errors of
+// the stuffbin and the os packages are classified.
+func ExitCode(err error) int {
+	var (
+		e    *ExitError
+		pErr *os.PathError
+		lErr *os.LinkError
+		sErr *os.SyscallError
+	)
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, stuffbin.ErrNoID):
+		return ExitNoID
+	case errors.Is(err, stuffbin.ErrNoKey), errors.Is(err, stuffbin.ErrNotExecutable):
+		return ExitUsage
+	case errors.Is(err, stuffbin.ErrSignature):
+		return ExitVerify
+	case errors.Is(err, stuffbin.ErrCorruptPayload), errors.Is(err, stuffbin.ErrTruncated),
+		errors.Is(err, stuffbin.ErrChecksum), errors.Is(err, stuffbin.ErrDecrypt):
+		return ExitCorrupt
+	case errors.As(err, &pErr), errors.As(err, &lErr), errors.As(err, &sErr):
+		return ExitIO
+	}
+	return ExitFailed
+}
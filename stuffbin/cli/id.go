@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/knadh/stuffbin"
+)
+
+// IDOptions are the options of RunID.
+type IDOptions struct {
+	// Path is the path of the stuffed binary.
+	Path string
+
+	// Key decrypts encrypted payloads and files.
+	Key stuffbin.KeyFunc
+
+	// JSON writes the ID and the files as JSON.
+	JSON bool
+
+	// Output is where the ID and the files are written to.
+	Output io.Writer
+}
+
+// RunID writes the ID and the files of a stuffed binary to Output
+// as the id command does.
+func RunID(o IDOptions, l *slog.Logger) error {
+	l = logTo(l)
+
+	id, err := stuffbin.GetFileID(o.Path)
+	if err != nil {
+		if err == stuffbin.ErrNoID {
+			return WrapError(err, "%s: %v", o.Path, err)
+		}
+		return WrapError(err, "error reading file: %v", err)
+	}
+	if o.JSON {
+		return idJSON(o, id)
+	}
+
+	out := output(o.Output)
+	out.Printf("%s: %s format v%d (%0.2f KB binary, %0.2f KB %s stuff)\n\n",
+		o.Path, id.Name, id.Version, float64(id.BinSize)/1024, float64(id.ZipSize)/1024, id.Codec)
+	WarnVersion(l, o.Path, id)
+
+	if id.Flags&stuffbin.FlagSidecar != 0 {
+		out.Printf("sidecar %s\n", id.Sidecar)
+	}
+	if id.Flags&stuffbin.FlagChecksum != 0 {
+		out.Printf("sha256 %x\n", id.Checksum)
+	}
+	if id.Flags&stuffbin.FlagEncrypted != 0 {
+		out.Printf("encrypted (aes-256-gcm)\n")
+	}
+	if id.Flags&stuffbin.FlagEncryptedFiles != 0 {
+		out.Printf("encrypted files (aes-256-gcm)\n")
+	}
+	meta := id.Extra().Meta
+	if len(meta) > 0 {
+		keys := make([]string, 0, len(meta))
+		for k := range meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out.Printf("%s=%s\n", k, meta[k])
+		}
+	}
+	if id.Flags&(stuffbin.FlagSidecar|stuffbin.FlagChecksum|stuffbin.FlagEncrypted|stuffbin.FlagEncryptedFiles) != 0 || len(meta) > 0 {
+		out.Println()
+	}
+
+	// Unstuff and list files. Files that are encrypted
+	// individually are only listed with the key.
+	fs, err := stuffbin.UnStuffWithOpt(o.Path, stuffbin.UnStuffOpt{Key: o.Key})
+	if err != nil {
+		return err
+	}
+
+	out.Printf("%d files totalling %0.2f KB\n", fs.Len(), float64(fs.Size())/1024)
+	for _, p := range fs.List() {
+		f, _ := fs.Get(p)
+		info, err := f.Stat()
+		f.Close()
+		if err != nil {
+			return WrapError(err, "error reading %s: %v", p, err)
+		}
+		out.Printf("%0.2f KB \t\t %s", float64(info.Size())/1024, p)
+	}
+
+	return nil
+}
+
+// idOut is the JSON output of RunID.
+type idOut struct {
+	Path     string            `json:"path"`
+	Name     string            `json:"name"`
+	Version  uint8             `json:"version"`
+	Codec    string            `json:"codec"`
+	BinSize  uint64            `json:"bin_size"`
+	ZipSize  uint64            `json:"zip_size"`
+	Sidecar  string            `json:"sidecar,omitempty"`
+	Checksum string            `json:"checksum,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+
+	Files []stuffbin.Entry `json:"files"`
+}
+
+// idJSON writes the ID and the stuffed files of a binary as JSON.
+func idJSON(o IDOptions, id stuffbin.ID) error {
+	files, err := stuffbin.ListStuff(o.Path, stuffbin.UnStuffOpt{Key: o.Key})
+	if err != nil {
+		return err
+	}
+
+	out := idOut{
+		Path:    o.Path,
+		Name:    strings.TrimRight(string(id.Name[:]), "\x00"),
+		Version: id.Version,
+		Codec:   id.Codec.String(),
+		BinSize: id.BinSize,
+		ZipSize: id.ZipSize,
+		Meta:    id.Extra().Meta,
+		Files:   files,
+	}
+	if id.Flags&stuffbin.FlagSidecar != 0 {
+		out.Sidecar = id.Sidecar
+	}
+	if id.Flags&stuffbin.FlagChecksum != 0 {
+		out.Checksum = hex.EncodeToString(id.Checksum[:])
+	}
+
+	w := o.Output
+	if w == nil {
+		w = os.Stdout
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,239 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"sort"
+
+	"github.com/knadh/stuffbin"
+)
+
+// StuffOptions are the options of RunStuff.
+type StuffOptions struct {
+	// In and Out are the paths of the input and the output binaries.
+	// They're optional with DryRun, and not used with Targets.
+	In, Out string
+
+	// Files are the paths of the local files to embed with optional
+	// aliases (see stuffbin.StuffWithOpt).
+	Files []string
+
+	// Archive is the path of a zip or tar archive whose files to embed
+	// instead of Files with an optional alias (see
+	// stuffbin.StuffArchiveWithOpt).
+	Archive string
+
+	// Manifest is a manifest of the files to embed instead of Files.
+	// Opt should be its StuffOpt with any overrides (see
+	// stuffbin.Manifest.StuffOpt).
+	Manifest *stuffbin.Manifest
+
+	// Targets are the input and output binaries to stuff Files or the
+	// files in Manifest into instead of In and Out, for instance, the
+	// cross-compiled binaries of a release. The files are compressed only
+	// once and the binaries are stuffed in parallel.
+	Targets []stuffbin.Target
+
+	// Opt are the options that the files are stuffed with.
+	Opt stuffbin.StuffOpt
+
+	// Force stuffs inputs that aren't ELF, PE, or Mach-O executables.
+	Force bool
+
+	// DryRun writes the target paths of the files that would be embedded
+	// with their sizes and compressed sizes to Output without writing
+	// the output binaries.
+	DryRun bool
+
+	// Top writes the given number of files with the largest compressed
+	// sizes and with the worst compression ratios (see
+	// stuffbin.LargestFiles) to Output after stuffing.
+	Top int
+
+	// Output is where the files of a dry run and the largest files
+	// are written to.
+	Output io.Writer
+}
+
+// RunStuff compresses files and embeds them into one or more binaries
+// as the stuff command does.
+func RunStuff(o StuffOptions, l *slog.Logger) error {
+	l = logTo(l)
+
+	// Validate the input and output binary paths.
+	ins := []string{o.In}
+	if len(o.Targets) > 0 {
+		if o.Archive != "" || o.In != "" || o.Out != "" {
+			return UsageError("-target can only be used with a manifest or file arguments instead of -in and -out")
+		}
+		ins = ins[:0]
+		for _, t := range o.Targets {
+			ins = append(ins, t.In)
+		}
+	} else if o.In == "" && !o.DryRun {
+		return UsageError("provide an input path")
+	} else if o.Out == "" && !o.DryRun {
+		return UsageError("provide an output path")
+	}
+	if !o.Force && (o.In != "" || len(o.Targets) > 0) {
+		if err := CheckExecutables(ins...); err != nil {
+			return err
+		}
+	}
+
+	opt := o.Opt
+	if o.DryRun {
+		opt.DryRun = printDryRun(o.Output, o.Top)
+	}
+
+	// logDone logs the result of stuffing or of a dry run, and
+	// reports the largest files in the output binary.
+	logDone := func(out string, binLen, zipLen int64) {
+		if o.DryRun {
+			l.Info(fmt.Sprintf("dry run complete. stuffed zip size would be %0.2f KB. nothing was written.", float64(zipLen)/1024),
+				"zip_size", zipLen)
+			return
+		}
+		if o.Top > 0 {
+			reportTop(o, out, l)
+		}
+		LogStuffed(l, binLen, zipLen)
+	}
+
+	// Build from a manifest.
+	if o.Manifest != nil {
+		if len(o.Files) > 0 {
+			return UsageError("provide either a manifest or files to embed, not both")
+		}
+		if len(o.Targets) > 0 {
+			zipLen, err := stuffbin.StuffManifestTargets(o.Targets, *o.Manifest, opt)
+			if err != nil {
+				return WrapError(err, "stuffing failed: %v", err)
+			}
+			logTargets(o, zipLen, l)
+			return nil
+		}
+		binLen, zipLen, err := stuffbin.StuffManifestWithOpt(o.In, o.Out, *o.Manifest, opt)
+		if err != nil {
+			return WrapError(err, "stuffing failed: %v", err)
+		}
+		logDone(o.Out, binLen, zipLen)
+		return nil
+	}
+
+	// Validate the list of files to embed.
+	if o.Archive != "" {
+		if len(o.Files) > 0 {
+			return UsageError("provide either an archive or files to embed, not both")
+		}
+	} else if len(o.Files) == 0 {
+		return UsageError("provide one or more files to embed")
+	}
+
+	// Stuff the files into multiple binaries.
+	if len(o.Targets) > 0 {
+		zipLen, err := stuffbin.StuffTargets(o.Targets, opt, o.Files...)
+		if err != nil {
+			return WrapError(err, "stuffing failed: %v", err)
+		}
+		logTargets(o, zipLen, l)
+		return nil
+	}
+
+	var (
+		binLen, zipLen int64
+		err            error
+	)
+	if o.Archive != "" {
+		binLen, zipLen, err = stuffbin.StuffArchiveWithOpt(o.In, o.Out, opt, o.Archive)
+	} else {
+		binLen, zipLen, err = stuffbin.StuffWithOpt(o.In, o.Out, opt, o.Files...)
+	}
+	if err != nil {
+		return WrapError(err, "stuffing failed: %v", err)
+	}
+	logDone(o.Out, binLen, zipLen)
+	return nil
+}
+
+// logTargets logs the result of stuffing multiple binaries or of a dry
+// run, and reports the largest files in the first output binary.
+func logTargets(o StuffOptions, zipLen int64, l *slog.Logger) {
+	if o.DryRun {
+		l.Info(fmt.Sprintf("dry run complete. stuffed zip size would be %0.2f KB. nothing was written.", float64(zipLen)/1024),
+			"zip_size", zipLen)
+		return
+	}
+	if o.Top > 0 {
+		reportTop(o, o.Targets[0].Out, l)
+	}
+	l.Info(fmt.Sprintf("stuffing complete. stuffed %d binaries. stuffed zip size is %0.2f KB.", len(o.Targets), float64(zipLen)/1024),
+		"binaries", len(o.Targets), "zip_size", zipLen)
+}
+
+// reportTop writes the largest files and the files with the worst
+// compression ratios in the payload of a stuffed binary to Output. As
+// stuffing has succeeded, errors listing the files are only logged.
+func reportTop(o StuffOptions, path string, l *slog.Logger) {
+	key := o.Opt.Key
+	switch {
+	case key != nil:
+	case o.Opt.Passphrase != "":
+		key = stuffbin.Key([]byte(o.Opt.Passphrase))
+	case o.Opt.EncryptionKey != nil:
+		key = stuffbin.Key(o.Opt.EncryptionKey)
+	}
+
+	files, err := stuffbin.ListStuff(path, stuffbin.UnStuffOpt{Key: key})
+	if err != nil {
+		l.Warn(fmt.Sprintf("%s: error listing the largest files: %v", path, err), "path", path, "error", err.Error())
+		return
+	}
+	printTop(output(o.Output), files, o.Top)
+}
+
+// printTop prints up to n of the files with the largest compressed
+// sizes and with the worst compression ratios.
+func printTop(out *log.Logger, files []stuffbin.Entry, n int) {
+	for _, t := range []struct {
+		name  string
+		files []stuffbin.Entry
+	}{
+		{"largest files", stuffbin.LargestFiles(files, n)},
+		{"worst compression ratios", stuffbin.WorstCompressed(files, n)},
+	} {
+		if len(t.files) == 0 {
+			continue
+		}
+		out.Printf("%s:", t.name)
+		for _, f := range t.files {
+			out.Printf("%10d %10d %6.1f%% %s", f.Size, f.CompressedSize, f.Ratio()*100, f.Path)
+		}
+	}
+}
+
+// printDryRun returns a function that prints the files of a dry run to w
+// sorted by their target paths with their sizes, compressed sizes, and
+// compression ratios, followed by up to top of the largest files.
+func printDryRun(w io.Writer, top int) func(files []stuffbin.Entry) {
+	out := output(w)
+	return func(files []stuffbin.Entry) {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Path < files[j].Path
+		})
+
+		var size, zSize uint64
+		for _, f := range files {
+			out.Printf("%10d %10d %6.1f%% %s", f.Size, f.CompressedSize, f.Ratio()*100, f.Path)
+			size += f.Size
+			zSize += f.CompressedSize
+		}
+		out.Printf("%d files totalling %0.2f KB, %0.2f KB compressed", len(files), float64(size)/1024, float64(zSize)/1024)
+
+		if top > 0 {
+			printTop(out, files, top)
+		}
+	}
+}
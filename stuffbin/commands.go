@@ -0,0 +1,1260 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/knadh/stuffbin"
+	"github.com/knadh/stuffbin/stuffbin/cli"
+)
+
+// command is a stuffbin subcommand with its own flags.
+type command struct {
+	name string
+	desc string
+	run  func(args []string) error
+}
+
+var commands = []command{
+	{aStuff, "compress files and embed them into a binary", runStuff},
+	{aBuild, "run go build and stuff files into the built binary", runBuild},
+	{aWatch, "stuff files into a binary again whenever they change", runWatch},
+	{aAdd, "add files to the payload of a stuffed binary", runAdd},
+	{aRemove, "remove files from the payload of a stuffed binary", runRemove},
+	{aRepack, "re-compress the payload of a stuffed binary with other options", runRepack},
+	{aID, "show the ID and the files of a stuffed binary", runID},
+	{aList, "list the files of a stuffed binary", runList},
+	{aCat, "print a file in a stuffed binary", runCat},
+	{aServe, "serve the files of a stuffed binary over HTTP", runServe},
+	{aUnstuff, "write the ZIP payload of a stuffed binary to a file", runUnstuff},
+	{aExtract, "extract the files of a stuffed binary into a directory", runExtract},
+	{aStrip, "strip the payload from a stuffed binary", runStrip},
+	{aAttach, "attach a payload kept by strip -keep-payload to a binary", runAttach},
+	{aVerify, "verify the payload and the files of a stuffed binary", runVerify},
+	{aEncrypt, "encrypt the payload of a stuffed binary", runEncrypt},
+	{aDecrypt, "decrypt the payload of a stuffed binary", runDecrypt},
+	{aSign, "sign the payload of a stuffed binary with an Ed25519 key", runSign},
+	{aVerifySig, "verify the Ed25519 signature of a stuffed binary", runVerifySig},
+	{aChecksum, "print the SHA-256 hashes of the payload and the files of a stuffed binary", runChecksum},
+	{aDiff, "list the files that changed between two stuffed binaries or make a patch", runDiff},
+	{aPatch, "apply a patch to a binary", runPatch},
+	{aVersion, "show the version of stuffbin and the payload formats it supports", runVersion},
+}
+
+// getCommand returns the command with the given name.
+func getCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// newFlagSet returns the flag set of a command with the
+// given usage line (after the flags) and help text.
+func newFlagSet(name, usage, help string) *flag.FlagSet {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	f.Usage = func() {
+		logger.Println(strings.TrimSpace("Usage: stuffbin " + name + " [flags] " + usage))
+		logger.Println(help)
+		f.PrintDefaults()
+	}
+	f.BoolVar(&logOpt.quiet, "q", false, "(optional) only log warnings and errors")
+	f.BoolVar(&logOpt.verbose, "v", false, "(optional) also log details, eg: every stuffed file")
+	f.StringVar(&logOpt.format, "log-format", "text", "(optional) format of the messages that are logged to stderr (text, json)")
+	return f
+}
+
+// parse parses the arguments of a command and expands
+// $VARS and ~ in the given path flags and the arguments.
+func parse(f *flag.FlagSet, args []string, paths ...*string) ([]string, error) {
+	args, err := parseRaw(f, args, paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]string, len(args))
+	for n, a := range args {
+		if out[n], err = stuffbin.ExpandPath(a); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// parseRaw is parse that returns the arguments as they are, for instance,
+// glob patterns. Flags can be given after the arguments (eg: serve app.bin
+// -addr :8080) up to a -- after which all arguments are positional.
+func parseRaw(f *flag.FlagSet, args []string, paths ...*string) ([]string, error) {
+	var out []string
+	for {
+		f.Parse(args)
+		rest := f.Args()
+		if n := len(args) - len(rest); len(rest) == 0 || (n > 0 && args[n-1] == "--") {
+			out = append(out, rest...)
+			break
+		}
+		out = append(out, rest[0])
+		args = rest[1:]
+	}
+	if err := setupLog(logOpt); err != nil {
+		return nil, cli.UsageError("%v", err)
+	}
+
+	for _, p := range paths {
+		v, err := stuffbin.ExpandPath(*p)
+		if err != nil {
+			return nil, cli.UsageError("%v", err)
+		}
+		*p = v
+	}
+	return out, nil
+}
+
+// keyFlags adds the flags of the passphrase and the age identity to a flag
+// set and returns a function that returns the passphrase and the key.
+func keyFlags(f *flag.FlagSet, action string) func() (string, stuffbin.KeyFunc, error) {
+	var (
+		fPass  = f.String("passphrase-env", "", fmt.Sprintf("(optional) name of the environment variable with the passphrase to %s the payload with", action))
+		fIdent = f.String("identity", "", "(optional) path to an age identity file to decrypt payloads encrypted to age recipients with")
+	)
+
+	return func() (string, stuffbin.KeyFunc, error) {
+		// The passphrase is read from the environment to keep it
+		// out of the shell history and the process list.
+		var (
+			pass string
+			key  stuffbin.KeyFunc
+		)
+		if *fPass != "" {
+			if pass = os.Getenv(*fPass); pass == "" {
+				return "", nil, cli.UsageError("environment variable %s is empty", *fPass)
+			}
+			key = stuffbin.Key([]byte(pass))
+		}
+		if *fIdent != "" {
+			if key != nil {
+				return "", nil, cli.UsageError("provide either -passphrase-env or -identity, not both")
+			}
+			ident, err := stuffbin.ExpandPath(*fIdent)
+			if err != nil {
+				return "", nil, err
+			}
+			key = stuffbin.IdentityFile(ident)
+		}
+		return pass, key, nil
+	}
+}
+
+// stuffFlags are the flags of the options that files are stuffed with.
+type stuffFlags struct {
+	root, codec, store, brotli, dict, dictFile, excl, enc, minify *string
+	ver, commit, max, maxFile, sign, signKey                      *string
+	level                                                         *int
+	hidden, noRec, gitIgn, incr, sect, side, sum, prog            *bool
+
+	rewrite, recips, bundles, meta listFlag
+}
+
+// addStuffFlags adds the flags of the stuffing options to a flag set.
+func addStuffFlags(f *flag.FlagSet) *stuffFlags {
+	s := &stuffFlags{
+		root:     f.String("root", "/", "(optional) root path to bind all files to"),
+		level:    f.Int("level", 0, "(optional) compression level. zip: -2 (huffman only) to 9 (best), zstd: 1 to 22. 0 is the default level"),
+		codec:    f.String("codec", "zip", "(optional) payload compression format (zip, zstd)"),
+		store:    f.String("store", "", "(optional) comma separated glob patterns of files to store without compression, eg: *.png,*.woff2"),
+		brotli:   f.String("brotli", "", "(optional) comma separated glob patterns of files to add brotli compressed .br copies of, eg: *.css,*.js"),
+		dict:     f.String("dict", "", "(optional) comma separated glob patterns of small files to compress with a dictionary that's trained on them, eg: *.html,*.json"),
+		dictFile: f.String("dict-file", "", "(optional) path to a dictionary (raw content or zstd --train) to compress the -dict files with instead of training one"),
+		excl:     f.String("exclude", "", "(optional) comma separated glob patterns of files and directories to skip, eg: **/*.map,node_modules/**"),
+		hidden:   f.Bool("skip-hidden", false, "(optional) skip dotfiles and dot-directories (eg: .git, .DS_Store) in embedded directories"),
+		noRec:    f.Bool("no-recursive", false, "(optional) only embed the files directly in the given directories, skipping their subdirectories"),
+		gitIgn:   f.Bool("respect-gitignore", false, "(optional) skip the files that git ignores (.gitignore, .git/info/exclude) and .git in embedded directories and glob patterns"),
+		incr:     f.Bool("incremental", false, "(optional) only compress files that have changed since the existing payload of the output (or input) binary. zip codec only"),
+		sect:     f.Bool("section", false, "(optional) stuff the files into a named section (ELF, PE) or segment (Mach-O) of the binary instead of appending them"),
+		side:     f.Bool("sidecar", false, "(optional) write the payload to a .stuff file next to the output binary and only append a reference to it to the binary"),
+		sign:     f.String("codesign", "", "(optional) re-sign the stuffed macOS binary with codesign and the given identity (- for ad-hoc). Implies -section"),
+		signKey:  f.String("signing-key", "", "(optional) path to an Ed25519 private key file (PEM, hex, or raw) to sign the payload with"),
+		sum:      f.Bool("checksum", false, "(optional) add a SHA-256 checksum of the stuffed payload that's verified when it's read"),
+		enc:      f.String("encrypt", "", "(optional) comma separated glob patterns of files to encrypt individually instead of the whole payload with -passphrase-env or -recipient, eg: /licenses/**,*.pem"),
+		prog:     f.Bool("progress", false, "(optional) log every file as it's stuffed with its size and the running compression ratio"),
+		minify:   f.String("minify", "", "(optional) comma separated glob patterns of CSS, JS, HTML, and SVG files to minify with the built-in minifiers, eg: * or static/**"),
+		ver:      f.String("version", "", "(optional) version of the embedded assets to record in the stuffed binary's ID along with the build time (SOURCE_DATE_EPOCH or now)"),
+		commit:   f.String("commit", "", "(optional) commit of the embedded assets to record in the stuffed binary's ID along with the build time"),
+		max:      f.String("max-size", "", "(optional) max size of the stuffed payload (eg: 50MB). Stuffing fails and lists the largest files if it's exceeded"),
+		maxFile:  f.String("max-file-size", "", "(optional) max size of a file to embed (eg: 5MB). Stuffing fails and lists the files that exceed it"),
+	}
+	f.Var(&s.rewrite, "rewrite", "(optional) sed style rule to rewrite the paths of files without aliases, eg: 's|^frontend/dist|/admin|'. Can be repeated")
+	f.Var(&s.recips, "recipient", "(optional) age public key (age1...) to encrypt the payload to. Can be repeated")
+	f.Var(&s.bundles, "bundle", "(optional) name=pattern,pattern named bundle of path glob patterns that can be unstuffed on its own, eg: docs=/docs/**,/samples/**. Can be repeated")
+	f.Var(&s.meta, "meta", "(optional) key=value metadata to store in the stuffed binary's ID, eg: version=1.2.0. Can be repeated")
+
+	return s
+}
+
+// opt returns the StuffOpt of the flags with the given passphrase and key.
+func (s *stuffFlags) opt(pass string, key stuffbin.KeyFunc) (stuffbin.StuffOpt, error) {
+	codec, err := stuffbin.ParseCodec(*s.codec)
+	if err != nil {
+		return stuffbin.StuffOpt{}, err
+	}
+
+	o := stuffbin.StuffOpt{
+		RootPath:         *s.root,
+		CompressionLevel: *s.level,
+		Codec:            codec,
+		SkipHidden:       *s.hidden,
+		NoRecursive:      *s.noRec,
+		RespectGitignore: *s.gitIgn,
+		Rewrite:          s.rewrite,
+		Incremental:      *s.incr,
+		Section:          *s.sect,
+		Sidecar:          *s.side,
+		Checksum:         *s.sum,
+		Passphrase:       pass,
+		Recipients:       s.recips,
+		Key:              key,
+	}
+	if *s.max != "" {
+		if o.MaxSize, err = stuffbin.ParseSize(*s.max); err != nil {
+			return o, err
+		}
+	}
+	if *s.maxFile != "" {
+		if o.MaxFileSize, err = stuffbin.ParseSize(*s.maxFile); err != nil {
+			return o, err
+		}
+	}
+	if *s.prog {
+		o.Progress = logProgress(slog.LevelInfo)
+	} else if logLevel.Level() <= slog.LevelDebug {
+		o.Progress = logProgress(slog.LevelDebug)
+	}
+	if *s.sign != "" {
+		o.Section = true
+		o.PostStuff = stuffbin.Codesign(*s.sign)
+	}
+	if *s.signKey != "" {
+		if o.SigningKey, err = stuffbin.LoadSigningKey(*s.signKey); err != nil {
+			return o, err
+		}
+	}
+	if *s.ver != "" || *s.commit != "" {
+		o.Meta = stuffbin.NewBuildInfo(*s.ver, *s.commit).Meta()
+	}
+	if len(s.meta) > 0 {
+		if o.Meta == nil {
+			o.Meta = make(map[string]string, len(s.meta))
+		}
+		for _, m := range s.meta {
+			k, v, ok := strings.Cut(m, "=")
+			if !ok || k == "" {
+				return o, cli.UsageError("invalid meta '%s'. Should be key=value", m)
+			}
+			o.Meta[k] = v
+		}
+	}
+	if len(s.bundles) > 0 {
+		o.Bundles = make(map[string][]string, len(s.bundles))
+		for _, b := range s.bundles {
+			k, v, ok := strings.Cut(b, "=")
+			if !ok || k == "" || v == "" {
+				return o, cli.UsageError("invalid bundle '%s'. Should be name=pattern,pattern", b)
+			}
+			o.Bundles[k] = strings.Split(v, ",")
+		}
+	}
+	if *s.store != "" {
+		o.Store = strings.Split(*s.store, ",")
+	}
+	if *s.brotli != "" {
+		o.Brotli = strings.Split(*s.brotli, ",")
+	}
+	if *s.excl != "" {
+		o.Exclude = strings.Split(*s.excl, ",")
+	}
+	if *s.dict != "" {
+		o.Dictionary = strings.Split(*s.dict, ",")
+	}
+	if *s.dictFile != "" {
+		if *s.dict == "" {
+			return o, cli.UsageError("-dict-file needs -dict patterns of the files to compress with it")
+		}
+		p, err := stuffbin.ExpandPath(*s.dictFile)
+		if err != nil {
+			return o, err
+		}
+		if o.DictionaryData, err = os.ReadFile(p); err != nil {
+			return o, err
+		}
+	}
+	if *s.enc != "" {
+		o.Encrypt = strings.Split(*s.enc, ",")
+	}
+	if *s.minify != "" {
+		o.Transform = []stuffbin.TransformFunc{stuffbin.Minify(strings.Split(*s.minify, ",")...)}
+	}
+
+	return o, nil
+}
+
+// manifestNames are the names of the manifest files that are
+// looked up in the working directory (see findManifest).
+var manifestNames = []string{"stuffbin.yml", "stuffbin.yaml", "stuffbin.json"}
+
+// findManifest returns the name of the project's manifest file
+// in the working directory, if there's one.
+func findManifest() string {
+	for _, n := range manifestNames {
+		if st, err := os.Stat(n); err == nil && !st.IsDir() {
+			return n
+		}
+	}
+	return ""
+}
+
+// manifestOpt returns the options in a manifest with the ones
+// that are given as stuffing flags overriding them.
+func manifestOpt(m stuffbin.Manifest, f *flag.FlagSet, s *stuffFlags, getKey func() (string, stuffbin.KeyFunc, error)) (stuffbin.StuffOpt, error) {
+	o, err := m.StuffOpt()
+	if err != nil {
+		return o, err
+	}
+	pass, key, err := getKey()
+	if err != nil {
+		return o, err
+	}
+	fo, err := s.opt(pass, key)
+	if err != nil {
+		return o, err
+	}
+
+	f.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "root":
+			o.RootPath = fo.RootPath
+		case "level":
+			o.CompressionLevel = fo.CompressionLevel
+		case "codec":
+			o.Codec = fo.Codec
+		case "store":
+			o.Store = fo.Store
+		case "brotli":
+			o.Brotli = fo.Brotli
+		case "dict":
+			o.Dictionary = fo.Dictionary
+		case "dict-file":
+			o.DictionaryData = fo.DictionaryData
+		case "exclude":
+			o.Exclude = fo.Exclude
+		case "skip-hidden":
+			o.SkipHidden = fo.SkipHidden
+		case "no-recursive":
+			o.NoRecursive = fo.NoRecursive
+		case "respect-gitignore":
+			o.RespectGitignore = fo.RespectGitignore
+		case "rewrite":
+			o.Rewrite = fo.Rewrite
+		case "incremental":
+			o.Incremental = fo.Incremental
+		case "section":
+			o.Section = fo.Section
+		case "sidecar":
+			o.Sidecar = fo.Sidecar
+		case "codesign":
+			o.Section, o.PostStuff = true, fo.PostStuff
+		case "signing-key":
+			o.SigningKey = fo.SigningKey
+		case "checksum":
+			o.Checksum = fo.Checksum
+		case "encrypt":
+			o.Encrypt = fo.Encrypt
+		case "recipient":
+			o.Recipients = fo.Recipients
+		case "minify":
+			o.Transform = fo.Transform
+		case "max-size":
+			o.MaxSize = fo.MaxSize
+		case "max-file-size":
+			o.MaxFileSize = fo.MaxFileSize
+		case "progress":
+			o.Progress = fo.Progress
+		case "meta", "version", "commit":
+			o.Meta = mergeMeta(o.Meta, fo.Meta)
+		case "bundle":
+			b := make(map[string][]string, len(o.Bundles)+len(fo.Bundles))
+			for k, v := range o.Bundles {
+				b[k] = v
+			}
+			for k, v := range fo.Bundles {
+				b[k] = v
+			}
+			o.Bundles = b
+		}
+	})
+	o.Passphrase, o.Key = fo.Passphrase, fo.Key
+	if o.Progress == nil {
+		o.Progress = fo.Progress
+	}
+
+	return o, nil
+}
+
+// mergeMeta returns a copy of the metadata a with the metadata b added to it.
+func mergeMeta(a, b map[string]string) map[string]string {
+	out := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		out[k] = v
+	}
+	return out
+}
+
+func runStuff(args []string) error {
+	f := newFlagSet(aStuff, "/path/asset1 /path/asset2:/asset2 ...", stuffHelpTxt)
+	var (
+		fIn    = f.String("in", "", "path to the input binary")
+		fOut   = f.String("out", "", "path to the output binary")
+		fForce = f.Bool("force", false, "(optional) stuff the input binary even if it isn't an ELF, PE, or Mach-O executable")
+		fArch  = f.String("archive", "", "(optional) path to a zip, tar, tar.gz, or tar.zst archive whose files to embed instead of file arguments, optionally with an alias, eg: dist.tar.gz:/static")
+		fPlat  = f.String("platform", "", "(optional) GOOS/GOARCH to select the manifest files with platforms for, eg: linux/amd64. Defaults to the platform of the input binary")
+		fMan   = f.String("manifest", "", "(optional) path to a YAML or JSON manifest describing the binaries, the files to embed, and the options, which flags override. Defaults to stuffbin.yml (or .yaml, .json) in the working directory if no files are given")
+		fDry   = f.Bool("dry-run", false, "(optional) print the target paths of the files that would be embedded with their sizes and compressed sizes without writing the output binary. -in and -out are optional")
+		fTop   = f.Int("top", 0, "(optional) print this many of the files with the largest compressed sizes and with the worst compression ratios after stuffing")
+		getKey = keyFlags(f, "encrypt")
+		sf     = addStuffFlags(f)
+	)
+	var fTargets listFlag
+	f.Var(&fTargets, "target", "(optional) input=output binary paths to stuff the same files or manifest into instead of -in and -out, compressing them once and stuffing the binaries in parallel, eg: dist/app-linux=dist/app-linux.stuffed. The input can be a glob with an existing output directory ending in /, eg: 'dist/app-*=release/'. Can be repeated")
+
+	files, err := parse(f, args, fIn, fOut, fArch, fMan)
+	if err != nil {
+		return err
+	}
+
+	// Use the project's manifest if there's nothing else to embed.
+	if *fMan == "" && len(files) == 0 && *fArch == "" {
+		if *fMan = findManifest(); *fMan != "" {
+			lg.Info("using "+*fMan, "manifest", *fMan)
+		}
+	}
+	var m stuffbin.Manifest
+	if *fMan != "" {
+		if len(files) > 0 {
+			return cli.UsageError("provide either a manifest or files to embed, not both")
+		}
+		if m, err = stuffbin.LoadManifest(*fMan); err != nil {
+			return err
+		}
+
+		// The binaries in the manifest are used unless they're given.
+		for _, p := range []struct{ flag, v *string }{{fIn, &m.In}, {fOut, &m.Out}} {
+			if *p.flag == "" && len(fTargets) == 0 {
+				if *p.flag, err = stuffbin.ExpandPath(*p.v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	targets, err := parseTargets(fTargets)
+	if err != nil {
+		return err
+	}
+
+	o := cli.StuffOptions{
+		In:      *fIn,
+		Out:     *fOut,
+		Files:   files,
+		Archive: *fArch,
+		Targets: targets,
+		Force:   *fForce,
+		DryRun:  *fDry,
+		Top:     *fTop,
+	}
+
+	// Stuff with the manifest's options that the flags override.
+	if *fMan != "" {
+		if *fPlat != "" {
+			m.Platform = *fPlat
+		}
+		if o.Opt, err = manifestOpt(m, f, sf, getKey); err != nil {
+			return err
+		}
+		o.Manifest = &m
+		return cli.RunStuff(o, lg)
+	}
+
+	pass, key, err := getKey()
+	if err != nil {
+		return err
+	}
+	if o.Opt, err = sf.opt(pass, key); err != nil {
+		return err
+	}
+	return cli.RunStuff(o, lg)
+}
+
+// parseTargets parses input=output -target flags into Targets. An input
+// glob with an output directory ending in / expands into a target for
+// every matching binary in the directory, eg: dist/app-*=release/.
+func parseTargets(flags []string) ([]stuffbin.Target, error) {
+	var targets []stuffbin.Target
+	for _, t := range flags {
+		in, out, ok := strings.Cut(t, "=")
+		if !ok || in == "" || out == "" {
+			return nil, cli.UsageError("invalid target '%s'. Should be input=output", t)
+		}
+		isDir := strings.HasSuffix(out, "/")
+		for _, p := range []*string{&in, &out} {
+			v, err := stuffbin.ExpandPath(*p)
+			if err != nil {
+				return nil, err
+			}
+			*p = v
+		}
+		if !isDir {
+			targets = append(targets, stuffbin.Target{In: in, Out: out})
+			continue
+		}
+
+		ins, err := filepath.Glob(in)
+		if err != nil {
+			return nil, cli.UsageError("invalid target '%s': %v", t, err)
+		}
+		if len(ins) == 0 {
+			return nil, cli.UsageError("target '%s' doesn't match any binaries", t)
+		}
+		for _, p := range ins {
+			targets = append(targets, stuffbin.Target{In: p, Out: filepath.Join(out, filepath.Base(p))})
+		}
+	}
+	return targets, nil
+}
+
+func runBuild(args []string) error {
+	f := newFlagSet(aBuild, "", buildHelpTxt)
+	var (
+		fMan   = f.String("manifest", "", "(optional) path to a YAML or JSON manifest describing the files to embed and the options, which flags override. Defaults to stuffbin.yml (or .yaml, .json) in the working directory if no files are given")
+		getKey = keyFlags(f, "encrypt")
+		sf     = addStuffFlags(f)
+	)
+	f.Usage = func() {
+		logger.Println("Usage: stuffbin build [go build flags] [packages] -- [flags] /path/asset1 /path/asset2:/asset2 ...")
+		logger.Println(buildHelpTxt)
+		f.PrintDefaults()
+	}
+
+	// The arguments before -- are go build's and the rest are stuff's.
+	goArgs, stuffArgs := args, []string(nil)
+	for n, a := range args {
+		if a == "--" {
+			goArgs, stuffArgs = args[:n], args[n+1:]
+			break
+		}
+	}
+	for _, a := range goArgs {
+		if a == "-h" || a == "-help" || a == "--help" {
+			f.Usage()
+			return nil
+		}
+	}
+
+	files, err := parse(f, stuffArgs, fMan)
+	if err != nil {
+		return err
+	}
+	out, err := goBuildOutput(goArgs)
+	if err != nil {
+		return err
+	}
+
+	// Use the files and the options in the project's manifest if
+	// there are no files to embed.
+	var m stuffbin.Manifest
+	if *fMan == "" && len(files) == 0 {
+		if *fMan = findManifest(); *fMan != "" {
+			lg.Info("using "+*fMan, "manifest", *fMan)
+		}
+	}
+	if *fMan != "" {
+		if len(files) > 0 {
+			return cli.UsageError("provide either a manifest or files to embed, not both")
+		}
+		if m, err = stuffbin.LoadManifest(*fMan); err != nil {
+			return err
+		}
+	} else if len(files) == 0 {
+		return cli.UsageError("provide one or more files to embed after --")
+	}
+
+	var o stuffbin.StuffOpt
+	if *fMan != "" {
+		o, err = manifestOpt(m, f, sf, getKey)
+	} else {
+		pass, key, kerr := getKey()
+		if kerr != nil {
+			return kerr
+		}
+		o, err = sf.opt(pass, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	// go build picks GOOS, GOARCH, and the rest of the environment up.
+	lg.Info("go build "+strings.Join(goArgs, " "), "args", goArgs)
+	cmd := exec.Command("go", append([]string{"build"}, goArgs...)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return cli.WrapError(err, "go build failed: %v", err)
+	}
+
+	var binLen, zipLen int64
+	if *fMan != "" {
+		binLen, zipLen, err = stuffbin.StuffManifestWithOpt(out, out, m, o)
+	} else {
+		binLen, zipLen, err = stuffbin.StuffWithOpt(out, out, o, files...)
+	}
+	if err != nil {
+		return cli.WrapError(err, "stuffing failed: %v", err)
+	}
+	cli.LogStuffed(lg, binLen, zipLen)
+	return nil
+}
+
+func runWatch(args []string) error {
+	f := newFlagSet(aWatch, "/path/asset1 /path/asset2:/asset2 ...", watchHelpTxt)
+	var (
+		fIn    = f.String("in", "", "path to the input binary")
+		fOut   = f.String("out", "", "path to the output binary")
+		fForce = f.Bool("force", false, "(optional) stuff the input binary even if it isn't an ELF, PE, or Mach-O executable")
+		fIntvl = f.Duration("interval", 500*time.Millisecond, "(optional) interval to check the files for changes at")
+		fExec  = f.String("exec", "", "(optional) command to run (eg: the output binary with its arguments) and restart after every stuffing, eg: './app.stuffed -port 8080'")
+		getKey = keyFlags(f, "encrypt")
+		sf     = addStuffFlags(f)
+	)
+	files, err := parse(f, args, fIn, fOut)
+	if err != nil {
+		return err
+	}
+	if *fIn == "" {
+		return cli.UsageError("provide an input path")
+	}
+	if *fOut == "" {
+		return cli.UsageError("provide an output path")
+	}
+	if len(files) == 0 {
+		return cli.UsageError("provide one or more files to embed")
+	}
+	if *fIntvl <= 0 {
+		return cli.UsageError("-interval should be positive")
+	}
+	if !*fForce {
+		if err := cli.CheckExecutables(*fIn); err != nil {
+			return err
+		}
+	}
+
+	pass, key, err := getKey()
+	if err != nil {
+		return err
+	}
+	o, err := sf.opt(pass, key)
+	if err != nil {
+		return err
+	}
+	return watch(*fIn, *fOut, o, *fIntvl, strings.Fields(*fExec), files, lg)
+}
+
+// goBuildOutput returns the path of the binary in the -o flag of
+// go build's arguments, which is needed to stuff it.
+func goBuildOutput(args []string) (string, error) {
+	for n, a := range args {
+		a = strings.TrimPrefix(a, "-")
+		switch {
+		case a == "-o" || a == "o":
+			if n+1 < len(args) {
+				return checkBuildOutput(args[n+1])
+			}
+		case strings.HasPrefix(a, "-o=") || strings.HasPrefix(a, "o="):
+			_, v, _ := strings.Cut(a, "=")
+			return checkBuildOutput(v)
+		}
+	}
+	return "", cli.UsageError("provide the path of the output binary with -o")
+}
+
+// checkBuildOutput checks that the -o path of go build is a file.
+func checkBuildOutput(p string) (string, error) {
+	if strings.HasSuffix(p, "/") || strings.HasSuffix(p, string(os.PathSeparator)) {
+		return "", cli.UsageError("-o %s is a directory. Provide the path of the output binary", p)
+	}
+	if st, err := os.Stat(p); err == nil && st.IsDir() {
+		return "", cli.UsageError("-o %s is a directory. Provide the path of the output binary", p)
+	}
+	return p, nil
+}
+
+func runAdd(args []string) error {
+	f := newFlagSet(aAdd, "binary /path/asset1 /path/asset2:/asset2 ...", addHelpTxt)
+	var (
+		fIn    = f.String("in", "", "path to the input binary instead of the first argument")
+		fOut   = f.String("out", "", "(optional) path to the output binary. Defaults to the input binary, which is updated in-place")
+		fForce = f.Bool("force", false, "(optional) stuff the input binary even if it isn't an ELF, PE, or Mach-O executable")
+		getKey = keyFlags(f, "encrypt or decrypt")
+		sf     = addStuffFlags(f)
+	)
+	files, err := parse(f, args, fIn, fOut)
+	if err != nil {
+		return err
+	}
+	if *fIn == "" && len(files) > 0 {
+		*fIn, files = files[0], files[1:]
+	}
+	if *fIn == "" {
+		return cli.UsageError("provide an input path")
+	}
+	if *fOut == "" {
+		*fOut = *fIn
+	}
+	if len(files) == 0 {
+		return cli.UsageError("provide one or more files to embed")
+	}
+	if !*fForce {
+		if err := cli.CheckExecutables(*fIn); err != nil {
+			return err
+		}
+	}
+
+	pass, key, err := getKey()
+	if err != nil {
+		return err
+	}
+	o, err := sf.opt(pass, key)
+	if err != nil {
+		return err
+	}
+
+	// Keep the codec of the existing payload unless one is given.
+	codecSet := false
+	f.Visit(func(f *flag.Flag) {
+		codecSet = codecSet || f.Name == "codec"
+	})
+	if id, err := stuffbin.GetFileID(*fIn); err == nil && !codecSet {
+		o.Codec = id.Codec
+	}
+
+	binLen, zipLen, err := stuffbin.StuffAddWithOpt(*fIn, *fOut, o, files...)
+	if err != nil {
+		return cli.WrapError(err, "stuffing failed: %v", err)
+	}
+	cli.LogStuffed(lg, binLen, zipLen)
+	return nil
+}
+
+// readCmd parses the flags of a command that reads a stuffed binary with
+// an optional output path and returns the input, the output, the rest of
+// the arguments as they are, and the key. The input can be given with -in
+// or as the first argument.
+func readCmd(f *flag.FlagSet, out string, args []string) (string, string, []string, stuffbin.KeyFunc, error) {
+	var (
+		fIn    = f.String("in", "", "path to the stuffed binary instead of the first argument")
+		fOut   = new(string)
+		getKey = keyFlags(f, "decrypt")
+	)
+	if out != "" {
+		fOut = f.String("out", "", out)
+	}
+	args, err := parseRaw(f, args, fIn, fOut)
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+	if *fIn == "" && len(args) > 0 {
+		if *fIn, err = stuffbin.ExpandPath(args[0]); err != nil {
+			return "", "", nil, nil, err
+		}
+		args = args[1:]
+	}
+	if *fIn == "" {
+		return "", "", nil, nil, cli.UsageError("provide an input path")
+	}
+	if out != "" && *fOut == "" {
+		return "", "", nil, nil, cli.UsageError("provide an output path")
+	}
+
+	_, key, err := getKey()
+	return *fIn, *fOut, args, key, err
+}
+
+func runID(args []string) error {
+	f := newFlagSet(aID, "binary", "Show the ID, the metadata, and the files of a stuffed binary.")
+	fJSON := f.Bool("json", false, "(optional) print the ID and the files (path, size, compressed size, CRC-32, mode, mtime) as JSON")
+
+	in, _, _, key, err := readCmd(f, "", args)
+	if err != nil {
+		return err
+	}
+	return cli.RunID(cli.IDOptions{Path: in, Key: key, JSON: *fJSON}, lg)
+}
+
+func runRemove(args []string) error {
+	f := newFlagSet(aRemove, "binary /path ...", rmHelpTxt)
+	var (
+		fIn    = f.String("in", "", "path to the input binary instead of the first argument")
+		fOut   = f.String("out", "", "(optional) path to the output binary. Defaults to the input binary, which is updated in-place")
+		getKey = keyFlags(f, "encrypt or decrypt")
+	)
+	patterns, err := parseRaw(f, args, fIn, fOut)
+	if err != nil {
+		return err
+	}
+	if *fIn == "" && len(patterns) > 0 {
+		if *fIn, err = stuffbin.ExpandPath(patterns[0]); err != nil {
+			return err
+		}
+		patterns = patterns[1:]
+	}
+	if *fIn == "" {
+		return cli.UsageError("provide an input path")
+	}
+	if *fOut == "" {
+		*fOut = *fIn
+	}
+	if len(patterns) == 0 {
+		return cli.UsageError("provide one or more paths to remove")
+	}
+
+	id, err := stuffbin.GetFileID(*fIn)
+	if err != nil {
+		if err == stuffbin.ErrNoID {
+			return cli.WrapError(err, "%s: %v", *fIn, err)
+		}
+		return err
+	}
+	pass, key, err := getKey()
+	if err != nil {
+		return err
+	}
+
+	// Keep the codec, the metadata, and the checksum of the payload.
+	o := stuffbin.StuffOpt{
+		Codec:      id.Codec,
+		Meta:       id.Extra().Meta,
+		Checksum:   id.Flags&stuffbin.FlagChecksum != 0,
+		Passphrase: pass,
+		Key:        key,
+	}
+	binLen, zipLen, err := stuffbin.StuffRemoveWithOpt(*fIn, *fOut, o, patterns...)
+	if err != nil {
+		return cli.WrapError(err, "removing failed: %v", err)
+	}
+	cli.LogStuffed(lg, binLen, zipLen)
+	return nil
+}
+
+func runRepack(args []string) error {
+	f := newFlagSet(aRepack, "in.bin [out.bin]", repackHelpTxt)
+	var (
+		fIn    = f.String("in", "", "path to the input binary instead of the first argument")
+		fOut   = f.String("out", "", "(optional) path to the output binary instead of the second argument. Defaults to the input binary, which is updated in-place")
+		getKey = keyFlags(f, "encrypt or decrypt")
+		sf     = addStuffFlags(f)
+	)
+	paths, err := parse(f, args, fIn, fOut)
+	if err != nil {
+		return err
+	}
+	if *fIn == "" && len(paths) > 0 {
+		*fIn, paths = paths[0], paths[1:]
+	}
+	if *fOut == "" && len(paths) > 0 {
+		*fOut, paths = paths[0], paths[1:]
+	}
+	if *fIn == "" {
+		return cli.UsageError("provide an input path")
+	}
+	if len(paths) > 0 {
+		return cli.UsageError("unexpected arguments: %s", strings.Join(paths, " "))
+	}
+	if *fOut == "" {
+		*fOut = *fIn
+	}
+
+	id, err := stuffbin.GetFileID(*fIn)
+	if err != nil {
+		if err == stuffbin.ErrNoID {
+			return cli.WrapError(err, "%s: %v", *fIn, err)
+		}
+		return err
+	}
+	pass, key, err := getKey()
+	if err != nil {
+		return err
+	}
+	o, err := sf.opt(pass, key)
+	if err != nil {
+		return err
+	}
+
+	// Keep the codec, the metadata, and the checksum of the payload
+	// unless they're given.
+	set := make(map[string]bool)
+	f.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if !set["codec"] {
+		o.Codec = id.Codec
+	}
+	o.Meta = mergeMeta(id.Extra().Meta, o.Meta)
+	if !set["checksum"] {
+		o.Checksum = id.Flags&stuffbin.FlagChecksum != 0
+	}
+
+	binLen, zipLen, err := stuffbin.RepackWithOpt(*fIn, *fOut, o)
+	if err != nil {
+		return cli.WrapError(err, "repacking failed: %v", err)
+	}
+	cli.LogStuffed(lg, binLen, zipLen)
+	return nil
+}
+
+func runList(args []string) error {
+	f := newFlagSet(aList, "binary [pattern ...]", lsHelpTxt)
+	var (
+		fLong = f.Bool("l", false, "(optional) long listing with the mode, size, compressed size, compression ratio, CRC-32, and mtime of every file")
+		fJSON = f.Bool("json", false, "(optional) print the files as JSON")
+	)
+	in, _, patterns, key, err := readCmd(f, "", args)
+	if err != nil {
+		return err
+	}
+	return ls(in, patterns, *fLong, *fJSON, key, logger)
+}
+
+func runCat(args []string) error {
+	f := newFlagSet(aCat, "binary /path/inside", "Write the contents of a file in a stuffed binary to stdout.")
+	in, _, files, key, err := readCmd(f, "", args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return cli.UsageError("provide the stuffed binary and the path of the file to print")
+	}
+	return cat(in, files[0], key, os.Stdout)
+}
+
+func runServe(args []string) error {
+	f := newFlagSet(aServe, "binary", serveHelpTxt)
+	var (
+		fAddr   = f.String("addr", ":8080", "(optional) address to listen on")
+		fPrefix = f.String("prefix", "/", "(optional) URL path prefix to serve the files under, which is stripped from request paths, eg: /static/")
+	)
+	in, _, _, key, err := readCmd(f, "", args)
+	if err != nil {
+		return err
+	}
+	return serve(in, *fAddr, *fPrefix, key, lg)
+}
+
+func runUnstuff(args []string) error {
+	in, out, _, key, err := readCmd(newFlagSet(aUnstuff, "binary", "Write the ZIP payload of a stuffed binary to a file."), "path to the output ZIP file", args)
+	if err != nil {
+		return err
+	}
+	return unstuff(in, out, key, lg)
+}
+
+func runExtract(args []string) error {
+	f := newFlagSet(aExtract, "binary [pattern ...]", extractHelpTxt)
+	var (
+		fDir = f.String("C", "", "path to the directory to extract the files to")
+		fOut = f.String("out", "", "path to the directory to extract the files to (same as -C)")
+	)
+	in, _, patterns, key, err := readCmd(f, "", args)
+	if err != nil {
+		return err
+	}
+	if *fDir == "" {
+		fDir = fOut
+	}
+	if *fDir == "" {
+		return cli.UsageError("provide a directory to extract to with -C")
+	}
+	dir, err := stuffbin.ExpandPath(*fDir)
+	if err != nil {
+		return err
+	}
+	return extract(in, dir, patterns, key, lg)
+}
+
+func runVerify(args []string) error {
+	f := newFlagSet(aVerify, "binary", verifyHelpTxt)
+	var (
+		fHMAC = f.String("hmac-key-env", "", "(optional) name of the environment variable with the secret key to verify the payload's HMAC-SHA256 signature with")
+		fPub  = f.String("public-key", "", "(optional) hex encoded Ed25519 public key to verify the payload's signature with")
+		fJSON = f.Bool("json", false, "(optional) print a JSON report of the payload and every file")
+	)
+	in, _, _, key, err := readCmd(f, "", args)
+	if err != nil {
+		return err
+	}
+
+	o := stuffbin.UnStuffOpt{Key: key}
+	if *fHMAC != "" {
+		if o.HMACKey = []byte(os.Getenv(*fHMAC)); len(o.HMACKey) == 0 {
+			return cli.UsageError("environment variable %s is empty", *fHMAC)
+		}
+	}
+	if *fPub != "" {
+		if o.HMACKey != nil {
+			return cli.UsageError("provide either -hmac-key-env or -public-key, not both")
+		}
+		b, err := hex.DecodeString(*fPub)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return cli.UsageError("invalid public key. Should be a hex encoded Ed25519 public key")
+		}
+		o.PublicKey = b
+	}
+
+	return verify(in, o, *fJSON, logger)
+}
+
+func runChecksum(args []string) error {
+	f := newFlagSet(aChecksum, "binary", checksumHelpTxt)
+	fFiles := f.Bool("files", false, "(optional) only print the hashes of the files, for instance, to check them with sha256sum -c")
+
+	in, _, _, key, err := readCmd(f, "", args)
+	if err != nil {
+		return err
+	}
+	return checksum(in, *fFiles, key, os.Stdout)
+}
+
+func runEncrypt(args []string) error {
+	f := newFlagSet(aEncrypt, "binary", encryptHelpTxt)
+	var (
+		fIn    = f.String("in", "", "path to the stuffed binary instead of the first argument")
+		fOut   = f.String("out", "", "(optional) path to the output binary. Defaults to the input binary, which is updated in-place")
+		fPass  = f.String("passphrase-env", "", "(optional) name of the environment variable with the passphrase to encrypt the payload with")
+		recips listFlag
+	)
+	f.Var(&recips, "recipient", "(optional) age public key (age1...) to encrypt the payload to. Can be repeated")
+	files, err := parse(f, args, fIn, fOut)
+	if err != nil {
+		return err
+	}
+	if *fIn == "" && len(files) > 0 {
+		*fIn = files[0]
+	}
+	if *fIn == "" {
+		return cli.UsageError("provide an input path")
+	}
+	if *fOut == "" {
+		*fOut = *fIn
+	}
+
+	o := stuffbin.StuffOpt{Recipients: recips}
+	if *fPass != "" {
+		if o.Passphrase = os.Getenv(*fPass); o.Passphrase == "" {
+			return cli.UsageError("environment variable %s is empty", *fPass)
+		}
+	}
+	if o.Passphrase == "" && len(o.Recipients) == 0 {
+		return cli.UsageError("provide -passphrase-env or one or more -recipient")
+	}
+	return encrypt(*fIn, *fOut, o, lg)
+}
+
+func runDecrypt(args []string) error {
+	f := newFlagSet(aDecrypt, "binary", decryptHelpTxt)
+	var (
+		fIn    = f.String("in", "", "path to the stuffed binary instead of the first argument")
+		fOut   = f.String("out", "", "(optional) path to the output binary. Defaults to the input binary, which is updated in-place")
+		getKey = keyFlags(f, "decrypt")
+	)
+	files, err := parse(f, args, fIn, fOut)
+	if err != nil {
+		return err
+	}
+	if *fIn == "" && len(files) > 0 {
+		*fIn = files[0]
+	}
+	if *fIn == "" {
+		return cli.UsageError("provide an input path")
+	}
+	if *fOut == "" {
+		*fOut = *fIn
+	}
+
+	_, key, err := getKey()
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return cli.UsageError("provide -passphrase-env or -identity")
+	}
+	return decrypt(*fIn, *fOut, key, lg)
+}
+
+func runSign(args []string) error {
+	f := newFlagSet(aSign, "binary", signHelpTxt)
+	var (
+		fIn  = f.String("in", "", "path to the stuffed binary instead of the first argument")
+		fOut = f.String("out", "", "(optional) path to the output binary. Defaults to the input binary, which is updated in-place")
+		fKey = f.String("key", "", "path to the Ed25519 private key file (PEM, hex, or raw) to sign the payload with")
+	)
+	files, err := parse(f, args, fIn, fOut)
+	if err != nil {
+		return err
+	}
+	if *fIn == "" && len(files) > 0 {
+		*fIn = files[0]
+	}
+	if *fIn == "" {
+		return cli.UsageError("provide an input path")
+	}
+	if *fOut == "" {
+		*fOut = *fIn
+	}
+	if *fKey == "" {
+		return cli.UsageError("provide the private key with -key")
+	}
+
+	key, err := stuffbin.LoadSigningKey(*fKey)
+	if err != nil {
+		return err
+	}
+	return sign(*fIn, *fOut, key, lg)
+}
+
+func runVerifySig(args []string) error {
+	f := newFlagSet(aVerifySig, "binary", verifySigHelpTxt)
+	var (
+		fIn  = f.String("in", "", "path to the stuffed binary instead of the first argument")
+		fPub = f.String("pub", "", "path to the Ed25519 public key file (PEM, hex, or raw) to verify the payload's signature with")
+	)
+	files, err := parse(f, args, fIn)
+	if err != nil {
+		return err
+	}
+	if *fIn == "" && len(files) > 0 {
+		*fIn = files[0]
+	}
+	if *fIn == "" {
+		return cli.UsageError("provide an input path")
+	}
+	if *fPub == "" {
+		return cli.UsageError("provide the public key with -pub")
+	}
+
+	pub, err := stuffbin.LoadPublicKey(*fPub)
+	if err != nil {
+		return err
+	}
+	return verifySig(*fIn, pub, lg)
+}
+
+func runStrip(args []string) error {
+	f := newFlagSet(aStrip, "", stripHelpTxt)
+	var (
+		fIn   = f.String("in", "", "path to the stuffed binary")
+		fOut  = f.String("out", "", "path to the output binary. Can be the same as -in")
+		fKeep = f.Bool("keep-payload", false, "(optional) keep the removed payload and its ID in the output binary's path + .stuff to attach it again later with attach")
+	)
+	if _, err := parse(f, args, fIn, fOut); err != nil {
+		return err
+	}
+	if *fIn == "" || *fOut == "" {
+		return cli.UsageError("provide an input and an output path")
+	}
+	return strip(*fIn, *fOut, *fKeep, lg)
+}
+
+func runAttach(args []string) error {
+	f := newFlagSet(aAttach, "", "Attach a payload kept by strip -keep-payload to a binary as it was.")
+	var (
+		fIn      = f.String("in", "", "path to the binary")
+		fOut     = f.String("out", "", "path to the output binary. Can be the same as -in")
+		fPayload = f.String("payload", "", "(optional) path to the kept payload. Defaults to the input binary's path + .stuff")
+	)
+	if _, err := parse(f, args, fIn, fOut, fPayload); err != nil {
+		return err
+	}
+	if *fIn == "" || *fOut == "" {
+		return cli.UsageError("provide an input and an output path")
+	}
+	if *fPayload == "" {
+		*fPayload = *fIn + stuffbin.SidecarExt
+	}
+	return attach(*fIn, *fOut, *fPayload, lg)
+}
+
+func runDiff(args []string) error {
+	f := newFlagSet(aDiff, "old.bin new.bin", diffHelpTxt)
+	var (
+		fIn    = f.String("in", "", "(optional) path to the old binary instead of the first argument")
+		fOut   = f.String("out", "", "(optional) path to write a patch that turns the old binary into the new one to instead of listing the changes (see patch)")
+		fJSON  = f.Bool("json", false, "(optional) print the changes as JSON")
+		getKey = keyFlags(f, "decrypt")
+	)
+	files, err := parse(f, args, fIn, fOut)
+	if err != nil {
+		return err
+	}
+	if *fIn != "" {
+		files = append([]string{*fIn}, files...)
+	}
+	if len(files) != 2 {
+		return cli.UsageError("provide the old and the new binaries")
+	}
+
+	if *fOut != "" {
+		return diff(files[0], files[1], *fOut, lg)
+	}
+
+	_, key, err := getKey()
+	if err != nil {
+		return err
+	}
+	return compare(files[0], files[1], key, *fJSON, logger)
+}
+
+func runPatch(args []string) error {
+	f := newFlagSet(aPatch, "/path/file.patch", "Apply a patch made with diff to the input binary.")
+	var (
+		fIn  = f.String("in", "", "path to the old binary")
+		fOut = f.String("out", "", "path to the output binary")
+	)
+	files, err := parse(f, args, fIn, fOut)
+	if err != nil {
+		return err
+	}
+	if *fIn == "" || *fOut == "" {
+		return cli.UsageError("provide an input and an output path")
+	}
+	if len(files) != 1 {
+		return cli.UsageError("provide the patch file to apply")
+	}
+	return patch(*fIn, files[0], *fOut, lg)
+}
+
+func runVersion(args []string) error {
+	f := newFlagSet(aVersion, "[binary ...]", "Show the version of stuffbin, the payload format that it writes and the ones\nit reads, and the payload formats of the optional stuffed binaries.")
+	ins, err := parse(f, args)
+	if err != nil {
+		return err
+	}
+	return showVersion(ins, logger)
+}
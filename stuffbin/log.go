@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/knadh/stuffbin"
+)
+
+var (
+	// logLevel is the minimum level of the messages that are logged,
+	// which -q and -v change.
+	logLevel = new(slog.LevelVar)
+
+	// lg logs the messages of commands (as opposed to their output, which
+	// is written to stdout) to stderr as plain text or JSON (-log-format).
+	lg = slog.New(newTextHandler(os.Stderr, logLevel))
+)
+
+// logFlags are the logging flags that every command has.
+type logFlags struct {
+	quiet, verbose bool
+	format         string
+}
+
+var logOpt logFlags
+
+// setupLog sets the level and the format of the log from the logging flags.
+func setupLog(l logFlags) error {
+	switch {
+	case l.quiet && l.verbose:
+		return errors.New("provide either -q or -v, not both")
+	case l.quiet:
+		logLevel.Set(slog.LevelWarn)
+	case l.verbose:
+		logLevel.Set(slog.LevelDebug)
+	default:
+		logLevel.Set(slog.LevelInfo)
+	}
+
+	switch l.format {
+	case "", "text":
+		lg = slog.New(newTextHandler(os.Stderr, logLevel))
+	case "json":
+		lg = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+	default:
+		return fmt.Errorf("unknown log format '%s'. Should be text or json", l.format)
+	}
+	return nil
+}
+
+// textHandler is a slog.Handler that writes messages as plain lines for
+// people, prefixing warnings and errors with their level. Attributes are
+// left out as the messages include the details. They're only written with
+// -log-format=json for tools.
+type textHandler struct {
+	w     io.Writer
+	level slog.Leveler
+	mu    *sync.Mutex
+}
+
+func newTextHandler(w io.Writer, level slog.Leveler) *textHandler {
+	return &textHandler{w: w, level: level, mu: &sync.Mutex{}}
+}
+
+func (h *textHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	msg := r.Message
+	switch {
+	case r.Level >= slog.LevelError:
+		msg = "error: " + msg
+	case r.Level >= slog.LevelWarn:
+		msg = "warning: " + msg
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintln(h.w, msg)
+	return err
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// logProgress returns a progress function that logs every stuffed file
+// with its size and the running compression ratio at the given level.
+func logProgress(level slog.Level) stuffbin.ProgressFunc {
+	return func(e stuffbin.Event) {
+		if e.Type == stuffbin.EventFileDone {
+			lg.Log(context.Background(), level, fmt.Sprintf("%s (%0.2f KB, ratio %0.2f)", e.Path, float64(e.Size)/1024, e.Ratio()),
+				"path", e.Path, "size", e.Size, "ratio", e.Ratio())
+		}
+	}
+}
@@ -1,220 +1,851 @@
 package main
 
 import (
-	"bytes"
-	"flag"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/knadh/stuffbin"
+	"github.com/knadh/stuffbin/stuffbin/cli"
 )
 
 const helpTxt = `
 compress and embed static assets into Go binaries.
-Usage: stuffbin -a build -in yourbinary.bin -out stuffed.bin /path/asset1 /path/asset2:/asset2 ...
+Usage: stuffbin <command> [flags] [args]
+
+Commands:`
+
+const exitCodesTxt = `
+Exit codes:
+  0  success
+  1  other errors
+  2  invalid commands, flags, or arguments
+  3  no stuffed ID found in the binary
+  4  corrupt, truncated, or undecryptable payload
+  5  error reading or writing files
+  6  verification failed or missing or invalid signature`
+
+const stuffHelpTxt = `
+Compress files and embed them into a binary, for instance:
+stuffbin stuff -in yourbinary.bin -out stuffed.bin /path/asset1 /path/asset2:/asset2
 
 The file paths to embed can be suffixed by a colon and an 
 target (alias) path, for instance /original/local/path:/virtual/path.
 When compressed and stuffed, the original path is overwritten
 with the alias, which in turn can be used to access the file
-from within the application.`
+from within the application. Colons in paths can be escaped
+with \: or the alias can be separated with => instead, for
+instance C:\assets=>/static. $VARS and ~ in paths are expanded.
+
+Quoted glob patterns, for instance 'assets/**/*.css:/css', are
+expanded by stuffbin the same way on every platform. A ** segment
+matches any number of directories, and the paths of the files under
+the pattern's leading directory are mapped to the alias.`
+
+const buildHelpTxt = `
+Run go build with the arguments before -- and stuff the files after it
+into the built binary in one step, for instance:
+stuffbin build -tags prod -o app ./cmd/app -- -checksum assets/:/static
+
+go build needs -o with the path of the output binary. GOOS, GOARCH, and
+the rest of the environment apply to go build as usual. The stuffing flags
+go after -- along with the files.`
+
+const watchHelpTxt = `
+Stuff files into a binary, and stuff them again whenever they are added,
+removed, or modified, or the input binary is rebuilt, until interrupted.
+With -exec, the command (usually the output binary) is restarted after
+every stuffing for a live-reload development loop. The stuffing flags are
+the same as stuff's.`
+
+const addHelpTxt = `
+Add files to the payload of a stuffed binary instead of replacing it, for
+instance, to hotfix assets in a release without re-running the build.
+Existing files with the same paths are overwritten. The payload keeps its
+codec unless -codec is given. The file paths take aliases as with stuff.`
+
+const lsHelpTxt = `
+List the files of a stuffed binary that match the optional glob patterns,
+for instance, '/static/**' '*.css'. A ** segment matches any number of
+directories.`
+
+const extractHelpTxt = `
+Extract the files of a stuffed binary into a directory with their paths,
+permissions, and modification times. Existing files are overwritten. Only
+the files that match the optional glob patterns are extracted, for
+instance, '/static/**' '*.css'.`
+
+const diffHelpTxt = `
+List the files that were added, removed, or modified (by the SHA-256 of
+their contents) in the new stuffed binary with their size changes. With
+-out, write a patch that turns the old binary into the new one instead,
+which patch applies.`
+
+const serveHelpTxt = `
+Serve the files of a stuffed binary over HTTP, with the brotli compressed
+.br copies of files if there are any, to preview what the application
+will serve without running it. Requests are logged.`
+
+const rmHelpTxt = `
+Remove the files that match the given paths or glob patterns, along with
+their brotli compressed .br copies, from the payload of a stuffed binary
+without re-compressing the rest, for instance, '/static/old.js' or
+'/docs/**'. The payload keeps its codec, metadata, and checksum.`
+
+const repackHelpTxt = `
+Re-compress the files in the payload of a stuffed binary with other
+options, for instance, -codec zstd -level 19, without the original files.
+The payload keeps its codec, checksum, and metadata unless they're given.
+Files that are encrypted individually stay encrypted and need the key.`
+
+const encryptHelpTxt = `
+Encrypt the payload of a stuffed binary with a passphrase or to age
+recipients without re-compressing it, so that its files can't be read
+without the key. The payload's signature, if any, is dropped. Sign the
+binary again after encrypting it.`
+
+const decryptHelpTxt = `
+Decrypt the payload of a stuffed binary with the passphrase or the age
+identity, for instance, to ship it without the key. Files that are
+encrypted individually are decrypted too. The payload's signature, if
+any, is dropped.`
+
+const signHelpTxt = `
+Sign the payload of a stuffed binary with an Ed25519 private key without
+re-compressing it, for instance, in a release script after the build.
+Existing signatures are replaced. Apps verify the signature with
+stuffbin.UnStuffEd25519() and the public key.`
+
+const verifySigHelpTxt = `
+Verify the Ed25519 signature of the payload of a stuffed binary with a
+public key. Encrypted payloads don't need the key. The exit code is
+non-zero if the payload isn't signed or the signature is invalid.`
+
+const checksumHelpTxt = `
+Print the SHA-256 hashes of the payload of a stuffed binary as it's stored
+and of every file in it in the sha256sum format, for instance, to record
+the provenance of a release. The payload's line is named binary:payload.
+File paths are relative to the payload's root, so that sha256sum -c checks
+the files that extract writes from the directory they're written to.`
+
+const stripHelpTxt = `
+Write the original binary without the payload of a stuffed binary. The
+binary can be stripped in-place with the same -in and -out. With
+-keep-payload, the payload and its ID are kept next to the output binary
+(eg: app.stuff) to attach them to it again as they were with attach, for
+instance, after signing the binary:
+
+stuffbin strip -keep-payload -in app -out app
+codesign -s - app
+stuffbin attach -in app -out app`
+
+const verifyHelpTxt = `
+Check the ID, the payload's bounds, checksum, and signature, and the CRC-32
+of every file of a stuffed binary. Corrupt files are listed and the exit
+code is non-zero.`
 
 var (
-	aID      = "id"
-	aStuff   = "stuff"
-	aUnstuff = "unstuff"
-	aStrip   = "strip"
+	aID        = "id"
+	aStuff     = "stuff"
+	aUnstuff   = "unstuff"
+	aExtract   = "extract"
+	aStrip     = "strip"
+	aAttach    = "attach"
+	aAdd       = "add"
+	aBuild     = "build"
+	aWatch     = "watch"
+	aDiff      = "diff"
+	aPatch     = "patch"
+	aVerify    = "verify"
+	aList      = "ls"
+	aCat       = "cat"
+	aServe     = "serve"
+	aRemove    = "rm"
+	aRepack    = "repack"
+	aSign      = "sign"
+	aEncrypt   = "encrypt"
+	aDecrypt   = "decrypt"
+	aVerifySig = "verify-sig"
+	aChecksum  = "checksum"
+	aVersion   = "version"
 
 	logger = log.New(os.Stdout, "", 0)
 )
 
-// id shows the ID and stuffed files in a given binary.
-func id(path string, l *log.Logger) error {
-	id, err := stuffbin.GetFileID(path)
+// listFlag is a flag that can be repeated to get a list of values.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// ls lists the files in a stuffed binary that match the optional glob
+// patterns, with their sizes, modes, modification times, CRC-32s, and
+// compression ratios if long is set. Encrypted payloads are decrypted
+// with the optional key.
+func ls(in string, patterns []string, long, asJSON bool, key stuffbin.KeyFunc, l *log.Logger) error {
+	files, err := stuffbin.ListStuff(in, stuffbin.UnStuffOpt{Key: key}, patterns...)
 	if err != nil {
 		if err == stuffbin.ErrNoID {
-			return fmt.Errorf("%s: %v", path, err)
+			return cli.WrapError(err, "%s: %v", in, err)
 		}
-		return fmt.Errorf("error reading file: %v", err)
+		return err
 	}
 
-	l.Printf("%s: %s (%0.2f KB binary, %0.2f KB stuff)\n\n",
-		path, id.Name, float64(id.BinSize)/1024, float64(id.ZipSize)/1024)
+	if asJSON {
+		enc := json.NewEncoder(l.Writer())
+		enc.SetIndent("", "  ")
+		return enc.Encode(files)
+	}
 
-	// Get stuffed zip data.
-	b, err := stuffbin.GetStuff(path)
-	if err != nil {
-		return err
+	for _, f := range files {
+		if !long {
+			l.Println(f.Path)
+			continue
+		}
+
+		enc := ""
+		if f.Encrypted {
+			enc = " (encrypted)"
+		}
+		l.Printf("%s %10d %10d %6.1f%% %08x %s %s%s", f.Mode, f.Size, f.CompressedSize, f.Ratio()*100,
+			f.CRC32, f.ModTime.Format("2006-01-02 15:04"), f.Path, enc)
+	}
+
+	return nil
+}
+
+var (
+	// errDone stops walking a stuffed binary's files.
+	errDone = errors.New("done")
+
+	// errFailed is returned by commands that have reported their
+	// failure and only need to exit with a non-zero code.
+	errFailed = errors.New("failed")
+)
+
+// cat writes the contents of the file with the given path in a stuffed
+// binary to w. Encrypted payloads are decrypted with the optional key.
+func cat(in, p string, key stuffbin.KeyFunc, w io.Writer) error {
+	p = path.Clean("/" + p)
+
+	err := stuffbin.WalkStuff(in, stuffbin.UnStuffOpt{Key: key}, func(fPath string, _ os.FileInfo, r io.Reader) error {
+		if fPath != p {
+			return nil
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+		return errDone
+	})
+	switch err {
+	case errDone:
+		return nil
+	case nil:
+		return fmt.Errorf("%s: file not found in %s", p, in)
+	case stuffbin.ErrNoID:
+		return cli.WrapError(err, "%s: %v", in, err)
 	}
+	return err
+}
 
-	// Unzip and list files.
-	fs, err := stuffbin.UnZip(b)
+// serve serves the files in a stuffed binary over HTTP on addr under the
+// URL path prefix, which is stripped from the paths of requests, like the
+// application would with FileSystem.FileServer(). Encrypted payloads are
+// decrypted with the optional key.
+func serve(in, addr, prefix string, key stuffbin.KeyFunc, l *slog.Logger) error {
+	fs, err := stuffbin.UnStuffWithOpt(in, stuffbin.UnStuffOpt{Key: key})
 	if err != nil {
+		if err == stuffbin.ErrNoID {
+			return cli.WrapError(err, "%s: %v", in, err)
+		}
 		return err
 	}
 
-	l.Printf("%d files totalling %0.2f KB\n", fs.Len(), float64(fs.Size())/1024)
-	for _, p := range fs.List() {
-		f, _ := fs.Get(p)
-		info, err := f.Stat()
-		if err != nil {
-			return fmt.Errorf("error reading %s: %v", p, err)
-		}
-		l.Printf("%0.2f KB \t\t %s", float64(info.Size())/1024, p)
+	var h http.Handler = stuffbin.WithPrecompressed(fs.FileServer(), fs)
+	if prefix = path.Clean("/" + prefix); prefix != "/" {
+		mux := http.NewServeMux()
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, h))
+		h = mux
 	}
 
-	return nil
+	l.Info(fmt.Sprintf("serving %d files from %s on %s%s/", fs.Len(), in, addr, strings.TrimSuffix(prefix, "/")),
+		"files", fs.Len(), "addr", addr, "prefix", prefix)
+	return http.ListenAndServe(addr, stuffbin.WithAccessLog(h, stuffbin.NewAccessLogger(slog.NewLogLogger(l.Handler(), slog.LevelInfo))))
 }
 
-// unstuff extracts the ZIP from a stuffed binary.
-func unstuff(in, out string, l *log.Logger) error {
+// unstuff extracts the ZIP from a stuffed binary. Encrypted
+// payloads are decrypted with the optional key.
+func unstuff(in, out string, key stuffbin.KeyFunc, l *slog.Logger) error {
 	id, err := stuffbin.GetFileID(in)
 	if err != nil {
 		if err == stuffbin.ErrNoID {
-			return fmt.Errorf("%s: %v", in, err)
+			return cli.WrapError(err, "%s: %v", in, err)
 		}
-		return fmt.Errorf("error reading file: %v", err)
+		return cli.WrapError(err, "error reading file: %v", err)
 	}
 
-	l.Printf("%s: %s (%v bytes original binary, %v bytes zipped stuff)\n\n",
-		in, id.Name, id.BinSize, id.ZipSize)
+	logID(l, in, id)
 
-	// Get stuffed zip data.
-	b, err := stuffbin.GetStuff(in)
+	// Write out via a temporary file that replaces the output, so that an
+	// interrupted run doesn't leave a truncated ZIP behind.
+	to, err := os.CreateTemp(filepath.Dir(out), "."+filepath.Base(out)+".*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(to.Name())
 
-	// Write out.
-	to, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE, 0755)
-	if err != nil {
+	// Stream the stuffed zip data.
+	if _, err := stuffbin.WriteStuff(in, to, stuffbin.UnStuffOpt{Key: key}); err != nil {
+		to.Close()
 		return err
 	}
-	defer to.Close()
+	if err := to.Chmod(0644); err != nil {
+		to.Close()
+		return err
+	}
+	if err := to.Sync(); err != nil {
+		to.Close()
+		return err
+	}
+	if err := to.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(to.Name(), out); err != nil {
+		return err
+	}
+	l.Info("wrote to "+out, "path", out)
 
-	_, err = io.Copy(to, bytes.NewReader(b))
+	return nil
+}
+
+// extract writes the files in a stuffed binary that match the optional
+// glob patterns to a directory with their permissions and modification times.
+func extract(in, dir string, patterns []string, key stuffbin.KeyFunc, l *slog.Logger) error {
+	n, err := stuffbin.ExtractStuff(in, dir, stuffbin.UnStuffOpt{Key: key}, patterns...)
 	if err != nil {
-		to.Close()
 		return err
 	}
-	l.Printf("wrote to %s", out)
+	l.Info(fmt.Sprintf("extracted %d files to %s", n, dir), "files", n, "dir", dir)
 
 	return nil
 }
 
-// strip strips the binary of stuffed files.
-func strip(in, out string, l *log.Logger) error {
-	id, err := stuffbin.GetFileID(in)
+// verifyOut is the JSON report of verify.
+type verifyOut struct {
+	Path  string          `json:"path"`
+	OK    bool            `json:"ok"`
+	Error string          `json:"error,omitempty"`
+	Files []verifyFileOut `json:"files"`
+}
+
+// verifyFileOut is the JSON report of a file in verifyOut.
+type verifyFileOut struct {
+	Path  string `json:"path"`
+	Size  uint64 `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// verify verifies the integrity of the payload and the files in a stuffed
+// binary and lists the files that fail, or writes a JSON report of all the
+// files if asJSON is set.
+func verify(in string, o stuffbin.UnStuffOpt, asJSON bool, l *log.Logger) error {
+	res, err := stuffbin.VerifyStuffWithOpt(in, o)
+	if asJSON {
+		return verifyJSON(in, res, err, l.Writer())
+	}
 	if err != nil {
 		if err == stuffbin.ErrNoID {
-			return fmt.Errorf("%s: %v", in, err)
+			return cli.WrapError(err, "%s: %v", in, err)
+		}
+		return cli.WrapError(err, "error verifying file: %v", err)
+	}
+
+	l.Printf("%s: %s (%v bytes original binary, %v bytes zipped stuff)\n\n", in, res.ID.Name, res.ID.BinSize, res.ID.ZipSize)
+	if res.Err != nil {
+		l.Printf("payload: %v", res.Err)
+	}
+
+	failed := 0
+	for _, f := range res.Files {
+		if f.Err != nil {
+			l.Printf("%s: %v", f.Path, f.Err)
+			failed++
 		}
-		return fmt.Errorf("error reading file: %v", err)
 	}
 
-	l.Printf("%s: %s (%v bytes original binary, %v bytes zipped stuff)\n\n", in, id.Name, id.BinSize, id.ZipSize)
+	if !res.OK() {
+		return cli.VerifyError("verification failed. %d of %d files are corrupt", failed, len(res.Files))
+	}
+	l.Printf("verified %d files", len(res.Files))
+
+	return nil
+}
+
+// watch stuffs the files into the binary whenever they change and restarts
+// the optional command after every stuffing until it's interrupted.
+func watch(in, out string, o stuffbin.StuffOpt, interval time.Duration, command, files []string, l *slog.Logger) error {
+	r := &runner{args: command, l: l}
+	stop := stuffbin.StuffWatch(in, out, o, interval, func(binLen, zipLen int64, err error) {
+		if err != nil {
+			l.Error(fmt.Sprintf("stuffing failed: %v", err))
+			return
+		}
+		cli.LogStuffed(l, binLen, zipLen)
+		r.restart()
+	}, files...)
 
-	from, err := os.Open(in)
+	l.Info(fmt.Sprintf("watching for changes every %v. Press Ctrl+C to stop.", interval), "interval", interval)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	stop()
+	r.stop()
+	return nil
+}
+
+// runner runs a command and restarts it on demand.
+type runner struct {
+	args []string
+	l    *slog.Logger
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	done    chan struct{}
+	stopped *atomic.Bool
+}
+
+// restart stops the running command, if any, and starts it again.
+func (r *runner) restart() {
+	if len(r.args) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopCmd()
+
+	cmd := exec.Command(r.args[0], r.args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		r.l.Error(fmt.Sprintf("error running %s: %v", r.args[0], err))
+		return
+	}
+
+	// Errors of commands that are stopped are expected.
+	var (
+		done    = make(chan struct{})
+		stopped = &atomic.Bool{}
+	)
+	go func() {
+		if err := cmd.Wait(); err != nil && !stopped.Load() {
+			r.l.Warn(fmt.Sprintf("%s: %v", r.args[0], err))
+		}
+		close(done)
+	}()
+	r.cmd, r.done, r.stopped = cmd, done, stopped
+}
+
+// stop stops the running command, if any.
+func (r *runner) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopCmd()
+}
+
+// stopCmd interrupts the running command and kills it if it doesn't
+// exit in a few seconds. Commands are killed right away on platforms
+// that can't interrupt them (Windows).
+func (r *runner) stopCmd() {
+	if r.cmd == nil {
+		return
+	}
+	r.stopped.Store(true)
+	select {
+	case <-r.done:
+	default:
+		if err := r.cmd.Process.Signal(os.Interrupt); err != nil {
+			r.cmd.Process.Kill()
+		}
+		select {
+		case <-r.done:
+		case <-time.After(5 * time.Second):
+			r.cmd.Process.Kill()
+			<-r.done
+		}
+	}
+	r.cmd, r.done, r.stopped = nil, nil, nil
+}
+
+// encrypt encrypts the payload of a stuffed binary.
+func encrypt(in, out string, o stuffbin.StuffOpt, l *slog.Logger) error {
+	_, zLen, err := stuffbin.EncryptStuff(in, out, o)
 	if err != nil {
-		return err
+		if err == stuffbin.ErrNoID {
+			return cli.WrapError(err, "%s: %v", in, err)
+		}
+		return cli.WrapError(err, "encrypting failed: %v", err)
+	}
+
+	l.Info(fmt.Sprintf("encrypted the %0.2f KB payload of %s", float64(zLen)/1024, out), "path", out, "zip_size", zLen)
+	return nil
+}
+
+// decrypt decrypts the payload of a stuffed binary.
+func decrypt(in, out string, key stuffbin.KeyFunc, l *slog.Logger) error {
+	_, zLen, err := stuffbin.DecryptStuff(in, out, key)
+	if err != nil {
+		if err == stuffbin.ErrNoID {
+			return cli.WrapError(err, "%s: %v", in, err)
+		}
+		return cli.WrapError(err, "decrypting failed: %v", err)
 	}
-	defer from.Close()
 
-	// Write out.
-	to, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE, 0755)
+	l.Info(fmt.Sprintf("decrypted the %0.2f KB payload of %s", float64(zLen)/1024, out), "path", out, "zip_size", zLen)
+	return nil
+}
+
+// sign signs the payload of a stuffed binary with an Ed25519 private key.
+func sign(in, out string, key ed25519.PrivateKey, l *slog.Logger) error {
+	_, zLen, err := stuffbin.SignStuff(in, out, key)
+	if err != nil {
+		if err == stuffbin.ErrNoID {
+			return cli.WrapError(err, "%s: %v", in, err)
+		}
+		return cli.WrapError(err, "signing failed: %v", err)
+	}
+
+	l.Info(fmt.Sprintf("signed the %0.2f KB payload of %s", float64(zLen)/1024, out), "path", out, "zip_size", zLen)
+	return nil
+}
+
+// verifySig verifies the Ed25519 signature of the payload of a stuffed binary.
+func verifySig(in string, pub ed25519.PublicKey, l *slog.Logger) error {
+	if err := stuffbin.VerifySignature(in, pub); err != nil {
+		return cli.WrapError(err, "%s: %v", in, err)
+	}
+
+	l.Info(in+": signature OK", "path", in)
+	return nil
+}
+
+// version is the version of the CLI, which can be set at build time with
+// -ldflags "-X main.version=v1.2.3". It defaults to the module version.
+var version = ""
+
+// cliVersion returns the version of the CLI and the Go version it's built with.
+func cliVersion() (string, string) {
+	v, goVer := version, runtime.Version()
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if v == "" {
+			v = bi.Main.Version
+		}
+		goVer = bi.GoVersion
+	}
+	if v == "" {
+		v = "(devel)"
+	}
+	return v, goVer
+}
+
+// showVersion writes the version of the CLI, the payload formats it
+// supports, and those of the optional stuffed binaries to l.
+func showVersion(ins []string, l *log.Logger) error {
+	v, goVer := cliVersion()
+	l.Printf("stuffbin %s (%s %s/%s)", v, goVer, runtime.GOOS, runtime.GOARCH)
+	l.Printf("payload format v%d (reads v1 to v%d)", stuffbin.IDVersion, stuffbin.IDVersion)
+
+	for _, in := range ins {
+		id, err := stuffbin.GetFileID(in)
+		if err != nil {
+			if err == stuffbin.ErrNoID {
+				return cli.WrapError(err, "%s: %v", in, err)
+			}
+			return cli.WrapError(err, "error reading file: %v", err)
+		}
+		l.Printf("%s: payload format v%d", in, id.Version)
+		cli.WarnVersion(lg, in, id)
+	}
+	return nil
+}
+
+// checksum writes the SHA-256 hashes of the payload of a stuffed binary
+// and of its files, or only of the files if filesOnly is set, to w in the
+// sha256sum format. Encrypted payloads are decrypted with the optional key.
+func checksum(in string, filesOnly bool, key stuffbin.KeyFunc, w io.Writer) error {
+	sums, err := stuffbin.ChecksumStuff(in, stuffbin.UnStuffOpt{Key: key})
 	if err != nil {
+		if err == stuffbin.ErrNoID {
+			return cli.WrapError(err, "%s: %v", in, err)
+		}
 		return err
 	}
-	defer to.Close()
 
-	_, err = io.Copy(to, from)
+	if !filesOnly {
+		if _, err := fmt.Fprintf(w, "%x  %s:payload\n", sums.Payload, in); err != nil {
+			return err
+		}
+	}
+	for _, f := range sums.Files {
+		if _, err := fmt.Fprintf(w, "%x  %s\n", f.Sum, strings.TrimPrefix(f.Path, "/")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyJSON writes the JSON report of a verification result and its
+// error to w and returns errFailed with the exit code of the error, or
+// cli.ExitVerify, if the verification failed.
+func verifyJSON(in string, res stuffbin.VerifyResult, err error, w io.Writer) error {
+	out := verifyOut{
+		Path:  in,
+		OK:    err == nil && res.OK(),
+		Files: make([]verifyFileOut, 0, len(res.Files)),
+	}
+	if err == nil {
+		err = res.Err
+	}
 	if err != nil {
-		to.Close()
+		out.Error = err.Error()
+	}
+	for _, f := range res.Files {
+		fo := verifyFileOut{Path: f.Path, Size: f.Size}
+		if f.Err != nil {
+			fo.Error = f.Err.Error()
+		}
+		out.Files = append(out.Files, fo)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
 		return err
 	}
+	if !out.OK {
+		code := cli.ExitVerify
+		if err != nil && err != res.Err {
+			code = cli.ExitCode(err)
+		}
+		return &cli.ExitError{Code: code, Err: errFailed}
+	}
+	return nil
+}
+
+// logID logs the sizes in the ID of a stuffed binary.
+func logID(l *slog.Logger, in string, id stuffbin.ID) {
+	l.Info(fmt.Sprintf("%s: %s (%v bytes original binary, %v bytes zipped stuff)", in, id.Name, id.BinSize, id.ZipSize),
+		"path", in, "bin_size", id.BinSize, "zip_size", id.ZipSize)
+}
 
-	// Truncate the file to its original length, losing the stuffed zip.
-	if err := to.Truncate(int64(id.BinSize)); err != nil {
-		l.Fatalf("error stripping binary: %v", err)
+// strip strips the binary of stuffed files, optionally keeping the payload
+// in a file to attach it again.
+func strip(in, out string, keep bool, l *slog.Logger) error {
+	id, err := stuffbin.GetFileID(in)
+	if err != nil {
+		if err == stuffbin.ErrNoID {
+			return cli.WrapError(err, "%s: %v", in, err)
+		}
+		return cli.WrapError(err, "error reading file: %v", err)
+	}
+	st, err := os.Stat(in)
+	if err != nil {
+		return cli.WrapError(err, "error reading file: %v", err)
 	}
 
-	l.Printf("wrote stripped binary '%s'", out)
+	logID(l, in, id)
 
-	return to.Sync()
+	// Write out the original binary, losing the stuffed zip.
+	size, err := stuffbin.StripWithOpt(in, out, stuffbin.StripOpt{KeepPayload: keep})
+	if err != nil {
+		return cli.WrapError(err, "error stripping binary: %v", err)
+	}
+
+	removed := st.Size() - size
+	l.Info(fmt.Sprintf("wrote stripped binary '%s'. removed %d bytes (%0.2f KB)", out, removed, float64(removed)/1024),
+		"path", out, "size", size, "removed", removed)
+	if keep {
+		l.Info(fmt.Sprintf("kept the payload in '%s'", out+stuffbin.SidecarExt), "path", out+stuffbin.SidecarExt)
+	}
+	return nil
 }
 
-func main() {
+// attach attaches a payload that was kept by strip to a binary.
+func attach(in, out, payload string, l *slog.Logger) error {
+	binSize, zLen, err := stuffbin.Attach(in, out, payload)
+	if err != nil {
+		return cli.WrapError(err, "error attaching payload: %v", err)
+	}
+
+	l.Info(fmt.Sprintf("attached '%s' to '%s' (%0.2f KB binary, %0.2f KB zip stuff)", payload, out, float64(binSize)/1024, float64(zLen)/1024),
+		"path", out, "payload", payload, "bin_size", binSize, "zip_size", zLen)
+	return nil
+}
+
+// compare lists the files that were added, removed, or modified in the
+// stuffed binary newBin with their sizes. Encrypted payloads are decrypted
+// with the optional key.
+func compare(oldBin, newBin string, key stuffbin.KeyFunc, asJSON bool, l *log.Logger) error {
+	changes, err := stuffbin.CompareStuff(oldBin, newBin, stuffbin.UnStuffOpt{Key: key})
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		if changes == nil {
+			changes = []stuffbin.Change{}
+		}
+		enc := json.NewEncoder(l.Writer())
+		enc.SetIndent("", "  ")
+		return enc.Encode(changes)
+	}
+
 	var (
-		fAction = flag.String("a", "", fmt.Sprintf("action (%s, %s, %s, %s)", aID, aStuff, aUnstuff, aStrip))
-		fIn     = flag.String("in", "", "path to the input binary")
-		fRoot   = flag.String("root", "/", "(optional) root path to bind all files to")
-		fOut    = flag.String("out", "", "path to the output binary (stuff) or zip file (unstuff)")
+		delta  int64
+		counts = map[string]int{}
 	)
+	for _, c := range changes {
+		switch c.Kind {
+		case stuffbin.ChangeAdded:
+			l.Printf("+ %s (%0.2f KB)", c.Path, float64(c.NewSize)/1024)
+		case stuffbin.ChangeRemoved:
+			l.Printf("- %s (%0.2f KB)", c.Path, float64(c.OldSize)/1024)
+		default:
+			l.Printf("~ %s (%+0.2f KB, %0.2f KB -> %0.2f KB)", c.Path,
+				float64(c.Delta())/1024, float64(c.OldSize)/1024, float64(c.NewSize)/1024)
+		}
+		counts[c.Kind]++
+		delta += c.Delta()
+	}
+
+	l.Printf("%d files changed: %d added, %d removed, %d modified (%+0.2f KB)", len(changes),
+		counts[stuffbin.ChangeAdded], counts[stuffbin.ChangeRemoved], counts[stuffbin.ChangeModified], float64(delta)/1024)
+	return nil
+}
 
-	// Usage help.
-	flag.Usage = func() {
-		logger.Printf("stuffbin\n")
-		logger.Println(helpTxt)
-		flag.PrintDefaults()
+// diff writes a patch that turns the binary in into newBin to out.
+func diff(in, newBin, out string, l *slog.Logger) error {
+	b, err := stuffbin.MakePatch(in, newBin)
+	if err != nil {
+		return cli.WrapError(err, "error making patch: %v", err)
+	}
+	if err := os.WriteFile(out, b, 0644); err != nil {
+		return err
 	}
 
-	flag.Parse()
-	if flag.NFlag() == 0 {
-		flag.Usage()
-		return
+	st, err := os.Stat(newBin)
+	if err != nil {
+		return err
 	}
+	l.Info(fmt.Sprintf("wrote patch '%s' (%d bytes, %.1f%% of %s)", out, len(b), float64(len(b))*100/float64(st.Size()), newBin),
+		"path", out, "size", len(b))
+	return nil
+}
 
-	// Validate actions.
-	if *fAction != aID && *fAction != aStuff && *fAction != aUnstuff && *fAction != aStrip {
-		logger.Fatal("unknown action")
+// patch applies the patch file p to the binary in and writes the new binary to out.
+func patch(in, p, out string, l *slog.Logger) error {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return err
 	}
+	if err := stuffbin.ApplyPatch(in, out, b); err != nil {
+		return cli.WrapError(err, "error applying patch: %v", err)
+	}
+
+	l.Info(fmt.Sprintf("wrote patched binary '%s'", out), "path", out)
+	return nil
+}
 
-	// Validate input binary path.
-	if *fIn == "" {
-		logger.Fatal("provide an input path")
+func main() {
+	args := legacyArgs(os.Args[1:])
+	if len(args) == 0 {
+		usage()
+		return
 	}
 
-	// Show the file ID.
-	if *fAction == aID {
-		if err := id(*fIn, logger); err != nil {
-			logger.Fatal(err)
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		if len(args) < 2 {
+			usage()
+			return
 		}
-		return
+		name, args = args[1], []string{args[1], "-h"}
 	}
 
-	// Validate output binary path.
-	if *fOut == "" {
-		logger.Fatalf("provide an output path")
+	if name == "-version" || name == "--version" {
+		name = aVersion
 	}
 
-	// Unstuff bundled files.
-	if *fAction == aUnstuff {
-		if err := unstuff(*fIn, *fOut, logger); err != nil {
-			logger.Fatal(err)
+	c, ok := getCommand(name)
+	if !ok {
+		logger.Printf("unknown command '%s'\n", name)
+		usage()
+		os.Exit(cli.ExitUsage)
+	}
+	if err := c.run(args[1:]); err != nil {
+		if !errors.Is(err, errFailed) {
+			lg.Error(err.Error())
 		}
-		return
+		os.Exit(cli.ExitCode(err))
 	}
+}
 
-	// Strip binary of zip files.
-	if *fAction == aStrip {
-		if err := strip(*fIn, *fOut, logger); err != nil {
-			logger.Fatal(err)
-		}
-		return
+// usage prints the help text and the commands.
+func usage() {
+	logger.Printf("stuffbin\n")
+	logger.Println(helpTxt)
+	for _, c := range commands {
+		logger.Printf("  %-10s %s", c.name, c.desc)
 	}
+	logger.Println(exitCodesTxt)
+	logger.Printf("\nRun 'stuffbin <command> -h' for the flags of a command.")
+}
 
-	// Valid the list of files to embed.
-	if flag.NArg() == 0 {
-		logger.Fatalf("provide one or more files to embed")
+// legacyArgs turns the arguments of the old -a action flag
+// (eg: -a stuff -in ...) into the arguments of the command.
+func legacyArgs(args []string) []string {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "-") {
+		return args
 	}
 
-	// Build.
-	binLen, zipLen, err := stuffbin.Stuff(*fIn, *fOut, *fRoot, flag.Args()...)
-	if err != nil {
-		logger.Fatalf("stuffing failed: %v", err)
+	for n, a := range args {
+		var action string
+		switch {
+		case (a == "-a" || a == "--a") && n+1 < len(args):
+			action = args[n+1]
+			args = append(args[:n:n], args[n+2:]...)
+		case strings.HasPrefix(a, "-a=") || strings.HasPrefix(a, "--a="):
+			_, action, _ = strings.Cut(a, "=")
+			args = append(args[:n:n], args[n+1:]...)
+		default:
+			continue
+		}
+
+		lg.Warn(fmt.Sprintf("-a is deprecated. Use 'stuffbin %s [flags]' instead", action))
+		return append([]string{action}, args...)
 	}
-	logger.Printf("stuffing complete. binary size is %0.2f KB and stuffed zip size is %0.2f KB.",
-		float64(binLen)/1024, float64(zipLen)/1024)
+	return args
 }
@@ -0,0 +1,157 @@
+package stuffbin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Target is an input binary and the output path of its stuffed copy.
+type Target struct {
+	In  string
+	Out string
+}
+
+// StuffTargets stuffs the same files into multiple binaries, for instance,
+// the cross-compiled binaries of a release (linux/amd64, darwin/arm64,
+// windows/amd64). The files are compressed once into a temporary file and
+// the payload is copied into the binaries in parallel. It returns the size
+// of the stuffed ZIP. Incremental doesn't apply and PostStuff is called for
+// every binary, concurrently.
+func StuffTargets(targets []Target, o StuffOpt, files ...string) (int64, error) {
+	o, err := checkStuffOpt(o)
+	if err != nil {
+		return 0, err
+	}
+	if o.DryRun != nil {
+		_, zLen, err := dryRun(o, makeEntries(files), nil)
+		return zLen, err
+	}
+	return stuffTargets(targets, o, makeEntries(files))
+}
+
+// StuffManifestTargets is StuffTargets with the files in a Manifest and
+// StuffOpt options (see StuffManifestWithOpt). If the manifest has files
+// for specific platforms, the files are compressed once for the binaries
+// of every platform. It returns the size of the largest stuffed ZIP.
+func StuffManifestTargets(targets []Target, m Manifest, o StuffOpt) (int64, error) {
+	o, err := checkStuffOpt(o)
+	if err != nil {
+		return 0, err
+	}
+
+	// Group the binaries by their platforms if they're needed.
+	needPlatform := false
+	for _, f := range m.Files {
+		if len(f.Platforms) > 0 {
+			needPlatform = true
+		}
+	}
+	var (
+		platforms []string
+		groups    = make(map[string][]Target)
+	)
+	for _, t := range targets {
+		p := m.Platform
+		if p == "" && needPlatform {
+			if p, err = BinaryPlatform(t.In); err != nil {
+				return 0, fmt.Errorf("%s: %v. Set the platform in the manifest", t.In, err)
+			}
+		}
+		if _, ok := groups[p]; !ok {
+			platforms = append(platforms, p)
+		}
+		groups[p] = append(groups[p], t)
+	}
+
+	var zLen int64
+	for _, p := range platforms {
+		pm := m
+		pm.Platform = p
+		entries, err := manifestEntries("", pm)
+		if err != nil {
+			return 0, err
+		}
+
+		var n int64
+		if o.DryRun != nil {
+			_, n, err = dryRun(o, entries, nil)
+		} else {
+			n, err = stuffTargets(groups[p], o, entries)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n > zLen {
+			zLen = n
+		}
+	}
+
+	return zLen, nil
+}
+
+// stuffTargets compresses the entries once into a temporary file and
+// copies the payload into the binaries with workers in parallel. The
+// options should have been checked with checkStuffOpt.
+func stuffTargets(targets []Target, o StuffOpt, entries []stuffEntry) (int64, error) {
+	f, err := os.CreateTemp("", "stuffbin-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	id, err := encodePayload(f, o, entries, nil)
+	if err != nil {
+		return 0, err
+	}
+	zLen := int64(id.ZipSize)
+
+	var (
+		errs = make([]error, len(targets))
+		next int64
+		wg   sync.WaitGroup
+	)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(targets) {
+		workers = len(targets)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1) - 1)
+				if i >= len(targets) {
+					return
+				}
+
+				t := targets[i]
+				if _, _, err := stuffBinary(t.In, t.Out, o, func(w io.Writer, binSize int64, sec *section) (int64, int64, error) {
+					if _, err := io.Copy(w, io.NewSectionReader(f, 0, zLen)); err != nil {
+						return 0, 0, err
+					}
+
+					n, err := writeID(w, id, binSize, sec)
+					if err != nil {
+						return 0, 0, err
+					}
+					return zLen, zLen + n, nil
+				}); err != nil {
+					errs[i] = fmt.Errorf("%s: %v", t.In, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+	return zLen, nil
+}
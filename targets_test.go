@@ -0,0 +1,80 @@
+package stuffbin
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestStuffTargets(t *testing.T) {
+	dir := t.TempDir()
+	b, err := os.ReadFile(mockBin)
+	assert(t, "error reading file", nil, err)
+
+	// Binaries of different sizes.
+	var targets []Target
+	for n, name := range []string{"linux", "darwin", "windows.exe"} {
+		in := filepath.Join(dir, name)
+		assert(t, "error writing file", nil, os.WriteFile(in, append(b, make([]byte, n*100)...), 0755))
+		targets = append(targets, Target{In: in, Out: in + ".stuffed"})
+	}
+
+	zLen, err := StuffTargets(targets, StuffOpt{Checksum: true}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	var payload []byte
+	for n, tg := range targets {
+		id, err := GetFileID(tg.Out)
+		assert(t, "error getting file ID", nil, err)
+		assert(t, "mismatch in bin size", uint64(mockExeSize+n*100), id.BinSize)
+		assert(t, "mismatch in zip size", uint64(zLen), id.ZipSize)
+
+		fs, err := UnStuff(tg.Out)
+		assert(t, "error unstuffing", nil, err)
+		f := fs.List()
+		sort.Strings(f)
+		assert(t, "mismatch in unstuffed file paths", stuffedFiles, f)
+
+		// The payload is compressed once.
+		p, err := GetStuff(tg.Out)
+		assert(t, "error getting stuff", nil, err)
+		if payload == nil {
+			payload = p
+		}
+		assert(t, "mismatch in payloads", true, bytes.Equal(payload, p))
+	}
+
+	_, err = StuffTargets([]Target{{In: filepath.Join(dir, "nonexistent"), Out: filepath.Join(dir, "out")}}, StuffOpt{}, localFiles...)
+	assert(t, "expected error on missing binary", true, err != nil)
+}
+
+func TestStuffManifestTargets(t *testing.T) {
+	dir := t.TempDir()
+	targets := []Target{
+		{In: mockBin, Out: filepath.Join(dir, "a")},
+		{In: mockBin, Out: filepath.Join(dir, "b")},
+	}
+	m := Manifest{
+		Files: []ManifestFile{
+			{Src: "mock/foo.txt"},
+			{Src: "mock/bar.txt", Platforms: []string{"windows"}},
+		},
+	}
+
+	// Non-Go binaries need an explicit platform.
+	_, err := StuffManifestTargets(targets, m, StuffOpt{})
+	assert(t, "expected error without a platform", true, err != nil)
+
+	m.Platform = "windows/amd64"
+	_, err = StuffManifestTargets(targets, m, StuffOpt{})
+	assert(t, "error stuffing", nil, err)
+	for _, tg := range targets {
+		fs, err := UnStuff(tg.Out)
+		assert(t, "error unstuffing", nil, err)
+		f := fs.List()
+		sort.Strings(f)
+		assert(t, "mismatch in unstuffed file paths", []string{"/mock/bar.txt", "/mock/foo.txt"}, f)
+	}
+}
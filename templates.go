@@ -0,0 +1,293 @@
+package stuffbin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FSLoader is a function that loads and returns a FileSystem, for instance,
+// a closure that calls NewLocalFS() with a list of local template paths.
+type FSLoader func() (FileSystem, error)
+
+// Layout represents glob patterns for grouping template files in a
+// FileSystem into layouts, partials, and pages for composition
+// with ParseLayout.
+type Layout struct {
+	// Layouts are the base templates (eg: /templates/layouts/*.html).
+	Layouts string
+
+	// Partials are reusable blocks (eg: /templates/partials/*.html).
+	Partials string
+
+	// Pages are the individual pages (eg: /templates/pages/*.html).
+	Pages string
+}
+
+// TemplateSet holds templates parsed from a FileSystem with
+// ParseTemplatesGlob. The templates can be atomically reloaded at runtime,
+// for instance, in development mode to see template edits without restarting
+// the application while still using the stuffed FileSystem in production.
+type TemplateSet struct {
+	funcs   template.FuncMap
+	pattern string
+
+	mu   sync.RWMutex
+	tpl  *template.Template
+	hash [sha256.Size]byte
+}
+
+// NewTemplateSet parses templates matching the given pattern from the
+// FileSystem and returns a TemplateSet.
+func NewTemplateSet(f template.FuncMap, fs FileSystem, pattern string) (*TemplateSet, error) {
+	t := &TemplateSet{
+		funcs:   f,
+		pattern: pattern,
+	}
+	if _, err := t.Reload(fs); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Get returns the current parsed template.
+func (t *TemplateSet) Get() *template.Template {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tpl
+}
+
+// ExecuteTemplate executes the named template from the current
+// parsed template.
+func (t *TemplateSet) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	return t.Get().ExecuteTemplate(w, name, data)
+}
+
+// Lookup returns the template parsed from the given file path
+// from the current parsed template, or nil if there is none.
+func (t *TemplateSet) Lookup(path string) *template.Template {
+	return LookupTemplate(t.Get(), path)
+}
+
+// Reload re-parses the templates from the given FileSystem and swaps the
+// current template if any of the matching files have changed. It returns
+// true if the templates were swapped. On error, the current template is
+// retained.
+func (t *TemplateSet) Reload(fs FileSystem) (bool, error) {
+	h, err := t.hashFiles(fs)
+	if err != nil {
+		return false, err
+	}
+
+	t.mu.RLock()
+	same := t.tpl != nil && h == t.hash
+	t.mu.RUnlock()
+	if same {
+		return false, nil
+	}
+
+	tpl, err := ParseTemplatesGlob(t.funcs, fs, t.pattern)
+	if err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	t.tpl = tpl
+	t.hash = h
+	t.mu.Unlock()
+
+	return true, nil
+}
+
+// Watch invokes the loader every interval and reloads the templates from the
+// FileSystem it returns whenever they change. Errors in loading or parsing
+// are sent to the optional onErr callback and the current template is
+// retained. It returns a function that stops watching.
+func (t *TemplateSet) Watch(interval time.Duration, load FSLoader, onErr func(error)) func() {
+	var (
+		tk   = time.NewTicker(interval)
+		stop = make(chan struct{})
+		once sync.Once
+	)
+
+	go func() {
+		defer tk.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tk.C:
+			}
+
+			fs, err := load()
+			if err == nil {
+				_, err = t.Reload(fs)
+			}
+			if err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(stop) })
+	}
+}
+
+// ParseLayout parses the layouts and partials matching the patterns in the
+// given Layout once and composes every page with a copy of them. It returns
+// a map of page paths (eg: /templates/pages/index.html) to their composed
+// templates. Executing a composed template executes the first layout, which
+// can in turn execute blocks defined by the page. If there are no layouts,
+// the page itself is executed.
+func ParseLayout(f template.FuncMap, fs FileSystem, l Layout) (map[string]*template.Template, error) {
+	if l.Pages == "" {
+		return nil, fmt.Errorf("no pages pattern in call to ParseLayout")
+	}
+
+	// Parse the shared layouts and partials.
+	var shared []string
+	for _, pattern := range []string{l.Layouts, l.Partials} {
+		if pattern == "" {
+			continue
+		}
+		paths, err := fs.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(paths)
+		shared = append(shared, paths...)
+	}
+
+	pages, err := fs.Glob(l.Pages)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("pattern %s matches no files", l.Pages)
+	}
+
+	var base *template.Template
+	if len(shared) > 0 {
+		base, err = ParseTemplates(f, fs, shared...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		out  = make(map[string]*template.Template, len(pages))
+		errs TemplateErrors
+	)
+	for _, p := range pages {
+		// No layouts or partials. The page is standalone.
+		if base == nil {
+			tpl, err := ParseTemplates(f, fs, p)
+			if err != nil {
+				if e, ok := err.(TemplateErrors); ok {
+					errs = append(errs, e...)
+					continue
+				}
+				return nil, err
+			}
+			out[p] = tpl
+			continue
+		}
+
+		b, err := fs.Read(p)
+		if err != nil {
+			errs = append(errs, newTemplateError(p, "", err))
+			continue
+		}
+
+		tpl, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+
+		name := cleanPath("/", p)
+		if _, err := tpl.New(name).Parse(string(b)); err != nil {
+			errs = append(errs, newTemplateError(p, name, err))
+			continue
+		}
+		out[p] = tpl
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return out, nil
+}
+
+// ExecuteToFS executes every template associated with tpl that was parsed
+// from a file (ie: is named after a file path, eg: /pages/index.html) with
+// the given data and writes the output to the destination FileSystem under
+// the same path. Existing files in the destination are overwritten. This is
+// useful for pre-rendering static pages.
+func ExecuteToFS(tpl *template.Template, data interface{}, dest FileSystem) error {
+	now := time.Now()
+	for _, t := range tpl.Templates() {
+		name := t.Name()
+		if !strings.HasPrefix(name, "/") {
+			continue
+		}
+
+		b := &bytes.Buffer{}
+		if err := t.Execute(b, data); err != nil {
+			return err
+		}
+
+		// Overwrite existing files.
+		if f, err := dest.Get(name); err == nil {
+			f.Close()
+			if err := dest.Delete(name); err != nil {
+				return err
+			}
+		}
+
+		info := &fileInfo{
+			name:    path.Base(name),
+			size:    int64(b.Len()),
+			mode:    0644,
+			modTime: now,
+		}
+		if err := dest.Add(newFile(name, info, b.Bytes())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashFiles returns a hash of the paths and contents of all the files
+// in the FileSystem matching the TemplateSet's pattern.
+func (t *TemplateSet) hashFiles(fs FileSystem) ([sha256.Size]byte, error) {
+	var out [sha256.Size]byte
+
+	paths, err := fs.Glob(t.pattern)
+	if err != nil {
+		return out, err
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, p := range paths {
+		b, err := fs.Read(p)
+		if err != nil {
+			return out, err
+		}
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+		h.Write(b)
+	}
+
+	copy(out[:], h.Sum(nil))
+	return out, nil
+}
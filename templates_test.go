@@ -0,0 +1,162 @@
+package stuffbin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTemplateSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stuffbin")
+	assert(t, "error creating temp dir", nil, err)
+	defer os.RemoveAll(dir)
+
+	fPath := filepath.Join(dir, "index.html")
+	err = ioutil.WriteFile(fPath, []byte(`{{ define "index" }}one{{ end }}`), 0644)
+	assert(t, "error writing template", nil, err)
+
+	load := func() (FileSystem, error) {
+		return NewLocalFS("/", fPath+":/templates/index.html")
+	}
+	fs, err := load()
+	assert(t, "error creating local FS", nil, err)
+
+	ts, err := NewTemplateSet(nil, fs, "/templates/*.html")
+	assert(t, "error creating template set", nil, err)
+
+	b := bytes.Buffer{}
+	err = ts.ExecuteTemplate(&b, "index", nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in executed template", "one", b.String())
+
+	// Reloading an unchanged FS should be a no-op.
+	ok, err := ts.Reload(fs)
+	assert(t, "error reloading", nil, err)
+	assert(t, "unchanged reload", false, ok)
+
+	// Change the template and watch for the reload.
+	errs := make(chan error, 10)
+	stop := ts.Watch(time.Millisecond*5, load, func(err error) { errs <- err })
+	defer stop()
+
+	err = writeFileAtomic(fPath, []byte(`{{ define "index" }}two{{ end }}`))
+	assert(t, "error writing template", nil, err)
+
+	for i := 0; i < 100; i++ {
+		b.Reset()
+		_ = ts.ExecuteTemplate(&b, "index", nil)
+		if b.String() == "two" {
+			break
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	assert(t, "mismatch in reloaded template", "two", b.String())
+
+	// A broken template should be reported and the old one retained.
+	err = writeFileAtomic(fPath, []byte(`{{ define "index" }}{{ end `))
+	assert(t, "error writing template", nil, err)
+
+	select {
+	case err = <-errs:
+	case <-time.After(time.Second):
+	}
+	assert(t, "expected reload error", true, err != nil)
+
+	b.Reset()
+	err = ts.ExecuteTemplate(&b, "index", nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in retained template", "two", b.String())
+}
+
+// writeFileAtomic writes a file via a rename so that a concurrent
+// watcher never reads a partially written file.
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func TestParseLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stuffbin")
+	assert(t, "error creating temp dir", nil, err)
+	defer os.RemoveAll(dir)
+
+	for p, b := range map[string]string{
+		"/layouts/base.html":    `<main>{{ template "content" . }}</main>{{ template "footer" }}`,
+		"/partials/footer.html": `{{ define "footer" }}<footer/>{{ end }}`,
+		"/pages/index.html":     `{{ define "content" }}index{{ end }}`,
+		"/pages/about.html":     `{{ define "content" }}about {{ . }}{{ end }}`,
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0755)
+		assert(t, "error creating dir", nil, err)
+		err = ioutil.WriteFile(filepath.Join(dir, p), []byte(b), 0644)
+		assert(t, "error writing template", nil, err)
+	}
+
+	fs, err := NewLocalFS("/", dir+":/")
+	assert(t, "error creating local FS", nil, err)
+
+	pages, err := ParseLayout(nil, fs, Layout{
+		Layouts:  "/layouts/*.html",
+		Partials: "/partials/*.html",
+		Pages:    "/pages/*.html",
+	})
+	assert(t, "error parsing layout", nil, err)
+	assert(t, "page count", 2, len(pages))
+
+	b := bytes.Buffer{}
+	err = pages["/pages/index.html"].Execute(&b, nil)
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in composed page", "<main>index</main><footer/>", b.String())
+
+	b.Reset()
+	err = pages["/pages/about.html"].Execute(&b, "us")
+	assert(t, "template execute failed", nil, err)
+	assert(t, "mismatch in composed page", "<main>about us</main><footer/>", b.String())
+
+	_, err = ParseLayout(nil, fs, Layout{Pages: "/nope/*.html"})
+	assert(t, "expected error on no pages", true, err != nil)
+}
+
+func TestExecuteToFS(t *testing.T) {
+	fs, err := NewLocalFS("/", "mock/bar.txt:/pages/bar.txt", "mock/foo.txt:/pages/foo.txt", "mock/foofunc.txt:/pages/foofunc.txt")
+	assert(t, "error creating local FS", nil, err)
+
+	mp := map[string]interface{}{
+		"Foo": func() string {
+			return "func"
+		},
+	}
+	tpl, err := ParseTemplatesGlob(mp, fs, "/pages/*.txt")
+	assert(t, "error parsing template", nil, err)
+
+	// Existing files should be overwritten.
+	dest, err := NewLocalFS("/", "mock/subdir/baz.txt:/pages/bar.txt")
+	assert(t, "error creating local FS", nil, err)
+
+	err = ExecuteToFS(tpl, nil, dest)
+	assert(t, "error executing to FS", nil, err)
+
+	f := dest.List()
+	sort.Strings(f)
+	assert(t, "mismatch in rendered files", []string{"/pages/bar.txt", "/pages/foo.txt", "/pages/foofunc.txt"}, f)
+
+	b, err := dest.Read("/pages/bar.txt")
+	assert(t, "error reading rendered file", nil, err)
+	assert(t, "mismatch in rendered file", "bar", string(b))
+
+	b, err = dest.Read("/pages/foo.txt")
+	assert(t, "error reading rendered file", nil, err)
+	assert(t, "mismatch in rendered file", "foo\nfoo - func\n", string(b))
+
+	f2, _ := dest.Get("/pages/foo.txt")
+	info, _ := f2.Stat()
+	assert(t, "rendered file size", len(b), info.Size())
+	assert(t, "FS size", 3+len(b), dest.Size())
+}
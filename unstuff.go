@@ -3,21 +3,204 @@ package stuffbin
 import (
 	"archive/zip"
 	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
 )
 
+// maxInt is the maximum value of an int on the platform, which is the
+// maximum size of a byte slice. Payloads and files bigger than this (> 2GB
+// on 32 bit platforms) can't be loaded into memory.
+const maxInt = uint64(^uint(0) >> 1)
+
+// maxPrealloc is the most that's allocated up front for a file from its
+// size in the payload, which isn't trusted. Bigger files grow as they're read.
+const maxPrealloc = 1 << 20
+
+// UnStuffOpt represents options for unstuffing files.
+type UnStuffOpt struct {
+	// SkipVerify skips verifying the checksum of payloads
+	// that were stuffed with StuffOpt.Checksum.
+	SkipVerify bool
+
+	// HMACKey is an optional secret key that the payload's
+	// HMAC-SHA256 signature is verified with. Payloads without
+	// a valid signature are rejected with ErrSignature.
+	HMACKey []byte
+
+	// PublicKey is an optional Ed25519 public key that the payload's
+	// signature is verified with. Payloads without a valid signature
+	// are rejected with ErrSignature.
+	PublicKey ed25519.PublicKey
+
+	// Key is an optional function that returns the key, the passphrase,
+	// or the age identities that encrypted payloads are decrypted with.
+	// Encrypted payloads can't be read without it and are rejected with
+	// ErrNoKey. Files that are encrypted individually are skipped without it.
+	Key KeyFunc
+
+	// Progress is an optional function that's called as files are unstuffed.
+	Progress ProgressFunc
+
+	// MaxFileSize and MaxSize are optional limits of the uncompressed
+	// size of a file and of all the files in a payload, as a guard
+	// against ZIP bombs in payloads from less trusted sources (eg: themes,
+	// plugins). Payloads that exceed them are rejected with a SizeError
+	// before they're decompressed. Payloads compressed with CodecZstd
+	// are also rejected if they decompress to more than twice MaxSize.
+	MaxFileSize int64
+	MaxSize     int64
+
+	// SidecarDir is the optional directory to read the sidecar payloads of
+	// binaries stuffed with StuffOpt.Sidecar from. Defaults to the directory
+	// of the binary, or the working directory with UnStuffFrom.
+	SidecarDir string
+
+	// Lazy only reads the paths, sizes, and metadata of the files from
+	// the payload's ZIP directory when unstuffing, and decompresses a file
+	// when it's first read, for instance, for quick startups with large
+	// payloads. List, Len, Size, Glob, and Stat don't decompress files.
+	// The binary (or its sidecar, or the reader given to UnStuffFrom) is
+	// read from for as long as the FileSystem is used and shouldn't be
+	// modified in place. Payloads that can't be read in place (see
+	// UnStuffPaths) are read into memory and payloads with checksums are
+	// read once to verify them unless SkipVerify is set. Progress isn't
+	// called, and errors reading files are returned by File.Read and
+	// FileSystem.Read. The FileSystem implements io.Closer to close the
+	// payload once it's no longer used. Files returned by Get that aren't
+	// read should be closed as they keep the payload open.
+	Lazy bool
+
+	// StreamSize is the optional size above which the files in Lazy
+	// payloads aren't decompressed into memory but are streamed from the
+	// payload as they're read, for instance, to serve large datasets with
+	// http.ServeContent. Seeking forward in a streamed file decompresses
+	// and discards the bytes in between and seeking backward decompresses
+	// it from the start again, except for files stored without compression
+	// (see StuffOpt.Store), which are read in place. Files that are
+	// encrypted individually aren't streamed.
+	StreamSize int64
+}
+
 // UnStuff takes the path to a stuffed binary, unstuffs it, and returns
 // a FileSystem.
 func UnStuff(path string) (FileSystem, error) {
+	return UnStuffWithOpt(path, UnStuffOpt{})
+}
+
+// UnStuffWithOpt is UnStuff with UnStuffOpt options.
+func UnStuffWithOpt(path string, o UnStuffOpt) (FileSystem, error) {
+	if o.SidecarDir == "" {
+		o.SidecarDir = filepath.Dir(path)
+	}
+	if o.Lazy {
+		p, err := openStuff(path, o)
+		if err != nil {
+			return nil, err
+		}
+		return lazyFS(p, o)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return UnStuffFromWithOpt(f, stat.Size(), o)
+}
+
+// UnStuffSelf is UnStuff for the running executable. It returns ErrNoID
+// if the executable isn't stuffed, for instance, with go run.
+func UnStuffSelf() (FileSystem, error) {
+	return UnStuffSelfWithOpt(UnStuffOpt{})
+}
+
+// UnStuffSelfWithOpt is UnStuffSelf with UnStuffOpt options.
+func UnStuffSelfWithOpt(o UnStuffOpt) (FileSystem, error) {
+	path, err := selfPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return UnStuffWithOpt(path, o)
+}
+
+// selfPath returns the path of the running executable with symlinks
+// resolved, so that the sidecar next to the actual binary is found.
+// os.Args[0], which may be a relative path or a name looked up in
+// $PATH, is the fallback on platforms where os.Executable isn't supported.
+func selfPath() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		if path = os.Args[0]; !strings.ContainsRune(path, filepath.Separator) && !strings.Contains(path, "/") {
+			if path, err = exec.LookPath(path); err != nil {
+				return "", fmt.Errorf("error finding the executable: %v", err)
+			}
+		}
+		if path, err = filepath.Abs(path); err != nil {
+			return "", err
+		}
+	}
+
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+	return path, nil
+}
+
+// UnStuffFrom is UnStuff for a stuffed binary of the given size that's
+// read from r, for instance, a bytes.Reader of a binary held in memory.
+func UnStuffFrom(r io.ReaderAt, size int64) (FileSystem, error) {
+	return UnStuffFromWithOpt(r, size, UnStuffOpt{})
+}
+
+// UnStuffFromWithOpt is UnStuffFrom with UnStuffOpt options.
+func UnStuffFromWithOpt(r io.ReaderAt, size int64, o UnStuffOpt) (FileSystem, error) {
+	if o.Lazy {
+		p, err := openPayload(r, size, o)
+		if err != nil {
+			return nil, err
+		}
+		return lazyFS(p, o)
+	}
+
 	// Get stuffed zip data.
-	b, err := GetStuff(path)
+	id, b, err := readStuff(r, size, o)
 	if err != nil {
 		return nil, err
 	}
 
+	// Files that are encrypted individually are only loaded with the key.
+	var key []byte
+	if id.Flags&FlagEncryptedFiles != 0 && o.Key != nil {
+		if key, err = payloadKey(id, o.Key); err != nil {
+			return nil, err
+		}
+	}
+
 	// Unzip files into a FileSystem.
-	fs, err := UnZip(b)
+	fs, err := unZip(b, key, o)
 	if err != nil {
 		return nil, err
 	}
@@ -25,67 +208,667 @@ func UnStuff(path string) (FileSystem, error) {
 	return fs, nil
 }
 
-// GetStuff takes the path to a stuffed binary and extracts
-// the packed data.
-func GetStuff(in string) ([]byte, error) {
-	id, err := GetFileID(in)
+// UnStuffBytes is UnStuff for a stuffed binary that's held in memory,
+// for instance, a downloaded update or the binary on platforms where it
+// can't be opened as a file (js/wasm). Use UnStuffFrom for other sources
+// that implement io.ReaderAt.
+func UnStuffBytes(b []byte) (FileSystem, error) {
+	return UnStuffBytesWithOpt(b, UnStuffOpt{})
+}
+
+// UnStuffBytesWithOpt is UnStuffBytes with UnStuffOpt options.
+func UnStuffBytesWithOpt(b []byte, o UnStuffOpt) (FileSystem, error) {
+	return UnStuffFromWithOpt(bytes.NewReader(b), int64(len(b)), o)
+}
+
+// UnStuffPaths is UnStuff that only loads the files whose paths match any
+// of the given glob patterns, for instance, /migrations/*.sql. In addition
+// to the path.Match syntax, a ** segment in a pattern matches zero or more
+// directories (eg: /migrations/**). The files are looked up in the ZIP's
+// central directory and the rest of the payload isn't loaded into memory,
+// except for payloads that are compressed with a codec other than CodecZip,
+// encrypted as a whole, or verified with a signature, which are read whole.
+func UnStuffPaths(path string, patterns ...string) (FileSystem, error) {
+	return UnStuffPathsWithOpt(path, UnStuffOpt{}, patterns...)
+}
+
+// UnStuffPathsWithOpt is UnStuffPaths with UnStuffOpt options.
+func UnStuffPathsWithOpt(path string, o UnStuffOpt, patterns ...string) (FileSystem, error) {
+	for _, p := range patterns {
+		if err := checkPattern(p); err != nil {
+			return nil, err
+		}
+	}
+	p, err := openStuff(path, o)
 	if err != nil {
 		return nil, err
 	}
+	defer p.Close()
+
+	return unZipFrom(p, p.size, p.key, o, func(name string) bool {
+		return matchPath(patterns, name)
+	})
+}
+
+// payload is a ZIP payload that's read from a stuffed binary
+// or its sidecar without loading it into memory.
+type payload struct {
+	io.ReaderAt
+	id   ID
+	size int64
 
-	// Read the zip data from the binary.
-	b, err := getZipBytes(in, int64(id.BinSize), int64(id.ZipSize))
+	// key is the key of the files that are encrypted
+	// individually, if the payload has any and there's a key.
+	key []byte
+
+	bin     *os.File
+	sidecar *os.File
+}
+
+// Close closes the binary and the sidecar of the payload, if any.
+func (p *payload) Close() error {
+	if p.sidecar != nil {
+		p.sidecar.Close()
+	}
+	if p.bin != nil {
+		return p.bin.Close()
+	}
+	return nil
+}
+
+// openStuff opens the ZIP payload of the stuffed binary at the
+// given path (see openPayload).
+func openStuff(in string, o UnStuffOpt) (*payload, error) {
+	if o.SidecarDir == "" {
+		o.SidecarDir = filepath.Dir(in)
+	}
+
+	f, err := os.Open(in)
 	if err != nil {
 		return nil, err
 	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
 
-	return b, nil
+	p, err := openPayload(f, stat.Size(), o)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	p.bin = f
+
+	return p, nil
 }
 
-// UnZip unzips zipped bytes and returns a FileSystem
-// with the files mapped to it.
-func UnZip(b []byte) (FileSystem, error) {
-	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+// openPayload opens the ZIP payload of a stuffed binary of the given size
+// and verifies its checksum, if any, by streaming it. Payloads that can't be
+// read in place (see UnStuffPaths) are read into memory with readStuff.
+func openPayload(r io.ReaderAt, size int64, o UnStuffOpt) (*payload, error) {
+	p, err := readPayload(r, size, o)
 	if err != nil {
 		return nil, err
 	}
 
-	fs, _ := NewFS()
-	for _, f := range r.File {
-		// Read the file.
-		rd, err := f.Open()
+	// Files that are encrypted individually are only loaded with the key.
+	if p.id.Flags&FlagEncryptedFiles != 0 && o.Key != nil {
+		if p.key, err = payloadKey(p.id, o.Key); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// readPayload is openPayload without the key of the files that are
+// encrypted individually.
+func readPayload(r io.ReaderAt, size int64, o UnStuffOpt) (*payload, error) {
+	id, err := getID(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if id.Codec != CodecZip || id.Flags&FlagEncrypted != 0 || o.HMACKey != nil || o.PublicKey != nil {
+		id, b, err := readStuff(r, size, o)
 		if err != nil {
 			return nil, err
 		}
+		return &payload{ReaderAt: bytes.NewReader(b), id: id, size: int64(len(b))}, nil
+	}
 
-		b := new(bytes.Buffer)
-		if _, err := io.Copy(b, rd); err != nil {
+	p := &payload{id: id}
+	if id.Flags&FlagSidecar != 0 {
+		f, err := openSidecar(id, o.SidecarDir)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
 			return nil, err
 		}
+		p.ReaderAt, p.size, p.sidecar = f, stat.Size(), f
+	} else {
+		// The payload should lie within the file (see getZipBytes).
+		offset := id.payloadOffset()
+		if offset > uint64(size) || id.ZipSize > uint64(size)-offset {
+			return nil, &PayloadError{Err: ErrTruncated, Cause: fmt.Errorf("payload (%d bytes at %d) exceeds the file size %d", id.ZipSize, offset, size)}
+		}
+		p.ReaderAt, p.size = io.NewSectionReader(r, int64(offset), int64(id.ZipSize)), int64(id.ZipSize)
+	}
 
-		if err := fs.Add(NewFile(f.FileHeader.Name, f.FileInfo(), b.Bytes())); err != nil {
+	if !o.SkipVerify && id.Flags&FlagChecksum != 0 {
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(p, 0, p.size)); err != nil {
+			p.Close()
 			return nil, err
 		}
+		if !bytes.Equal(h.Sum(nil), id.Checksum[:]) {
+			p.Close()
+			return nil, ErrChecksum
+		}
 	}
 
-	return fs, nil
+	return p, nil
 }
 
-// getZipBytes gets the embedded ZIP data from a binary
-// given offset (from) and zipLen positions extracted
-// from the embedded ID.
-func getZipBytes(fName string, offset, zipLen int64) ([]byte, error) {
-	f, err := os.Open(fName)
+// checkPattern validates a glob pattern of paths.
+func checkPattern(pattern string) error {
+	for _, seg := range strings.Split(pattern, "/") {
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchPath checks whether the given target path matches any of the
+// given glob patterns where a ** segment matches zero or more directories.
+func matchPath(patterns []string, p string) bool {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for _, pattern := range patterns {
+		if matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnStuffVerified is UnStuff that refuses to load payloads that don't
+// have a valid HMAC-SHA256 signature for the given key (see StuffSigned),
+// for instance, payloads that have been tampered with.
+func UnStuffVerified(path string, key []byte) (FileSystem, error) {
+	return UnStuffWithOpt(path, UnStuffOpt{HMACKey: key})
+}
+
+// UnStuffEd25519 is UnStuff that refuses to load payloads that are not
+// signed by the private key of the given Ed25519 public key (see
+// StuffOpt.SigningKey). The public key is typically compiled into the
+// application so that a modified payload can't be loaded by it.
+func UnStuffEd25519(path string, pub ed25519.PublicKey) (FileSystem, error) {
+	return UnStuffWithOpt(path, UnStuffOpt{PublicKey: pub})
+}
+
+// UnStuffEncrypted is UnStuff for payloads that are encrypted
+// (see StuffOpt.EncryptionKey, StuffOpt.Passphrase, and StuffOpt.Recipients).
+// The key, the passphrase, or the age identity is fetched at runtime with the
+// given KeyFunc, for instance, KeyFromEnv("APP_KEY").
+func UnStuffEncrypted(path string, key KeyFunc) (FileSystem, error) {
+	return UnStuffWithOpt(path, UnStuffOpt{Key: key})
+}
+
+// GetStuff takes the path to a stuffed binary and extracts
+// the packed data as a ZIP archive, decompressing it if the
+// payload was stuffed with a codec other than CodecZip. If the
+// payload has a checksum, it's verified.
+func GetStuff(in string) ([]byte, error) {
+	return GetStuffWithOpt(in, UnStuffOpt{})
+}
+
+// GetStuffWithOpt is GetStuff with UnStuffOpt options. Files that are
+// encrypted individually (see StuffOpt.Encrypt) remain encrypted in the ZIP.
+func GetStuffWithOpt(in string, o UnStuffOpt) ([]byte, error) {
+	_, b, err := getStuff(in, o)
+	return b, err
+}
+
+// StuffReader is an io.ReaderAt over the ZIP payload of a stuffed binary
+// that's returned by GetStuffReader. It should be closed after use.
+type StuffReader struct {
+	p *payload
+}
+
+// GetStuffReader is GetStuff that returns a reader over the ZIP payload
+// in the binary or its sidecar instead of reading it into memory, for
+// instance, to open it with archive/zip or to serve files lazily. Payloads
+// that are compressed with a codec other than CodecZip, encrypted as a
+// whole, or verified with a signature are read into memory. If the payload
+// has a checksum, it's verified by reading it once.
+func GetStuffReader(in string) (*StuffReader, error) {
+	return GetStuffReaderWithOpt(in, UnStuffOpt{})
+}
+
+// GetStuffReaderWithOpt is GetStuffReader with UnStuffOpt options.
+func GetStuffReaderWithOpt(in string, o UnStuffOpt) (*StuffReader, error) {
+	p, err := openStuff(in, o)
 	if err != nil {
 		return nil, err
 	}
+	return &StuffReader{p: p}, nil
+}
+
+// ReadAt reads len(b) bytes of the payload at offset off.
+func (r *StuffReader) ReadAt(b []byte, off int64) (int, error) {
+	return r.p.ReadAt(b, off)
+}
+
+// Size returns the size of the payload.
+func (r *StuffReader) Size() int64 {
+	return r.p.size
+}
+
+// ID returns the ID of the stuffed binary.
+func (r *StuffReader) ID() ID {
+	return r.p.id
+}
+
+// Close closes the binary and the sidecar, if any.
+func (r *StuffReader) Close() error {
+	return r.p.Close()
+}
+
+// getStuff returns the ID and the ZIP payload of a stuffed binary.
+func getStuff(in string, o UnStuffOpt) (ID, []byte, error) {
+	if o.SidecarDir == "" {
+		o.SidecarDir = filepath.Dir(in)
+	}
+
+	f, err := os.Open(in)
+	if err != nil {
+		return ID{}, nil, err
+	}
 	defer f.Close()
 
+	stat, err := f.Stat()
+	if err != nil {
+		return ID{}, nil, err
+	}
+
+	return readStuff(f, stat.Size(), o)
+}
+
+// getStored returns the ID and the payload of a
+// stuffed binary as it's stored (see readStored).
+func getStored(in string) (ID, []byte, error) {
+	f, err := os.Open(in)
+	if err != nil {
+		return ID{}, nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return ID{}, nil, err
+	}
+
+	return readStored(f, stat.Size(), UnStuffOpt{SidecarDir: filepath.Dir(in)})
+}
+
+// readStuff returns the ID and the ZIP payload of a stuffed
+// binary of the given size.
+func readStuff(r io.ReaderAt, size int64, o UnStuffOpt) (ID, []byte, error) {
+	id, b, err := readStored(r, size, o)
+	if err != nil {
+		return id, nil, err
+	}
+
+	if !o.SkipVerify {
+		if err := verifyChecksum(id, b); err != nil {
+			return id, nil, err
+		}
+	}
+	if o.HMACKey != nil {
+		if err := verifyHMAC(id, b, o.HMACKey); err != nil {
+			return id, nil, err
+		}
+	}
+	if o.PublicKey != nil {
+		if err := verifyEd25519(id, b, o.PublicKey); err != nil {
+			return id, nil, err
+		}
+	}
+
+	if id.Flags&FlagEncrypted != 0 {
+		if b, err = decryptPayload(id, b, o.Key); err != nil {
+			return id, nil, err
+		}
+	}
+
+	// Decompress non-ZIP payloads into a ZIP.
+	if b, err = decodePayload(id.Codec, b, zstdMaxSize(o)); err != nil {
+		if err == zstd.ErrDecoderSizeExceeded {
+			return id, nil, &SizeError{Size: int64(zstdMaxSize(o)), MaxSize: o.MaxSize}
+		}
+		return id, nil, payloadError("", err)
+	}
+	return id, b, nil
+}
+
+// readStored returns the ID and the payload of a stuffed binary of the
+// given size as it's stored, without verifying, decrypting, or decoding it.
+func readStored(r io.ReaderAt, size int64, o UnStuffOpt) (ID, []byte, error) {
+	id, err := getID(r, size)
+	if err != nil {
+		return id, nil, err
+	}
+
+	// Read the zip data from the binary or its sidecar. Sidecars
+	// are read whole so that they can be replaced.
+	if id.Flags&FlagSidecar != 0 {
+		f, err := openSidecar(id, o.SidecarDir)
+		if err != nil {
+			return id, nil, err
+		}
+		defer f.Close()
+
+		b, err := io.ReadAll(f)
+		return id, b, err
+	}
+
+	b, err := getZipBytes(r, size, id.payloadOffset(), id.ZipSize)
+	return id, b, err
+}
+
+// verifyHMAC verifies the payload against the HMAC-SHA256
+// signature in its ID with the given key.
+func verifyHMAC(id ID, b, key []byte) error {
+	if id.Flags&FlagHMAC == 0 {
+		return ErrSignature
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	if !hmac.Equal(mac.Sum(nil), id.Extra().Signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// verifyEd25519 verifies the payload against the Ed25519ph
+// signature in its ID with the given public key.
+func verifyEd25519(id ID, b []byte, pub ed25519.PublicKey) error {
+	if id.Flags&FlagEd25519 == 0 || len(pub) != ed25519.PublicKeySize {
+		return ErrSignature
+	}
+
+	digest := sha512.Sum512(b)
+	if err := ed25519.VerifyWithOptions(pub, digest[:], id.Extra().Signature, &ed25519.Options{Hash: crypto.SHA512}); err != nil {
+		return ErrSignature
+	}
+	return nil
+}
+
+// verifyChecksum verifies the payload against the checksum
+// in its ID, if there's one.
+func verifyChecksum(id ID, b []byte) error {
+	if id.Flags&FlagChecksum == 0 {
+		return nil
+	}
+	if sha256.Sum256(b) != id.Checksum {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// UnZip unzips zipped bytes and returns a FileSystem
+// with the files mapped to it. Files that are encrypted
+// individually are skipped.
+func UnZip(b []byte) (FileSystem, error) {
+	return unZip(b, nil, UnStuffOpt{})
+}
+
+// unZip is UnZip that decrypts files that are encrypted
+// individually with the optional key.
+func unZip(b []byte, key []byte, o UnStuffOpt) (FileSystem, error) {
+	return unZipFrom(bytes.NewReader(b), int64(len(b)), key, o, nil)
+}
+
+// unZipFrom is unZip for a ZIP of the given size that's read from r.
+// If match isn't nil, only the files whose paths it matches are read.
+func unZipFrom(ra io.ReaderAt, size int64, key []byte, o UnStuffOpt, match func(string) bool) (FileSystem, error) {
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, payloadError("", err)
+	}
+
+	// Files that are compressed with the payload's dictionary
+	// are decompressed with it.
+	_, dec, err := readDict(r, uint64(o.MaxFileSize))
+	if err != nil {
+		return nil, payloadError(dictName, err)
+	}
+	if dec != nil {
+		defer dec.Close()
+	}
+
+	files := payloadFiles(r, key, match)
+	if err := checkUnzipSize(files, o); err != nil {
+		return nil, err
+	}
+
+	// Files are decompressed by workers in parallel and
+	// added to the FileSystem in order as they're done.
+	var (
+		out  = make([]unzipped, len(files))
+		next int64
+		stop int32
+		wg   sync.WaitGroup
+	)
+	for i := range out {
+		out[i].done = make(chan struct{})
+	}
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(files) {
+		workers = len(files)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				i := int(atomic.AddInt64(&next, 1) - 1)
+				if i >= len(files) {
+					return
+				}
+				out[i].file, out[i].err = readZipFile(files[i], key)
+				out[i].err = payloadError(files[i].Name, out[i].err)
+				close(out[i].done)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer atomic.StoreInt32(&stop, 1)
+
+	var compressed int64
+	pr := newProgress(o.Progress, func() int64 { return compressed })
+
+	fs, _ := NewFS()
+	for i, f := range files {
+		pr.start(f.Name, int64(f.UncompressedSize64))
+		compressed += int64(f.CompressedSize64)
+
+		<-out[i].done
+		if out[i].err != nil {
+			return nil, out[i].err
+		}
+		if err := fs.Add(out[i].file); err != nil {
+			return nil, err
+		}
+		out[i].file = nil
+	}
+	pr.finish()
+
+	return fs, nil
+}
+
+// payloadFiles returns the files in a payload ZIP without the dictionary
+// and the files that are encrypted individually if there's no key. If
+// match isn't nil, only the files whose paths it matches are returned.
+func payloadFiles(r *zip.Reader, key []byte, match func(string) bool) []*zip.File {
+	var files []*zip.File
+	for _, f := range r.File {
+		if f.Name == dictName || (match != nil && !match(f.Name)) {
+			continue
+		}
+		if isEncryptedFile(f) && key == nil {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// unzipped is a file that's decompressed by an unZipFrom worker.
+type unzipped struct {
+	file *File
+	err  error
+	done chan struct{}
+}
+
+// readZipFile reads and decompresses a file in a ZIP, decrypting
+// it with the key if it's encrypted individually.
+func readZipFile(f *zip.File, key []byte) (*File, error) {
+	if isEncryptedFile(f) {
+		b, err := readEncryptedFile(f, key)
+		if err != nil {
+			return nil, err
+		}
+
+		info := f.FileInfo()
+		file := newFile(f.Name, &fileInfo{
+			name:    info.Name(),
+			size:    int64(len(b)),
+			mode:    info.Mode(),
+			modTime: info.ModTime(),
+		}, b)
+		file.meta = parseMeta(f.Comment)
+		return file, nil
+	}
+
+	// Sizes above 4GB are read from the ZIP64 records.
+	if f.UncompressedSize64 > maxInt {
+		return nil, fmt.Errorf("%s: size %d is too large for this platform", f.Name, f.UncompressedSize64)
+	}
+
+	rd, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	b, err := readSized(f.Name, rd, f.UncompressedSize64)
+	if err != nil {
+		return nil, err
+	}
+
+	file := newFile(f.FileHeader.Name, f.FileInfo(), b)
+	file.meta = parseMeta(f.Comment)
+	return file, nil
+}
+
+// readSized reads a file of the given size, which should be at most maxInt,
+// from r. Files that turn out to be larger than the size fail.
+func readSized(name string, r io.Reader, size uint64) ([]byte, error) {
+	b := bytes.NewBuffer(make([]byte, 0, int(min(size, maxPrealloc))))
+	if _, err := io.Copy(b, io.LimitReader(r, int64(size)+1)); err != nil {
+		return nil, err
+	}
+	if uint64(b.Len()) > size {
+		return nil, fmt.Errorf("%s: file is larger than its size %d", name, size)
+	}
+	return b.Bytes(), nil
+}
+
+// getZipBytes gets the embedded ZIP data from a binary of the
+// given size given offset (from) and zipLen positions extracted
+// from the embedded ID.
+func getZipBytes(r io.ReaderAt, size int64, offset, zipLen uint64) ([]byte, error) {
+	// The payload should lie within the file. Sizes are checked as uint64
+	// to avoid overflows with corrupt IDs.
+	if offset > uint64(size) || zipLen > uint64(size)-offset {
+		return nil, &PayloadError{Err: ErrTruncated, Cause: fmt.Errorf("payload (%d bytes at %d) exceeds the file size %d", zipLen, offset, size)}
+	}
+	if zipLen > maxInt {
+		return nil, fmt.Errorf("payload size %d is too large for this platform", zipLen)
+	}
+
 	var b = make([]byte, zipLen)
-	_, err = f.ReadAt(b, offset)
+	_, err := r.ReadAt(b, int64(offset))
 	if err != nil {
+		if err == io.EOF {
+			return nil, &PayloadError{Err: ErrTruncated, Cause: err}
+		}
 		return nil, err
 	}
 
 	return b, nil
 }
+
+// PayloadError is returned when a payload or a file in it is corrupt or
+// truncated. Err is ErrCorruptPayload, ErrTruncated, or ErrChecksum, and
+// both it and the underlying Cause can be checked with errors.Is.
+type PayloadError struct {
+	// Path is the path of the file in the payload that
+	// couldn't be read, or empty for the payload itself.
+	Path string
+
+	Err   error
+	Cause error
+}
+
+// Error returns the error message prefixed with the file path, if any.
+func (e *PayloadError) Error() string {
+	s := e.Err.Error()
+	if e.Path != "" {
+		s = e.Path + ": " + s
+	}
+	if e.Cause != nil {
+		s += ": " + e.Cause.Error()
+	}
+	return s
+}
+
+// Unwrap returns the error and the underlying cause.
+func (e *PayloadError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.Cause}
+}
+
+// payloadError returns a PayloadError for an error reading the
+// file at the given path in a payload, or the payload itself. Errors
+// that aren't about damaged data, such as ErrDecrypt, are returned as is.
+func payloadError(path string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrDecrypt), errors.Is(err, ErrNoKey):
+		return err
+	}
+	if _, ok := err.(*PayloadError); ok {
+		return err
+	}
+
+	e := &PayloadError{Path: path, Err: ErrCorruptPayload, Cause: err}
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		e.Err = ErrTruncated
+	case errors.Is(err, zip.ErrChecksum):
+		e.Err = ErrChecksum
+	}
+	return e
+}
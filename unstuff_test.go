@@ -1,8 +1,21 @@
 package stuffbin
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"testing"
+
+	"filippo.io/age"
 )
 
 func TestUnStuff(t *testing.T) {
@@ -13,6 +26,67 @@ func TestUnStuff(t *testing.T) {
 	assert(t, "mismatch in unstuffed file paths", stuffedFiles, f)
 }
 
+func TestUnStuffSelf(t *testing.T) {
+	exe, err := os.Executable()
+	assert(t, "error getting executable", nil, err)
+	exe, err = filepath.EvalSymlinks(exe)
+	assert(t, "error resolving executable", nil, err)
+
+	p, err := selfPath()
+	assert(t, "error getting self path", nil, err)
+	assert(t, "mismatch in self path", exe, p)
+
+	// The test binary isn't stuffed.
+	_, err = UnStuffSelf()
+	assert(t, "unstuffed an unstuffed binary", ErrNoID, err)
+}
+
+func TestUnStuffBytes(t *testing.T) {
+	b, err := ioutil.ReadFile(mockBinStuffed)
+	assert(t, "error reading file", nil, err)
+
+	fs, err := UnStuffBytes(b)
+	assert(t, "error unstuffing", nil, err)
+	f := fs.List()
+	sort.Strings(f)
+	assert(t, "mismatch in unstuffed file paths", stuffedFiles, f)
+
+	_, err = UnStuffBytes(b[:len(b)-1])
+	assert(t, "unstuffed a truncated binary", ErrNoID, err)
+}
+
+func TestUnStuffPaths(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "paths.exe")
+	for _, o := range []StuffOpt{{}, {Checksum: true}, {Codec: CodecZstd}, {Sidecar: true}} {
+		_, _, err := StuffWithOpt(mockBin, out, o, "mock/")
+		assert(t, "error stuffing", nil, err)
+
+		fs, err := UnStuffPaths(out, "/mock/*.txt", "/mock/subdir/**")
+		assert(t, "error unstuffing", nil, err)
+		f := fs.List()
+		sort.Strings(f)
+		assert(t, "mismatch in unstuffed file paths", []string{"/mock/bar.txt", "/mock/foo.txt", "/mock/foofunc.txt", "/mock/subdir/baz.txt"}, f)
+
+		fs, err = UnStuffPaths(out, "/mock/foo.txt")
+		assert(t, "error unstuffing", nil, err)
+		assert(t, "mismatch in unstuffed file paths", []string{"/mock/foo.txt"}, fs.List())
+	}
+
+	// A corrupt payload fails the checksum without reading it into memory.
+	_, _, err := StuffWithOpt(mockBin, out, StuffOpt{Checksum: true}, "mock/")
+	assert(t, "error stuffing", nil, err)
+	b, err := os.ReadFile(out)
+	assert(t, "error reading file", nil, err)
+	b[mockExeSize+10] ^= 0xff
+	assert(t, "error writing file", nil, os.WriteFile(out, b, 0755))
+	_, err = UnStuffPaths(out, "/mock/foo.txt")
+	assert(t, "mismatch in error", ErrChecksum, err)
+
+	_, err = UnStuffPaths(out, "[")
+	assert(t, "accepted an invalid pattern", true, err != nil)
+}
+
 func TestGetStuff(t *testing.T) {
 	b, err := GetStuff(mockBinStuffed)
 	assert(t, "error getting stuff", nil, err)
@@ -32,3 +106,442 @@ func TestUnzipFiles(t *testing.T) {
 	sort.Strings(f)
 	assert(t, "mismatch in zipped file paths", stuffedFiles, f)
 }
+
+func TestUnZip64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping ZIP64 test in short mode")
+	}
+
+	// More than 65535 entries forces ZIP64 end of central directory records.
+	dir, err := ioutil.TempDir("", "stuffbin")
+	assert(t, "error creating temp dir", nil, err)
+	defer os.RemoveAll(dir)
+
+	const n = 1<<16 + 10
+	for i := 0; i < n; i++ {
+		err := ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(i)), []byte(strconv.Itoa(i)), 0644)
+		assert(t, "error writing file", nil, err)
+	}
+
+	_, _, err = Stuff(mockBin, mockBinStuffed2, "/", dir+":/data")
+	assert(t, "error stuffing", nil, err)
+	defer os.Remove(mockBinStuffed2)
+
+	fs, err := UnStuff(mockBinStuffed2)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "file count", n, fs.Len())
+
+	b, err := fs.Read("/data/65540")
+	assert(t, "error reading file", nil, err)
+	assert(t, "mismatch in file", "65540", string(b))
+}
+
+func TestUnZipBadSize(t *testing.T) {
+	// A file whose header claims a size that can't be allocated.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "/foo.txt",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE([]byte("foo")),
+		CompressedSize64:   3,
+		UncompressedSize64: 1 << 46,
+	})
+	assert(t, "error creating file", nil, err)
+	_, err = w.Write([]byte("foo"))
+	assert(t, "error writing file", nil, err)
+	assert(t, "error closing zip", nil, zw.Close())
+
+	_, err = UnZip(buf.Bytes())
+	assert(t, "expected error on bad size", true, err != nil)
+}
+
+func TestUnZipConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	const n = 1000
+	for i := 0; i < n; i++ {
+		w, err := zw.Create("/data/" + strconv.Itoa(i))
+		assert(t, "error creating file", nil, err)
+		_, err = w.Write(bytes.Repeat([]byte(strconv.Itoa(i)), 100))
+		assert(t, "error writing file", nil, err)
+	}
+	assert(t, "error closing zip", nil, zw.Close())
+
+	// Progress events are sent in the order of the files.
+	var paths []string
+	fs, err := unZip(buf.Bytes(), nil, UnStuffOpt{Progress: func(e Event) {
+		if e.Type == EventFileDone {
+			paths = append(paths, e.Path)
+		}
+	}})
+	assert(t, "error unzipping", nil, err)
+	assert(t, "file count", n, fs.Len())
+	for i := 0; i < n; i++ {
+		b, err := fs.Read("/data/" + strconv.Itoa(i))
+		assert(t, "error reading file", nil, err)
+		assert(t, "mismatch in file", strings.Repeat(strconv.Itoa(i), 100), string(b))
+		assert(t, "mismatch in progress order", "/data/"+strconv.Itoa(i), paths[i])
+	}
+
+	// A corrupt file fails the whole payload.
+	b := buf.Bytes()
+	b[bytes.Index(b, []byte("/data/500"))+20] ^= 0xff
+	_, err = UnZip(b)
+	assert(t, "unzipped a corrupt file", true, err != nil)
+}
+
+func TestUnStuffMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	big := filepath.Join(dir, "big")
+	assert(t, "error writing file", nil, os.WriteFile(big, make([]byte, 1<<20), 0644))
+
+	out := filepath.Join(dir, "bomb.exe")
+	for _, c := range []Codec{CodecZip, CodecZstd} {
+		_, _, err := StuffWithOpt(mockBin, out, StuffOpt{Codec: c}, big+":/big", "mock/foo.txt")
+		assert(t, "error stuffing", nil, err)
+
+		_, err = UnStuffWithOpt(out, UnStuffOpt{MaxFileSize: 1 << 10})
+		var se *SizeError
+		assert(t, "mismatch in error", true, errors.As(err, &se))
+		if c == CodecZip {
+			assert(t, "mismatch in files", []FileSize{{Path: "/big", Size: 1 << 20}}, se.Files)
+		}
+
+		_, err = UnStuffWithOpt(out, UnStuffOpt{MaxSize: 1 << 19})
+		assert(t, "mismatch in error", true, errors.As(err, &se))
+
+		fs, err := UnStuffWithOpt(out, UnStuffOpt{MaxSize: 2 << 20, MaxFileSize: 1 << 20})
+		assert(t, "error unstuffing", nil, err)
+		assert(t, "file count", 2, fs.Len())
+	}
+
+	// Sizes in the headers that are smaller than the data fail.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{Name: "/bomb", Method: zip.Store, UncompressedSize64: 10, CompressedSize64: 1 << 20})
+	assert(t, "error creating file", nil, err)
+	w.Write(make([]byte, 1<<20))
+	assert(t, "error closing zip", nil, zw.Close())
+	_, err = unZip(buf.Bytes(), nil, UnStuffOpt{MaxFileSize: 100})
+	assert(t, "unzipped a file larger than its header", true, err != nil)
+}
+
+func TestGetStuffTruncated(t *testing.T) {
+	b, err := ioutil.ReadFile(mockBinStuffed)
+	assert(t, "error reading file", nil, err)
+
+	// Corrupt the payload size in the ID to point beyond the file.
+	id := mockID
+	id.ZipSize = 1 << 62
+	b = append(b[:len(b)-lenID], makeIDBytes(id)...)
+	err = ioutil.WriteFile(mockBinStuffed2, b, 0644)
+	assert(t, "error writing file", nil, err)
+	defer os.Remove(mockBinStuffed2)
+
+	_, err = GetStuff(mockBinStuffed2)
+	assert(t, "expected error on corrupt size", true, errors.Is(err, ErrTruncated))
+}
+
+func TestPayloadError(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "corrupt.exe")
+	_, _, err := Stuff(mockBin, out, "/", localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	// Corrupt the contents of foo.txt.
+	b, err := os.ReadFile(out)
+	assert(t, "error reading file", nil, err)
+	zb, err := GetStuff(out)
+	assert(t, "error getting stuff", nil, err)
+	zr, err := zip.NewReader(bytes.NewReader(zb), int64(len(zb)))
+	assert(t, "error reading zip", nil, err)
+	for _, f := range zr.File {
+		if f.Name == "/mock/foo.txt" {
+			off, err := f.DataOffset()
+			assert(t, "error getting offset", nil, err)
+			b[mockExeSize+int(off)] ^= 0xff
+		}
+	}
+	assert(t, "error writing file", nil, os.WriteFile(out, b, 0755))
+
+	_, err = UnStuff(out)
+	var pe *PayloadError
+	assert(t, "mismatch in error type", true, errors.As(err, &pe))
+	assert(t, "mismatch in error path", "/mock/foo.txt", pe.Path)
+	assert(t, "mismatch in error", true, errors.Is(err, ErrChecksum) || errors.Is(err, ErrCorruptPayload))
+
+	// A damaged central directory.
+	_, err = UnZip(zb[:len(zb)-10])
+	assert(t, "mismatch in error", true, errors.As(err, &pe) && pe.Path == "")
+}
+
+func TestGetStuffChecksum(t *testing.T) {
+	for _, c := range []Codec{CodecZip, CodecZstd} {
+		_, zSize, err := StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{Codec: c, Checksum: true}, localFiles...)
+		assert(t, "error stuffing", nil, err)
+
+		id, err := GetFileID(mockBinStuffed2)
+		assert(t, "error getting file ID", nil, err)
+		assert(t, "ID flags", FlagChecksum, id.Flags)
+
+		fs, err := UnStuff(mockBinStuffed2)
+		assert(t, "error unstuffing", nil, err)
+		assert(t, "file count", len(localFiles), fs.Len())
+
+		// Flip a bit in the payload.
+		b, err := ioutil.ReadFile(mockBinStuffed2)
+		assert(t, "error reading file", nil, err)
+		b[mockExeSize+zSize/2] ^= 1
+		err = ioutil.WriteFile(mockBinStuffed2, b, 0644)
+		assert(t, "error writing file", nil, err)
+
+		_, err = GetStuff(mockBinStuffed2)
+		assert(t, "expected checksum error", ErrChecksum, err)
+		_, err = GetStuffWithOpt(mockBinStuffed2, UnStuffOpt{SkipVerify: true})
+		assert(t, "unexpected checksum error", false, err == ErrChecksum)
+		_, _, err = StuffWithOpt(mockBinStuffed2, mockBinStuffed2, StuffOpt{Incremental: true}, localFiles...)
+		assert(t, "expected error on incremental stuffing", true, err != nil)
+	}
+	_ = os.Remove(mockBinStuffed2)
+}
+
+func TestUnStuffVerified(t *testing.T) {
+	key := []byte("secret")
+	_, zSize, err := StuffSigned(mockBin, mockBinStuffed2, "/", key, localFiles...)
+	assert(t, "error stuffing", nil, err)
+	defer os.Remove(mockBinStuffed2)
+
+	fs, err := UnStuffVerified(mockBinStuffed2, key)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "file count", len(localFiles), fs.Len())
+
+	_, err = UnStuffVerified(mockBinStuffed2, []byte("wrong"))
+	assert(t, "expected signature error with the wrong key", ErrSignature, err)
+
+	// Unsigned payloads should be rejected.
+	_, err = UnStuffVerified(mockBinStuffed, key)
+	assert(t, "expected signature error on unsigned payload", ErrSignature, err)
+
+	// Tamper with the payload.
+	b, err := ioutil.ReadFile(mockBinStuffed2)
+	assert(t, "error reading file", nil, err)
+	b[mockExeSize+zSize/2] ^= 1
+	err = ioutil.WriteFile(mockBinStuffed2, b, 0644)
+	assert(t, "error writing file", nil, err)
+	_, err = UnStuffVerified(mockBinStuffed2, key)
+	assert(t, "expected signature error on tampered payload", ErrSignature, err)
+}
+
+func TestUnStuffEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert(t, "error generating key", nil, err)
+
+	_, zSize, err := StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{SigningKey: priv, Codec: CodecZstd}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+	defer os.Remove(mockBinStuffed2)
+
+	fs, err := UnStuffEd25519(mockBinStuffed2, pub)
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "file count", len(localFiles), fs.Len())
+
+	other, _, err := ed25519.GenerateKey(nil)
+	assert(t, "error generating key", nil, err)
+	_, err = UnStuffEd25519(mockBinStuffed2, other)
+	assert(t, "expected signature error with another key", ErrSignature, err)
+	_, err = UnStuffEd25519(mockBinStuffed, pub)
+	assert(t, "expected signature error on unsigned payload", ErrSignature, err)
+
+	// Tamper with the payload.
+	b, err := ioutil.ReadFile(mockBinStuffed2)
+	assert(t, "error reading file", nil, err)
+	b[mockExeSize+zSize/2] ^= 1
+	err = ioutil.WriteFile(mockBinStuffed2, b, 0644)
+	assert(t, "error writing file", nil, err)
+	_, err = UnStuffEd25519(mockBinStuffed2, pub)
+	assert(t, "expected signature error on tampered payload", ErrSignature, err)
+
+	_, _, err = StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{SigningKey: priv, HMACKey: []byte("x")}, localFiles...)
+	assert(t, "expected error with both keys", true, err != nil)
+}
+
+func TestUnStuffEncrypted(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert(t, "error generating key", nil, err)
+	defer os.Remove(mockBinStuffed2)
+
+	for _, o := range []StuffOpt{
+		{EncryptionKey: key},
+		{EncryptionKey: key, Codec: CodecZstd, Checksum: true},
+		{Passphrase: "secret"},
+	} {
+		secret := key
+		if o.Passphrase != "" {
+			secret = []byte(o.Passphrase)
+		}
+
+		_, _, err := StuffWithOpt(mockBin, mockBinStuffed2, o, localFiles...)
+		assert(t, "error stuffing", nil, err)
+
+		id, err := GetFileID(mockBinStuffed2)
+		assert(t, "error getting file ID", nil, err)
+		assert(t, "encrypted flag", FlagEncrypted, id.Flags&FlagEncrypted)
+		assert(t, "passphrase flag", o.Passphrase != "", id.Flags&FlagPassphrase != 0)
+
+		_, err = UnStuff(mockBinStuffed2)
+		assert(t, "expected error without a key", ErrNoKey, err)
+		_, err = UnStuffEncrypted(mockBinStuffed2, Key(append([]byte{}, secret[:len(secret)-1]...)))
+		assert(t, "expected error with the wrong key", true, err != nil)
+
+		fs, err := UnStuffEncrypted(mockBinStuffed2, Key(secret))
+		assert(t, "error unstuffing", nil, err)
+		f := fs.List()
+		sort.Strings(f)
+		assert(t, "mismatch in unstuffed file paths", stuffedFiles, f)
+
+		// Files added to the payload should be encrypted with the same key.
+		_, _, err = StuffAddWithOpt(mockBinStuffed2, mockBinStuffed2, o, "mock/mock.exe")
+		assert(t, "error adding files", nil, err)
+		fs, err = UnStuffEncrypted(mockBinStuffed2, Key(secret))
+		assert(t, "error unstuffing", nil, err)
+		assert(t, "file count", len(localFiles)+1, fs.Len())
+	}
+
+	// The wrong key of the right size should fail to decrypt.
+	wrong := make([]byte, 32)
+	_, err = UnStuffEncrypted(mockBinStuffed2, Key(wrong))
+	assert(t, "expected decryption error", ErrDecrypt, err)
+
+	// Keys can be read from the environment.
+	t.Setenv("STUFFBIN_TEST_KEY", "secret")
+	_, err = UnStuffEncrypted(mockBinStuffed2, KeyFromEnv("STUFFBIN_TEST_KEY"))
+	assert(t, "error unstuffing with key from env", nil, err)
+
+	_, _, err = StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{EncryptionKey: []byte("short")}, localFiles...)
+	assert(t, "expected error on invalid key size", true, err != nil)
+}
+
+func TestUnStuffAge(t *testing.T) {
+	var ids []*age.X25519Identity
+	for i := 0; i < 3; i++ {
+		id, err := age.GenerateX25519Identity()
+		assert(t, "error generating identity", nil, err)
+		ids = append(ids, id)
+	}
+
+	o := StuffOpt{Recipients: []string{ids[0].Recipient().String(), ids[1].Recipient().String()}}
+	_, _, err := StuffWithOpt(mockBin, mockBinStuffed2, o, localFiles...)
+	assert(t, "error stuffing", nil, err)
+	defer os.Remove(mockBinStuffed2)
+
+	id, err := GetFileID(mockBinStuffed2)
+	assert(t, "error getting file ID", nil, err)
+	assert(t, "ID flags", FlagEncrypted|FlagAge, id.Flags)
+	assert(t, "wrapped key missing", true, len(id.Extra().WrappedKey) > 0)
+
+	// Any of the recipients should be able to decrypt the payload.
+	for _, i := range ids[:2] {
+		fs, err := UnStuffEncrypted(mockBinStuffed2, Key([]byte(i.String())))
+		assert(t, "error unstuffing", nil, err)
+		assert(t, "file count", len(localFiles), fs.Len())
+	}
+	_, err = UnStuffEncrypted(mockBinStuffed2, Key([]byte(ids[2].String())))
+	assert(t, "expected error with another identity", ErrDecrypt, err)
+	_, err = UnStuff(mockBinStuffed2)
+	assert(t, "expected error without an identity", ErrNoKey, err)
+
+	// Files can be added with an identity file.
+	idFile := filepath.Join(t.TempDir(), "key.txt")
+	err = ioutil.WriteFile(idFile, []byte("# test\n"+ids[1].String()+"\n"), 0600)
+	assert(t, "error writing file", nil, err)
+	o.Key = IdentityFile(idFile)
+	_, _, err = StuffAddWithOpt(mockBinStuffed2, mockBinStuffed2, o, "mock/mock.exe")
+	assert(t, "error adding files", nil, err)
+	fs, err := UnStuffEncrypted(mockBinStuffed2, IdentityFile(idFile))
+	assert(t, "error unstuffing", nil, err)
+	assert(t, "file count", len(localFiles)+1, fs.Len())
+
+	_, _, err = StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{Recipients: []string{"age1invalid"}}, localFiles...)
+	assert(t, "expected error on invalid recipient", true, err != nil)
+}
+
+func TestUnStuffEncryptedFiles(t *testing.T) {
+	defer os.Remove(mockBinStuffed2)
+
+	plain, err := ioutil.ReadFile("mock/foo.txt")
+	assert(t, "error reading file", nil, err)
+
+	for _, c := range []Codec{CodecZip, CodecZstd} {
+		o := StuffOpt{Passphrase: "secret", Encrypt: []string{"/mock/foo.*"}, Codec: c}
+		_, _, err := StuffWithOpt(mockBin, mockBinStuffed2, o, localFiles...)
+		assert(t, "error stuffing", nil, err)
+
+		id, err := GetFileID(mockBinStuffed2)
+		assert(t, "error getting file ID", nil, err)
+		assert(t, "ID flags", FlagEncryptedFiles|FlagPassphrase, id.Flags)
+
+		// The encrypted file shouldn't be readable in the ZIP.
+		b, err := GetStuff(mockBinStuffed2)
+		assert(t, "error getting stuff", nil, err)
+		assert(t, "plaintext found in payload", false, bytes.Contains(b, plain))
+
+		// Without the key, only the plain files are loaded.
+		fs, err := UnStuff(mockBinStuffed2)
+		assert(t, "error unstuffing", nil, err)
+		assert(t, "mismatch in plain files", []string{"/mock/bar.txt"}, fs.List())
+
+		fs, err = UnStuffEncrypted(mockBinStuffed2, Key([]byte("secret")))
+		assert(t, "error unstuffing", nil, err)
+		f := fs.List()
+		sort.Strings(f)
+		assert(t, "mismatch in unstuffed file paths", stuffedFiles, f)
+		b, err = fs.Read("/mock/foo.txt")
+		assert(t, "error reading file", nil, err)
+		assert(t, "mismatch in decrypted file", string(plain), string(b))
+		file, _ := fs.Get("/mock/foo.txt")
+		info, _ := file.Stat()
+		assert(t, "mismatch in decrypted file size", int64(len(plain)), info.Size())
+
+		_, err = UnStuffEncrypted(mockBinStuffed2, Key([]byte("wrong")))
+		assert(t, "expected error with the wrong key", ErrDecrypt, err)
+
+		// Encrypted files should be kept when adding files.
+		o.Encrypt = nil
+		_, _, err = StuffAddWithOpt(mockBinStuffed2, mockBinStuffed2, o, "mock/mock.exe")
+		assert(t, "error adding files", nil, err)
+		fs, err = UnStuffEncrypted(mockBinStuffed2, Key([]byte("secret")))
+		assert(t, "error unstuffing", nil, err)
+		assert(t, "file count", len(localFiles)+1, fs.Len())
+		b, err = fs.Read("/mock/foo.txt")
+		assert(t, "error reading file", nil, err)
+		assert(t, "mismatch in re-encrypted file", string(plain), string(b))
+	}
+
+	_, _, err = StuffWithOpt(mockBin, mockBinStuffed2, StuffOpt{Encrypt: []string{"*.txt"}}, localFiles...)
+	assert(t, "expected error without a key", true, err != nil)
+}
+
+func TestGetStuffReader(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "reader.exe")
+	for _, o := range []StuffOpt{{Checksum: true}, {Codec: CodecZstd}, {Sidecar: true}} {
+		_, zLen, err := StuffWithOpt(mockBin, out, o, localFiles...)
+		assert(t, "error stuffing", nil, err)
+
+		r, err := GetStuffReader(out)
+		assert(t, "error getting stuff reader", nil, err)
+		assert(t, "mismatch in codec", o.Codec, r.ID().Codec)
+		if o.Codec == CodecZip {
+			assert(t, "mismatch in size", zLen, r.Size())
+		}
+
+		zr, err := zip.NewReader(r, r.Size())
+		assert(t, "error reading zip", nil, err)
+		var f []string
+		for _, zf := range zr.File {
+			f = append(f, zf.Name)
+		}
+		sort.Strings(f)
+		assert(t, "mismatch in zip file paths", stuffedFiles, f)
+		assert(t, "error closing reader", nil, r.Close())
+	}
+}
@@ -0,0 +1,124 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"io"
+)
+
+// VerifyResult is the result of verifying a stuffed binary.
+type VerifyResult struct {
+	ID ID
+
+	// Err is the error of the payload's checksum or signature, for
+	// instance, ErrChecksum if the checksum doesn't match or ErrSignature
+	// if the signature doesn't match UnStuffOpt.HMACKey or PublicKey.
+	Err error
+
+	// Files are the results of the files in the payload.
+	Files []VerifyFile
+}
+
+// VerifyFile is the result of verifying a file in a payload.
+type VerifyFile struct {
+	Path string
+	Size uint64
+
+	// Err is the error reading the file, for instance, a
+	// PayloadError with ErrChecksum if its CRC-32 doesn't match.
+	Err error
+}
+
+// OK checks whether the payload and all of its files are intact.
+func (r VerifyResult) OK() bool {
+	if r.Err != nil {
+		return false
+	}
+	for _, f := range r.Files {
+		if f.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyStuff verifies the integrity of a stuffed binary, for instance,
+// to catch a corrupt artifact in a release pipeline before it ships. The
+// ID and the bounds of the payload are validated and errors with them are
+// returned. The checksum of the payload, if any, and the CRC-32 of every
+// file are verified and reported in the result.
+func VerifyStuff(path string) (VerifyResult, error) {
+	return VerifyStuffWithOpt(path, UnStuffOpt{})
+}
+
+// VerifyStuffWithOpt is VerifyStuff with UnStuffOpt options. Signatures
+// are verified with UnStuffOpt.HMACKey or UnStuffOpt.PublicKey, and files
+// that are encrypted individually are authenticated with UnStuffOpt.Key.
+// Without the key, the CRC-32 of their encrypted contents is verified.
+func VerifyStuffWithOpt(path string, o UnStuffOpt) (VerifyResult, error) {
+	// The files of a payload that fails its checksum
+	// or signature are still reported.
+	var res VerifyResult
+	p, err := openStuff(path, o)
+	if err == ErrChecksum || err == ErrSignature {
+		res.Err = err
+		o.SkipVerify, o.HMACKey, o.PublicKey = true, nil, nil
+		p, err = openStuff(path, o)
+	}
+	if err != nil {
+		return res, err
+	}
+	defer p.Close()
+	res.ID = p.id
+
+	zr, err := zip.NewReader(p, p.size)
+	if err != nil {
+		return res, payloadError("", err)
+	}
+	_, dec, err := readDict(zr, 0)
+	if err != nil {
+		return res, payloadError(dictName, err)
+	}
+	if dec != nil {
+		defer dec.Close()
+	}
+
+	var compressed int64
+	pr := newProgress(o.Progress, func() int64 { return compressed })
+
+	for _, f := range zr.File {
+		if f.Name == dictName {
+			continue
+		}
+		pr.start(f.Name, int64(f.UncompressedSize64))
+		compressed += int64(f.CompressedSize64)
+
+		res.Files = append(res.Files, VerifyFile{
+			Path: f.Name,
+			Size: f.UncompressedSize64,
+			Err:  payloadError(f.Name, verifyZipFile(f, p.key)),
+		})
+	}
+	pr.finish()
+
+	return res, nil
+}
+
+// verifyZipFile reads a file in a ZIP and returns the error, if any.
+// Files that are encrypted individually are decrypted with the key, if
+// there's one.
+func verifyZipFile(f *zip.File, key []byte) error {
+	if key != nil && isEncryptedFile(f) {
+		_, err := readEncryptedFile(f, key)
+		return err
+	}
+
+	rd, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	// The CRC-32 is verified at the end of the file.
+	_, err = io.Copy(io.Discard, rd)
+	return err
+}
@@ -0,0 +1,86 @@
+package stuffbin
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyStuff(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "verify.exe")
+	_, _, err := StuffWithOpt(mockBin, out, StuffOpt{Checksum: true}, localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	res, err := VerifyStuff(out)
+	assert(t, "error verifying", nil, err)
+	assert(t, "mismatch in result", true, res.OK())
+	assert(t, "mismatch in file count", len(stuffedFiles), len(res.Files))
+
+	// Corrupt the contents of foo.txt.
+	b, err := os.ReadFile(out)
+	assert(t, "error reading file", nil, err)
+	zb, err := GetStuff(out)
+	assert(t, "error getting stuff", nil, err)
+	zr, err := zip.NewReader(bytes.NewReader(zb), int64(len(zb)))
+	assert(t, "error reading zip", nil, err)
+	for _, f := range zr.File {
+		if f.Name == "/mock/foo.txt" {
+			off, err := f.DataOffset()
+			assert(t, "error getting offset", nil, err)
+			b[mockExeSize+int(off)] ^= 0xff
+		}
+	}
+	assert(t, "error writing file", nil, os.WriteFile(out, b, 0755))
+
+	res, err = VerifyStuff(out)
+	assert(t, "error verifying", nil, err)
+	assert(t, "mismatch in result", false, res.OK())
+	assert(t, "mismatch in payload error", ErrChecksum, res.Err)
+	for _, f := range res.Files {
+		if f.Path == "/mock/foo.txt" {
+			assert(t, "corrupt file passed", true, errors.Is(f.Err, ErrChecksum) || errors.Is(f.Err, ErrCorruptPayload))
+		} else {
+			assert(t, "mismatch in file error", nil, f.Err)
+		}
+	}
+
+	// A truncated payload.
+	assert(t, "error writing file", nil, os.WriteFile(out, b[:len(b)-lenID-1], 0755))
+	_, err = VerifyStuff(out)
+	assert(t, "verified a truncated binary", ErrNoID, err)
+}
+
+func TestVerifyStuffSigned(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "verify.exe")
+	_, _, err := StuffSigned(mockBin, out, "/", []byte("secret"), localFiles...)
+	assert(t, "error stuffing", nil, err)
+
+	res, err := VerifyStuffWithOpt(out, UnStuffOpt{HMACKey: []byte("secret")})
+	assert(t, "error verifying", nil, err)
+	assert(t, "mismatch in result", true, res.OK())
+
+	// The files are still reported with the wrong key.
+	res, err = VerifyStuffWithOpt(out, UnStuffOpt{HMACKey: []byte("nope")})
+	assert(t, "error verifying", nil, err)
+	assert(t, "mismatch in result", false, res.OK())
+	assert(t, "mismatch in payload error", ErrSignature, res.Err)
+	assert(t, "mismatch in file count", len(stuffedFiles), len(res.Files))
+}
+
+func TestVerifyZipFile(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "/a", Method: zip.Store})
+	assert(t, "error creating file", nil, err)
+	w.Write([]byte("hello"))
+	assert(t, "error closing zip", nil, zw.Close())
+
+	b := buf.Bytes()
+	b[bytes.Index(b, []byte("hello"))] = 'j'
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	assert(t, "error reading zip", nil, err)
+	assert(t, "mismatch in error", zip.ErrChecksum, verifyZipFile(r.File[0], nil))
+}
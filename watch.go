@@ -0,0 +1,100 @@
+package stuffbin
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// StuffWatch stuffs the files into the binary with StuffWithOpt, and stuffs
+// them again whenever they change, for instance, to test a stuffed binary
+// while its assets are being edited. The files and the input binary (unless
+// it's the output) are checked every interval for files that were added,
+// removed, or modified. Git paths aren't watched. The result of every
+// stuffing, including the first, is sent to the optional onStuff callback.
+// It returns a function that stops watching.
+func StuffWatch(in, out string, o StuffOpt, interval time.Duration, onStuff func(binLen, zipLen int64, err error), files ...string) func() {
+	var (
+		tk   = time.NewTicker(interval)
+		stop = make(chan struct{})
+		once sync.Once
+	)
+
+	stuff := func() {
+		binLen, zipLen, err := StuffWithOpt(in, out, o, files...)
+		if onStuff != nil {
+			onStuff(binLen, zipLen, err)
+		}
+	}
+
+	go func() {
+		defer tk.Stop()
+
+		last := watchStamps(in, out, o, files)
+		stuff()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tk.C:
+			}
+
+			cur := watchStamps(in, out, o, files)
+			if sameStamps(last, cur) {
+				continue
+			}
+			last = cur
+			stuff()
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(stop) })
+	}
+}
+
+// fileStamp is the size and the modification time of a file
+// that's compared to find changes.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// watchStamps returns the stamps of the input binary, unless it's the
+// output, and the local files to stuff that aren't excluded. Files that
+// can't be read are left out, so that they're stuffed (and the error is
+// reported) again when they change.
+func watchStamps(in, out string, o StuffOpt, files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	if in != out {
+		if st, err := os.Stat(in); err == nil {
+			stamps[in] = fileStamp{size: st.Size(), modTime: st.ModTime()}
+		}
+	}
+
+	wo := walkOpt{rootPath: o.RootPath, exclude: o.Exclude, skipHidden: o.SkipHidden, noRecursive: o.NoRecursive, gitIgnore: o.RespectGitignore}
+	for _, f := range files {
+		if isGitPath(f) {
+			continue
+		}
+		_ = walkPaths(func(srcPath, _ string, info os.FileInfo) error {
+			stamps[srcPath] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+			return nil
+		}, wo, f)
+	}
+
+	return stamps
+}
+
+// sameStamps checks whether two sets of stamps are the same.
+func sameStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, s := range a {
+		if t, ok := b[p]; !ok || t.size != s.size || !t.modTime.Equal(s.modTime) {
+			return false
+		}
+	}
+	return true
+}
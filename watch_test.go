@@ -0,0 +1,67 @@
+package stuffbin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStuffWatch(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		assets = filepath.Join(dir, "assets")
+		bin    = filepath.Join(dir, "app")
+	)
+	assert(t, "error creating dir", nil, os.Mkdir(assets, 0755))
+	assert(t, "error writing file", nil, os.WriteFile(filepath.Join(assets, "a.txt"), []byte("one"), 0644))
+
+	errs := make(chan error, 10)
+	stop := StuffWatch(mockBin, bin, StuffOpt{}, time.Millisecond*5, func(_, _ int64, err error) { errs <- err }, assets+":/static")
+	defer stop()
+
+	wait := func() {
+		t.Helper()
+		select {
+		case err := <-errs:
+			assert(t, "error stuffing", nil, err)
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for stuffing")
+		}
+	}
+	// Files are written outside the watched directory and moved in
+	// so that they aren't seen half-written.
+	write := func(name, data string) {
+		t.Helper()
+		tmp := filepath.Join(dir, name)
+		assert(t, "error writing file", nil, os.WriteFile(tmp, []byte(data), 0644))
+		assert(t, "error moving file", nil, os.Rename(tmp, filepath.Join(assets, name)))
+	}
+	read := func(p string) string {
+		t.Helper()
+		fs, err := UnStuff(bin)
+		assert(t, "error unstuffing", nil, err)
+		b, _ := fs.Read(p)
+		return string(b)
+	}
+
+	// The files are stuffed first.
+	wait()
+	assert(t, "mismatch in file", "one", read("/static/a.txt"))
+
+	// Modified and added files are stuffed again.
+	write("a.txt", "two!")
+	wait()
+	assert(t, "mismatch in modified file", "two!", read("/static/a.txt"))
+
+	write("b.txt", "three")
+	wait()
+	assert(t, "mismatch in added file", "three", read("/static/b.txt"))
+
+	// Nothing is stuffed without changes.
+	select {
+	case <-errs:
+		t.Fatal("stuffed without changes")
+	case <-time.After(time.Millisecond * 50):
+	}
+}